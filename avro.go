@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strings"
+)
+
+// avroBlockSize is the size a block of records is allowed to grow to before
+// it is written.
+const avroBlockSize = 64 * 1024
+
+// avroScalars are the types in the union for fields with no known type.
+var avroScalars = []interface{}{"null", "boolean", "long", "double", "string"}
+
+// avroprim returns the Avro type for the given schema type. Types that have no
+// equivalent are written as strings, in the same form as they would be in the
+// JSON.
+func avroprim(typ string) string {
+	switch typ {
+	case "bool":
+		return "boolean"
+	case "int":
+		return "long"
+	case "float":
+		return "double"
+	}
+	return "string"
+}
+
+// avroname returns the given name with any characters that are not valid in
+// an Avro name replaced with an underscore.
+func avroname(name string) string {
+	var buf strings.Builder
+
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			buf.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				buf.WriteByte('_')
+			}
+			buf.WriteRune(c)
+		default:
+			buf.WriteByte('_')
+		}
+	}
+
+	if buf.Len() == 0 {
+		return "_"
+	}
+	return buf.String()
+}
+
+// avrounion returns the union of types for the given field. Every field is
+// nullable, since a column with no value is not written.
+func avrounion(f Field) []interface{} {
+	var items interface{} = avroScalars
+
+	if f.Type != "" {
+		items = []interface{}{"null", avroprim(f.Type)}
+	}
+
+	if f.Array {
+		return []interface{}{"null", map[string]interface{}{
+			"type":  "array",
+			"items": items,
+		}}
+	}
+
+	if f.Type == "" {
+		return append(avroScalars[:len(avroScalars):len(avroScalars)], map[string]interface{}{
+			"type":  "array",
+			"items": avroScalars,
+		})
+	}
+	return items.([]interface{})
+}
+
+// AvroSchema returns the Avro schema for records with the given fields.
+func AvroSchema(name string, fields []Field) ([]byte, error) {
+	type avroField struct {
+		Name    string        `json:"name"`
+		Type    []interface{} `json:"type"`
+		Default interface{}   `json:"default"`
+	}
+
+	afields := make([]avroField, 0, len(fields))
+
+	for _, f := range fields {
+		afields = append(afields, avroField{
+			Name: avroname(f.Name),
+			Type: avrounion(f),
+		})
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"type":   "record",
+		"name":   avroname(name),
+		"fields": afields,
+	})
+}
+
+// WriteAvroSchema writes the Avro schema for records with the given fields to
+// the given writer.
+func WriteAvroSchema(w io.Writer, name string, fields []Field) error {
+	b, err := AvroSchema(name, fields)
+
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	if err := json.Indent(&buf, b, "", "  "); err != nil {
+		return err
+	}
+
+	buf.WriteByte('\n')
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// avroEncoder writes records to an Avro object container file. Records are
+// written in blocks, each followed by the sync marker from the header.
+type avroEncoder struct {
+	w      io.Writer
+	fields []Field
+	unions [][]interface{}
+	sync   [16]byte
+
+	buf   bytes.Buffer // block of records yet to be written
+	count int
+}
+
+func avrolong(buf *bytes.Buffer, n int64) {
+	var b [binary.MaxVarintLen64]byte
+
+	// Avro uses the same zig-zag encoding as binary.PutVarint.
+	buf.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+func avrostring(buf *bytes.Buffer, s string) {
+	avrolong(buf, int64(len(s)))
+	buf.WriteString(s)
+}
+
+// NewAvroEncoder returns an Encoder that writes records with the given fields
+// to w as an Avro object container file.
+func NewAvroEncoder(w io.Writer, name string, fields []Field) (Encoder, error) {
+	schema, err := AvroSchema(name, fields)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e := &avroEncoder{
+		w:      w,
+		fields: fields,
+		unions: make([][]interface{}, 0, len(fields)),
+	}
+
+	for _, f := range fields {
+		e.unions = append(e.unions, avrounion(f))
+	}
+
+	if _, err := rand.Read(e.sync[:]); err != nil {
+		return nil, err
+	}
+
+	var hdr bytes.Buffer
+
+	hdr.WriteString("Obj\x01")
+
+	// The metadata is a map of strings to bytes, written as a single block.
+	avrolong(&hdr, 2)
+	avrostring(&hdr, "avro.schema")
+	avrostring(&hdr, string(schema))
+	avrostring(&hdr, "avro.codec")
+	avrostring(&hdr, "null")
+	avrolong(&hdr, 0)
+
+	hdr.Write(e.sync[:])
+
+	if _, err := w.Write(hdr.Bytes()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// branch returns the index of the type in the union for the given value.
+func avrobranch(union []interface{}, v interface{}) (int, error) {
+	want := "null"
+
+	switch v.(type) {
+	case bool:
+		want = "boolean"
+	case int64:
+		want = "long"
+	case float64:
+		want = "double"
+	case string:
+		want = "string"
+	case []interface{}:
+		want = "array"
+	}
+
+	for i, typ := range union {
+		if m, ok := typ.(map[string]interface{}); ok {
+			typ = m["type"]
+		}
+
+		if typ == want {
+			return i, nil
+		}
+
+		// Whole numbers can be written to a double.
+		if typ == "double" && want == "long" {
+			return i, nil
+		}
+	}
+	return 0, errors.New("cannot encode " + want + " value")
+}
+
+func (e *avroEncoder) value(union []interface{}, v interface{}) error {
+	i, err := avrobranch(union, v)
+
+	if err != nil {
+		return err
+	}
+
+	avrolong(&e.buf, int64(i))
+
+	switch x := v.(type) {
+	case bool:
+		if x {
+			e.buf.WriteByte(1)
+		} else {
+			e.buf.WriteByte(0)
+		}
+	case int64:
+		if m, ok := union[i].(string); ok && m == "double" {
+			binary.Write(&e.buf, binary.LittleEndian, math.Float64bits(float64(x)))
+			break
+		}
+		avrolong(&e.buf, x)
+	case float64:
+		binary.Write(&e.buf, binary.LittleEndian, math.Float64bits(x))
+	case string:
+		avrostring(&e.buf, x)
+	case []interface{}:
+		items := union[i].(map[string]interface{})["items"].([]interface{})
+
+		if len(x) > 0 {
+			avrolong(&e.buf, int64(len(x)))
+
+			for _, item := range x {
+				if err := e.value(items, item); err != nil {
+					return err
+				}
+			}
+		}
+		avrolong(&e.buf, 0)
+	}
+	return nil
+}
+
+func (e *avroEncoder) Encode(r *Record) error {
+	n := e.buf.Len()
+
+	for i, f := range e.fields {
+		var val interface{}
+
+		if v, ok := r.Get(f.Name); ok {
+			var err error
+
+			val, err = native(v)
+
+			if err != nil {
+				e.buf.Truncate(n)
+				return EncodeError{Field: f.Name, Err: err}
+			}
+		}
+
+		if err := e.value(e.unions[i], val); err != nil {
+			// Drop the partially encoded record from the block.
+			e.buf.Truncate(n)
+			return EncodeError{Field: f.Name, Err: err}
+		}
+	}
+
+	e.count++
+
+	if e.buf.Len() >= avroBlockSize {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *avroEncoder) flush() error {
+	if e.count == 0 {
+		return nil
+	}
+
+	var hdr bytes.Buffer
+
+	avrolong(&hdr, int64(e.count))
+	avrolong(&hdr, int64(e.buf.Len()))
+
+	if _, err := e.w.Write(hdr.Bytes()); err != nil {
+		return err
+	}
+
+	if _, err := e.w.Write(e.buf.Bytes()); err != nil {
+		return err
+	}
+
+	if _, err := e.w.Write(e.sync[:]); err != nil {
+		return err
+	}
+
+	e.buf.Reset()
+	e.count = 0
+
+	return nil
+}
+
+func (e *avroEncoder) Close() error { return e.flush() }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// avroDecoder decodes the records of an Avro object container file written by
+// the avroEncoder, into the maps they would be in the JSON.
+type avroDecoder struct {
+	t  *testing.T
+	br *bufio.Reader
+}
+
+func (d *avroDecoder) long() int64 {
+	n, err := binary.ReadVarint(d.br)
+
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	return n
+}
+
+func (d *avroDecoder) bytes(n int) []byte {
+	b := make([]byte, n)
+
+	if _, err := io.ReadFull(d.br, b); err != nil {
+		d.t.Fatal(err)
+	}
+	return b
+}
+
+func (d *avroDecoder) string() string { return string(d.bytes(int(d.long()))) }
+
+func (d *avroDecoder) value(union []interface{}) interface{} {
+	typ := union[d.long()]
+
+	if m, ok := typ.(map[string]interface{}); ok {
+		items := m["items"].([]interface{})
+		arr := make([]interface{}, 0)
+
+		for n := d.long(); n != 0; n = d.long() {
+			for ; n > 0; n-- {
+				arr = append(arr, d.value(items))
+			}
+		}
+		return arr
+	}
+
+	switch typ {
+	case "boolean":
+		return d.bytes(1)[0] == 1
+	case "long":
+		return float64(d.long())
+	case "double":
+		return math.Float64frombits(binary.LittleEndian.Uint64(d.bytes(8)))
+	case "string":
+		return d.string()
+	}
+	return nil
+}
+
+func decodeAvro(t *testing.T, fname string) []map[string]interface{} {
+	f, err := os.Open(fname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	d := &avroDecoder{t: t, br: bufio.NewReader(f)}
+
+	if magic := string(d.bytes(4)); magic != "Obj\x01" {
+		t.Fatalf("unexpected magic, got=%q\n", magic)
+	}
+
+	meta := make(map[string]string)
+
+	for n := d.long(); n != 0; n = d.long() {
+		for ; n > 0; n-- {
+			meta[d.string()] = d.string()
+		}
+	}
+
+	if codec := meta["avro.codec"]; codec != "null" {
+		t.Fatalf("unexpected codec, expected=%q, got=%q\n", "null", codec)
+	}
+
+	var schema struct {
+		Fields []struct {
+			Name string
+			Type []interface{}
+		}
+	}
+
+	if err := json.Unmarshal([]byte(meta["avro.schema"]), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	sync := d.bytes(16)
+	records := make([]map[string]interface{}, 0)
+
+	for {
+		if _, err := d.br.Peek(1); err == io.EOF {
+			break
+		}
+
+		count := d.long()
+		d.long()
+
+		for i := int64(0); i < count; i++ {
+			r := make(map[string]interface{})
+
+			for _, f := range schema.Fields {
+				if v := d.value(f.Type); v != nil {
+					r[f.Name] = v
+				}
+			}
+			records = append(records, r)
+		}
+
+		if b := d.bytes(16); !bytes.Equal(b, sync) {
+			t.Fatalf("unexpected sync marker after block, got=%x\n", b)
+		}
+	}
+	return records
+}
+
+func Test_FormatAvro(t *testing.T) {
+	dir := t.TempDir()
+	schema := filepath.Join("testdata", "sales.schema")
+
+	if err := run([]string{"csv2json", "-s", schema, "-format", "avro", filepath.Join("testdata", "sales.csv")}); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll("sales.avro")
+	defer os.RemoveAll("sales.avsc")
+
+	// Write the decoded records out as JSON, so they can be checked against
+	// the golden file.
+	actual := filepath.Join(dir, "sales.json")
+
+	out, err := os.Create(actual)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := json.NewEncoder(out)
+
+	for _, r := range decodeAvro(t, "sales.avro") {
+		if err := enc.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out.Close()
+
+	f, err := os.Open(filepath.Join("testdata", "sales.golden"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	checkCsv(t, f, actual)
+
+	b, err := os.ReadFile("sales.avsc")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var avsc struct {
+		Type   string
+		Name   string
+		Fields []struct {
+			Name string
+			Type []interface{}
+		}
+	}
+
+	if err := json.Unmarshal(b, &avsc); err != nil {
+		t.Fatal(err)
+	}
+
+	if avsc.Type != "record" || avsc.Name != "sales" {
+		t.Fatalf("unexpected schema, expected record sales, got=%s %s\n", avsc.Type, avsc.Name)
+	}
+
+	types := map[string]string{
+		"day":        "string",
+		"amount":     "long",
+		"avg_2":      "double",
+		"week_count": "long",
+	}
+
+	for _, f := range avsc.Fields {
+		expected, ok := types[f.Name]
+
+		if !ok {
+			continue
+		}
+
+		if len(f.Type) != 2 || f.Type[1] != expected {
+			t.Errorf("unexpected type for %s, expected=[null %s], got=%v\n", f.Name, expected, f.Type)
+		}
+	}
+}
+
+func Test_AvroName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"id", "id"},
+		{"first name", "first_name"},
+		{"2fa", "_2fa"},
+		{"", "_"},
+	}
+
+	for _, test := range tests {
+		if name := avroname(test.name); name != test.expected {
+			t.Errorf("unexpected name for %q, expected=%q, got=%q\n", test.name, test.expected, name)
+		}
+	}
+}
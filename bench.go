@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// countReader counts the number of bytes read from the underlying reader.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// Bench is the result of benchmarking the conversion of a CSV file.
+type Bench struct {
+	Rows     int64         // number of records written
+	Errors   int           // number of records that failed
+	Read     int64         // size of the input read
+	Written  int64         // size of the output written
+	Duration time.Duration // time spent converting
+	Allocs   uint64        // number of heap allocations made
+	Bytes    uint64        // number of bytes allocated
+}
+
+// add adds the result of another run to the benchmark.
+func (b *Bench) add(b2 Bench) {
+	b.Rows += b2.Rows
+	b.Errors += b2.Errors
+	b.Read += b2.Read
+	b.Written += b2.Written
+	b.Duration += b2.Duration
+	b.Allocs += b2.Allocs
+	b.Bytes += b2.Bytes
+}
+
+func (b Bench) String() string {
+	secs := b.Duration.Seconds()
+
+	var rate, mbps float64
+
+	if secs > 0 {
+		rate = float64(b.Rows) / secs
+		mbps = float64(b.Read) / (1 << 20) / secs
+	}
+
+	var allocs, bytes float64
+
+	if b.Rows > 0 {
+		allocs = float64(b.Allocs) / float64(b.Rows)
+		bytes = float64(b.Bytes) / float64(b.Rows)
+	}
+
+	return "rows=" + strconv.FormatInt(b.Rows, 10) +
+		" errors=" + strconv.Itoa(b.Errors) +
+		" time=" + b.Duration.Round(time.Microsecond).String() +
+		" rows/s=" + strconv.FormatFloat(rate, 'f', 0, 64) +
+		" MB/s=" + strconv.FormatFloat(mbps, 'f', 1, 64) +
+		" allocs/row=" + strconv.FormatFloat(allocs, 'f', 1, 64) +
+		" bytes/row=" + strconv.FormatFloat(bytes, 'f', 0, 64) +
+		" output=" + formatsize(b.Written)
+}
+
+// bench converts the given file in full, without writing the output anywhere,
+// and measures how long it took, and how much was allocated. Record errors are
+// counted rather than reported, since they are part of what is measured.
+func bench(fname string, delim rune, schema *Schema, fetch *Fetcher, opts []ParserOption) (Bench, error) {
+	f, err := openinput(fname, "", delim, fetch)
+
+	if err != nil {
+		return Bench{}, err
+	}
+
+	defer f.Close()
+
+	in := &countReader{r: f}
+
+	var out countWriter
+
+	errh := func(_, _ int, _ string) {}
+
+	var before, after runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+
+	p, err := NewParser(in, delim, schema, errh, inputopts(fname, opts)...)
+
+	if err != nil {
+		return Bench{}, errors.New(fname + ": " + err.Error())
+	}
+
+	if err := p.Parse(&out); err != nil {
+		return Bench{}, errors.New(fname + ": " + err.Error())
+	}
+
+	dur := time.Since(start)
+
+	runtime.ReadMemStats(&after)
+
+	return Bench{
+		Rows:     int64(p.emitted),
+		Errors:   p.errc,
+		Read:     in.n,
+		Written:  out.n,
+		Duration: dur,
+		Allocs:   after.Mallocs - before.Mallocs,
+		Bytes:    after.TotalAlloc - before.TotalAlloc,
+	}, nil
+}
+
+// runBench benchmarks the conversion of each of the given CSV files, printing
+// the throughput and allocations of each, optionally writing CPU and heap
+// profiles of the conversions.
+func runBench(argv0 string, args []string) error {
+	var (
+		schema     string
+		delim      string
+		workers    int
+		count      int
+		cpuprofile string
+		memprofile string
+		plugins    listFlag
+	)
+
+	fs := flag.NewFlagSet(argv0+" bench", flag.ExitOnError)
+	fs.StringVar(&schema, "s", "", "the schema file to convert with")
+	fs.StringVar(&delim, "d", ",", "the csv delimeter, or auto to detect it for each file")
+	fs.IntVar(&workers, "workers", 1, "the number of workers parsing the records of each file")
+	fs.IntVar(&count, "count", 1, "the number of times to convert each file")
+	fs.StringVar(&cpuprofile, "cpuprofile", "", "the file to write a CPU profile to")
+	fs.StringVar(&memprofile, "memprofile", "", "the file to write a heap profile to")
+	fs.Var(&plugins, "plugin", "a Go plugin exporting schema types to load, can be given more than once")
+
+	args = parseflags(fs, args)
+
+	if len(args) < 1 {
+		return usageError(argv0 + " bench [-d delim] [-s schema] [-workers n] [-count n] [-cpuprofile file] [-memprofile file] <file,...>")
+	}
+
+	d, _ := utf8.DecodeRuneInString(delim)
+
+	if d == utf8.RuneError {
+		return errors.New("invalid utf-8 character for delimeter, must be a single character")
+	}
+
+	if delim == "auto" {
+		d = 0
+	}
+
+	if count < 1 {
+		return errors.New("-count must be at least 1")
+	}
+
+	for _, path := range plugins {
+		if err := LoadPlugin(path); err != nil {
+			return err
+		}
+	}
+
+	s := NewSchema()
+
+	if schema != "" {
+		if err := s.Load(schema); err != nil {
+			return err
+		}
+	}
+
+	var opts []ParserOption
+
+	if workers > 1 {
+		opts = append(opts, WithWorkers(workers))
+	}
+
+	if cpuprofile != "" {
+		f, err := os.Create(cpuprofile)
+
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return err
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	fetch := NewFetcher("", 0)
+
+	var total Bench
+
+	for _, fname := range args {
+		var b Bench
+
+		for i := 0; i < count; i++ {
+			run, err := bench(fname, d, s, fetch, opts)
+
+			if err != nil {
+				return err
+			}
+			b.add(run)
+		}
+
+		total.add(b)
+
+		fmt.Printf("%s %s\n", inputname(fname), b)
+	}
+
+	if len(args) > 1 {
+		fmt.Printf("total %s\n", total)
+	}
+
+	if memprofile != "" {
+		f, err := os.Create(memprofile)
+
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		runtime.GC()
+
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// benchcsv returns a CSV file of the given number of rows, along with the
+// schema for it.
+func benchcsv(b *testing.B, rows int) ([]byte, *Schema) {
+	var buf bytes.Buffer
+
+	buf.WriteString("id,name,email,amount,created_at,active\n")
+
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&buf, "%d,user %d,user%d@example.com,%d.%02d,2021-12-%02dT10:04:05Z,%v\n", i, i, i, i*3, i%100, i%28+1, i%2 == 0)
+	}
+
+	schema := filepath.Join(b.TempDir(), "bench.schema")
+
+	src := "id          int\n" +
+		"name        string\n" +
+		"email       string\n" +
+		"amount      float\n" +
+		"created_at  time  2006-01-02T15:04:05Z\n" +
+		"active      bool\n"
+
+	if err := os.WriteFile(schema, []byte(src), os.FileMode(0644)); err != nil {
+		b.Fatal(err)
+	}
+
+	s := NewSchema()
+
+	if err := s.Load(schema); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes(), s
+}
+
+// benchwide returns a CSV file of the given number of rows, and columns,
+// along with the schema for it. Every column is an int.
+func benchwide(b *testing.B, rows, cols int) ([]byte, *Schema) {
+	var (
+		buf bytes.Buffer
+		src strings.Builder
+	)
+
+	for i := 0; i < cols; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "col%d", i)
+		fmt.Fprintf(&src, "col%d  int\n", i)
+	}
+	buf.WriteByte('\n')
+
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(strconv.Itoa(i * j))
+		}
+		buf.WriteByte('\n')
+	}
+
+	schema := filepath.Join(b.TempDir(), "wide.schema")
+
+	if err := os.WriteFile(schema, []byte(src.String()), os.FileMode(0644)); err != nil {
+		b.Fatal(err)
+	}
+
+	s := NewSchema()
+
+	if err := s.Load(schema); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes(), s
+}
+
+func benchParse(b *testing.B, in []byte, s *Schema, opts ...ParserOption) {
+
+	out, err := os.Create(filepath.Join(b.TempDir(), "bench.json"))
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer out.Close()
+
+	errh := func(line, col int, msg string) {
+		b.Fatalf("%d:%d - %s\n", line, col, msg)
+	}
+
+	b.SetBytes(int64(len(in)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := out.Seek(0, 0); err != nil {
+			b.Fatal(err)
+		}
+
+		p, err := NewParser(bytes.NewReader(in), ',', s, errh, opts...)
+
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if err := p.Parse(out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_Parse(b *testing.B) {
+	in, s := benchcsv(b, 10000)
+	benchParse(b, in, s)
+}
+
+func Benchmark_ParseWorkers(b *testing.B) {
+	in, s := benchcsv(b, 10000)
+	benchParse(b, in, s, WithWorkers(4))
+}
+
+func Benchmark_ParseWide(b *testing.B) {
+	in, s := benchwide(b, 1000, 200)
+	benchParse(b, in, s)
+}
+
+func Test_Bench(t *testing.T) {
+	fname := filepath.Join("testdata", "ips.csv")
+
+	info, err := os.Stat(fname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := bench(fname, ',', NewSchema(), nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Rows != 10 {
+		t.Errorf("unexpected rows, expected=%d, got=%d\n", 10, b.Rows)
+	}
+
+	if b.Read != info.Size() {
+		t.Errorf("unexpected read, expected=%d, got=%d\n", info.Size(), b.Read)
+	}
+
+	if b.Written == 0 || b.Allocs == 0 || b.Duration == 0 {
+		t.Errorf("expected output, allocations, and duration to be measured, got=%+v\n", b)
+	}
+
+	for _, s := range []string{"rows=10 ", "rows/s=", "MB/s=", "allocs/row=", "bytes/row="} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected %q in %q\n", s, b.String())
+		}
+	}
+}
+
+func Test_RunBench(t *testing.T) {
+	dir := t.TempDir()
+
+	cpu := filepath.Join(dir, "cpu.prof")
+	mem := filepath.Join(dir, "mem.prof")
+
+	args := []string{"-count", "2", "-cpuprofile", cpu, "-memprofile", mem, filepath.Join("testdata", "ips.csv")}
+
+	if err := runBench("csv2json", args); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prof := range []string{cpu, mem} {
+		info, err := os.Stat(prof)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Size() == 0 {
+			t.Errorf("%s - expected profile to be written\n", prof)
+		}
+	}
+
+	if err := runBench("csv2json", []string{"-count", "0", filepath.Join("testdata", "ips.csv")}); err == nil {
+		t.Errorf("expected error for -count 0\n")
+	}
+}
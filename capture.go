@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// parsecaptures parses the destinations of the groups captured by the given
+// pattern from the value of a capture option. If no destinations are given,
+// then the names of the groups in the pattern are used, and unnamed groups are
+// skipped. A destination of _ skips its group.
+func parsecaptures(pat, val string) (*regexp.Regexp, []string, error) {
+	re, err := regexp.Compile(pat)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if val == "" {
+		dests := make([]string, 0, re.NumSubexp())
+		named := false
+
+		for _, name := range re.SubexpNames()[1:] {
+			if name == "" {
+				name = "_"
+			} else {
+				named = true
+			}
+			dests = append(dests, name)
+		}
+
+		if !named {
+			return nil, nil, errors.New("captures option requires named groups, or a list of destinations")
+		}
+		return re, dests, nil
+	}
+
+	dests := strings.Split(val, ",")
+
+	if len(dests) > re.NumSubexp() {
+		return nil, nil, errors.New("captures option has more destinations than the pattern has groups")
+	}
+	return re, dests, nil
+}
+
+// capture sets the fields of the given record to the groups captured by the
+// schema record's pattern from the given value. Groups that did not match
+// anything are left out.
+func capture(r *Record, rec SchemaRecord, val string) {
+	m := rec.Capture.FindStringSubmatchIndex(val)
+
+	if m == nil {
+		return
+	}
+
+	for i, dest := range rec.Captures {
+		start, end := m[2*(i+1)], m[2*(i+1)+1]
+
+		if dest == "_" || start < 0 {
+			continue
+		}
+		r.Set(dest, &String{s: val[start:end]})
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// parseflags parses the given flags, allowing for flags to be interspersed
+// with the positional arguments. The positional arguments are returned.
+func parseflags(fs *flag.FlagSet, args []string) []string {
+	pos := make([]string, 0)
+
+	for {
+		fs.Parse(args)
+
+		args = fs.Args()
+
+		if len(args) == 0 {
+			break
+		}
+
+		pos = append(pos, args[0])
+		args = args[1:]
+	}
+	return pos
+}
+
+// catline is a single line of NDJSON being concatenated, along with the JSON
+// value of the field being sorted on.
+type catline struct {
+	b   []byte
+	key interface{}
+}
+
+// catcmp compares the two JSON values a and b, returning true if a is less
+// than b. Null values are ordered first, then bools, then numbers, and then
+// strings. Any other values are compared by their JSON encoding.
+func catcmp(a, b interface{}) bool {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case bool:
+			return 1
+		case json.Number:
+			return 2
+		case string:
+			return 3
+		}
+		return 4
+	}
+
+	ra, rb := rank(a), rank(b)
+
+	if ra != rb {
+		return ra < rb
+	}
+
+	switch x := a.(type) {
+	case bool:
+		return !x && b.(bool)
+	case json.Number:
+		f, _ := x.Float64()
+		g, _ := b.(json.Number).Float64()
+		return f < g
+	case string:
+		return x < b.(string)
+	}
+
+	p, _ := json.Marshal(a)
+	q, _ := json.Marshal(b)
+
+	return bytes.Compare(p, q) < 0
+}
+
+type catter struct {
+	dedupe string
+	sort   string
+	desc   bool
+
+	seen  map[string]struct{}
+	lines []catline
+}
+
+// add adds the given line to the output, the line is written to w immediately
+// unless the output is being sorted.
+func (c *catter) add(w io.Writer, line []byte) error {
+	var buf bytes.Buffer
+
+	if err := json.Compact(&buf, line); err != nil {
+		return err
+	}
+
+	if c.dedupe == "" && c.sort == "" {
+		buf.WriteByte('\n')
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+
+	var obj map[string]json.RawMessage
+
+	if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+		return err
+	}
+
+	// Records without the field have no key to be a duplicate of, so they
+	// are all kept.
+	if raw, ok := obj[c.dedupe]; ok && c.dedupe != "" {
+		key := string(raw)
+
+		if _, ok := c.seen[key]; ok {
+			return nil
+		}
+		c.seen[key] = struct{}{}
+	}
+
+	buf.WriteByte('\n')
+
+	if c.sort == "" {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+
+	var key interface{}
+
+	if raw, ok := obj[c.sort]; ok {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+
+		if err := dec.Decode(&key); err != nil {
+			return err
+		}
+	}
+
+	c.lines = append(c.lines, catline{
+		b:   buf.Bytes(),
+		key: key,
+	})
+	return nil
+}
+
+// flush writes any sorted lines to w.
+func (c *catter) flush(w io.Writer) error {
+	sort.SliceStable(c.lines, func(i, j int) bool {
+		if c.desc {
+			return catcmp(c.lines[j].key, c.lines[i].key)
+		}
+		return catcmp(c.lines[i].key, c.lines[j].key)
+	})
+
+	for _, line := range c.lines {
+		if _, err := w.Write(line.b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cat reads each line from the given file and adds it to the output. Any lines
+// that are not valid JSON are reported to errh.
+func (c *catter) cat(w io.Writer, fname string, errh func(int, string)) error {
+	f, err := os.Open(fname)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+
+	n := 0
+
+	for {
+		line, err := br.ReadBytes('\n')
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			n++
+
+			if err := c.add(w, line); err != nil {
+				var syntax *json.SyntaxError
+				var typ *json.UnmarshalTypeError
+
+				if errors.As(err, &syntax) || errors.As(err, &typ) {
+					errh(n, err.Error())
+					continue
+				}
+				return err
+			}
+		}
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func runCat(argv0 string, args []string) error {
+	var (
+		out    string
+		dedupe string
+		sortby string
+	)
+
+	fs := flag.NewFlagSet(argv0+" cat", flag.ExitOnError)
+	fs.StringVar(&out, "o", "", "the file to write the output to, defaults to stdout")
+	fs.StringVar(&dedupe, "dedupe", "", "the field to dedupe records on, the first record is kept")
+	fs.StringVar(&sortby, "sort", "", "the field to sort records on, a :desc suffix sorts in descending order")
+
+	args = parseflags(fs, args)
+
+	if len(args) < 1 {
+		return usageError(argv0 + " cat [-o file, -dedupe field, -sort field[:desc]] <file,...>")
+	}
+
+	c := &catter{
+		dedupe: dedupe,
+		sort:   sortby,
+		seen:   make(map[string]struct{}),
+	}
+
+	if strings.HasSuffix(c.sort, ":desc") {
+		c.sort = strings.TrimSuffix(c.sort, ":desc")
+		c.desc = true
+	}
+
+	var w io.Writer = os.Stdout
+
+	if out != "" {
+		f, err := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644))
+
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		w = f
+	}
+
+	bw := bufio.NewWriter(w)
+
+	errc := 0
+
+	for _, fname := range args {
+		errh := func(line int, msg string) {
+			fmt.Fprintf(os.Stderr, "%s,%d - %s\n", fname, line, msg)
+			errc++
+		}
+
+		if err := c.cat(bw, fname, errh); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", argv0, err)
+			errc++
+		}
+	}
+
+	if err := c.flush(bw); err != nil {
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if errc > 0 {
+		return errors.New("encountered errors during cat")
+	}
+	return nil
+}
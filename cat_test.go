@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Cat(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.json")
+
+	a := filepath.Join("testdata", "cat", "a.json")
+	b := filepath.Join("testdata", "cat", "b.json")
+	c := filepath.Join("testdata", "cat", "c.json")
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{
+			[]string{a, b},
+			`{"id":1,"ts":"2021-01-03"}
+{"id":2,"ts":"2021-01-01"}
+{"id":2,"ts":"2021-01-09"}
+{"id":3,"ts":"2021-01-02"}
+`,
+		},
+		{
+			[]string{a, b, "-dedupe", "id"},
+			`{"id":1,"ts":"2021-01-03"}
+{"id":2,"ts":"2021-01-01"}
+{"id":3,"ts":"2021-01-02"}
+`,
+		},
+		{
+			[]string{a, c, "-dedupe", "id"},
+			`{"id":1,"ts":"2021-01-03"}
+{"id":2,"ts":"2021-01-01"}
+{"ts":"2021-01-04"}
+{"ts":"2021-01-06"}
+`,
+		},
+		{
+			[]string{a, b, "-sort", "ts"},
+			`{"id":2,"ts":"2021-01-01"}
+{"id":3,"ts":"2021-01-02"}
+{"id":1,"ts":"2021-01-03"}
+{"id":2,"ts":"2021-01-09"}
+`,
+		},
+		{
+			[]string{"-dedupe", "id", a, b, "-sort", "id:desc"},
+			`{"id":3,"ts":"2021-01-02"}
+{"id":2,"ts":"2021-01-01"}
+{"id":1,"ts":"2021-01-03"}
+`,
+		},
+	}
+
+	for i, test := range tests {
+		args := append([]string{"csv2json", "cat", "-o", out}, test.args...)
+
+		if err := run(args); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		b, err := ioutil.ReadFile(out)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if string(b) != test.expected {
+			t.Fatalf("tests[%d] - unexpected output, expected=\n%s\ngot=\n%s\n", i, test.expected, string(b))
+		}
+	}
+}
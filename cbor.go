@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// CBOR major types.
+const (
+	cborUint   = 0
+	cborNegint = 1
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+)
+
+// cborEncoder writes each record to the underlying writer as a CBOR map, one
+// after the other.
+type cborEncoder struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// NewCBOREncoder returns an Encoder that writes records to w as a sequence of
+// CBOR maps.
+func NewCBOREncoder(w io.Writer, _ string, _ []Field) (Encoder, error) {
+	return &cborEncoder{w: w}, nil
+}
+
+// head writes the initial byte for the given major type, followed by n in the
+// smallest number of bytes it fits in.
+func (e *cborEncoder) head(major byte, n uint64) {
+	major <<= 5
+
+	switch {
+	case n < 24:
+		e.buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		e.buf.WriteByte(major | 24)
+		e.buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		e.buf.WriteByte(major | 25)
+		binary.Write(&e.buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		e.buf.WriteByte(major | 26)
+		binary.Write(&e.buf, binary.BigEndian, uint32(n))
+	default:
+		e.buf.WriteByte(major | 27)
+		binary.Write(&e.buf, binary.BigEndian, n)
+	}
+}
+
+func (e *cborEncoder) string(s string) {
+	e.head(cborText, uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *cborEncoder) value(v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		e.buf.WriteByte(0xf6)
+	case bool:
+		if x {
+			e.buf.WriteByte(0xf5)
+		} else {
+			e.buf.WriteByte(0xf4)
+		}
+	case int64:
+		if x < 0 {
+			e.head(cborNegint, uint64(-1-x))
+			break
+		}
+		e.head(cborUint, uint64(x))
+	case float64:
+		e.buf.WriteByte(0xfb)
+		binary.Write(&e.buf, binary.BigEndian, math.Float64bits(x))
+	case string:
+		e.string(x)
+	case []interface{}:
+		e.head(cborArray, uint64(len(x)))
+
+		for _, item := range x {
+			if err := e.value(item); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("cannot encode value")
+	}
+	return nil
+}
+
+func (e *cborEncoder) Encode(r *Record) error {
+	e.buf.Reset()
+
+	keys := r.Keys()
+
+	e.head(cborMap, uint64(len(keys)))
+
+	for _, key := range keys {
+		v, _ := r.Get(key)
+
+		val, err := native(v)
+
+		if err != nil {
+			return EncodeError{Field: key, Err: err}
+		}
+
+		e.string(key)
+
+		if err := e.value(val); err != nil {
+			return EncodeError{Field: key, Err: err}
+		}
+	}
+
+	_, err := e.w.Write(e.buf.Bytes())
+	return err
+}
+
+func (e *cborEncoder) Close() error { return nil }
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Check is a sanity check that compares the value of a column in a record
+// against the value of that column in the previous record. If By is set, then
+// the previous record is the last record with the same value for the By
+// column, this allows for checking interleaved series, such as readings from
+// multiple sensors.
+type Check struct {
+	Kind   string // either monotonic or maxdelta
+	Column string
+	By     string
+	Strict bool    // monotonic values must strictly increase or decrease
+	Desc   bool    // monotonic values must decrease
+	Delta  float64 // maximum change allowed between records for maxdelta
+}
+
+// lastval is the last value seen for a Check.
+type lastval struct {
+	n   float64
+	raw string
+}
+
+func (s *Schema) AddCheck(c Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checks = append(s.checks, c)
+}
+
+func (s *Schema) Checks() []Check {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.checks
+}
+
+// loadCheck decodes the given parts of a @monotonic or @maxdelta directive into
+// a Check and adds it to the schema. These are in the form of,
+//
+//     @monotonic column [strict] [desc] [by=column]
+//     @maxdelta  column delta [by=column]
+func (s *Schema) loadCheck(parts []string) error {
+	c := Check{
+		Kind: parts[0][1:],
+	}
+
+	if len(parts) < 2 {
+		return errors.New("too few columns in " + c.Kind + " directive")
+	}
+
+	c.Column = parts[1]
+	parts = parts[2:]
+
+	if c.Kind == "maxdelta" {
+		if len(parts) < 1 {
+			return errors.New("too few columns in " + c.Kind + " directive")
+		}
+
+		n, err := strconv.ParseFloat(parts[0], 64)
+
+		if err != nil {
+			return err
+		}
+
+		if n < 0 {
+			return errors.New("maxdelta cannot be negative")
+		}
+
+		c.Delta = n
+		parts = parts[1:]
+	}
+
+	for _, opt := range parseopts(parts) {
+		switch opt.key {
+		case "by":
+			c.By = opt.val
+		case "strict", "desc":
+			if c.Kind != "monotonic" {
+				return errors.New("unexpected option " + opt.key + " in " + c.Kind + " directive")
+			}
+
+			if opt.key == "strict" {
+				c.Strict = true
+			} else {
+				c.Desc = true
+			}
+		default:
+			return errors.New("unexpected option " + opt.key + " in " + c.Kind + " directive")
+		}
+	}
+
+	s.AddCheck(c)
+	return nil
+}
+
+// checkvalue returns the numeric value of v for comparison. Times are compared
+// by their Unix time in nanoseconds.
+func checkvalue(v Value) (float64, bool) {
+	if t, ok := v.(*Time); ok {
+		return float64(t.t.UnixNano()), true
+	}
+	return numeric(v)
+}
+
+// check performs the schema's checks against the values of the current record.
+// The last values for each check are only updated if all checks pass.
+func (p *Parser) check(rw *row, cols map[string]Value) error {
+	checks := p.schema.Checks()
+
+	if len(checks) == 0 {
+		return nil
+	}
+
+	if p.last == nil {
+		p.last = make([]map[string]lastval, len(checks))
+
+		for i := range p.last {
+			p.last[i] = make(map[string]lastval)
+		}
+	}
+
+	next := make([]*lastval, len(checks))
+
+	for i, c := range checks {
+		v, ok := cols[c.Column]
+
+		if !ok {
+			continue
+		}
+
+		n, ok := checkvalue(v)
+
+		if !ok {
+			return ColumnError{
+				Col: c.Column,
+				Err: errors.New("cannot check non-numeric value"),
+			}
+		}
+
+		raw := p.column(rw.fields, c.Column)
+
+		// Derived columns have no raw value, so use the marshalled value for
+		// any errors.
+		if raw == "" {
+			b, err := v.MarshalJSON()
+
+			if err != nil {
+				return err
+			}
+			raw = string(b)
+		}
+
+		next[i] = &lastval{n: n, raw: raw}
+
+		prev, ok := p.last[i][p.column(rw.fields, c.By)]
+
+		if !ok {
+			continue
+		}
+
+		switch c.Kind {
+		case "monotonic":
+			bad := n < prev.n
+			word := "less than"
+
+			if c.Desc {
+				bad = n > prev.n
+				word = "greater than"
+			}
+
+			if c.Strict && n == prev.n {
+				bad = true
+				word = "equal to"
+			}
+
+			if bad {
+				return ColumnError{
+					Col: c.Column,
+					Err: errors.New(strconv.Quote(raw) + " is " + word + " previous value " + strconv.Quote(prev.raw)),
+				}
+			}
+		case "maxdelta":
+			delta := n - prev.n
+
+			if delta < 0 {
+				delta = -delta
+			}
+
+			if delta > c.Delta {
+				return ColumnError{
+					Col: c.Column,
+					Err: errors.New(strconv.Quote(raw) + " changed by more than " + strconv.FormatFloat(c.Delta, 'f', -1, 64) + " from previous value " + strconv.Quote(prev.raw)),
+				}
+			}
+		}
+	}
+
+	for i, c := range checks {
+		if next[i] != nil {
+			p.last[i][p.column(rw.fields, c.By)] = *next[i]
+		}
+	}
+	return nil
+}
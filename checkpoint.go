@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Checkpoint is the progress made converting a single input, persisted so an
+// interrupted conversion can be resumed from where it left off.
+type Checkpoint struct {
+	Records int   `json:"records"` // records read from the input
+	Offset  int64 `json:"offset"`  // byte offset in the input after the last record read
+	Emitted int   `json:"emitted"` // records written to the output
+	Size    int64 `json:"size"`    // size of the output
+}
+
+// checkpointpath returns the path of the checkpoint for the given output.
+func checkpointpath(path string) string {
+	return path + ".checkpoint"
+}
+
+// LoadCheckpoint loads the checkpoint at the given path. If there is no
+// checkpoint then nil is returned.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var c Checkpoint
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	return &c, nil
+}
+
+// Save writes the checkpoint to the given path. The checkpoint is written to a
+// temporary file first, then renamed, so an interrupted save never leaves a
+// partial checkpoint.
+func (c Checkpoint) Save(path string) error {
+	b, err := json.Marshal(c)
+
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".")
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// WithCheckpoint configures the Parser to call fn with its progress every n
+// records written. The Size of the Checkpoint is left for fn to set, since only
+// the caller knows what has been written.
+func WithCheckpoint(n int, fn func(c Checkpoint) error) ParserOption {
+	return func(p *Parser) {
+		p.checkpointn = n
+		p.checkpoint = fn
+	}
+}
+
+// WithResume configures the Parser to resume from the given Checkpoint. The
+// records read before the checkpoint are read over without being parsed.
+// Rolling aggregates, checks, and deduplication start afresh from the
+// checkpoint.
+func WithResume(c *Checkpoint) ParserOption {
+	return func(p *Parser) {
+		p.resume = c
+	}
+}
+
+// fastforward reads over the records read before the Parser's checkpoint. The
+// offset of the input must match that of the checkpoint, otherwise the input
+// has changed since it was saved.
+func (p *Parser) fastforward() error {
+	c := p.resume
+
+	for p.nread < c.Records {
+		if err := p.nextrecord(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return errors.New("input has fewer records than its checkpoint, it has changed since the checkpoint was saved")
+			}
+			return err
+		}
+		p.nread++
+	}
+
+	if offset := p.csv.InputOffset(); offset != c.Offset {
+		return errors.New("input offset " + strconv.FormatInt(offset, 10) + " does not match its checkpoint, it has changed since the checkpoint was saved")
+	}
+
+	p.skipped = p.skip
+
+	if c.Records < p.skip {
+		p.skipped = c.Records
+	}
+
+	p.emitted = c.Emitted
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkpoints parses the given file, returning the checkpoints saved every n
+// records, and the output.
+func checkpoints(t *testing.T, fname string, n int) ([]Checkpoint, []byte) {
+	f, err := os.Open(fname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	var (
+		buf bytes.Buffer
+		cps []Checkpoint
+	)
+
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	p, err := NewParser(f, ',', NewSchema(), errh, WithCheckpoint(n, func(c Checkpoint) error {
+		c.Size = int64(buf.Len())
+		cps = append(cps, c)
+		return nil
+	}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Parse(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return cps, buf.Bytes()
+}
+
+func Test_Checkpoint(t *testing.T) {
+	cps, out := checkpoints(t, filepath.Join("testdata", "visits.csv"), 2)
+
+	if len(cps) != 2 {
+		t.Fatalf("unexpected number of checkpoints, expected=%d, got=%d\n", 2, len(cps))
+	}
+
+	for i, c := range cps {
+		if c.Records != (i+1)*2 || c.Emitted != (i+1)*2 {
+			t.Errorf("cps[%d] - unexpected checkpoint %+v\n", i, c)
+		}
+
+		if lines := bytes.Count(out[:c.Size], []byte("\n")); lines != c.Emitted {
+			t.Errorf("cps[%d] - unexpected output size, expected %d lines, got=%d\n", i, c.Emitted, lines)
+		}
+	}
+}
+
+func Test_CheckpointResume(t *testing.T) {
+	csvfile := filepath.Join("testdata", "visits.csv")
+
+	cps, expected := checkpoints(t, csvfile, 2)
+
+	dir := t.TempDir()
+
+	out := filepath.Join(dir, "visits.json")
+
+	// Simulate a conversion interrupted part way through writing a record
+	// after the first checkpoint.
+	partial := append(expected[:cps[0].Size:cps[0].Size], []byte(`{"id":`)...)
+
+	if err := os.WriteFile(temppath(out), partial, os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cps[0].Save(checkpointpath(out)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"csv2json", "-o", dir, "-checkpoint", csvfile}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(out)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b, expected) {
+		t.Errorf("unexpected output\n\texpected=%q\n\tgot=%q\n", expected, b)
+	}
+
+	if _, err := os.Stat(checkpointpath(out)); !os.IsNotExist(err) {
+		t.Errorf("expected checkpoint to be removed, got=%v\n", err)
+	}
+}
+
+func Test_CheckpointChanged(t *testing.T) {
+	dir := t.TempDir()
+
+	out := filepath.Join(dir, "visits.json")
+
+	c := Checkpoint{Records: 2, Offset: 10, Emitted: 2}
+
+	if err := os.WriteFile(temppath(out), nil, os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Save(checkpointpath(out)); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"csv2json", "-o", dir, "-checkpoint", filepath.Join("testdata", "visits.csv")}
+
+	if err := run(args); err == nil || !strings.Contains(err.Error(), "encountered errors") {
+		t.Fatalf("expected error for changed input, got=%v\n", err)
+	}
+
+	for _, path := range []string{checkpointpath(out), temppath(out)} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to be kept, got=%v\n", path, err)
+		}
+	}
+}
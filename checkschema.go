@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Columns returns the columns of the CSV file referred to by the schema, in
+// alphabetical order.
+func (s *Schema) Columns() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := make(map[string]struct{})
+
+	add := func(cols ...string) {
+		for _, col := range cols {
+			if col != "" {
+				set[col] = struct{}{}
+			}
+		}
+	}
+
+	for col := range s.recs {
+		add(col)
+	}
+
+	for _, c := range s.combines {
+		add(c.Columns...)
+	}
+
+	for _, c := range s.concats {
+		add(c.Columns...)
+	}
+
+	for _, c := range s.checks {
+		add(c.Column, c.By)
+	}
+
+	for _, r := range s.rollings {
+		add(r.Column, r.By, r.Time)
+	}
+
+	for _, l := range s.lookups {
+		add(l.Column)
+	}
+
+	cols := make([]string, 0, len(set))
+
+	for col := range set {
+		cols = append(cols, col)
+	}
+
+	sort.Strings(cols)
+	return cols
+}
+
+// checkfile checks the given CSV file against the schema, writing any problems
+// found to w. The schema columns that are not in the header are reported, as
+// are the columns in the header that are not in the schema, then the first n
+// records are parsed without being written anywhere. This returns the number
+// of problems found, columns that are not in the schema are not counted since
+// they are still converted.
+func checkfile(w io.Writer, fname string, delim rune, s *Schema, n int) (int, error) {
+	f, err := os.Open(fname)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	problems := 0
+
+	errh := func(line, col int, msg string) {
+		problems++
+		fmt.Fprintf(w, "%s,%d:%d - %s\n", fname, line, col, msg)
+	}
+
+	p, err := NewParser(f, delim, s, errh)
+
+	if err != nil {
+		return 0, errors.New(fname + ": " + err.Error())
+	}
+
+	for _, col := range s.Columns() {
+		if _, ok := p.hdridx[col]; !ok {
+			problems++
+			fmt.Fprintf(w, "%s: schema column %s is not in the header\n", fname, col)
+		}
+	}
+
+	for i, hdr := range p.headers {
+		if _, ok := p.schemacol(i, hdr); !ok {
+			fmt.Fprintf(w, "%s: column %s is not in the schema\n", fname, hdr)
+		}
+	}
+
+	for p.nread < n {
+		rw, err := p.read()
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return problems, errors.New(fname + ": " + err.Error())
+		}
+
+		r, cols, err := p.json(rw)
+
+		if _, err := p.accept(result{rw: rw, r: r, cols: cols, err: err}); err != nil {
+			return problems, errors.New(fname + ": " + err.Error())
+		}
+	}
+
+	fmt.Fprintf(w, "%s: checked %d records\n", fname, p.nread)
+	return problems, nil
+}
+
+// runCheck validates the given schema, and checks it against each of the
+// given CSV files, without writing any output.
+func runCheck(argv0 string, args []string) error {
+	var (
+		schema  string
+		delim   string
+		n       int
+		plugins listFlag
+	)
+
+	fs := flag.NewFlagSet(argv0+" check", flag.ExitOnError)
+	fs.StringVar(&schema, "s", "", "the schema file to check")
+	fs.StringVar(&delim, "d", ",", "the csv delimeter, or auto to detect it for each file")
+	fs.IntVar(&n, "n", 100, "the number of records to parse in each file")
+	fs.Var(&plugins, "plugin", "a Go plugin exporting schema types to load, can be given more than once")
+
+	args = parseflags(fs, args)
+
+	if schema == "" || len(args) < 1 {
+		return usageError(argv0 + " check [-d delim] [-n records] [-plugin file] -s <schema> <file,...>")
+	}
+
+	d, _ := utf8.DecodeRuneInString(delim)
+
+	if d == utf8.RuneError {
+		return errors.New("invalid utf-8 character for delimeter, must be a single character")
+	}
+
+	if delim == "auto" {
+		d = 0
+	}
+
+	for _, path := range plugins {
+		if err := LoadPlugin(path); err != nil {
+			return err
+		}
+	}
+
+	s := NewSchema()
+
+	// Every error in the schema is reported, so they can be fixed at once.
+	if err := s.LoadAll(schema); err != nil {
+		return err
+	}
+
+	problems := 0
+
+	for _, fname := range args {
+		found, err := checkfile(os.Stdout, fname, d, s, n)
+
+		if err != nil {
+			return err
+		}
+		problems += found
+	}
+
+	if problems > 0 {
+		return errors.New(strconv.Itoa(problems) + " problems found")
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_CheckFile(t *testing.T) {
+	dir := t.TempDir()
+
+	schema := filepath.Join(dir, "users.schema")
+	csv := filepath.Join(dir, "users.csv")
+
+	writefile := func(fname, s string) {
+		if err := os.WriteFile(fname, []byte(s), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writefile(schema, "id int\nemail email\n@monotonic id\n")
+	writefile(csv, "id,name\n1,Gordon\nfoo,Alyx\n3,Eli\nbar,Barney\n")
+
+	s := NewSchema()
+
+	if err := s.Load(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	if cols := s.Columns(); !reflect.DeepEqual(cols, []string{"email", "id"}) {
+		t.Fatalf("unexpected columns, expected=%v, got=%v\n", []string{"email", "id"}, cols)
+	}
+
+	var buf bytes.Buffer
+
+	problems, err := checkfile(&buf, csv, ',', s, 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if problems != 2 {
+		t.Errorf("unexpected problems, expected=%d, got=%d\n%s", 2, problems, buf.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	expected := []string{
+		csv + ": schema column email is not in the header",
+		csv + ": column name is not in the schema",
+		csv + ",3:",
+		csv + ": checked 3 records",
+	}
+
+	if len(lines) != len(expected) {
+		t.Fatalf("unexpected output, expected=%d lines, got=%d\n%s", len(expected), len(lines), buf.String())
+	}
+
+	for i, line := range lines {
+		if !strings.HasPrefix(line, expected[i]) {
+			t.Errorf("lines[%d] - unexpected line, expected prefix=%q, got=%q\n", i, expected[i], line)
+		}
+	}
+}
+
+func Test_RunCheck(t *testing.T) {
+	dir := t.TempDir()
+
+	schema := filepath.Join(dir, "users.schema")
+
+	if err := os.WriteFile(schema, []byte("id money\n"), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"csv2json", "check", "-s", schema, filepath.Join("testdata", "numbers.csv")}); err == nil {
+		t.Fatal("expected error for invalid schema, got nil")
+	}
+
+	if err := run([]string{"csv2json", "check", "-s", filepath.Join("testdata", "numbers.schema"), filepath.Join("testdata", "numbers.csv")}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// CaseResult is the result of running a single conformance case. A case
+// passes if both the records and the errors match what was expected.
+type CaseResult struct {
+	Case    string `json:"case"`
+	Pass    bool   `json:"pass"`
+	Records bool   `json:"records"`
+	Errors  bool   `json:"errors"`
+	Message string `json:"message,omitempty"`
+}
+
+// readlines returns the non-empty lines of the given file. If the file does not
+// exist, then no lines are returned.
+func readlines(fname string) ([]string, error) {
+	f, err := os.Open(fname)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	defer f.Close()
+
+	lines := make([]string, 0)
+
+	sc := bufio.NewScanner(f)
+
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// decodelines decodes each of the given lines of JSON.
+func decodelines(lines []string) ([]interface{}, error) {
+	vals := make([]interface{}, 0, len(lines))
+
+	for i, line := range lines {
+		var v interface{}
+
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			return nil, errors.New("line " + strconv.Itoa(i+1) + ": " + err.Error())
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// runCase runs the conformance case with the given name in dir. The case is
+// made up of the files,
+//
+//	name.csv    - the CSV file to convert
+//	name.schema - the schema to use, optional
+//	name.json   - the expected records
+//	name.errors - the expected errors, optional
+//
+// Records are compared by their JSON values, so the order of fields does not
+// matter. Errors are in the form of "line:col - message", one per line.
+func runCase(dir, name string, delim rune) CaseResult {
+	res := CaseResult{Case: name}
+
+	base := filepath.Join(dir, name)
+
+	fail := func(err error) CaseResult {
+		res.Message = err.Error()
+		return res
+	}
+
+	schema := NewSchema()
+
+	if _, err := os.Stat(base + ".schema"); err == nil {
+		if err := schema.Load(base + ".schema"); err != nil {
+			return fail(err)
+		}
+	}
+
+	expected, err := readlines(base + ".json")
+
+	if err != nil {
+		return fail(err)
+	}
+
+	expectedvals, err := decodelines(expected)
+
+	if err != nil {
+		return fail(errors.New(name + ".json: " + err.Error()))
+	}
+
+	expectederrs, err := readlines(base + ".errors")
+
+	if err != nil {
+		return fail(err)
+	}
+
+	f, err := os.Open(base + ".csv")
+
+	if err != nil {
+		return fail(err)
+	}
+
+	defer f.Close()
+
+	errs := make([]string, 0)
+
+	errh := func(line, col int, msg string) {
+		errs = append(errs, strconv.Itoa(line)+":"+strconv.Itoa(col)+" - "+msg)
+	}
+
+	p, err := NewParser(f, delim, schema, errh)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	var out bytes.Buffer
+
+	if err := p.Parse(&out); err != nil {
+		return fail(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+	if out.Len() == 0 {
+		lines = nil
+	}
+
+	vals, err := decodelines(lines)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	msgs := make([]string, 0)
+
+	res.Records = reflect.DeepEqual(vals, expectedvals)
+
+	if !res.Records {
+		msgs = append(msgs, fmt.Sprintf("expected %d records, got %d, or they differ", len(expectedvals), len(vals)))
+	}
+
+	if expectederrs == nil {
+		expectederrs = []string{}
+	}
+
+	res.Errors = reflect.DeepEqual(errs, expectederrs)
+
+	if !res.Errors {
+		msgs = append(msgs, fmt.Sprintf("expected errors %q, got %q", expectederrs, errs))
+	}
+
+	res.Pass = res.Records && res.Errors
+	res.Message = strings.Join(msgs, "; ")
+
+	return res
+}
+
+// conformance runs each of the conformance cases in the given directory, in
+// alphabetical order. Each CSV file in the directory is a case.
+func conformance(dir string, delim rune) ([]CaseResult, error) {
+	csvfiles, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(csvfiles)
+
+	results := make([]CaseResult, 0, len(csvfiles))
+
+	for _, csvfile := range csvfiles {
+		name := strings.TrimSuffix(filepath.Base(csvfile), ".csv")
+
+		results = append(results, runCase(dir, name, delim))
+	}
+	return results, nil
+}
+
+func runConformance(argv0 string, args []string) error {
+	var delim string
+
+	fs := flag.NewFlagSet(argv0+" conformance", flag.ExitOnError)
+	fs.StringVar(&delim, "d", ",", "the csv delimeter")
+
+	args = parseflags(fs, args)
+
+	if len(args) < 1 {
+		return usageError(argv0 + " conformance [-d delim] <dir,...>")
+	}
+
+	d, _ := utf8.DecodeRuneInString(delim)
+
+	if d == utf8.RuneError {
+		return errors.New("invalid utf-8 character for delimeter, must be a single character")
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+
+	failed := 0
+
+	for _, dir := range args {
+		results, err := conformance(dir, d)
+
+		if err != nil {
+			return err
+		}
+
+		for _, res := range results {
+			if dir != "." && len(args) > 1 {
+				res.Case = filepath.Join(dir, res.Case)
+			}
+
+			if !res.Pass {
+				failed++
+			}
+
+			if err := enc.Encode(res); err != nil {
+				return err
+			}
+		}
+	}
+
+	if failed > 0 {
+		return errors.New(strconv.Itoa(failed) + " conformance cases failed")
+	}
+	return nil
+}
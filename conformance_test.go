@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Conformance(t *testing.T) {
+	results, err := conformance(filepath.Join("testdata", "conformance"), ',')
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("unexpected number of results, expected=%d, got=%d\n", 2, len(results))
+	}
+
+	for _, res := range results {
+		if !res.Pass {
+			t.Fatalf("%s - %s\n", res.Case, res.Message)
+		}
+	}
+
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"users.csv":  "id,name\n1,Gordon\n",
+		"users.json": `{"id":2,"name":"Gordon"}` + "\n",
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err = conformance(dir, ',')
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("unexpected number of results, expected=%d, got=%d\n", 1, len(results))
+	}
+
+	if res := results[0]; res.Pass || res.Records || !res.Errors {
+		t.Fatalf("unexpected result %+v\n", res)
+	}
+}
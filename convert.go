@@ -0,0 +1,1044 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// converter converts the inputs given on the command line with the options
+// given, holding what is shared between each of the inputs, such as the
+// schema, the parser options, and the errors reported.
+type converter struct {
+	// rejected is the number of records across all inputs that were not
+	// converted because of an error. It is first so it is aligned for atomic
+	// access.
+	rejected int64
+
+	*options
+
+	argv0  string
+	inputs []string
+
+	log       *slog.Logger
+	s         *Schema
+	schemas   map[string]*Schema
+	popts     []ParserOption
+	outformat format
+	fetch     *Fetcher
+	tmp       *TempDir
+	njobs     int
+
+	ctx  context.Context
+	stop context.CancelFunc
+
+	ingestedAt time.Time
+
+	sqlite    *SQLiteDB
+	keys      *KeySet
+	errlog    *ErrorLog
+	errreport *Report
+	metrics   *Metrics
+	mf        Manifest
+}
+
+// newConverter returns a converter for the given inputs with the given
+// options, which should have been validated. The converter should be closed
+// once done with.
+func newConverter(argv0 string, o *options, inputs []string) (*converter, error) {
+	log, err := NewLogger(os.Stderr, argv0, o.logformat, o.level)
+
+	if err != nil {
+		return nil, errors.New("invalid -log-format: " + err.Error())
+	}
+
+	c := &converter{
+		options: o,
+		argv0:   argv0,
+		inputs:  inputs,
+		log:     log,
+		s:       NewSchema(),
+		schemas: make(map[string]*Schema),
+	}
+
+	// Plugins are loaded before the schema, since it can use the types they
+	// register.
+	for _, path := range c.plugins {
+		if err := LoadPlugin(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.schema != "" {
+		if c.fromjson {
+			if err := c.s.LoadJSONSchema(c.schema); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := loadschema(c.s, c.schema, c.allschema); err != nil {
+				return nil, schemaerror(log, err)
+			}
+		}
+	}
+
+	// Inputs with their own schema in -schema-dir use it instead of the one
+	// given via -s.
+	if c.schemadir != "" {
+		c.schemas, err = loadschemas(c.schemadir, inputs, c.allschema)
+
+		if err != nil {
+			return nil, schemaerror(log, err)
+		}
+	}
+
+	if c.fixed && c.schemadir == "" && len(c.s.Ranges()) == 0 {
+		return nil, errors.New("-fixed requires column ranges in the schema")
+	}
+
+	if c.maxopen == 0 {
+		c.maxopen = openlimit()
+	}
+
+	c.njobs = jobslimit(c.jobs, c.maxopen)
+
+	if c.jobs > c.njobs {
+		log.Warn("converting " + strconv.Itoa(c.njobs) + " files at once, to stay within the limit of " + strconv.Itoa(c.maxopen) + " open files")
+	}
+
+	c.tmp = NewTempDir(c.tmpdir, c.tmpsize)
+
+	if err := c.parseroptions(); err != nil {
+		return nil, err
+	}
+
+	c.fetch = NewFetcher(c.httpauth, c.httpretries)
+	c.outformat = c.outputformat()
+
+	if c.skipexist || c.neweronly {
+		convert, skipped, err := skipinputs(c.inputs, c.dest, c.outformat.ext, c.neweronly)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fname := range skipped {
+			log.Info("skipped, output is up to date", "file", inputname(fname))
+		}
+		c.inputs = convert
+	}
+
+	c.ingestedAt = time.Now().UTC()
+
+	// Interrupting the process cancels ctx, so each input stops being parsed,
+	// and its outputs are closed. Signals after the first are left to kill
+	// the process as usual.
+	c.ctx, c.stop = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-c.ctx.Done()
+		c.stop()
+	}()
+	return c, nil
+}
+
+// parseroptions sets the options of the parser shared by each of the inputs.
+// The options that depend on the preflight checks are added by prepare.
+func (c *converter) parseroptions() error {
+	c.popts = append(c.popts, WithOrder(c.order))
+
+	if c.normhdrs {
+		c.popts = append(c.popts, WithNormalizedHeaders())
+	}
+
+	if c.allowempty {
+		c.popts = append(c.popts, WithAllowEmpty())
+	}
+
+	c.popts = append(c.popts, WithInference(c.inference))
+
+	if c.noinfer {
+		c.popts = append(c.popts, WithNoInfer())
+	}
+
+	enckey := c.enckey
+
+	if enckey == "" {
+		enckey = os.Getenv(EncryptKeyEnv)
+	}
+
+	if enckey != "" {
+		key, err := ParseEncryptKey(enckey)
+
+		if err != nil {
+			return errors.New("invalid -encrypt-key: " + err.Error())
+		}
+		c.popts = append(c.popts, WithEncryptKey(key))
+	}
+
+	c.popts = append(c.popts, WithDuplicateHeaders(c.dupheaders))
+
+	if c.encoding != "" {
+		c.popts = append(c.popts, WithEncoding(c.encoding))
+	}
+
+	c.popts = append(c.popts, WithTempDir(c.tmp), WithRagged(c.ragged, c.overflow))
+
+	if c.skiplines > 0 || c.hdrprefix != "" {
+		c.popts = append(c.popts, WithPreamble(c.skiplines, c.hdrprefix))
+	}
+
+	if c.cmt != 0 {
+		c.popts = append(c.popts, WithComment(c.cmt))
+	}
+
+	if c.q != '"' || c.esc != 0 {
+		c.popts = append(c.popts, WithQuote(c.q, c.esc))
+	}
+
+	if c.lazyquotes {
+		c.popts = append(c.popts, WithLazyQuotes())
+	}
+
+	if c.fixed {
+		c.popts = append(c.popts, WithFixedWidth())
+	}
+
+	if c.workers > 1 {
+		c.popts = append(c.popts, WithWorkers(c.workers))
+	}
+
+	if c.skip > 0 {
+		c.popts = append(c.popts, WithSkip(c.skip))
+	}
+
+	if c.limit > 0 {
+		c.popts = append(c.popts, WithLimit(c.limit))
+	}
+
+	if c.sample != 0 {
+		seed := c.seed
+
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		c.popts = append(c.popts, WithSample(c.sample, seed))
+	}
+
+	// Inputs are parsed in turn when merged, otherwise the memory is split
+	// between the files converted at once.
+	if c.maxmem != "" {
+		max := c.maxmemsize
+
+		if !c.merge {
+			max /= int64(c.njobs)
+		}
+		c.popts = append(c.popts, WithMaxMemory(max))
+	}
+
+	if c.filter != "" {
+		e, err := CompileExpr(c.filter)
+
+		if err != nil {
+			return err
+		}
+		c.popts = append(c.popts, WithFilter(e))
+	}
+
+	if c.validatefile != "" {
+		v, err := LoadValidator(c.validatefile)
+
+		if err != nil {
+			return err
+		}
+		c.popts = append(c.popts, WithValidator(v))
+	}
+
+	if len(c.lookups) > 0 {
+		tables := make(map[string]*LookupTable)
+
+		for _, lookup := range c.lookups {
+			name, t, err := parselookup(lookup)
+
+			if err != nil {
+				return err
+			}
+			tables[name] = t
+		}
+		c.popts = append(c.popts, WithLookups(tables))
+	}
+	return nil
+}
+
+// outputformat returns the format of the outputs. Sinks such as -db have no
+// output file, so only the Encoder the records are written with is set.
+func (c *converter) outputformat() format {
+	outformat := formats[c.outfmt]
+
+	if c.yamlseq {
+		outformat.enc = NewYAMLEncoder(true)
+	}
+
+	if c.db != "" {
+		outformat = format{enc: NewPostgresEncoder(c.db, c.table)}
+	}
+
+	// The encoder is set once the database is created, after the preflight
+	// checks.
+	if c.sqlitedb != "" {
+		outformat = format{}
+	}
+
+	if c.outfmt == "sql" {
+		outformat.enc = NewSQLEncoder(c.table, c.sqlstyle)
+	}
+
+	if c.posturl != "" {
+		outformat = format{enc: NewWebhookEncoder(c.posturl, c.batch, c.fetch)}
+	}
+	return outformat
+}
+
+// close stops watching for interrupts, and removes the temporary files of the
+// conversion.
+func (c *converter) close() {
+	c.stop()
+	c.tmp.Remove()
+}
+
+// run converts each of the inputs, returning a RecordsError if any of their
+// records could not be converted.
+func (c *converter) run() error {
+	if c.estimate {
+		return runEstimate(c.argv0, c.inputs, c.d, c.schemafor, c.sheet, c.fetch, c.popts, c.metafields, c.ingestedAt)
+	}
+
+	if err := c.preflight(); err != nil {
+		return err
+	}
+
+	if err := c.prepare(); err != nil {
+		return err
+	}
+	return c.finish(c.convertall())
+}
+
+// schemafor returns the schema to parse the given input with.
+func (c *converter) schemafor(fname string) *Schema {
+	if fschema, ok := c.schemas[fname]; ok {
+		return fschema
+	}
+	return c.s
+}
+
+// preflight checks that each of the outputs can be written, and parses a
+// sample of each input if -preflight-sample is given.
+func (c *converter) preflight() error {
+	// The single output of -merge is only checked when it is created, since
+	// preflight checks an output for each input.
+	preext := c.outformat.ext
+
+	if c.merge {
+		preext = ""
+	}
+
+	preerrs := preflight(c.inputs, c.dest, preext)
+
+	// Outputs that are split or partitioned are only known once they are
+	// created, so they are checked then instead.
+	if c.noclobber && preext != "" && c.chunkrows == 0 && c.chunksize == 0 && c.partitionby == "" {
+		paths := make([]string, 0, len(c.inputs))
+
+		for _, fname := range c.inputs {
+			paths = append(paths, outputpath(c.dest, outputname(fname, preext)))
+		}
+		preerrs = append(preerrs, clobbered(paths)...)
+	}
+
+	if c.merge && c.noclobber {
+		preerrs = append(preerrs, clobbered([]string{outputpath(c.mergedest, c.mergename)})...)
+	}
+
+	if len(preerrs) > 0 {
+		for _, err := range preerrs {
+			c.log.Error(err.Error())
+		}
+		return errors.New("encountered errors during preflight")
+	}
+
+	// A sample of each input is parsed before any are converted, so a wrong
+	// delimiter, or schema, fails here rather than part way through.
+	if c.presample > 0 {
+		failed := 0
+
+		for _, fname := range c.inputs {
+			smp, err := samplefile(fname, c.d, c.schemafor(fname), c.sheet, c.fetch, c.popts, c.presample)
+
+			if err != nil {
+				c.log.Error(err.Error(), "file", inputname(fname))
+				failed++
+				continue
+			}
+
+			if smp.Rate() > c.premax {
+				c.log.Error(strconv.Itoa(smp.Failed)+" of "+strconv.Itoa(smp.Records)+" sampled records failed, first error "+smp.First, "file", inputname(fname))
+				failed++
+				continue
+			}
+			c.log.Debug("sampled", "file", inputname(fname), "records", smp.Records, "failed", smp.Failed)
+		}
+
+		if failed > 0 {
+			return errors.New("encountered errors during preflight")
+		}
+	}
+	return nil
+}
+
+// prepare creates what is written to across all of the inputs, such as the
+// -sqlite database and the -errors-json log, once the preflight checks have
+// passed.
+func (c *converter) prepare() error {
+	if c.sqlitedb != "" {
+		var err error
+
+		c.sqlite, err = CreateSQLite(c.sqlitedb)
+
+		if err != nil {
+			return err
+		}
+		c.outformat.enc = c.sqlite.Encoder()
+	}
+
+	if c.unique != "" {
+		c.keys = NewKeySet()
+
+		if c.uniquestate != "" {
+			var err error
+
+			c.keys, err = LoadKeySet(c.uniquestate)
+
+			if err != nil {
+				return err
+			}
+		}
+		c.popts = append(c.popts, WithUnique(c.unique, c.keys))
+	}
+
+	if c.dedupekey != "" {
+		var cols []string
+
+		if c.dedupekey != "*" {
+			cols = strings.Split(c.dedupekey, ",")
+		}
+		c.popts = append(c.popts, WithDedupe(cols, c.dedupekeep == "last"))
+	}
+
+	if c.sortby != "" {
+		c.popts = append(c.popts, WithSortBy(c.sortfield, c.sortdesc, c.sortmax))
+	}
+
+	if c.errorsjson != "" {
+		w, err := createoutput(c.errorsjson, c.fetch)
+
+		if err != nil {
+			return err
+		}
+		c.errlog = NewErrorLog(w)
+	}
+
+	if c.reportfile != "" {
+		c.errreport = NewReport()
+	}
+
+	if c.metricsfile != "" {
+		c.metrics = NewMetrics()
+	}
+	return nil
+}
+
+// errhandler returns the function to log the errors of the given input with.
+func (c *converter) errhandler(fname string) func(line, col int, msg string) {
+	return func(line, col int, msg string) {
+		c.log.Error(msg, "file", inputname(fname), "line", line, "col", col)
+	}
+}
+
+// parseropts returns the options of the parser for the given input.
+func (c *converter) parseropts(fname string) []ParserOption {
+	opts := inputopts(fname, c.popts)
+
+	if c.errlog != nil || c.errreport != nil {
+		opts = append(opts[:len(opts):len(opts)], WithErrorRecords(func(e ErrorRecord) {
+			e.File = inputname(fname)
+
+			if c.errlog != nil {
+				c.errlog.Write(e)
+			}
+
+			if c.errreport != nil {
+				c.errreport.Add(e)
+			}
+		}))
+	}
+	return opts
+}
+
+// output creates the output with the given name in the given destination,
+// returning the writer and EncoderFunc to parse into, along with the set of
+// outputs written to. If the output is split or partitioned, then the outputs
+// are created as records are encoded.
+func (c *converter) output(dest, outname string) (io.Writer, EncoderFunc, *outputSet, error) {
+	outs := newOutputSet(dest, c.fetch)
+	outs.noclobber = c.noclobber
+	outs.keep = c.checkpoint
+	outs.hash = c.manifest != ""
+
+	var out io.Writer = io.Discard
+
+	name := strings.TrimSuffix(outname, c.outformat.ext)
+	enc := c.outformat.enc
+
+	chunked := c.chunkrows > 0 || c.chunksize > 0
+
+	if (chunked || c.partitionby != "" || c.groupcols != nil) && enc == nil {
+		enc = NewJSONEncoder
+	}
+
+	// Records are counted as they are encoded into each output, which could
+	// be split or partitioned, so the counting is innermost.
+	if enc != nil {
+		enc = outs.counted(enc)
+	}
+
+	switch {
+	case c.partitionby != "":
+		partenc := enc
+
+		// Each of the inputs converted at once holds its own partitions
+		// open.
+		partjobs := c.njobs
+
+		if c.merge {
+			partjobs = 1
+		} else if len(c.inputs) < partjobs {
+			partjobs = len(c.inputs)
+		}
+
+		enc = NewPartitionEncoder(c.partitionby, partitionlimit(partjobs, c.maxopen), func(value string) (io.Writer, EncoderFunc, error) {
+			dir := c.partitionby + "=" + value + "/"
+
+			if chunked {
+				return io.Discard, NewSplitEncoder(partenc, outs.chunks(dir+name, c.outformat.ext), c.chunkrows, c.chunksize), nil
+			}
+
+			w, err := outs.create(dir + outname)
+
+			if err != nil {
+				return nil, nil, err
+			}
+			return w, partenc, nil
+		})
+	case chunked:
+		enc = NewSplitEncoder(enc, outs.chunks(name, c.outformat.ext), c.chunkrows, c.chunksize)
+	case c.outformat.ext != "":
+		w, err := outs.create(outname)
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		out = w
+	}
+
+	// Groups are only written once all of the records have been read, so
+	// grouping wraps the encoding of the groups, which could then be split or
+	// partitioned.
+	if c.groupcols != nil {
+		enc = NewGroupEncoder(c.groupcols, c.groupaggs, enc)
+	}
+	return out, enc, outs, nil
+}
+
+// writeschema writes the Avro schema for the output with the given name, if
+// the output is Avro.
+func (c *converter) writeschema(dest, name string, fields []Field) error {
+	if c.outfmt != "avro" {
+		return nil
+	}
+
+	avsc, err := createoutput(outputpath(dest, name+".avsc"), c.fetch)
+
+	if err != nil {
+		return err
+	}
+
+	err = WriteAvroSchema(avsc, name, fields)
+
+	if cerr := avsc.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// report prints the paths of the outputs written. Nothing is written for sinks
+// such as -db, so the name of the output is printed instead.
+func (c *converter) report(outs *outputSet, outname string) {
+	paths := outs.paths
+
+	if c.outformat.ext == "" {
+		paths = []string{outname}
+	}
+
+	for _, path := range paths {
+		fmt.Println(path)
+	}
+}
+
+// addmanifest adds the closed outputs of the given set to the manifest, if one
+// is being written.
+func (c *converter) addmanifest(outs *outputSet, sources []string) error {
+	if c.manifest == "" {
+		return nil
+	}
+
+	entries, err := outs.entries(sources...)
+
+	if err != nil {
+		return err
+	}
+
+	c.mf.Add(entries...)
+	return nil
+}
+
+// interrupted handles the conversion of the given input being interrupted,
+// returning the error to report. The outputs are marked as partial, unless
+// they are being checkpointed, in which case they are left as they are to be
+// resumed.
+func (c *converter) interrupted(fname string, outs *outputSet) error {
+	if outs != nil && !c.checkpoint {
+		if err := outs.Partial(); err != nil {
+			return err
+		}
+	}
+	return errors.New(inputname(fname) + ": interrupted")
+}
+
+// convertall converts each of the inputs, either into a single output if
+// merged, or each into their own, returning the number of errors logged.
+func (c *converter) convertall() int {
+	errs := make(chan error)
+
+	wg := sync.WaitGroup{}
+
+	if c.merge {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := c.mergeall(); err != nil {
+				if c.metrics != nil {
+					c.metrics.Failed()
+				}
+				errs <- err
+			}
+		}()
+	} else {
+		sems := make(chan struct{}, c.njobs)
+
+		wg.Add(len(c.inputs))
+
+		// Each input is only started once there is a job free for it, so a
+		// directory of thousands of files does not start them all at once.
+		go func() {
+			for _, fname := range c.inputs {
+				sems <- struct{}{}
+
+				go func(fname string) {
+					defer func() {
+						wg.Done()
+						<-sems
+					}()
+
+					if err := c.convert(fname); err != nil {
+						if c.metrics != nil {
+							c.metrics.Failed()
+						}
+						errs <- err
+					}
+				}(fname)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(errs)
+	}()
+
+	errc := 0
+
+	for err := range errs {
+		c.log.Error(err.Error())
+		errc++
+	}
+	return errc
+}
+
+// mergeall converts all of the inputs into a single output, in the order they
+// were given. The header of each input is read first, so the fields of the
+// output can be known before any records are written, and then each input is
+// converted in turn, so only one is open at a time.
+func (c *converter) mergeall() error {
+	open := func(fname string, errh func(line, col int, msg string)) (*Parser, io.Closer, error) {
+		f, err := openinput(fname, c.sheet, c.d, c.fetch)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		opts := c.parseropts(fname)
+
+		if c.metafields != nil {
+			opts = append(opts[:len(opts):len(opts)], WithMeta(inputname(fname), c.metafields, c.ingestedAt))
+		}
+
+		p, err := NewParser(f, c.d, c.schemafor(fname), errh, opts...)
+
+		if err != nil {
+			f.Close()
+			return nil, nil, errors.New(inputname(fname) + ": " + err.Error())
+		}
+		return p, f, nil
+	}
+
+	inputs := make([][]Field, 0, len(c.inputs))
+
+	for _, fname := range c.inputs {
+		// Any errors are reported once the input is converted.
+		p, f, err := open(fname, func(_, _ int, _ string) {})
+
+		if err != nil {
+			return err
+		}
+
+		inputs = append(inputs, p.fields())
+		f.Close()
+	}
+
+	out, enc, outs, err := c.output(c.mergedest, c.mergename)
+
+	if err != nil {
+		return err
+	}
+
+	defer outs.Abort()
+
+	name := strings.TrimSuffix(c.mergename, filepath.Ext(c.mergename))
+
+	fields := mergefields(inputs)
+
+	if err := c.writeschema(c.mergedest, name, fields); err != nil {
+		return err
+	}
+
+	var merged Encoder
+
+	if enc != nil {
+		merged, err = enc(out, name, fields)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	emitted := 0
+
+	convert := func(fname string) error {
+		p, f, err := open(fname, c.errhandler(fname))
+
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		if merged != nil {
+			WithEncoder(sharedEncoder(merged), name)(p)
+		}
+
+		start := time.Now()
+
+		c.log.Debug("converting", "file", inputname(fname), "output", c.mergename)
+
+		if err := p.ParseContext(c.ctx, out); err != nil {
+			if c.ctx.Err() != nil {
+				if merged != nil {
+					merged.Close()
+				}
+				return c.interrupted(c.mergename, outs)
+			}
+			return err
+		}
+		atomic.AddInt64(&c.rejected, int64(p.errc))
+
+		// JSON is written directly by each parser, so the records are
+		// counted by them rather than an Encoder.
+		emitted += p.emitted
+
+		if c.metrics != nil {
+			c.metrics.Converted(time.Since(start), p.emitted, p.errc)
+		}
+
+		c.log.Debug("converted", "file", inputname(fname), "records", p.emitted, "errors", p.errc, "delimiter", strconv.QuoteRune(p.csv.Comma), "duration", time.Since(start))
+		return nil
+	}
+
+	for _, fname := range c.inputs {
+		if err := convert(fname); err != nil {
+			return err
+		}
+	}
+
+	if merged != nil {
+		if err := merged.Close(); err != nil {
+			return err
+		}
+	}
+
+	if err := outs.Close(); err != nil {
+		return err
+	}
+
+	sources := make([]string, 0, len(c.inputs))
+
+	for _, fname := range c.inputs {
+		sources = append(sources, inputname(fname))
+	}
+
+	if merged == nil {
+		outs.written(emitted)
+	}
+
+	if err := c.addmanifest(outs, sources); err != nil {
+		return err
+	}
+
+	c.report(outs, c.mergename)
+	return nil
+}
+
+// convert converts the given input into its own output in -o.
+func (c *converter) convert(fname string) error {
+	if c.ctx.Err() != nil {
+		return c.interrupted(fname, nil)
+	}
+
+	start := time.Now()
+
+	c.log.Debug("converting", "file", inputname(fname))
+
+	f, err := openinput(fname, c.sheet, c.d, c.fetch)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	outname := outputname(fname, c.outformat.ext)
+
+	cppath := checkpointpath(outputpath(c.dest, outname))
+
+	var resume *Checkpoint
+
+	if c.checkpoint {
+		resume, err = LoadCheckpoint(cppath)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	var (
+		out  io.Writer
+		enc  EncoderFunc
+		outs *outputSet
+	)
+
+	if resume != nil {
+		outs = newOutputSet(c.dest, c.fetch)
+		outs.noclobber = c.noclobber
+		outs.keep = true
+
+		out, err = outs.reopen(outname, resume.Size)
+	} else {
+		out, enc, outs, err = c.output(c.dest, outname)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Objects are only complete once they are closed, so the outputs are
+	// closed explicitly once parsed to report any error. If the conversion
+	// fails first, the outputs are aborted.
+	defer outs.Abort()
+
+	name := strings.TrimSuffix(outname, c.outformat.ext)
+
+	opts := c.parseropts(fname)
+
+	if enc != nil {
+		opts = append(opts[:len(opts):len(opts)], WithEncoder(enc, name))
+	}
+
+	if c.metafields != nil {
+		// Limit the capacity of the slice so each goroutine appends to its
+		// own copy.
+		opts = append(opts[:len(opts):len(opts)], WithMeta(inputname(fname), c.metafields, c.ingestedAt))
+	}
+
+	if c.checkpoint {
+		// Checkpoints are only used for json written to disk, so the output
+		// is always a file.
+		outf := out.(*os.File)
+
+		opts = append(opts[:len(opts):len(opts)], WithCheckpoint(c.cpevery, func(cp Checkpoint) error {
+			if err := outf.Sync(); err != nil {
+				return err
+			}
+
+			size, err := outf.Seek(0, io.SeekCurrent)
+
+			if err != nil {
+				return err
+			}
+
+			cp.Size = size
+			return cp.Save(cppath)
+		}))
+
+		if resume != nil {
+			opts = append(opts, WithResume(resume))
+		}
+	}
+
+	p, err := NewParser(f, c.d, c.schemafor(fname), c.errhandler(fname), opts...)
+
+	if err != nil {
+		return errors.New(inputname(fname) + ": " + err.Error())
+	}
+
+	if err := c.writeschema(c.dest, name, p.fields()); err != nil {
+		return err
+	}
+
+	if err := p.ParseContext(c.ctx, out); err != nil {
+		if c.ctx.Err() != nil {
+			err = c.interrupted(fname, outs)
+		}
+		return err
+	}
+
+	atomic.AddInt64(&c.rejected, int64(p.errc))
+
+	if err := outs.Close(); err != nil {
+		return err
+	}
+
+	if c.checkpoint {
+		if err := os.Remove(cppath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	// JSON is written directly by the parser, so the records are counted by
+	// it rather than an Encoder.
+	if enc == nil {
+		outs.written(p.emitted)
+	}
+
+	if err := c.addmanifest(outs, []string{inputname(fname)}); err != nil {
+		return err
+	}
+	c.report(outs, outname)
+
+	if c.metrics != nil {
+		c.metrics.Converted(time.Since(start), p.emitted, p.errc)
+	}
+
+	c.log.Debug("converted", "file", inputname(fname), "records", p.emitted, "errors", p.errc, "delimiter", strconv.QuoteRune(p.csv.Comma), "duration", time.Since(start))
+	return nil
+}
+
+// finish closes what was written to across all of the inputs, and writes the
+// report, metrics, and manifest, given the number of errors logged during
+// conversion.
+func (c *converter) finish(errc int) error {
+	if c.keys != nil {
+		if err := c.keys.Close(); err != nil {
+			c.log.Error(err.Error())
+			errc++
+		}
+	}
+
+	if c.sqlite != nil {
+		if err := c.sqlite.Close(); err != nil {
+			c.log.Error(err.Error())
+			errc++
+		}
+	}
+
+	if c.errlog != nil {
+		if err := c.errlog.Close(); err != nil {
+			c.log.Error(err.Error())
+			errc++
+		}
+	}
+
+	if c.errreport != nil {
+		if err := writereport(c.reportfile, c.errreport, c.fetch); err != nil {
+			c.log.Error(err.Error())
+			errc++
+		}
+	}
+
+	// Metrics are written even if some of the inputs failed, since failures
+	// are what they are watched for.
+	if c.metrics != nil {
+		if err := writemetrics(c.metricsfile, c.metrics); err != nil {
+			c.log.Error(err.Error())
+			errc++
+		}
+	}
+
+	// The manifest is written even if some of the inputs failed, listing the
+	// outputs that were completed.
+	if c.manifest != "" {
+		if err := writemanifest(c.manifest, &c.mf, c.fetch); err != nil {
+			c.log.Error(err.Error())
+			errc++
+		}
+	}
+
+	if errc > 0 {
+		return errors.New("encountered errors during generation")
+	}
+
+	if c.rejected > 0 {
+		return RecordsError{N: int(c.rejected)}
+	}
+	return nil
+}
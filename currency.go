@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rate is the exchange rate between two currencies on a given date. The date
+// is empty if the rate is not for a specific date.
+type rate struct {
+	date string
+	rate float64
+}
+
+// Rates is a table of exchange rates between currencies, loaded from either a
+// CSV or JSON file.
+type Rates struct {
+	pairs map[string][]rate // rates for each pair, ordered by date
+}
+
+type ratesRecord struct {
+	From string  `json:"from"`
+	To   string  `json:"to"`
+	Rate float64 `json:"rate"`
+	Date string  `json:"date"`
+}
+
+// LoadRates loads the exchange rates from the given file. If the file has a
+// .json extension, then it should be an array of objects with the fields from,
+// to, rate, and optionally date. Otherwise it should be a CSV file with the
+// same columns.
+func LoadRates(fname string) (*Rates, error) {
+	f, err := os.Open(fname)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	recs := make([]ratesRecord, 0)
+
+	if strings.HasSuffix(fname, ".json") {
+		if err := json.NewDecoder(f).Decode(&recs); err != nil {
+			return nil, errors.New(fname + ": " + err.Error())
+		}
+	} else {
+		rows, err := csv.NewReader(f).ReadAll()
+
+		if err != nil {
+			return nil, errors.New(fname + ": " + err.Error())
+		}
+
+		if len(rows) == 0 {
+			return nil, errors.New(fname + ": no header")
+		}
+
+		idx := make(map[string]int)
+
+		for i, hdr := range rows[0] {
+			idx[strings.ToLower(strings.TrimSpace(hdr))] = i
+		}
+
+		for _, col := range []string{"from", "to", "rate"} {
+			if _, ok := idx[col]; !ok {
+				return nil, errors.New(fname + ": missing column " + col)
+			}
+		}
+
+		for i, row := range rows[1:] {
+			n, err := strconv.ParseFloat(row[idx["rate"]], 64)
+
+			if err != nil {
+				return nil, fmt.Errorf("%s,%d: invalid rate %q", fname, i+2, row[idx["rate"]])
+			}
+
+			rec := ratesRecord{
+				From: row[idx["from"]],
+				To:   row[idx["to"]],
+				Rate: n,
+			}
+
+			if j, ok := idx["date"]; ok {
+				rec.Date = row[j]
+			}
+			recs = append(recs, rec)
+		}
+	}
+
+	r := &Rates{
+		pairs: make(map[string][]rate),
+	}
+
+	for _, rec := range recs {
+		if rec.Rate <= 0 {
+			return nil, fmt.Errorf("%s: rate for %s->%s must be positive", fname, rec.From, rec.To)
+		}
+
+		key := strings.ToUpper(rec.From) + "->" + strings.ToUpper(rec.To)
+
+		r.pairs[key] = append(r.pairs[key], rate{date: rec.Date, rate: rec.Rate})
+	}
+
+	for _, rates := range r.pairs {
+		// Dates are expected to be in the form of YYYY-MM-DD, so they can be
+		// ordered as strings.
+		sort.SliceStable(rates, func(i, j int) bool {
+			return rates[i].date < rates[j].date
+		})
+	}
+	return r, nil
+}
+
+// lookup returns the rate for the given pair on or before the given date. If
+// date is empty, then the latest rate is returned.
+func (r *Rates) lookup(key, date string) (float64, bool) {
+	rates, ok := r.pairs[key]
+
+	if !ok {
+		return 0, false
+	}
+
+	if date == "" {
+		return rates[len(rates)-1].rate, true
+	}
+
+	i := sort.Search(len(rates), func(i int) bool {
+		return rates[i].date > date
+	})
+
+	if i == 0 {
+		return 0, false
+	}
+	return rates[i-1].rate, true
+}
+
+// Rate returns the rate to convert from one currency to another, on or before
+// the given date. If there is no rate for the pair, then the inverse of the
+// rate for the reverse pair is used.
+func (r *Rates) Rate(from, to, date string) (float64, error) {
+	from = strings.ToUpper(from)
+	to = strings.ToUpper(to)
+
+	if n, ok := r.lookup(from+"->"+to, date); ok {
+		return n, nil
+	}
+
+	if n, ok := r.lookup(to+"->"+from, date); ok {
+		return 1 / n, nil
+	}
+
+	if date != "" {
+		return 0, errors.New("no " + from + "->" + to + " rate on or before " + date)
+	}
+	return 0, errors.New("no " + from + "->" + to + " rate")
+}
+
+// Conversion converts a monetary value from one currency to another. If Date
+// is set, then the rate used is for the date in that column.
+type Conversion struct {
+	From  string
+	To    string
+	Date  string
+	Rates *Rates
+
+	at int // position of the conversion in the schema record's transforms
+}
+
+// parseconvert parses the given conversion in the form of FROM->TO@file. The
+// file is relative to the given directory.
+func parseconvert(s, dir string) (*Conversion, error) {
+	i := strings.Index(s, "@")
+
+	if i < 0 {
+		return nil, errors.New("convert option missing rates file, expected FROM->TO@file")
+	}
+
+	pair, fname := s[:i], s[i+1:]
+
+	j := strings.Index(pair, "->")
+
+	if j < 0 {
+		return nil, errors.New("invalid currency pair " + pair + ", expected FROM->TO")
+	}
+
+	if !filepath.IsAbs(fname) {
+		fname = filepath.Join(dir, fname)
+	}
+
+	rates, err := LoadRates(fname)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conversion{
+		From:  pair[:j],
+		To:    pair[j+2:],
+		Rates: rates,
+	}, nil
+}
+
+// datekey returns the date of the given Value for looking up a rate. Times are
+// formatted as YYYY-MM-DD, anything else is used as is.
+func datekey(v Value, raw string) string {
+	if t, ok := v.(*Time); ok {
+		return t.t.Format("2006-01-02")
+	}
+	return raw
+}
+
+// Apply converts the given numeric Value using the rate for the given date.
+func (c *Conversion) Apply(v Value, date string) (Value, error) {
+	n, ok := numeric(v)
+
+	if !ok {
+		return nil, errors.New("cannot convert non-numeric value")
+	}
+
+	r, err := c.Rates.Rate(c.From, c.To, date)
+
+	if err != nil {
+		return nil, err
+	}
+	return &Float{n: n * r}, nil
+}
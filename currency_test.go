@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Rates(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "rates.json")
+
+	rates := `[
+	{"from": "EUR", "to": "USD", "rate": 1.1, "date": "2021-12-01"},
+	{"from": "EUR", "to": "USD", "rate": 1.2, "date": "2021-12-06"},
+	{"from": "USD", "to": "GBP", "rate": 0.8}
+]`
+
+	if err := os.WriteFile(fname, []byte(rates), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := LoadRates(fname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		from, to, date string
+		expected       float64
+	}{
+		{"EUR", "USD", "2021-12-01", 1.1},
+		{"EUR", "USD", "2021-12-05", 1.1},
+		{"eur", "usd", "2021-12-06", 1.2},
+		{"EUR", "USD", "", 1.2},
+		{"GBP", "USD", "", 1.25},
+	}
+
+	for i, test := range tests {
+		n, err := r.Rate(test.from, test.to, test.date)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if n != test.expected {
+			t.Fatalf("tests[%d] - unexpected rate, expected=%v, got=%v\n", i, test.expected, n)
+		}
+	}
+
+	if _, err := r.Rate("EUR", "USD", "2021-11-30"); err == nil {
+		t.Fatal("expected error for date before any rate")
+	}
+
+	if _, err := r.Rate("EUR", "JPY", ""); err == nil {
+		t.Fatal("expected error for unknown pair")
+	}
+}
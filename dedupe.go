@@ -0,0 +1,607 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// dedupekey is the hash of the key of a row being deduplicated. Only the hash
+// is kept so the memory used for each key is fixed, regardless of how long the
+// key is.
+type dedupekey [16]byte
+
+const (
+	// dedupeKeyMem is roughly the memory used by each key held in memory,
+	// including the overhead of the map it is held in.
+	dedupeKeyMem = 64
+
+	// dedupeMaxRuns is the number of runs of keys spilled to disk before they
+	// are merged into one, so each key is only searched for in a few runs.
+	dedupeMaxRuns = 8
+)
+
+// dedupeset is the set of keys seen when deduplicating. Keys are held in
+// memory until roughly max bytes of them have been added, at which point they
+// are sorted and spilled to a temporary file as a run. Keys are then searched
+// for in each run on disk too. If max is 0 then keys are only ever held in
+// memory.
+type dedupeset struct {
+	keys map[dedupekey]struct{}
+	max  int64
+	tmp  *TempDir
+	own  bool // whether the TempDir was created for the set
+
+	runs []*dedupeRun
+}
+
+// dedupeRun is a sorted run of keys spilled to disk.
+type dedupeRun struct {
+	f *TempFile
+	n int64
+}
+
+func newDedupeSet() *dedupeset {
+	return &dedupeset{
+		keys: make(map[dedupekey]struct{}),
+	}
+}
+
+// has searches the run for the given key.
+func (r *dedupeRun) has(key dedupekey) (bool, error) {
+	var (
+		buf dedupekey
+		err error
+	)
+
+	i := sort.Search(int(r.n), func(i int) bool {
+		if err != nil {
+			return true
+		}
+
+		_, err = r.f.ReadAt(buf[:], int64(i)*int64(len(buf)))
+
+		return bytes.Compare(buf[:], key[:]) >= 0
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	if int64(i) == r.n {
+		return false, nil
+	}
+
+	if _, err := r.f.ReadAt(buf[:], int64(i)*int64(len(buf))); err != nil {
+		return false, err
+	}
+	return buf == key, nil
+}
+
+// add adds the given key to the set, returning false if it was already in the
+// set.
+func (s *dedupeset) add(key dedupekey) (bool, error) {
+	if _, ok := s.keys[key]; ok {
+		return false, nil
+	}
+
+	for _, run := range s.runs {
+		ok, err := run.has(key)
+
+		if err != nil {
+			return false, err
+		}
+
+		if ok {
+			return false, nil
+		}
+	}
+
+	s.keys[key] = struct{}{}
+
+	if s.max > 0 && int64(len(s.keys))*dedupeKeyMem >= s.max {
+		if err := s.spill(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// spill writes the keys held in memory to disk as a sorted run, merging the
+// runs into one if there are too many.
+func (s *dedupeset) spill() error {
+	if s.tmp == nil {
+		s.tmp = NewTempDir("", 0)
+		s.own = true
+	}
+
+	keys := make([]dedupekey, 0, len(s.keys))
+
+	for key := range s.keys {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+
+	f, err := s.tmp.Create()
+
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+
+	for _, key := range keys {
+		w.Write(key[:])
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Remove()
+		return err
+	}
+
+	s.runs = append(s.runs, &dedupeRun{f: f, n: int64(len(keys))})
+	s.keys = make(map[dedupekey]struct{})
+
+	if len(s.runs) > dedupeMaxRuns {
+		return s.merge()
+	}
+	return nil
+}
+
+// merge merges all of the runs on disk into a single run. The keys in each run
+// are distinct, since a key is only added if it is not already in the set.
+func (s *dedupeset) merge() error {
+	f, err := s.tmp.Create()
+
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+
+	readers := make([]*bufio.Reader, len(s.runs))
+	heads := make([]*dedupekey, len(s.runs))
+
+	next := func(i int) error {
+		var key dedupekey
+
+		if _, err := io.ReadFull(readers[i], key[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				heads[i] = nil
+				return nil
+			}
+			return err
+		}
+
+		heads[i] = &key
+		return nil
+	}
+
+	var n int64
+
+	for i, run := range s.runs {
+		readers[i] = bufio.NewReader(io.NewSectionReader(run.f, 0, run.n*int64(len(dedupekey{}))))
+
+		if err := next(i); err != nil {
+			f.Remove()
+			return err
+		}
+		n += run.n
+	}
+
+	for {
+		min := -1
+
+		for i, key := range heads {
+			if key != nil && (min < 0 || bytes.Compare(key[:], heads[min][:]) < 0) {
+				min = i
+			}
+		}
+
+		if min < 0 {
+			break
+		}
+
+		w.Write(heads[min][:])
+
+		if err := next(min); err != nil {
+			f.Remove()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Remove()
+		return err
+	}
+
+	for _, run := range s.runs {
+		run.f.Remove()
+	}
+
+	s.runs = []*dedupeRun{{f: f, n: n}}
+	return nil
+}
+
+// remove removes the runs spilled to disk, along with the keys held in memory.
+func (s *dedupeset) remove() {
+	s.keys = make(map[dedupekey]struct{})
+
+	for _, run := range s.runs {
+		run.f.Remove()
+	}
+
+	s.runs = nil
+
+	if s.own {
+		s.tmp.Remove()
+		s.tmp = nil
+		s.own = false
+	}
+}
+
+// WithDedupe configures the Parser to drop records with the same values for
+// the given columns as a record already seen. If no columns are given, then
+// the whole row is used as the key. If last is true, then the last occurrence
+// of each key is kept instead of the first, which requires the input to be
+// spilled to disk so it can be read a second time.
+//
+// Unlike WithUnique, duplicate records are dropped silently.
+func WithDedupe(cols []string, last bool) ParserOption {
+	return func(p *Parser) {
+		p.dedupe = true
+		p.dedupecols = cols
+		p.dedupelast = last
+		p.seen = newDedupeSet()
+	}
+}
+
+// dedupekey returns the key of the given fields.
+func (p *Parser) dedupekey(fields []string) dedupekey {
+	h := sha256.New()
+
+	var n [binary.MaxVarintLen64]byte
+
+	write := func(s string) {
+		h.Write(n[:binary.PutUvarint(n[:], uint64(len(s)))])
+		h.Write([]byte(s))
+	}
+
+	if len(p.dedupecols) == 0 {
+		for _, fld := range fields {
+			write(fld)
+		}
+	} else {
+		for _, col := range p.dedupecols {
+			write(p.column(fields, col))
+		}
+	}
+
+	var key dedupekey
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// checkdedupe checks that the columns being deduplicated on are in the
+// headers.
+func (p *Parser) checkdedupe() error {
+	for _, col := range p.dedupecols {
+		if _, ok := p.hdridx[col]; !ok {
+			return errors.New("cannot dedupe on unknown column " + col)
+		}
+	}
+	return nil
+}
+
+// duplicate returns true if the key of the given row has already been seen,
+// adding it if not.
+func (p *Parser) duplicate(rw *row) (bool, error) {
+	ok, err := p.seen.add(p.dedupekey(rw.fields))
+
+	if err != nil {
+		return false, err
+	}
+	return !ok, nil
+}
+
+// writerow writes the line of the given row, and its fields, to w, each
+// prefixed with its length.
+func writerow(w *bufio.Writer, rw *row) error {
+	var n [binary.MaxVarintLen64]byte
+
+	w.Write(n[:binary.PutUvarint(n[:], uint64(rw.pos.line))])
+	w.Write(n[:binary.PutUvarint(n[:], uint64(len(rw.fields)))])
+
+	for _, fld := range rw.fields {
+		w.Write(n[:binary.PutUvarint(n[:], uint64(len(fld)))])
+
+		if _, err := w.WriteString(fld); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readrow reads a row written via writerow from r.
+func readrow(r *bufio.Reader) (*row, error) {
+	line, err := binary.ReadUvarint(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := binary.ReadUvarint(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, 0, n)
+
+	for i := uint64(0); i < n; i++ {
+		l, err := binary.ReadUvarint(r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		b := make([]byte, l)
+
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		fields = append(fields, string(b))
+	}
+
+	return &row{
+		fields: fields,
+		pos:    pos{line: int(line), col: 1},
+	}, nil
+}
+
+// reverseReader reads the fixed size records of a file from the last to the
+// first, reading the file in blocks from its end.
+type reverseReader struct {
+	r    io.ReaderAt
+	off  int64 // offset of the block held in buf
+	size int
+	blk  []byte
+	buf  []byte
+}
+
+// reverseBlockSize is roughly the size of each block read by a reverseReader.
+const reverseBlockSize = 64 * 1024
+
+// newReverseReader returns a reverseReader for the n records of the given size
+// in r.
+func newReverseReader(r io.ReaderAt, n int64, size int) *reverseReader {
+	return &reverseReader{
+		r:    r,
+		off:  n * int64(size),
+		size: size,
+		blk:  make([]byte, (reverseBlockSize/size+1)*size),
+	}
+}
+
+// next returns the record before the last one returned, or io.EOF once the
+// first record has been returned. The record is only valid until the next
+// call to next.
+func (r *reverseReader) next() ([]byte, error) {
+	if len(r.buf) == 0 {
+		if r.off == 0 {
+			return nil, io.EOF
+		}
+
+		n := int64(len(r.blk))
+
+		if n > r.off {
+			n = r.off
+		}
+
+		r.off -= n
+		r.buf = r.blk[:n]
+
+		if m, err := r.r.ReadAt(r.buf, r.off); m < len(r.buf) {
+			return nil, err
+		}
+	}
+
+	rec := r.buf[len(r.buf)-r.size:]
+	r.buf = r.buf[:len(r.buf)-r.size]
+	return rec, nil
+}
+
+// lastrows returns a function that returns the rows read via read, keeping
+// only the last occurrence of each key. The rows are first spilled to a
+// temporary file, along with the key of each row. The keys are then read back
+// from the last to the first, so the first time a key is seen is its last
+// occurrence, and the position of each of these is recorded. Keys are seen via
+// the dedupeset of the Parser, so they are spilled to disk beyond the memory
+// given to WithMaxMemory. Then the rows are read back, and only those at the
+// recorded positions are returned. The returned done function removes the
+// temporary files.
+func (p *Parser) lastrows(read func() (*row, error)) (func() (*row, error), func(), error) {
+	tmp := p.tmp
+
+	if tmp == nil {
+		tmp = NewTempDir("", 0)
+	}
+
+	files := make([]*TempFile, 0, 3)
+
+	done := func() {
+		for _, f := range files {
+			f.Remove()
+		}
+
+		if tmp != p.tmp {
+			tmp.Remove()
+		}
+	}
+
+	create := func() (*TempFile, error) {
+		f, err := tmp.Create()
+
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, f)
+		return f, nil
+	}
+
+	f, err := create()
+
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+
+	keysf, err := create()
+
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	kw := bufio.NewWriter(keysf)
+
+	var n int64
+
+	for {
+		rw, err := read()
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				done()
+				return nil, nil, err
+			}
+			break
+		}
+
+		if rw.err != nil {
+			p.err(rw.pos, rw.err)
+			continue
+		}
+
+		if err := writerow(w, rw); err != nil {
+			done()
+			return nil, nil, err
+		}
+
+		key := p.dedupekey(rw.fields)
+
+		if _, err := kw.Write(key[:]); err != nil {
+			done()
+			return nil, nil, err
+		}
+		n++
+	}
+
+	if err := w.Flush(); err != nil {
+		done()
+		return nil, nil, err
+	}
+
+	if err := kw.Flush(); err != nil {
+		done()
+		return nil, nil, err
+	}
+
+	// The positions of the last occurrences are found from the last row to
+	// the first, so they are written in descending order.
+	posf, err := create()
+
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+
+	pw := bufio.NewWriter(posf)
+	keys := newReverseReader(keysf, n, len(dedupekey{}))
+
+	var (
+		npos int64
+		buf  [8]byte
+	)
+
+	for i := n - 1; i >= 0; i-- {
+		b, err := keys.next()
+
+		if err != nil {
+			done()
+			return nil, nil, err
+		}
+
+		var key dedupekey
+		copy(key[:], b)
+
+		ok, err := p.seen.add(key)
+
+		if err != nil {
+			done()
+			return nil, nil, err
+		}
+
+		if ok {
+			binary.BigEndian.PutUint64(buf[:], uint64(i))
+
+			if _, err := pw.Write(buf[:]); err != nil {
+				done()
+				return nil, nil, err
+			}
+			npos++
+		}
+	}
+
+	if err := pw.Flush(); err != nil {
+		done()
+		return nil, nil, err
+	}
+
+	// The keys are no longer needed once the positions are known.
+	p.seen.remove()
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		done()
+		return nil, nil, err
+	}
+
+	r := bufio.NewReader(f)
+	positions := newReverseReader(posf, npos, len(buf))
+
+	var i int64
+
+	rows := func() (*row, error) {
+		b, err := positions.next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		next := int64(binary.BigEndian.Uint64(b))
+
+		for ; i <= next; i++ {
+			rw, err := readrow(r)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if i == next {
+				i++
+				return rw, nil
+			}
+		}
+		return nil, io.EOF
+	}
+	return rows, done, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Dedupe(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-dedupe-key", "id"}, "1:home,2:about,3:home"},
+		{[]string{"-dedupe-key", "id", "-dedupe-keep", "last"}, "1:pricing,3:home,2:about"},
+		{[]string{"-dedupe-key", "*"}, "1:home,2:about,1:pricing,3:home"},
+		{[]string{"-dedupe-key", "name,page", "-dedupe-keep", "last"}, "1:home,1:pricing,3:home,2:about"},
+		{[]string{"-dedupe-key", "id", "-dedupe-keep", "last", "-limit", "2"}, "1:pricing,3:home"},
+		{[]string{"-dedupe-key", "id", "-max-memory", "1"}, "1:home,2:about,3:home"},
+		{[]string{"-dedupe-key", "id", "-dedupe-keep", "last", "-max-memory", "1"}, "1:pricing,3:home,2:about"},
+		{[]string{"-dedupe-key", "*", "-max-memory", "1", "-sort-by", "id"}, "1:home,1:pricing,2:about,3:home"},
+	}
+
+	for i, test := range tests {
+		dir := t.TempDir()
+
+		args := append([]string{"csv2json", "-o", dir, "-tmpdir", dir}, test.args...)
+
+		if err := run(append(args, filepath.Join("testdata", "visits.csv"))); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		f, err := os.Open(filepath.Join(dir, "visits.json"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		visits := make([]string, 0)
+
+		sc := bufio.NewScanner(f)
+
+		for sc.Scan() {
+			var rec map[string]interface{}
+
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				t.Fatal(err)
+			}
+			visits = append(visits, fmt.Sprint(rec["id"])+":"+fmt.Sprint(rec["page"]))
+		}
+		f.Close()
+
+		if s := strings.Join(visits, ","); s != test.expected {
+			t.Errorf("tests[%d] - unexpected records, expected=%q, got=%q\n", i, test.expected, s)
+		}
+
+		tmps, _ := filepath.Glob(filepath.Join(dir, "csv2json-*", "*"))
+
+		if len(tmps) != 0 {
+			t.Errorf("tests[%d] - expected temporary files to be removed, got=%v\n", i, tmps)
+		}
+	}
+}
+
+func Test_DedupeLastSpill(t *testing.T) {
+	dir := t.TempDir()
+
+	// Enough keys to be spilled many times over, and enough rows for their
+	// keys to be read back across many blocks.
+	var buf strings.Builder
+
+	buf.WriteString("id,page\n")
+
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "%d,%d\n", i%3000, i)
+	}
+
+	events := filepath.Join(dir, "events.csv")
+
+	if err := os.WriteFile(events, []byte(buf.String()), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"csv2json", "-o", dir, "-tmpdir", dir, "-dedupe-key", "id", "-dedupe-keep", "last", "-max-memory", "1K", events}
+
+	if err := run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "events.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+
+	// The last occurrence of each id is in the last 3000 rows, in order.
+	page := 7000
+
+	for sc.Scan() {
+		var rec map[string]int
+
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+
+		if rec["page"] != page || rec["id"] != page%3000 {
+			t.Fatalf("unexpected record, expected id=%d page=%d, got=%v\n", page%3000, page, rec)
+		}
+		page++
+	}
+
+	if page != 10000 {
+		t.Errorf("unexpected number of records, expected=%d, got=%d\n", 3000, page-7000)
+	}
+
+	tmps, _ := filepath.Glob(filepath.Join(dir, "csv2json-*", "*"))
+
+	if len(tmps) != 0 {
+		t.Errorf("expected temporary files to be removed, got=%v\n", tmps)
+	}
+}
+
+func Test_DedupeUnknownColumn(t *testing.T) {
+	dir := t.TempDir()
+
+	args := []string{
+		"csv2json",
+		"-o", dir,
+		"-dedupe-key", "email",
+		filepath.Join("testdata", "visits.csv"),
+	}
+
+	if err := run(args); err == nil || !strings.Contains(err.Error(), "encountered errors") {
+		t.Fatalf("expected error for unknown column, got=%v\n", err)
+	}
+}
+
+func Test_DedupeSet(t *testing.T) {
+	s := newDedupeSet()
+	s.max = dedupeKeyMem * 10
+	s.tmp = NewTempDir(t.TempDir(), 0)
+
+	defer s.remove()
+
+	keys := make([]dedupekey, 0, 1000)
+
+	for i := 0; i < cap(keys); i++ {
+		var key dedupekey
+		copy(key[:], fmt.Sprintf("%016d", i*7919))
+		keys = append(keys, key)
+	}
+
+	for i, key := range keys {
+		ok, err := s.add(key)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !ok {
+			t.Fatalf("keys[%d] - unexpected duplicate\n", i)
+		}
+	}
+
+	if len(s.runs) == 0 || len(s.runs) > dedupeMaxRuns {
+		t.Errorf("unexpected runs, expected between 1 and %d, got=%d\n", dedupeMaxRuns, len(s.runs))
+	}
+
+	for i, key := range keys {
+		ok, err := s.add(key)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ok {
+			t.Fatalf("keys[%d] - expected duplicate\n", i)
+		}
+	}
+
+	var key dedupekey
+	copy(key[:], "not-a-key-at-all")
+
+	if ok, err := s.add(key); err != nil || !ok {
+		t.Errorf("unexpected result for new key, expected=true, got=%v, err=%v\n", ok, err)
+	}
+}
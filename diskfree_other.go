@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import "errors"
+
+// diskfree is not supported on this platform, so the disk space check is
+// skipped.
+func diskfree(dir string) (int64, error) {
+	return 0, errors.New("diskfree: not supported")
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package main
+
+import "syscall"
+
+// diskfree returns the number of bytes available on the filesystem of the
+// given directory.
+func diskfree(dir string) (int64, error) {
+	var st syscall.Statfs_t
+
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+)
+
+// utf16Reader transcodes UTF-16 from the underlying reader into UTF-8.
+type utf16Reader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	buf   bytes.Buffer // transcoded UTF-8 yet to be read
+}
+
+func newUTF16Reader(r io.Reader, order binary.ByteOrder) *utf16Reader {
+	br, ok := r.(*bufio.Reader)
+
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	return &utf16Reader{
+		r:     br,
+		order: order,
+	}
+}
+
+// next reads the next UTF-16 code unit from the underlying reader.
+func (r *utf16Reader) next() (uint16, error) {
+	var b [2]byte
+
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, errors.New("utf-16: odd number of bytes")
+		}
+		return 0, err
+	}
+	return r.order.Uint16(b[:]), nil
+}
+
+func (r *utf16Reader) Read(p []byte) (int, error) {
+	var enc [utf8.UTFMax]byte
+
+	for r.buf.Len() < len(p) {
+		u, err := r.next()
+
+		if err != nil {
+			// Return what has been transcoded so far, the error will be
+			// returned on the next read.
+			if r.buf.Len() > 0 {
+				break
+			}
+			return 0, err
+		}
+
+		c := rune(u)
+
+		if utf16.IsSurrogate(c) {
+			u2, err := r.next()
+
+			if err != nil {
+				c = utf8.RuneError
+			} else {
+				c = utf16.DecodeRune(c, rune(u2))
+			}
+		}
+
+		n := utf8.EncodeRune(enc[:], c)
+		r.buf.Write(enc[:n])
+
+		// Don't block waiting for more input if we already have some to
+		// return.
+		if r.r.Buffered() < 2 {
+			break
+		}
+	}
+	return r.buf.Read(p)
+}
+
+// decodebom checks the given reader for a byte order mark. A UTF-8 BOM is
+// stripped, and a UTF-16 BOM will cause the rest of the input to be
+// transcoded from UTF-16 into UTF-8.
+func decodebom(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+
+	// Peek returns an error if there are fewer bytes than asked for, so the
+	// bytes that were available are used regardless.
+	b, _ := br.Peek(3)
+
+	switch {
+	case bytes.HasPrefix(b, bomUTF8):
+		br.Discard(len(bomUTF8))
+	case bytes.HasPrefix(b, bomUTF16BE):
+		br.Discard(len(bomUTF16BE))
+		return newUTF16Reader(br, binary.BigEndian)
+	case bytes.HasPrefix(b, bomUTF16LE):
+		br.Discard(len(bomUTF16LE))
+		return newUTF16Reader(br, binary.LittleEndian)
+	}
+	return br
+}
+
+// charmapReader transcodes a single byte character set from the underlying
+// reader into UTF-8.
+type charmapReader struct {
+	r     io.Reader
+	table *[256]rune
+	buf   []byte // transcoded UTF-8 yet to be read
+	raw   []byte
+}
+
+func newCharmapReader(r io.Reader, table *[256]rune) *charmapReader {
+	return &charmapReader{
+		r:     r,
+		table: table,
+	}
+}
+
+func (r *charmapReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if cap(r.raw) == 0 {
+			r.raw = make([]byte, 4096)
+		}
+
+		n, err := r.r.Read(r.raw)
+
+		if n == 0 {
+			return 0, err
+		}
+
+		for _, b := range r.raw[:n] {
+			if b < utf8.RuneSelf {
+				r.buf = append(r.buf, b)
+				continue
+			}
+
+			var enc [utf8.UTFMax]byte
+
+			m := utf8.EncodeRune(enc[:], r.table[b])
+			r.buf = append(r.buf, enc[:m]...)
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+var (
+	latin1      [256]rune
+	windows1252 [256]rune
+)
+
+func init() {
+	for i := range latin1 {
+		latin1[i] = rune(i)
+		windows1252[i] = rune(i)
+	}
+
+	// Windows-1252 differs from Latin-1 only in the 0x80 to 0x9F range, the
+	// bytes undefined in Windows-1252 are mapped to the C1 control characters
+	// as they are in Latin-1.
+	cp1252 := [32]rune{
+		0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+		0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+		0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+		0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+	}
+
+	for i, c := range cp1252 {
+		windows1252[0x80+i] = c
+	}
+}
+
+// Encodings is the list of character encodings that input can be decoded
+// from.
+var Encodings = []string{"utf-8", "latin1", "windows-1252", "utf-16le", "utf-16be"}
+
+// encodingname returns the canonical name of the given character encoding,
+// or an empty string if the encoding is not supported.
+func encodingname(enc string) string {
+	switch strings.ToLower(enc) {
+	case "utf-8", "utf8":
+		return "utf-8"
+	case "latin1", "latin-1", "iso-8859-1":
+		return "latin1"
+	case "windows-1252", "cp1252":
+		return "windows-1252"
+	case "utf-16le":
+		return "utf-16le"
+	case "utf-16be":
+		return "utf-16be"
+	}
+	return ""
+}
+
+// decodeencoding wraps the given reader so it is transcoded from the given
+// character encoding into UTF-8. A BOM matching the encoding is stripped.
+func decodeencoding(r io.Reader, enc string) (io.Reader, error) {
+	switch encodingname(enc) {
+	case "utf-8":
+		return decodebom(r), nil
+	case "latin1":
+		return newCharmapReader(r, &latin1), nil
+	case "windows-1252":
+		return newCharmapReader(r, &windows1252), nil
+	case "utf-16le":
+		br := bufio.NewReader(r)
+
+		if b, _ := br.Peek(2); bytes.Equal(b, bomUTF16LE) {
+			br.Discard(len(bomUTF16LE))
+		}
+		return newUTF16Reader(br, binary.LittleEndian), nil
+	case "utf-16be":
+		br := bufio.NewReader(r)
+
+		if b, _ := br.Peek(2); bytes.Equal(b, bomUTF16BE) {
+			br.Discard(len(bomUTF16BE))
+		}
+		return newUTF16Reader(br, binary.BigEndian), nil
+	}
+	return nil, errors.New("unknown encoding " + enc)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strconv"
+)
+
+// EncryptKeyEnv is the environment variable the key to encrypt columns with
+// is read from, if not given via -encrypt-key.
+const EncryptKeyEnv = "CSV2JSON_ENCRYPT_KEY"
+
+// ParseEncryptKey parses the given base64 encoded AES key, which must be 16,
+// 24, or 32 bytes long once decoded.
+func ParseEncryptKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+
+	if err != nil {
+		return nil, errors.New("encryption key must be base64 encoded")
+	}
+
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, errors.New("encryption key must be 16, 24, or 32 bytes, got " + strconv.Itoa(len(key)))
+	}
+	return key, nil
+}
+
+// WithEncryptKey configures the Parser to encrypt the values of columns with
+// the encrypt option via AES-GCM with the given key.
+func WithEncryptKey(key []byte) ParserOption {
+	return func(p *Parser) {
+		p.enckey = key
+	}
+}
+
+// checkencrypt checks that there is a key to encrypt with if any column in the
+// schema is encrypted, and sets up the cipher to encrypt with.
+func (p *Parser) checkencrypt() error {
+	for _, col := range p.schema.Columns() {
+		rec, ok := p.schema.Get(col)
+
+		if !ok || !rec.Encrypt {
+			continue
+		}
+
+		if p.enckey == nil {
+			return errors.New("column " + col + " is encrypted, but no key was given, expected -encrypt-key or " + EncryptKeyEnv)
+		}
+		break
+	}
+
+	if p.enckey == nil {
+		return nil
+	}
+
+	block, err := aes.NewCipher(p.enckey)
+
+	if err != nil {
+		return err
+	}
+
+	p.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// encrypt encrypts the given Value as it would be written, returning the
+// base64 encoded nonce followed by the ciphertext. Null values are left as is.
+func (p *Parser) encrypt(v Value) (Value, error) {
+	s, ok, err := valuestring(v)
+
+	if err != nil || !ok {
+		return v, err
+	}
+
+	nonce := make([]byte, p.aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	b := p.aead.Seal(nonce, nonce, []byte(s), nil)
+
+	return &String{s: base64.StdEncoding.EncodeToString(b)}, nil
+}
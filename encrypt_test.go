@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Encrypt(t *testing.T) {
+	dir := t.TempDir()
+	schema := filepath.Join(dir, "patients.schema")
+
+	if err := os.WriteFile(schema, []byte("ssn  string  _  _  _  encrypt=aes-gcm\n"), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSchema()
+
+	if err := s.Load(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	csv := "id,ssn\n1,123-45-6789\n2,123-45-6789\n"
+
+	if _, err := NewParser(strings.NewReader(csv), ',', s, errh); err == nil {
+		t.Fatal("expected error for missing key, got nil")
+	}
+
+	key, err := ParseEncryptKey(base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewParser(strings.NewReader(csv), ',', s, errh, WithEncryptKey(key))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]struct{})
+
+	err = p.ParseFunc(func(r *Record) error {
+		v, ok := r.Get("ssn")
+
+		if !ok {
+			t.Fatal("expected ssn in record")
+		}
+
+		b, err := v.MarshalJSON()
+
+		if err != nil {
+			return err
+		}
+
+		var ciphertext string
+
+		if err := json.Unmarshal(b, &ciphertext); err != nil {
+			return err
+		}
+
+		if _, ok := seen[ciphertext]; ok {
+			t.Errorf("expected unique ciphertext, got %q twice\n", ciphertext)
+		}
+		seen[ciphertext] = struct{}{}
+
+		raw, err := base64.StdEncoding.DecodeString(ciphertext)
+
+		if err != nil {
+			return err
+		}
+
+		n := aead.NonceSize()
+
+		plaintext, err := aead.Open(nil, raw[:n], raw[n:], nil)
+
+		if err != nil {
+			return err
+		}
+
+		if string(plaintext) != "123-45-6789" {
+			t.Errorf("unexpected plaintext, expected=%q, got=%q\n", "123-45-6789", string(plaintext))
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseEncryptKey(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Fatal("expected error for short key, got nil")
+	}
+}
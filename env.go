@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// validenv reports whether the given name is a valid environment variable
+// name, made up of letters, digits, and underscores, not starting with a
+// digit.
+func validenv(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// expandenv replaces each ${NAME} in s with the value of the environment
+// variable NAME. A default can be given for when the variable is not set, or
+// is empty, as ${NAME:-default}, otherwise a variable that is not set is an
+// error, so a schema is not silently loaded with an empty value. Only the
+// braced form of a valid name is expanded, so the $ of positional columns,
+// such as $1, and of replacements, such as ${1}, are left as they are. A
+// literal ${ can be written as $${.
+func expandenv(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var buf strings.Builder
+
+	for {
+		i := strings.Index(s, "${")
+
+		if i < 0 {
+			buf.WriteString(s)
+			break
+		}
+
+		if i > 0 && s[i-1] == '$' {
+			buf.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+
+		buf.WriteString(s[:i])
+
+		end := strings.IndexByte(s[i:], '}')
+
+		if end < 0 {
+			buf.WriteString(s[i:])
+			break
+		}
+
+		name := s[i+2 : i+end]
+
+		def, hasdef := "", false
+
+		if j := strings.Index(name, ":-"); j >= 0 {
+			name, def, hasdef = name[:j], name[j+2:], true
+		}
+
+		if !validenv(name) {
+			buf.WriteString(s[i : i+end+1])
+			s = s[i+end+1:]
+			continue
+		}
+
+		s = s[i+end+1:]
+
+		val, ok := os.LookupEnv(name)
+
+		if hasdef && val == "" {
+			val, ok = def, true
+		}
+
+		if !ok {
+			return "", errors.New("environment variable " + name + " is not set")
+		}
+		buf.WriteString(val)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_ExpandEnv(t *testing.T) {
+	t.Setenv("CSV2JSON_ENV", "staging")
+	t.Setenv("CSV2JSON_EMPTY", "")
+
+	tests := []struct {
+		in       string
+		expected string
+		err      bool
+	}{
+		{"env", "env", false},
+		{"${CSV2JSON_ENV}", "staging", false},
+		{"${CSV2JSON_ENV}_id", "staging_id", false},
+		{"${CSV2JSON_ENV}.${CSV2JSON_ENV}", "staging.staging", false},
+		{"${CSV2JSON_UNSET:-production}", "production", false},
+		{"${CSV2JSON_EMPTY:-production}", "production", false},
+		{"${CSV2JSON_ENV:-production}", "staging", false},
+		{"${CSV2JSON_EMPTY}", "", false},
+		{"$1", "$1", false},
+		{"^[a-z]+$", "^[a-z]+$", false},
+		{"${CSV2JSON_UNSET}", "", true},
+		{"${1}-${2}", "${1}-${2}", false},
+		{"${1ENV}", "${1ENV}", false},
+		{"${}", "${}", false},
+		{"$${CSV2JSON_ENV}", "${CSV2JSON_ENV}", false},
+		{"a$${CSV2JSON_ENV}b${CSV2JSON_ENV}", "a${CSV2JSON_ENV}bstaging", false},
+		{"${CSV2JSON_ENV", "${CSV2JSON_ENV", false},
+	}
+
+	for i, test := range tests {
+		s, err := expandenv(test.in)
+
+		if err != nil {
+			if !test.err {
+				t.Errorf("tests[%d] - unexpected error: %s\n", i, err)
+			}
+			continue
+		}
+
+		if test.err {
+			t.Errorf("tests[%d] - expected error, got=%q\n", i, s)
+			continue
+		}
+
+		if s != test.expected {
+			t.Errorf("tests[%d] - unexpected expansion, expected=%q, got=%q\n", i, test.expected, s)
+		}
+	}
+}
+
+func Test_SchemaEnv(t *testing.T) {
+	t.Setenv("DEPLOY_ENV", "staging")
+
+	dir := t.TempDir()
+	schema := filepath.Join(dir, "users.schema")
+
+	lines := `id       int     _           _               ${DEPLOY_ENV}_id
+joined   time    02/01/2006  2006-01-02
+code     string  "(?P<word>[a-z]+)([0-9]+)"  "${word}-${2}"  $${code}
+$3       string  ^[a-z]+$    _               name
+environment = "${DEPLOY_ENV}"
+`
+
+	if err := os.WriteFile(schema, []byte(lines), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSchema()
+
+	if err := s.Load(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	p, err := NewParser(strings.NewReader("id,joined,name,code\n1,07/12/2021,gordon,ab12\n"), ',', s, errh)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := p.Parse(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"${code}":"ab-12","environment":"staging","joined":"2021-12-07","name":"gordon","staging_id":1}` + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("unexpected output, expected=%q, got=%q\n", expected, buf.String())
+	}
+
+	unset := filepath.Join(dir, "unset.schema")
+
+	if err := os.WriteFile(unset, []byte("id  int  _  _  ${CSV2JSON_UNSET}_id\n"), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewSchema().Load(unset)
+
+	if err == nil || !strings.Contains(err.Error(), unset+":1 - environment variable CSV2JSON_UNSET is not set") {
+		t.Errorf("unexpected error, expected variable not set, got=%v\n", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"strconv"
+)
+
+// ErrorKind is the kind of an error reported by a Parser, so errors can be
+// told apart without matching on their messages.
+type ErrorKind string
+
+const (
+	TypeMismatch    ErrorKind = "type_mismatch"    // value could not be parsed as its type
+	PatternMismatch ErrorKind = "pattern_mismatch" // value does not match its pattern
+	MissingColumn   ErrorKind = "missing_column"   // value of a required column is empty
+	RaggedRow       ErrorKind = "ragged_row"       // record has the wrong number of fields
+	InvalidRecord   ErrorKind = "invalid_record"   // record fails a check, or validation
+	IOError         ErrorKind = "io_error"         // input could not be read
+)
+
+// errRequired is returned when the value of a required column is empty.
+var errRequired = errors.New("required value is empty")
+
+// RaggedError is the error returned when a record has the wrong number of
+// fields.
+type RaggedError struct {
+	Expected int
+	Got      int
+}
+
+func (e RaggedError) Error() string {
+	return "wrong number of fields, expected " + strconv.Itoa(e.Expected) + ", got " + strconv.Itoa(e.Got)
+}
+
+// errorkind returns the kind of the given error. Errors that are not about
+// the values of a record are taken to be from reading the input.
+func errorkind(err error) ErrorKind {
+	var (
+		paterr    PatternError
+		raggederr RaggedError
+		unmarshal UnmarshalError
+		colerr    ColumnError
+	)
+
+	switch {
+	case errors.As(err, &paterr):
+		return PatternMismatch
+	case errors.As(err, &unmarshal):
+		return TypeMismatch
+	case errors.Is(err, errRequired):
+		return MissingColumn
+	case errors.As(err, &raggederr), errors.Is(err, csv.ErrFieldCount):
+		return RaggedRow
+	case errors.As(err, &colerr):
+		return InvalidRecord
+	}
+
+	var (
+		validerr  ValidationError
+		filtererr FilterError
+		encerr    EncodeError
+	)
+
+	if errors.As(err, &validerr) || errors.As(err, &filtererr) || errors.As(err, &encerr) {
+		return InvalidRecord
+	}
+	return IOError
+}
+
+// Exit codes of the program. Records that could not be converted are told
+// apart from failures of the conversion itself, such as an input that could
+// not be read, or an output that could not be written.
+const (
+	exitFailure = 1
+	exitInvalid = 2
+)
+
+// RecordsError is returned from a conversion that completed, but where some
+// of the records were not converted because of the errors reported for them.
+type RecordsError struct {
+	N int
+}
+
+func (e RecordsError) Error() string {
+	if e.N == 1 {
+		return "1 record not converted"
+	}
+	return strconv.Itoa(e.N) + " records not converted"
+}
+
+// exitcode returns the exit code for the given error returned from run.
+func exitcode(err error) int {
+	var recerr RecordsError
+
+	if errors.As(err, &recerr) {
+		return exitInvalid
+	}
+	return exitFailure
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"os"
+	"testing"
+)
+
+func Test_ErrorKind(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected ErrorKind
+	}{
+		{ColumnError{Col: "id", Err: UnmarshalError{Type: "int", Err: errors.New("invalid syntax")}}, TypeMismatch},
+		{ColumnError{Col: "name", Err: UnmarshalError{Type: "string", Err: PatternError{Value: "x", Pattern: "^[A-Z]"}}}, PatternMismatch},
+		{ColumnError{Col: "email", Err: errRequired}, MissingColumn},
+		{RaggedError{Expected: 3, Got: 5}, RaggedRow},
+		{&csv.ParseError{Line: 2, Err: csv.ErrFieldCount}, RaggedRow},
+		{ColumnError{Col: "id", Err: errors.New(`duplicate value "2"`)}, InvalidRecord},
+		{ValidationError{Path: "/total", Err: errors.New("value is less than the minimum")}, InvalidRecord},
+		{&csv.ParseError{Line: 2, Err: csv.ErrBareQuote}, IOError},
+		{&os.PathError{Op: "read", Path: "users.csv", Err: os.ErrClosed}, IOError},
+	}
+
+	for i, test := range tests {
+		if kind := errorkind(test.err); kind != test.expected {
+			t.Errorf("tests[%d] - unexpected kind, expected=%q, got=%q\n", i, test.expected, kind)
+		}
+	}
+}
+
+func Test_ExitCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{RecordsError{N: 2}, exitInvalid},
+		{errors.New("encountered errors during generation"), exitFailure},
+	}
+
+	for i, test := range tests {
+		if code := exitcode(test.err); code != test.expected {
+			t.Errorf("tests[%d] - unexpected exit code, expected=%d, got=%d\n", i, test.expected, code)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrorRecord is an error reported by a Parser, in a form that can be written
+// as JSON for other programs to consume. The column and type are only set if
+// the error concerns a single column, or value.
+type ErrorRecord struct {
+	File    string    `json:"file"`
+	Line    int       `json:"line"`
+	Col     int       `json:"col"`
+	Kind    ErrorKind `json:"kind"`
+	Column  string    `json:"column,omitempty"`
+	Type    string    `json:"type,omitempty"`
+	Message string    `json:"message"`
+}
+
+// errorrecord returns the ErrorRecord for the given error at the given
+// position.
+func errorrecord(pos pos, err error) ErrorRecord {
+	e := ErrorRecord{
+		Line:    pos.line,
+		Col:     pos.col,
+		Kind:    errorkind(err),
+		Message: err.Error(),
+	}
+
+	var colerr ColumnError
+
+	if errors.As(err, &colerr) {
+		e.Column = colerr.Col
+	}
+
+	var unmarshalerr UnmarshalError
+
+	if errors.As(err, &unmarshalerr) {
+		e.Type = unmarshalerr.Type
+	}
+	return e
+}
+
+// WithErrorRecords configures the Parser to pass each error reported to the
+// given function as an ErrorRecord, as well as to the error handler. The file
+// of the record is left for the function to set.
+func WithErrorRecords(fn func(e ErrorRecord)) ParserOption {
+	return func(p *Parser) {
+		p.errfn = fn
+	}
+}
+
+// ErrorLog writes ErrorRecords as newline delimited JSON. It is safe for
+// concurrent use, so the errors of every input can be written to the same
+// log.
+type ErrorLog struct {
+	mu  sync.Mutex
+	w   io.WriteCloser
+	enc *json.Encoder
+	err error // first error encountered when writing
+}
+
+func NewErrorLog(w io.WriteCloser) *ErrorLog {
+	return &ErrorLog{
+		w:   w,
+		enc: json.NewEncoder(w),
+	}
+}
+
+// Write writes the given record to the log. Errors are kept until the log is
+// closed, so the conversion is not interrupted by the log.
+func (l *ErrorLog) Write(e ErrorRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return
+	}
+	l.err = l.enc.Encode(e)
+}
+
+// Close closes the log, returning the first error encountered when writing
+// to it.
+func (l *ErrorLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.w.Close(); err != nil && l.err == nil {
+		l.err = err
+	}
+	return l.err
+}
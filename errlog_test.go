@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ErrorsJSON(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"users.schema": "id     int\nemail  string  ^.+@.+$  _  _  required\n",
+		"users.csv":    "id,email\n1,gordon@example.com\nx,alyx@example.com\n3,\n",
+	}
+
+	for name, s := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(s), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errorsjson := filepath.Join(dir, "errors.ndjson")
+
+	args := []string{
+		"csv2json",
+		"-o", dir,
+		"-errors-json", errorsjson,
+		"-s", filepath.Join(dir, "users.schema"),
+		filepath.Join(dir, "users.csv"),
+	}
+
+	if err := run(args); exitcode(err) != exitInvalid {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", RecordsError{N: 2}, err)
+	}
+
+	f, err := os.Open(errorsjson)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	fname := filepath.Join(dir, "users.csv")
+
+	expected := []ErrorRecord{
+		{File: fname, Line: 3, Col: 1, Kind: TypeMismatch, Column: "id", Type: "int"},
+		{File: fname, Line: 4, Col: 3, Kind: MissingColumn, Column: "email"},
+	}
+
+	sc := bufio.NewScanner(f)
+
+	i := 0
+
+	for ; sc.Scan(); i++ {
+		var e ErrorRecord
+
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+
+		if i >= len(expected) {
+			t.Fatalf("unexpected error record %q\n", sc.Text())
+		}
+
+		if e.Message == "" {
+			t.Errorf("errors[%d] - expected message\n", i)
+		}
+
+		e.Message = ""
+
+		if e != expected[i] {
+			t.Errorf("errors[%d] - unexpected error record, expected=%+v, got=%+v\n", i, expected[i], e)
+		}
+	}
+
+	if i != len(expected) {
+		t.Errorf("unexpected number of errors, expected=%d, got=%d\n", len(expected), i)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// estimateRows is the number of records sampled from each file when
+// estimating the size of the output.
+const estimateRows = 10000
+
+// countWriter counts the number of bytes and lines written to it.
+type countWriter struct {
+	n     int64
+	lines int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	w.lines += int64(bytes.Count(p, []byte{'\n'}))
+	return len(p), nil
+}
+
+// Estimate is the estimated size of the output for a CSV file, based off a
+// sample of records from the start of the file.
+type Estimate struct {
+	Rows int64 // number of records written
+	Size int64 // size of the JSON output
+	Gzip int64 // size of the JSON output if gzipped
+}
+
+// formatsize formats the given number of bytes into a human readable size.
+func formatsize(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+
+	f := float64(n)
+	i := 0
+
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	return strconv.FormatFloat(f, 'f', 1, 64) + units[i]
+}
+
+func (e Estimate) String() string {
+	return "rows=" + strconv.FormatInt(e.Rows, 10) + " size=" + formatsize(e.Size) + " gzip=" + formatsize(e.Gzip)
+}
+
+// estimate parses a sample of records from the given file, and extrapolates
+// the size of the output from the amount of the file that was read. Excel
+// workbooks are compressed, so the amount read cannot be compared to the size
+// of the file, and only the sample is reported. The same goes for URLs, since
+// the size is not known until they are read.
+func estimate(fname string, delim rune, schema *Schema, sheet string, fetch *Fetcher, opts []ParserOption) (Estimate, error) {
+	var size int64
+
+	if !isremote(fname) {
+		info, err := os.Stat(fname)
+
+		if err != nil {
+			return Estimate{}, err
+		}
+		size = info.Size()
+	}
+
+	f, err := openinput(fname, sheet, delim, fetch)
+
+	if err != nil {
+		return Estimate{}, err
+	}
+
+	defer f.Close()
+
+	// Errors are ignored, since these would only affect the records that are
+	// written.
+	errh := func(_, _ int, _ string) {}
+
+	opts = inputopts(fname, opts)
+	opts = append(opts[:len(opts):len(opts)], WithLimit(estimateRows))
+
+	p, err := NewParser(f, delim, schema, errh, opts...)
+
+	if err != nil {
+		return Estimate{}, err
+	}
+
+	var raw, gzc countWriter
+
+	gz := gzip.NewWriter(&gzc)
+
+	out := io.MultiWriter(&raw, gz)
+
+	if err := p.Parse(out); err != nil {
+		return Estimate{}, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return Estimate{}, err
+	}
+
+	est := Estimate{
+		Rows: raw.lines,
+		Size: raw.n,
+		Gzip: gzc.n,
+	}
+
+	read := p.csv.InputOffset()
+
+	// Only extrapolate if the file was not read in full.
+	if !isxlsx(fname) && read > 0 && read < size {
+		ratio := float64(size) / float64(read)
+
+		est.Rows = int64(float64(est.Rows) * ratio)
+		est.Size = int64(float64(est.Size) * ratio)
+		est.Gzip = int64(float64(est.Gzip) * ratio)
+	}
+	return est, nil
+}
+
+// runEstimate prints the estimated output size of each of the given files,
+// along with the total.
+func runEstimate(argv0 string, args []string, delim rune, schemafor func(fname string) *Schema, sheet string, fetch *Fetcher, opts []ParserOption, meta []string, ingestedAt time.Time) error {
+	var total Estimate
+
+	errc := 0
+
+	for _, fname := range args {
+		fopts := opts
+
+		if meta != nil {
+			fopts = append(fopts[:len(fopts):len(fopts)], WithMeta(inputname(fname), meta, ingestedAt))
+		}
+
+		est, err := estimate(fname, delim, schemafor(fname), sheet, fetch, fopts)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", argv0, err)
+			errc++
+			continue
+		}
+
+		total.Rows += est.Rows
+		total.Size += est.Size
+		total.Gzip += est.Gzip
+
+		fmt.Printf("%s %s\n", inputname(fname), est)
+	}
+
+	if len(args) > 1 {
+		fmt.Printf("total %s\n", total)
+	}
+
+	if errc > 0 {
+		return errors.New("encountered errors during estimation")
+	}
+	return nil
+}
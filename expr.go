@@ -0,0 +1,809 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Expr is a compiled expression that can be evaluated against a set of
+// variables. Expressions support the arithmetic operators + - * / %, the
+// comparison operators == != < <= > >=, the logical operators && || !, string
+// and number literals, and calls to the builtin functions in exprfuncs.
+type Expr struct {
+	src  string
+	root exprnode
+}
+
+// ExprEnv is used to lookup the value of a variable when evaluating an
+// expression. If the variable does not exist then false should be returned.
+type ExprEnv func(name string) (interface{}, bool)
+
+type ExprError struct {
+	Expr string
+	Pos  int
+	Err  error
+}
+
+func (e ExprError) Error() string {
+	return fmt.Sprintf("%q:%d - %s", e.Expr, e.Pos+1, e.Err)
+}
+
+type exprnode interface {
+	eval(env ExprEnv) (interface{}, error)
+}
+
+type exprlit struct {
+	val interface{}
+}
+
+type exprident struct {
+	name string
+}
+
+type exprunary struct {
+	op string
+	x  exprnode
+}
+
+type exprbinary struct {
+	op   string
+	x, y exprnode
+}
+
+type exprcall struct {
+	name string
+	fn   exprfunc
+	args []exprnode
+}
+
+type exprtok struct {
+	kind byte // one of 'n' for number, 's' for string, 'i' for ident, or 'o' for operator
+	text string
+	pos  int
+}
+
+// exprlex slices the given source into tokens. Strings can be wrapped in
+// either single or double quotes.
+func exprlex(src string) ([]exprtok, error) {
+	toks := make([]exprtok, 0)
+
+	i := 0
+
+	for i < len(src) {
+		r, w := utf8.DecodeRuneInString(src[i:])
+
+		if unicode.IsSpace(r) {
+			i += w
+			continue
+		}
+
+		start := i
+
+		switch {
+		case r >= '0' && r <= '9' || (r == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9'):
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			toks = append(toks, exprtok{kind: 'n', text: src[start:i], pos: start})
+		case r == '_' || unicode.IsLetter(r):
+			for i < len(src) {
+				r, w := utf8.DecodeRuneInString(src[i:])
+
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				i += w
+			}
+			toks = append(toks, exprtok{kind: 'i', text: src[start:i], pos: start})
+		case r == '"' || r == '\'':
+			var buf strings.Builder
+
+			i++
+
+			for {
+				if i >= len(src) {
+					return nil, ExprError{Expr: src, Pos: start, Err: errors.New("unterminated string")}
+				}
+
+				c := src[i]
+
+				if c == byte(r) {
+					i++
+					break
+				}
+
+				if c == '\\' && i+1 < len(src) {
+					i++
+
+					switch src[i] {
+					case 'n':
+						c = '\n'
+					case 't':
+						c = '\t'
+					default:
+						c = src[i]
+					}
+				}
+				buf.WriteByte(c)
+				i++
+			}
+			toks = append(toks, exprtok{kind: 's', text: buf.String(), pos: start})
+		default:
+			op := src[i : i+1]
+
+			if i+1 < len(src) {
+				switch src[i : i+2] {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					op = src[i : i+2]
+				}
+			}
+
+			if !strings.Contains("+-*/%<>!(),", op) && len(op) == 1 {
+				return nil, ExprError{Expr: src, Pos: start, Err: fmt.Errorf("unexpected character %q", op)}
+			}
+
+			i += len(op)
+			toks = append(toks, exprtok{kind: 'o', text: op, pos: start})
+		}
+	}
+	return toks, nil
+}
+
+// exprprec is the precedence of each binary operator, the higher the number
+// the tighter the operator binds.
+var exprprec = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3,
+	"!=": 3,
+	"<":  4,
+	"<=": 4,
+	">":  4,
+	">=": 4,
+	"+":  5,
+	"-":  5,
+	"*":  6,
+	"/":  6,
+	"%":  6,
+}
+
+type exprparser struct {
+	src  string
+	toks []exprtok
+	pos  int
+}
+
+func (p *exprparser) err(pos int, format string, args ...interface{}) error {
+	return ExprError{Expr: p.src, Pos: pos, Err: fmt.Errorf(format, args...)}
+}
+
+func (p *exprparser) peek() (exprtok, bool) {
+	if p.pos >= len(p.toks) {
+		return exprtok{pos: len(p.src)}, false
+	}
+	return p.toks[p.pos], true
+}
+
+func (p *exprparser) expect(op string) error {
+	tok, ok := p.peek()
+
+	if !ok || tok.kind != 'o' || tok.text != op {
+		return p.err(tok.pos, "expected %q", op)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprparser) binary(prec int) (exprnode, error) {
+	x, err := p.unary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok, ok := p.peek()
+
+		if !ok || tok.kind != 'o' {
+			return x, nil
+		}
+
+		opprec, ok := exprprec[tok.text]
+
+		if !ok || opprec < prec {
+			return x, nil
+		}
+
+		p.pos++
+
+		y, err := p.binary(opprec + 1)
+
+		if err != nil {
+			return nil, err
+		}
+		x = exprbinary{op: tok.text, x: x, y: y}
+	}
+}
+
+func (p *exprparser) unary() (exprnode, error) {
+	tok, ok := p.peek()
+
+	if ok && tok.kind == 'o' && (tok.text == "!" || tok.text == "-") {
+		p.pos++
+
+		x, err := p.unary()
+
+		if err != nil {
+			return nil, err
+		}
+		return exprunary{op: tok.text, x: x}, nil
+	}
+	return p.operand()
+}
+
+func (p *exprparser) operand() (exprnode, error) {
+	tok, ok := p.peek()
+
+	if !ok {
+		return nil, p.err(tok.pos, "unexpected end of expression")
+	}
+
+	p.pos++
+
+	switch tok.kind {
+	case 'n':
+		if !strings.Contains(tok.text, ".") {
+			n, err := strconv.ParseInt(tok.text, 10, 64)
+
+			if err != nil {
+				return nil, p.err(tok.pos, "%s", err)
+			}
+			return exprlit{val: n}, nil
+		}
+
+		f, err := strconv.ParseFloat(tok.text, 64)
+
+		if err != nil {
+			return nil, p.err(tok.pos, "%s", err)
+		}
+		return exprlit{val: f}, nil
+	case 's':
+		return exprlit{val: tok.text}, nil
+	case 'i':
+		switch tok.text {
+		case "true":
+			return exprlit{val: true}, nil
+		case "false":
+			return exprlit{val: false}, nil
+		case "null":
+			return exprlit{val: nil}, nil
+		}
+
+		next, ok := p.peek()
+
+		if !ok || next.kind != 'o' || next.text != "(" {
+			return exprident{name: tok.text}, nil
+		}
+
+		fn, ok := exprfuncs[tok.text]
+
+		if !ok {
+			return nil, p.err(tok.pos, "unknown function %s", tok.text)
+		}
+
+		p.pos++
+
+		args := make([]exprnode, 0)
+
+		if next, ok := p.peek(); ok && next.kind == 'o' && next.text == ")" {
+			p.pos++
+			return exprcall{name: tok.text, fn: fn, args: args}, nil
+		}
+
+		for {
+			arg, err := p.binary(1)
+
+			if err != nil {
+				return nil, err
+			}
+
+			args = append(args, arg)
+
+			if next, ok := p.peek(); ok && next.kind == 'o' && next.text == "," {
+				p.pos++
+				continue
+			}
+
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+		return exprcall{name: tok.text, fn: fn, args: args}, nil
+	case 'o':
+		if tok.text == "(" {
+			x, err := p.binary(1)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	return nil, p.err(tok.pos, "unexpected %q", tok.text)
+}
+
+// CompileExpr compiles the given expression source.
+func CompileExpr(src string) (*Expr, error) {
+	toks, err := exprlex(src)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprparser{
+		src:  src,
+		toks: toks,
+	}
+
+	root, err := p.binary(1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tok, ok := p.peek(); ok {
+		return nil, p.err(tok.pos, "unexpected %q", tok.text)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string { return e.src }
+
+// Eval evaluates the expression using the given environment for variable
+// lookup. The returned value will be one of int64, float64, string, bool, or
+// nil.
+func (e *Expr) Eval(env ExprEnv) (interface{}, error) {
+	return e.root.eval(env)
+}
+
+func (n exprlit) eval(_ ExprEnv) (interface{}, error) { return n.val, nil }
+
+func (n exprident) eval(env ExprEnv) (interface{}, error) {
+	v, ok := env(n.name)
+
+	if !ok {
+		return nil, errors.New("undefined variable " + n.name)
+	}
+	return v, nil
+}
+
+func (n exprunary) eval(env ExprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "!":
+		b, ok := x.(bool)
+
+		if !ok {
+			return nil, fmt.Errorf("invalid operand %v for !", x)
+		}
+		return !b, nil
+	case "-":
+		switch v := x.(type) {
+		case int64:
+			return -v, nil
+		case float64:
+			return -v, nil
+		}
+		return nil, fmt.Errorf("invalid operand %v for -", x)
+	}
+	return nil, errors.New("unknown operator " + n.op)
+}
+
+// exprfloat returns the float value of the given number, and whether or not
+// it was a number.
+func exprfloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// exprstring returns the string representation of the given expression value.
+func exprstring(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	return fmt.Sprint(v)
+}
+
+func expreq(x, y interface{}) bool {
+	if a, ok := exprfloat(x); ok {
+		if b, ok := exprfloat(y); ok {
+			return a == b
+		}
+		return false
+	}
+	return x == y
+}
+
+func (n exprbinary) eval(env ExprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Short circuit the logical operators, so the right hand side is only
+	// evaluated if needed.
+	if n.op == "&&" || n.op == "||" {
+		a, ok := x.(bool)
+
+		if !ok {
+			return nil, fmt.Errorf("invalid operand %v for %s", x, n.op)
+		}
+
+		if (n.op == "&&" && !a) || (n.op == "||" && a) {
+			return a, nil
+		}
+
+		y, err := n.y.eval(env)
+
+		if err != nil {
+			return nil, err
+		}
+
+		b, ok := y.(bool)
+
+		if !ok {
+			return nil, fmt.Errorf("invalid operand %v for %s", y, n.op)
+		}
+		return b, nil
+	}
+
+	y, err := n.y.eval(env)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return expreq(x, y), nil
+	case "!=":
+		return !expreq(x, y), nil
+	}
+
+	_, xstr := x.(string)
+	_, ystr := y.(string)
+
+	if n.op == "+" && (xstr || ystr) {
+		return exprstring(x) + exprstring(y), nil
+	}
+
+	if xstr && ystr {
+		a, b := x.(string), y.(string)
+
+		switch n.op {
+		case "<":
+			return a < b, nil
+		case "<=":
+			return a <= b, nil
+		case ">":
+			return a > b, nil
+		case ">=":
+			return a >= b, nil
+		}
+		return nil, fmt.Errorf("invalid operands %q and %q for %s", a, b, n.op)
+	}
+
+	a, ok := exprfloat(x)
+
+	if !ok {
+		return nil, fmt.Errorf("invalid operand %v for %s", x, n.op)
+	}
+
+	b, ok := exprfloat(y)
+
+	if !ok {
+		return nil, fmt.Errorf("invalid operand %v for %s", y, n.op)
+	}
+
+	i, xint := x.(int64)
+	j, yint := y.(int64)
+
+	switch n.op {
+	case "<":
+		return a < b, nil
+	case "<=":
+		return a <= b, nil
+	case ">":
+		return a > b, nil
+	case ">=":
+		return a >= b, nil
+	case "+":
+		if xint && yint {
+			return i + j, nil
+		}
+		return a + b, nil
+	case "-":
+		if xint && yint {
+			return i - j, nil
+		}
+		return a - b, nil
+	case "*":
+		if xint && yint {
+			return i * j, nil
+		}
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return a / b, nil
+	case "%":
+		if !xint || !yint {
+			return nil, errors.New("operands of % must be integers")
+		}
+		if j == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return i % j, nil
+	}
+	return nil, errors.New("unknown operator " + n.op)
+}
+
+func (n exprcall) eval(env ExprEnv) (interface{}, error) {
+	args := make([]interface{}, 0, len(n.args))
+
+	for _, arg := range n.args {
+		v, err := arg.eval(env)
+
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	v, err := n.fn(args)
+
+	if err != nil {
+		return nil, errors.New(n.name + ": " + err.Error())
+	}
+	return v, nil
+}
+
+type exprfunc func(args []interface{}) (interface{}, error)
+
+// exprnargs checks that the given number of arguments is n.
+func exprnargs(args []interface{}, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+	return nil
+}
+
+// exprstrfunc returns an exprfunc that applies fn to its single string
+// argument.
+func exprstrfunc(fn func(string) string) exprfunc {
+	return func(args []interface{}) (interface{}, error) {
+		if err := exprnargs(args, 1); err != nil {
+			return nil, err
+		}
+		return fn(exprstring(args[0])), nil
+	}
+}
+
+// exprmathfunc returns an exprfunc that applies fn to its single numeric
+// argument.
+func exprmathfunc(fn func(float64) float64) exprfunc {
+	return func(args []interface{}) (interface{}, error) {
+		if err := exprnargs(args, 1); err != nil {
+			return nil, err
+		}
+
+		if n, ok := args[0].(int64); ok {
+			return int64(fn(float64(n))), nil
+		}
+
+		f, ok := exprfloat(args[0])
+
+		if !ok {
+			return nil, fmt.Errorf("invalid argument %v", args[0])
+		}
+		return fn(f), nil
+	}
+}
+
+// exprstrpredfunc returns an exprfunc that applies fn to its two string
+// arguments.
+func exprstrpredfunc(fn func(string, string) bool) exprfunc {
+	return func(args []interface{}) (interface{}, error) {
+		if err := exprnargs(args, 2); err != nil {
+			return nil, err
+		}
+		return fn(exprstring(args[0]), exprstring(args[1])), nil
+	}
+}
+
+var exprfuncs map[string]exprfunc
+
+func init() {
+	exprfuncs = map[string]exprfunc{
+		"upper":     exprstrfunc(strings.ToUpper),
+		"lower":     exprstrfunc(strings.ToLower),
+		"trim":      exprstrfunc(strings.TrimSpace),
+		"abs":       exprmathfunc(math.Abs),
+		"floor":     exprmathfunc(math.Floor),
+		"ceil":      exprmathfunc(math.Ceil),
+		"contains":  exprstrpredfunc(strings.Contains),
+		"hasprefix": exprstrpredfunc(strings.HasPrefix),
+		"hassuffix": exprstrpredfunc(strings.HasSuffix),
+		"len": func(args []interface{}) (interface{}, error) {
+			if err := exprnargs(args, 1); err != nil {
+				return nil, err
+			}
+			return int64(utf8.RuneCountInString(exprstring(args[0]))), nil
+		},
+		"replace": func(args []interface{}) (interface{}, error) {
+			if err := exprnargs(args, 3); err != nil {
+				return nil, err
+			}
+			return strings.ReplaceAll(exprstring(args[0]), exprstring(args[1]), exprstring(args[2])), nil
+		},
+		"round": func(args []interface{}) (interface{}, error) {
+			if len(args) != 1 && len(args) != 2 {
+				return nil, fmt.Errorf("expected 1 or 2 arguments, got %d", len(args))
+			}
+
+			f, ok := exprfloat(args[0])
+
+			if !ok {
+				return nil, fmt.Errorf("invalid argument %v", args[0])
+			}
+
+			if len(args) == 1 {
+				return int64(math.Round(f)), nil
+			}
+
+			places, ok := args[1].(int64)
+
+			if !ok {
+				return nil, fmt.Errorf("invalid argument %v", args[1])
+			}
+
+			pow := math.Pow(10, float64(places))
+
+			return math.Round(f*pow) / pow, nil
+		},
+		"int": func(args []interface{}) (interface{}, error) {
+			if err := exprnargs(args, 1); err != nil {
+				return nil, err
+			}
+
+			switch v := args[0].(type) {
+			case int64:
+				return v, nil
+			case float64:
+				return int64(v), nil
+			case bool:
+				if v {
+					return int64(1), nil
+				}
+				return int64(0), nil
+			}
+
+			n, err := strconv.ParseInt(strings.TrimSpace(exprstring(args[0])), 10, 64)
+
+			if err != nil {
+				return nil, err
+			}
+			return n, nil
+		},
+		"float": func(args []interface{}) (interface{}, error) {
+			if err := exprnargs(args, 1); err != nil {
+				return nil, err
+			}
+
+			if f, ok := exprfloat(args[0]); ok {
+				return f, nil
+			}
+
+			f, err := strconv.ParseFloat(strings.TrimSpace(exprstring(args[0])), 64)
+
+			if err != nil {
+				return nil, err
+			}
+			return f, nil
+		},
+		"string": func(args []interface{}) (interface{}, error) {
+			if err := exprnargs(args, 1); err != nil {
+				return nil, err
+			}
+			return exprstring(args[0]), nil
+		},
+	}
+}
+
+// exprvalue returns the expression value for the given Value.
+func exprvalue(v Value) (interface{}, error) {
+	switch x := v.(type) {
+	case *Int:
+		return int64(x.n), nil
+	case *Float:
+		return x.n, nil
+	case Bool:
+		return x.b, nil
+	case Null:
+		return nil, nil
+	}
+
+	b, err := v.MarshalJSON()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var i interface{}
+
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.UseNumber()
+
+	if err := dec.Decode(&i); err != nil {
+		return nil, err
+	}
+
+	if n, ok := i.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+		return n.Float64()
+	}
+
+	if s, ok := i.(string); ok {
+		return s, nil
+	}
+	return exprstring(i), nil
+}
+
+// valueof returns the Value for the given expression value.
+func valueof(v interface{}) Value {
+	switch x := v.(type) {
+	case nil:
+		return Null{}
+	case int64:
+		return &Int{n: int(x)}
+	case float64:
+		return &Float{n: x}
+	case bool:
+		return Bool{b: x}
+	}
+	return &String{s: exprstring(v)}
+}
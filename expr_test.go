@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_Expr(t *testing.T) {
+	vars := map[string]interface{}{
+		"age":     int64(21),
+		"country": "US",
+		"price":   19.5,
+		"name":    "  Gordon  ",
+	}
+
+	env := func(name string) (interface{}, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		src      string
+		expected interface{}
+	}{
+		{`1 + 2 * 3`, int64(7)},
+		{`(1 + 2) * 3`, int64(9)},
+		{`7 / 2`, 3.5},
+		{`7 % 2`, int64(1)},
+		{`-price`, -19.5},
+		{`age > 18 && country == "US"`, true},
+		{`age > 18 && country == 'GB'`, false},
+		{`!(age < 18) || price > 100`, true},
+		{`upper(trim(name))`, "GORDON"},
+		{`"n=" + age`, "n=21"},
+		{`round(price * 1.1, 2)`, 21.45},
+		{`int("42") + 1`, int64(43)},
+		{`replace(country, "U", "A")`, "AS"},
+		{`age == 21.0`, true},
+		{`null == null`, true},
+	}
+
+	for i, test := range tests {
+		e, err := CompileExpr(test.src)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		v, err := e.Eval(env)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if !reflect.DeepEqual(v, test.expected) {
+			t.Fatalf("tests[%d] - unexpected result, expected=%v(%T), got=%v(%T)\n", i, test.expected, test.expected, v, v)
+		}
+	}
+}
+
+func Test_ExprErrors(t *testing.T) {
+	tests := []string{
+		`1 +`,
+		`(1 + 2`,
+		`foo(1)`,
+		`"unterminated`,
+		`1 $ 2`,
+	}
+
+	for i, src := range tests {
+		if _, err := CompileExpr(src); err == nil {
+			t.Fatalf("tests[%d] - expected error for %q\n", i, src)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isurl checks if the given input is an http or https URL, rather than a
+// file.
+func isurl(fname string) bool {
+	return strings.HasPrefix(fname, "http://") || strings.HasPrefix(fname, "https://")
+}
+
+// inputname returns the name of the given input to use in messages and
+// metadata. Any credentials in a URL are removed.
+func inputname(fname string) string {
+	if !isurl(fname) {
+		return fname
+	}
+
+	u, err := url.Parse(fname)
+
+	if err != nil || u.User == nil {
+		return fname
+	}
+
+	u.User = nil
+	return u.String()
+}
+
+// urlbase returns the last element of the path of the given URL, falling back
+// to the host if the path is empty.
+func urlbase(fname string) string {
+	u, err := url.Parse(fname)
+
+	if err != nil {
+		return path.Base(fname)
+	}
+
+	if base := path.Base(u.Path); base != "/" && base != "." {
+		return base
+	}
+	return u.Hostname()
+}
+
+// HTTPError is the error returned when a URL responds with an unexpected
+// status.
+type HTTPError struct {
+	URL    string
+	Status int
+}
+
+func (e HTTPError) Error() string {
+	return e.URL + ": unexpected status " + strconv.Itoa(e.Status) + " " + http.StatusText(e.Status)
+}
+
+// Fetcher fetches inputs from URLs, and from object stores. Requests that fail
+// to connect, or that fail with a status that could be temporary, are retried
+// with an exponential backoff. Redirects are followed by the underlying
+// http.Client.
+type Fetcher struct {
+	client  *http.Client
+	user    string
+	pass    string
+	retries int
+	backoff time.Duration
+
+	mu     sync.Mutex
+	stores map[string]ObjectStore // object stores by scheme, created on use
+}
+
+// NewFetcher returns a Fetcher that retries failed requests the given number
+// of times. If auth is not empty, then it is used as the user:password for
+// basic authentication.
+func NewFetcher(auth string, retries int) *Fetcher {
+	f := &Fetcher{
+		client:  &http.Client{},
+		retries: retries,
+		backoff: time.Second,
+		stores:  make(map[string]ObjectStore),
+	}
+
+	if auth != "" {
+		f.user = auth
+		f.pass = ""
+
+		if i := strings.Index(auth, ":"); i >= 0 {
+			f.user = auth[:i]
+			f.pass = auth[i+1:]
+		}
+	}
+	return f
+}
+
+// retryable checks if a response with the given status could succeed if the
+// request was retried.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// store returns the object store for the given scheme, configuring it from the
+// environment the first time it is used.
+func (f *Fetcher) store(scheme string) (ObjectStore, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s, ok := f.stores[scheme]; ok {
+		return s, nil
+	}
+
+	var s ObjectStore
+
+	switch scheme {
+	case "s3":
+		s = newS3Store(f.client)
+	case "gs":
+		s = newGCSStore(f.client)
+	case "az":
+		az, err := newAzureStore(f.client)
+
+		if err != nil {
+			return nil, err
+		}
+		s = az
+	default:
+		return nil, errors.New("unsupported object store " + scheme)
+	}
+
+	f.stores[scheme] = s
+	return s, nil
+}
+
+// Create creates the given object for writing.
+func (f *Fetcher) Create(rawurl string) (io.WriteCloser, error) {
+	scheme, bucket, key, err := splitobject(rawurl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := f.store(scheme)
+
+	if err != nil {
+		return nil, err
+	}
+	return s.Create(bucket, key)
+}
+
+// Open requests the given URL, returning the body of the response to be
+// streamed. Objects are opened from their object store.
+func (f *Fetcher) Open(rawurl string) (io.ReadCloser, error) {
+	if isobject(rawurl) {
+		scheme, bucket, key, err := splitobject(rawurl)
+
+		if err != nil {
+			return nil, err
+		}
+
+		s, err := f.store(scheme)
+
+		if err != nil {
+			return nil, err
+		}
+		return s.Open(bucket, key)
+	}
+
+	req, err := http.NewRequest("GET", rawurl, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.do(req, nil)
+
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Post sends the given body to the given URL, retrying the request the same
+// way as Open.
+func (f *Fetcher) Post(rawurl, contentType string, body []byte) error {
+	req, err := http.NewRequest("POST", rawurl, nil)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := f.do(req, body)
+
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// do sends the given request with the given body, retrying it if it fails.
+// The response is returned if it has a successful status.
+func (f *Fetcher) do(req *http.Request, body []byte) (*http.Response, error) {
+	name := inputname(req.URL.String())
+	delay := f.backoff
+
+	if f.user != "" {
+		req.SetBasicAuth(f.user, f.pass)
+	}
+
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		if body != nil {
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.ContentLength = int64(len(body))
+		}
+
+		var resp *http.Response
+
+		resp, err = f.client.Do(req)
+
+		if err != nil {
+			if attempt < f.retries {
+				continue
+			}
+			return nil, err
+		}
+
+		if resp.StatusCode/100 == 2 {
+			return resp, nil
+		}
+
+		resp.Body.Close()
+
+		err = HTTPError{URL: name, Status: resp.StatusCode}
+
+		if !retryable(resp.StatusCode) || attempt >= f.retries {
+			return nil, err
+		}
+	}
+}
+
+// errURLWorkbook is returned when an Excel workbook is given as a URL, or an
+// object, since these cannot be streamed.
+var errURLWorkbook = errors.New("excel workbooks cannot be read from a url")
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ColumnRange is the range of bytes a column occupies in each line of a fixed
+// width file. The range is zero based, and the end is exclusive.
+type ColumnRange struct {
+	Column string
+	Start  int
+	End    int
+	Raw    bool // the value is kept as is, including any padding
+}
+
+// parserange parses the given range in the form of start:end.
+func parserange(s string) (int, int, error) {
+	i := strings.Index(s, ":")
+
+	if i < 0 {
+		return 0, 0, errors.New("invalid range " + s + ", expected start:end")
+	}
+
+	start, err := strconv.Atoi(s[:i])
+
+	if err != nil {
+		return 0, 0, errors.New("invalid range " + s + ", expected start:end")
+	}
+
+	end, err := strconv.Atoi(s[i+1:])
+
+	if err != nil {
+		return 0, 0, errors.New("invalid range " + s + ", expected start:end")
+	}
+
+	if start < 0 || end <= start {
+		return 0, 0, errors.New("invalid range " + s + ", end must be after start")
+	}
+	return start, end, nil
+}
+
+// isrange checks if the given schema option is a range.
+func isrange(s string) bool {
+	i := strings.Index(s, ":")
+
+	if i <= 0 || i == len(s)-1 {
+		return false
+	}
+
+	for j, c := range s {
+		if j != i && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Ranges returns the ranges of each column in the schema that has one, ordered
+// by where they start.
+func (s *Schema) Ranges() []ColumnRange {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ranges := make([]ColumnRange, 0)
+
+	for col, rec := range s.recs {
+		if rec.End > 0 {
+			ranges = append(ranges, ColumnRange{
+				Column: col,
+				Start:  rec.Start,
+				End:    rec.End,
+				Raw:    rec.Type == "raw",
+			})
+		}
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	return ranges
+}
+
+// fixedReader rewrites a fixed width file into CSV. The header of the CSV is
+// the name of each column, and the value of each column is trimmed of any
+// padding, unless it is raw.
+type fixedReader struct {
+	sc     *bufio.Scanner
+	ranges []ColumnRange
+
+	buf    bytes.Buffer
+	cw     *csv.Writer
+	header bool
+}
+
+func newFixedReader(r io.Reader, ranges []ColumnRange, delim rune) *fixedReader {
+	fr := &fixedReader{
+		sc:     linescanner(r),
+		ranges: ranges,
+	}
+
+	fr.cw = csv.NewWriter(&fr.buf)
+	fr.cw.Comma = delim
+
+	return fr
+}
+
+func (r *fixedReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		record := make([]string, len(r.ranges))
+
+		if !r.header {
+			for i, rng := range r.ranges {
+				record[i] = rng.Column
+			}
+			r.header = true
+		} else {
+			if !r.sc.Scan() {
+				if err := r.sc.Err(); err != nil {
+					return 0, err
+				}
+				return 0, io.EOF
+			}
+
+			line := r.sc.Text()
+
+			// Blank lines are skipped, as they would be in a CSV file.
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+
+			for i, rng := range r.ranges {
+				if rng.Start >= len(line) {
+					continue
+				}
+
+				end := rng.End
+
+				if end > len(line) {
+					end = len(line)
+				}
+				record[i] = line[rng.Start:end]
+
+				if !rng.Raw {
+					record[i] = strings.TrimSpace(record[i])
+				}
+			}
+		}
+
+		if err := r.cw.Write(record); err != nil {
+			return 0, err
+		}
+		r.cw.Flush()
+	}
+	return r.buf.Read(p)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Encoder encodes records into a format other than JSON. Close is called once
+// all records have been encoded, and should write out anything buffered.
+type Encoder interface {
+	Encode(r *Record) error
+
+	Close() error
+}
+
+// EncoderFunc returns an Encoder that writes to w. The name is the name of the
+// output without its extension, and fields are the fields that can appear in
+// each record.
+type EncoderFunc func(w io.Writer, name string, fields []Field) (Encoder, error)
+
+// Field is a field that can appear in the records written by a Parser, along
+// with the schema type of its values. If the type is not known, or can vary
+// between records, then Type is empty.
+type Field struct {
+	Name  string
+	Type  string
+	Array bool // the values are arrays of the type
+}
+
+// EncodeError is the error returned when a single record cannot be encoded.
+// The record is reported and skipped, rather than failing the whole file.
+type EncodeError struct {
+	Field string
+	Err   error
+}
+
+func (e EncodeError) Error() string { return e.Field + ": " + e.Err.Error() }
+
+// format is an output format, and the extension of the files written in that
+// format. JSON has no EncoderFunc, since the Parser writes it directly.
+type format struct {
+	ext string
+	enc EncoderFunc
+}
+
+var formats = map[string]format{
+	"json":    {ext: ".json"},
+	"avro":    {ext: ".avro", enc: NewAvroEncoder},
+	"msgpack": {ext: ".msgpack", enc: NewMsgpackEncoder},
+	"cbor":    {ext: ".cbor", enc: NewCBOREncoder},
+	"yaml":    {ext: ".yaml", enc: NewYAMLEncoder(false)},
+	"sql":     {ext: ".sql", enc: NewSQLEncoder("", "insert")},
+}
+
+// jsonEncoder writes each record as a line of JSON. The Parser writes JSON
+// itself, so this is only used where an Encoder is needed, such as when
+// splitting output.
+type jsonEncoder struct {
+	w io.Writer
+}
+
+// NewJSONEncoder returns an Encoder that writes records as NDJSON.
+func NewJSONEncoder(w io.Writer, _ string, _ []Field) (Encoder, error) {
+	return &jsonEncoder{w: w}, nil
+}
+
+func (e *jsonEncoder) Encode(r *Record) error {
+	b, err := json.Marshal(r)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(append(b, '\n'))
+	return err
+}
+
+func (e *jsonEncoder) Close() error { return nil }
+
+// formatnames returns the names of the output formats, in alphabetical order.
+func formatnames() []string {
+	names := make([]string, 0, len(formats))
+
+	for name := range formats {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// native returns the given Value as one of nil, bool, int64, float64, string,
+// or []interface{}, for encoding into formats other than JSON. Values that are
+// not numbers or bools are taken as they would be in the JSON, and objects as
+// a string of their JSON. Formatted integers and floats are taken as they would
+// be in the JSON too, so they are rounded, or written as strings, the same in
+// every format.
+func native(v Value) (interface{}, error) {
+	switch x := v.(type) {
+	case nil, Null:
+		return nil, nil
+	case Bool:
+		return x.b, nil
+	case *Int:
+		if x.outfmt == "" {
+			return int64(x.n), nil
+		}
+	case *Float:
+		if x.outfmt == "" {
+			return x.n, nil
+		}
+
+		b, err := x.MarshalJSON()
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Formatted floats are numbers unless formatted as something else,
+		// such as when zero padded, in which case they are strings.
+		if n, err := strconv.ParseFloat(string(b), 64); err == nil {
+			return n, nil
+		}
+	case *Array:
+		vals := make([]interface{}, 0, len(x.vals))
+
+		for _, v := range x.vals {
+			val, err := native(v)
+
+			if err != nil {
+				return nil, err
+			}
+			vals = append(vals, val)
+		}
+		return vals, nil
+	case *Object:
+		// Nested objects are encoded as their JSON.
+		b, err := x.MarshalJSON()
+
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+
+	b, err := v.MarshalJSON()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var i interface{}
+
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.UseNumber()
+
+	if err := dec.Decode(&i); err != nil {
+		return nil, err
+	}
+
+	switch x := i.(type) {
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return n, nil
+		}
+		return x.Float64()
+	case nil, bool, string:
+		return x, nil
+	}
+	return nil, errors.New("cannot encode value " + string(b))
+}
+
+// outtype returns the type of the values of the given type once written with
+// the given format. Formatted integers are written as strings, and floats
+// formatted as something other than a number, such as when zero padded, could
+// be written as either.
+func outtype(typ, format string) string {
+	switch {
+	case format == "":
+	case typ == "int":
+		return "string"
+	case typ == "float" && strings.HasPrefix(format, "%") && !numre.MatchString(fmt.Sprintf(format, 1.5)):
+		return ""
+	}
+	return typ
+}
+
+// fields returns the fields that can appear in the records written by the
+// Parser, in the order of the CSV file, followed by any fields that are not
+// in the CSV file.
+func (p *Parser) fields() []Field {
+	fields := make([]Field, 0, len(p.headers))
+	index := make(map[string]int)
+
+	add := func(f Field) {
+		if i, ok := index[f.Name]; ok {
+			// Fields set more than once could have values of either type.
+			if fields[i].Type != f.Type || fields[i].Array != f.Array {
+				fields[i].Type = ""
+			}
+			return
+		}
+
+		index[f.Name] = len(fields)
+		fields = append(fields, f)
+	}
+
+	for i, hdr := range p.headers {
+		f := Field{Name: hdr}
+
+		if rec, ok := p.schemarec(i, hdr); ok {
+			if rec.Redact {
+				continue
+			}
+
+			if rec.Capture != nil {
+				for _, dest := range rec.Captures {
+					if dest != "_" {
+						add(Field{Name: dest, Type: "string"})
+					}
+				}
+				continue
+			}
+
+			f.Name = rec.Dest
+
+			// Transforms can change the type of the value.
+			if len(rec.Transforms) == 0 {
+				f.Type = rec.Type
+			}
+
+			f.Type = outtype(f.Type, rec.Outfmt)
+
+			if rec.Encrypt {
+				f.Type = "string"
+			}
+
+			if rec.Convert != nil {
+				f.Type = ""
+
+				if len(rec.Transforms) == 0 {
+					f.Type = "float"
+				}
+			}
+		} else if p.noinfer {
+			f.Type = "string"
+		}
+
+		if _, ok := p.dupes[hdr]; ok {
+			f.Array = true
+		}
+		add(f)
+	}
+
+	if p.overflow != "" {
+		add(Field{Name: p.overflow, Type: "string", Array: true})
+	}
+
+	for _, c := range p.schema.Combines() {
+		add(Field{Name: c.Dest, Type: outtype(c.Type, c.Outfmt)})
+	}
+
+	for _, c := range p.schema.Concats() {
+		add(Field{Name: c.Dest, Type: outtype(c.Type, c.Outfmt)})
+	}
+
+	for _, l := range p.schema.Lookups() {
+		add(Field{Name: l.Dest})
+	}
+
+	for _, d := range p.schema.Derived() {
+		add(Field{Name: d.Dest})
+	}
+
+	for _, r := range p.schema.Rollings() {
+		typ := "float"
+
+		if r.Func == "count" {
+			typ = "int"
+		}
+		add(Field{Name: r.Dest, Type: typ})
+	}
+
+	metakeys := make([]string, 0, len(p.meta))
+
+	for k := range p.meta {
+		metakeys = append(metakeys, k)
+	}
+
+	sort.Strings(metakeys)
+
+	for _, k := range metakeys {
+		typ := "string"
+
+		if k == "_ingested_at" {
+			typ = "time"
+		}
+		add(Field{Name: k, Type: typ})
+	}
+
+	if p.metaline {
+		add(Field{Name: "_line", Type: "int"})
+	}
+	return fields
+}
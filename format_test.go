@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_BinaryEncoders(t *testing.T) {
+	r := NewRecord()
+	r.Set("id", &Int{n: 1})
+	r.Set("name", &String{s: "ab"})
+	r.Set("ok", Bool{b: true})
+	r.Set("score", &Int{n: -300})
+	r.Set("tags", &Array{vals: []Value{Null{}, &Float{n: 1.5}}})
+
+	tests := []struct {
+		format   string
+		expected []byte
+	}{
+		{
+			"msgpack",
+			[]byte{
+				0x85,
+				0xa2, 'i', 'd', 0x01,
+				0xa4, 'n', 'a', 'm', 'e', 0xa2, 'a', 'b',
+				0xa2, 'o', 'k', 0xc3,
+				0xa5, 's', 'c', 'o', 'r', 'e', 0xd1, 0xfe, 0xd4,
+				0xa4, 't', 'a', 'g', 's', 0x92, 0xc0, 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+			},
+		},
+		{
+			"cbor",
+			[]byte{
+				0xa5,
+				0x62, 'i', 'd', 0x01,
+				0x64, 'n', 'a', 'm', 'e', 0x62, 'a', 'b',
+				0x62, 'o', 'k', 0xf5,
+				0x65, 's', 'c', 'o', 'r', 'e', 0x39, 0x01, 0x2b,
+				0x64, 't', 'a', 'g', 's', 0x82, 0xf6, 0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+
+		enc, err := formats[test.format].enc(&buf, "test", nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := enc.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(buf.Bytes(), test.expected) {
+			t.Errorf("unexpected %s encoding\n\texpected=%x\n\tgot=     %x\n", test.format, test.expected, buf.Bytes())
+		}
+	}
+}
+
+func Test_FormatOrder(t *testing.T) {
+	defer os.RemoveAll("sales.msgpack")
+
+	if err := run([]string{"csv2json", "-format", "msgpack", "-order", "csv", filepath.Join("testdata", "sales.csv")}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile("sales.msgpack")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first record should start with a map of the three columns, in the
+	// order of the CSV file.
+	prefix := []byte{0x83, 0xa5, 's', 't', 'o', 'r', 'e', 0xa1, 'a', 0xa3, 'd', 'a', 'y'}
+
+	if !bytes.HasPrefix(b, prefix) {
+		t.Fatalf("unexpected start of output\n\texpected=%x\n\tgot=     %x\n", prefix, b[:len(prefix)])
+	}
+}
+
+func Test_YAMLEncoder(t *testing.T) {
+	r := NewRecord()
+	r.Set("id", &Int{n: 1})
+	r.Set("name", &String{s: "andrew"})
+	r.Set("note", &String{s: "yes: no"})
+	r.Set("created_at", &String{s: "2021-12-07"})
+	r.Set("tags", &Array{vals: []Value{&String{s: "a"}, Null{}}})
+
+	tests := []struct {
+		seq      bool
+		expected string
+	}{
+		{
+			false,
+			"---\nid: 1\nname: andrew\nnote: \"yes: no\"\ncreated_at: \"2021-12-07\"\ntags: [a, null]\n" +
+				"---\nid: 1\nname: andrew\nnote: \"yes: no\"\ncreated_at: \"2021-12-07\"\ntags: [a, null]\n",
+		},
+		{
+			true,
+			"- id: 1\n  name: andrew\n  note: \"yes: no\"\n  created_at: \"2021-12-07\"\n  tags: [a, null]\n" +
+				"- id: 1\n  name: andrew\n  note: \"yes: no\"\n  created_at: \"2021-12-07\"\n  tags: [a, null]\n",
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+
+		enc, err := NewYAMLEncoder(test.seq)(&buf, "test", nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := enc.Encode(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if s := buf.String(); s != test.expected {
+			t.Errorf("unexpected yaml, seq=%v\n\texpected=%q\n\tgot=     %q\n", test.seq, test.expected, s)
+		}
+	}
+}
+
+func Test_YAMLPlain(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected bool
+	}{
+		{"andrew", true},
+		{"hello world", true},
+		{"user@example.com", true},
+		{"", false},
+		{"true", false},
+		{"No", false},
+		{"10", false},
+		{" padded", false},
+		{"a: b", false},
+		{"-dash", false},
+		{"#comment", false},
+	}
+
+	for _, test := range tests {
+		if ok := yamlplain(test.s); ok != test.expected {
+			t.Errorf("unexpected result for %q, expected=%v, got=%v\n", test.s, test.expected, ok)
+		}
+	}
+}
+
+func Test_SQLEncoder(t *testing.T) {
+	fields := []Field{
+		{Name: "id", Type: "int"},
+		{Name: "name", Type: "string"},
+		{Name: "Bio"},
+		{Name: "active", Type: "bool"},
+	}
+
+	r := NewRecord()
+	r.Set("id", &Int{n: 1})
+	r.Set("name", &String{s: "O'Brien"})
+	r.Set("active", Bool{b: true})
+	r.Set("Bio", &String{s: "line\tone\nline two"})
+
+	tests := []struct {
+		style    string
+		expected string
+	}{
+		{
+			"insert",
+			"INSERT INTO \"users\" (\"id\", \"name\", \"Bio\", \"active\") VALUES (1, 'O''Brien', 'line\tone\nline two', TRUE);\n" +
+				"INSERT INTO \"users\" (\"id\", \"name\", \"Bio\", \"active\") VALUES (2, NULL, NULL, NULL);\n",
+		},
+		{
+			"copy",
+			"COPY \"users\" (\"id\", \"name\", \"Bio\", \"active\") FROM stdin;\n" +
+				"1\tO'Brien\tline\\tone\\nline two\tt\n" +
+				"2\t\\N\t\\N\t\\N\n" +
+				"\\.\n",
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+
+		enc, err := NewSQLEncoder("", test.style)(&buf, "users", fields)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := enc.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+
+		r2 := NewRecord()
+		r2.Set("id", &Int{n: 2})
+
+		if err := enc.Encode(r2); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if s := buf.String(); s != test.expected {
+			t.Errorf("unexpected sql, style=%s\n\texpected=%q\n\tgot=     %q\n", test.style, test.expected, s)
+		}
+	}
+}
+
+func Test_SQLEncoderKeywords(t *testing.T) {
+	fields := []Field{
+		{Name: "user", Type: "string"},
+		{Name: "order", Type: "int"},
+		{Name: "group", Type: "string"},
+		{Name: `say "hi"`, Type: "string"},
+	}
+
+	r := NewRecord()
+	r.Set("user", &String{s: "me"})
+	r.Set("order", &Int{n: 1})
+	r.Set("group", &String{s: "admin"})
+	r.Set(`say "hi"`, &String{s: "hi"})
+
+	var buf bytes.Buffer
+
+	enc, err := NewSQLEncoder("", "insert")(&buf, "kw", fields)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := enc.Encode(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `INSERT INTO "kw" ("user", "order", "group", "say ""hi""") VALUES ('me', 1, 'admin', 'hi');` + "\n"
+
+	if s := buf.String(); s != expected {
+		t.Errorf("unexpected sql\n\texpected=%q\n\tgot=     %q\n", expected, s)
+	}
+}
+
+func Test_NativeIntFormats(t *testing.T) {
+	tests := []struct {
+		v        *Int
+		expected interface{}
+	}{
+		{&Int{n: 255}, int64(255)},
+		{&Int{n: 255, outfmt: "hex"}, "ff"},
+		{&Int{n: 8, outfmt: "oct"}, "10"},
+		{&Int{n: 5, outfmt: "bin"}, "101"},
+		{&Int{n: 42, outfmt: "%05d"}, "00042"},
+	}
+
+	for i, test := range tests {
+		val, err := native(test.v)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if val != test.expected {
+			t.Errorf("tests[%d] - unexpected value, expected=%#v, got=%#v\n", i, test.expected, val)
+		}
+
+		// Formatted integers are written the same as in the JSON.
+		if s, ok := val.(string); ok {
+			b, _ := test.v.MarshalJSON()
+
+			if `"`+s+`"` != string(b) {
+				t.Errorf("tests[%d] - unexpected value, expected=%s, got=%q\n", i, b, s)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+
+	enc, err := NewSQLEncoder("", "insert")(&buf, "codes", []Field{{Name: "code", Type: "string"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRecord()
+	r.Set("code", &Int{n: 255, outfmt: "hex"})
+
+	if err := enc.Encode(r); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `INSERT INTO "codes" ("code") VALUES ('ff');` + "\n"
+
+	if s := buf.String(); s != expected {
+		t.Errorf("unexpected sql\n\texpected=%q\n\tgot=     %q\n", expected, s)
+	}
+}
+
+func Test_NativeFloatFormats(t *testing.T) {
+	tests := []struct {
+		v        *Float
+		expected interface{}
+	}{
+		{&Float{n: 1.5}, 1.5},
+		{&Float{n: 19.900000000000002, outfmt: "round:2"}, 19.9},
+		{&Float{n: 2.6, outfmt: "round:0"}, float64(3)},
+		{&Float{n: 3.14159, outfmt: "%.2f"}, 3.14},
+		{&Float{n: 1.5, outfmt: "%08.2f"}, "00001.50"},
+	}
+
+	for i, test := range tests {
+		val, err := native(test.v)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if val != test.expected {
+			t.Errorf("tests[%d] - unexpected value, expected=%#v, got=%#v\n", i, test.expected, val)
+		}
+	}
+}
+
+func Test_OutType(t *testing.T) {
+	tests := []struct {
+		typ      string
+		format   string
+		expected string
+	}{
+		{"int", "", "int"},
+		{"int", "hex", "string"},
+		{"int", "%05d", "string"},
+		{"float", "", "float"},
+		{"float", "round:2", "float"},
+		{"float", "%.2f", "float"},
+		{"float", "%08.2f", ""},
+		{"time", "2006-01-02", "time"},
+	}
+
+	for i, test := range tests {
+		if typ := outtype(test.typ, test.format); typ != test.expected {
+			t.Errorf("tests[%d] - unexpected type, expected=%q, got=%q\n", i, test.expected, typ)
+		}
+	}
+}
@@ -1 +1,3 @@
 module github.com/andrewpillar/csv2json
+
+go 1.27.1
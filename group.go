@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Aggregate is an aggregate of a column computed over each group of records,
+// when records are grouped via -group-by. A count with no column counts the
+// records in the group.
+type Aggregate struct {
+	Func   string // one of sum, avg, min, max, or count
+	Column string
+	Dest   string
+}
+
+// ParseAggregates parses the given comma separated list of aggregates, each
+// in the form of func(column), with an optional "as dest" suffix. If no dest
+// is given then the aggregate is named func_column, or count for count().
+func ParseAggregates(s string) ([]Aggregate, error) {
+	aggs := make([]Aggregate, 0)
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+
+		if part == "" {
+			continue
+		}
+
+		var agg Aggregate
+
+		if i := strings.Index(part, " as "); i >= 0 {
+			agg.Dest = strings.TrimSpace(part[i+4:])
+			part = strings.TrimSpace(part[:i])
+		}
+
+		open := strings.Index(part, "(")
+
+		if open < 0 || !strings.HasSuffix(part, ")") {
+			return nil, errors.New("invalid aggregate " + part + ", expected func(column)")
+		}
+
+		agg.Func = part[:open]
+		agg.Column = strings.TrimSpace(part[open+1 : len(part)-1])
+
+		switch agg.Func {
+		case "count":
+		case "sum", "avg", "min", "max":
+			if agg.Column == "" {
+				return nil, errors.New("aggregate " + agg.Func + " requires a column")
+			}
+		default:
+			return nil, errors.New("unknown aggregate function " + agg.Func)
+		}
+
+		if agg.Dest == "" {
+			agg.Dest = agg.Func
+
+			if agg.Column != "" {
+				agg.Dest += "_" + agg.Column
+			}
+		}
+		aggs = append(aggs, agg)
+	}
+
+	if len(aggs) == 0 {
+		return nil, errors.New("no aggregates given")
+	}
+	return aggs, nil
+}
+
+// group is a single group of records, holding the values of the columns the
+// records are grouped by, and the running aggregates of the group.
+type group struct {
+	keys []Value
+	wins []*rollwin
+}
+
+// groupEncoder groups the records it is given by the values of a set of
+// columns, and encodes a single record for each group once closed. Groups are
+// held in memory until then, and are encoded in the order they were first
+// seen.
+type groupEncoder struct {
+	enc  Encoder
+	by   []string
+	aggs []Aggregate
+
+	groups map[string]*group
+	order  []string
+}
+
+// NewGroupEncoder returns an EncoderFunc that groups records by the given
+// columns, and writes the aggregates of each group with the Encoder returned
+// by enc.
+func NewGroupEncoder(by []string, aggs []Aggregate, enc EncoderFunc) EncoderFunc {
+	return func(w io.Writer, name string, fields []Field) (Encoder, error) {
+		types := make(map[string]Field)
+
+		for _, f := range fields {
+			types[f.Name] = f
+		}
+
+		gfields := make([]Field, 0, len(by)+len(aggs))
+
+		for _, col := range by {
+			f, ok := types[col]
+
+			if !ok {
+				return nil, errors.New("cannot group by unknown column " + col)
+			}
+			gfields = append(gfields, f)
+		}
+
+		for _, agg := range aggs {
+			if _, ok := types[agg.Column]; agg.Column != "" && !ok {
+				return nil, errors.New("cannot aggregate unknown column " + agg.Column)
+			}
+
+			typ := "float"
+
+			if agg.Func == "count" {
+				typ = "int"
+			}
+			gfields = append(gfields, Field{Name: agg.Dest, Type: typ})
+		}
+
+		e, err := enc(w, name, gfields)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &groupEncoder{
+			enc:    e,
+			by:     by,
+			aggs:   aggs,
+			groups: make(map[string]*group),
+		}, nil
+	}
+}
+
+func (e *groupEncoder) Encode(r *Record) error {
+	keys := make([]Value, 0, len(e.by))
+
+	var buf strings.Builder
+
+	for _, col := range e.by {
+		v, _ := r.Get(col)
+
+		if v == nil {
+			v = Null{}
+		}
+
+		b, err := v.MarshalJSON()
+
+		if err != nil {
+			return err
+		}
+
+		buf.Write(b)
+		buf.WriteByte(0)
+
+		keys = append(keys, v)
+	}
+
+	// Check every aggregate before adding any, so a record that cannot be
+	// aggregated is skipped entirely.
+	nums := make([]float64, len(e.aggs))
+	has := make([]bool, len(e.aggs))
+
+	for i, agg := range e.aggs {
+		if agg.Column == "" {
+			has[i] = true
+			continue
+		}
+
+		v, ok := r.Get(agg.Column)
+
+		if !ok || v == nil {
+			continue
+		}
+
+		if _, ok := v.(Null); ok {
+			continue
+		}
+
+		n, ok := numeric(v)
+
+		if !ok {
+			return EncodeError{
+				Field: agg.Column,
+				Err:   errors.New("cannot aggregate non-numeric value"),
+			}
+		}
+
+		nums[i] = n
+		has[i] = true
+	}
+
+	key := buf.String()
+
+	g, ok := e.groups[key]
+
+	if !ok {
+		g = &group{
+			keys: keys,
+			wins: make([]*rollwin, len(e.aggs)),
+		}
+
+		for i := range g.wins {
+			g.wins[i] = &rollwin{}
+		}
+
+		e.groups[key] = g
+		e.order = append(e.order, key)
+	}
+
+	for i, agg := range e.aggs {
+		if has[i] {
+			g.wins[i].add(Rolling{Func: agg.Func}, time.Time{}, nums[i])
+		}
+	}
+	return nil
+}
+
+func (e *groupEncoder) Close() error {
+	for _, key := range e.order {
+		g := e.groups[key]
+
+		r := NewRecord()
+
+		for i, col := range e.by {
+			r.Set(col, g.keys[i])
+		}
+
+		for i, agg := range e.aggs {
+			var v Value = Null{}
+
+			if g.wins[i].count > 0 {
+				v = g.wins[i].value(Rolling{Func: agg.Func})
+			} else if agg.Func == "count" {
+				v = &Int{}
+			}
+			r.Set(agg.Dest, v)
+		}
+
+		if err := e.enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return e.enc.Close()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_GroupBy(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{
+			[]string{"-group-by", "store"},
+			`{"store":"a","count":4}` + "\n" +
+				`{"store":"b","count":2}` + "\n",
+		},
+		{
+			[]string{"-group-by", "store", "-agg", "count(), sum(amount), avg(amount) as mean, min(amount), max(amount)"},
+			`{"store":"a","count":4,"sum_amount":100,"mean":25,"min_amount":10,"max_amount":40}` + "\n" +
+				`{"store":"b","count":2,"sum_amount":20,"mean":10,"min_amount":5,"max_amount":15}` + "\n",
+		},
+		{
+			[]string{"-group-by", "store,day", "-agg", "sum(amount)", "-limit", "3"},
+			`{"store":"a","day":"2021-12-01T00:00:00Z","sum_amount":10}` + "\n" +
+				`{"store":"b","day":"2021-12-01T00:00:00Z","sum_amount":5}` + "\n" +
+				`{"store":"a","day":"2021-12-02T00:00:00Z","sum_amount":20}` + "\n",
+		},
+	}
+
+	for i, test := range tests {
+		dir := t.TempDir()
+
+		args := append([]string{"csv2json", "-o", dir, "-s", filepath.Join("testdata", "sales.schema")}, test.args...)
+
+		if err := run(append(args, filepath.Join("testdata", "sales.csv"))); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, "sales.json"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != test.expected {
+			t.Errorf("tests[%d] - unexpected output\n\texpected=%q\n\tgot=     %q\n", i, test.expected, b)
+		}
+	}
+}
+
+func Test_ParseAggregates(t *testing.T) {
+	aggs, err := ParseAggregates("count(), count(id), sum(amount) as total")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Aggregate{
+		{Func: "count", Dest: "count"},
+		{Func: "count", Column: "id", Dest: "count_id"},
+		{Func: "sum", Column: "amount", Dest: "total"},
+	}
+
+	if len(aggs) != len(expected) {
+		t.Fatalf("unexpected number of aggregates, expected=%d, got=%d\n", len(expected), len(aggs))
+	}
+
+	for i, agg := range aggs {
+		if agg != expected[i] {
+			t.Errorf("aggs[%d] - expected=%+v, got=%+v\n", i, expected[i], agg)
+		}
+	}
+
+	for _, s := range []string{"", "sum()", "median(amount)", "sum amount"} {
+		if _, err := ParseAggregates(s); err == nil {
+			t.Errorf("expected error for %q\n", s)
+		} else if !strings.Contains(err.Error(), "aggregate") {
+			t.Errorf("unexpected error for %q: %s\n", s, err)
+		}
+	}
+}
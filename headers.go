@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// WithNormalizedHeaders configures the Parser to match headers to the columns
+// of the schema regardless of case, surrounding whitespace, and any stray byte
+// order marks, so a header of " Email " matches the schema column email.
+// Headers that match exactly are preferred.
+func WithNormalizedHeaders() ParserOption {
+	return func(p *Parser) {
+		p.normhdrs = true
+	}
+}
+
+// normheader normalizes the given header for matching against the columns of
+// the schema.
+func normheader(hdr string) string {
+	hdr = strings.Map(func(r rune) rune {
+		// Byte order marks and zero width spaces are left behind when files
+		// are concatenated, or exported from spreadsheets.
+		if r == '\uFEFF' || r == '\u200B' {
+			return -1
+		}
+		return r
+	}, hdr)
+
+	return strings.ToLower(strings.TrimFunc(hdr, unicode.IsSpace))
+}
+
+// initcols builds the lookup of the schema's columns by their aliases, and by
+// their normalized names and aliases if headers are normalized. If more than
+// one column has the same alias, then the first in alphabetical order wins.
+func (p *Parser) initcols() {
+	p.aliases = make(map[string]string)
+	p.normcols = make(map[string]string)
+
+	for _, col := range p.schema.Columns() {
+		rec, ok := p.schema.Get(col)
+
+		if !ok {
+			continue
+		}
+
+		for _, alias := range rec.Aliases {
+			if _, ok := p.aliases[alias]; !ok {
+				p.aliases[alias] = col
+			}
+		}
+
+		if !p.normhdrs {
+			continue
+		}
+
+		for _, name := range append([]string{col}, rec.Aliases...) {
+			if _, ok := p.normcols[normheader(name)]; !ok {
+				p.normcols[normheader(name)] = col
+			}
+		}
+	}
+}
+
+// poscol returns the positional column of the schema for the header at index
+// i, in the form of $N where N starts at 1.
+func poscol(i int) string {
+	return "$" + strconv.Itoa(i+1)
+}
+
+// schemacol returns the column of the schema the header at index i is matched
+// to. Headers are matched to the positional column for their index first, then
+// to the name of a column, then to its aliases.
+func (p *Parser) schemacol(i int, hdr string) (string, bool) {
+	if col := poscol(i); col != hdr {
+		if _, ok := p.schema.Get(col); ok {
+			return col, true
+		}
+	}
+
+	if _, ok := p.schema.Get(hdr); ok {
+		return hdr, true
+	}
+
+	if p.aliases == nil {
+		p.initcols()
+	}
+
+	if col, ok := p.aliases[hdr]; ok {
+		return col, true
+	}
+
+	if p.normhdrs {
+		col, ok := p.normcols[normheader(hdr)]
+		return col, ok
+	}
+	return "", false
+}
+
+// schemarec returns the schema record the header at index i is matched to.
+// Positional columns without a destination are written under the header.
+func (p *Parser) schemarec(i int, hdr string) (SchemaRecord, bool) {
+	col, ok := p.schemacol(i, hdr)
+
+	if !ok {
+		return SchemaRecord{}, false
+	}
+
+	rec, ok := p.schema.Get(col)
+
+	if col == poscol(i) && rec.Dest == col {
+		rec.Dest = hdr
+	}
+	return rec, ok
+}
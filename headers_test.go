@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_NormalizedHeaders(t *testing.T) {
+	s := NewSchema()
+	s.Add("email", SchemaRecord{Type: "email", Dest: "email", Unmarshal: UnmarshalEmail})
+	s.Add("id", SchemaRecord{Type: "int", Dest: "id", Unmarshal: UnmarshalInt(10)})
+
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	csv := "\u200BID, Email ,Name\n1,gordon@blackmesa.com,Gordon\n"
+
+	tests := []struct {
+		opts     []ParserOption
+		expected string
+	}{
+		{
+			nil,
+			`{" Email ":"gordon@blackmesa.com","Name":"Gordon","` + "\u200B" + `ID":1}` + "\n",
+		},
+		{
+			[]ParserOption{WithNormalizedHeaders(), WithDedupe([]string{"email"}, false)},
+			`{"Name":"Gordon","email":"gordon@blackmesa.com","id":1}` + "\n",
+		},
+	}
+
+	for i, test := range tests {
+		p, err := NewParser(strings.NewReader(csv), ',', s, errh, test.opts...)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		var buf bytes.Buffer
+
+		if err := p.Parse(&buf); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if buf.String() != test.expected {
+			t.Errorf("tests[%d] - unexpected output, expected=%q, got=%q\n", i, test.expected, buf.String())
+		}
+	}
+}
+
+func Test_NormHeader(t *testing.T) {
+	tests := []struct {
+		hdr      string
+		expected string
+	}{
+		{" Email ", "email"},
+		{"\uFEFFid", "id"},
+		{"\tCreated At\u200B", "created at"},
+	}
+
+	for i, test := range tests {
+		if norm := normheader(test.hdr); norm != test.expected {
+			t.Errorf("tests[%d] - unexpected header, expected=%q, got=%q\n", i, test.expected, norm)
+		}
+	}
+}
+
+func Test_HeaderAliases(t *testing.T) {
+	s := NewSchema()
+
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	dir := t.TempDir()
+
+	schema := filepath.Join(dir, "users.schema")
+
+	if err := os.WriteFile(schema, []byte("email  email  _  _  _  aliases=e-mail,mail\n"), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Load(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		csv      string
+		opts     []ParserOption
+		expected string
+	}{
+		{
+			"id,e-mail\n1,gordon@blackmesa.com\n",
+			nil,
+			`{"email":"gordon@blackmesa.com","id":1}` + "\n",
+		},
+		{
+			"id,mail\n1,gordon@blackmesa.com\n",
+			nil,
+			`{"email":"gordon@blackmesa.com","id":1}` + "\n",
+		},
+		{
+			"id, E-Mail\n1,gordon@blackmesa.com\n",
+			[]ParserOption{WithNormalizedHeaders()},
+			`{"email":"gordon@blackmesa.com","id":1}` + "\n",
+		},
+	}
+
+	for i, test := range tests {
+		p, err := NewParser(strings.NewReader(test.csv), ',', s, errh, test.opts...)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		var buf bytes.Buffer
+
+		if err := p.Parse(&buf); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if buf.String() != test.expected {
+			t.Errorf("tests[%d] - unexpected output, expected=%q, got=%q\n", i, test.expected, buf.String())
+		}
+	}
+}
+
+func Test_PositionalColumns(t *testing.T) {
+	s := NewSchema()
+
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	dir := t.TempDir()
+
+	schema := filepath.Join(dir, "readings.schema")
+
+	src := "$2  float  _  _  low\n" +
+		"$3  float  _  _  high\n" +
+		"$4  string\n"
+
+	if err := os.WriteFile(schema, []byte(src), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Load(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	csv := "id,value,value,unit\n1,2,10,3\n"
+
+	p, err := NewParser(strings.NewReader(csv), ',', s, errh, WithDuplicateHeaders("suffix"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := p.Parse(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"high":10,"id":1,"low":2,"unit":"3"}` + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("unexpected output, expected=%q, got=%q\n", expected, buf.String())
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// numre matches numbers as they are written in JSON. Values with leading
+// zeros, or a leading plus sign, are more likely to be codes, such as phone
+// numbers, than numbers, so are not inferred as numbers.
+var numre = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// probes are the types that can be inferred for the values of columns that are
+// not in the schema.
+var probes = map[string]UnmarshalFunc{
+	"int":   probeNumber(UnmarshalInt(10)),
+	"float": probeNumber(UnmarshalFloat),
+	"bool":  probeBool,
+	"time":  UnmarshalTime(time.RFC3339),
+}
+
+// DefaultInference is the order in which types are inferred by default.
+var DefaultInference = []string{"int", "float", "time"}
+
+// unmarshalAny infers the type of the given value from the DefaultInference
+// types.
+var unmarshalAny = inferrer(DefaultInference)
+
+// probeNumber returns an UnmarshalFunc that only unmarshals values that are
+// written as numbers are in JSON via the given function. This stops strconv
+// from accepting values such as "Inf", "NaN", and "0x1p-2".
+func probeNumber(fn UnmarshalFunc) UnmarshalFunc {
+	return func(s string) (Value, error) {
+		if !numre.MatchString(s) {
+			return nil, errors.New("not a number: " + s)
+		}
+		return fn(s)
+	}
+}
+
+// probeBool unmarshals only true and false, regardless of case.
+func probeBool(s string) (Value, error) {
+	return UnmarshalBool(strings.ToLower(s))
+}
+
+// inferrer returns an UnmarshalFunc that infers the type of a value by trying
+// each of the given types in order, falling back to a string if none of them
+// match.
+func inferrer(types []string) UnmarshalFunc {
+	funcs := make([]UnmarshalFunc, 0, len(types))
+
+	for _, typ := range types {
+		if fn, ok := probes[typ]; ok {
+			funcs = append(funcs, fn)
+		}
+	}
+
+	return func(s string) (Value, error) {
+		for _, fn := range funcs {
+			if v, err := fn(s); err == nil {
+				return v, nil
+			}
+		}
+		return &String{s: s}, nil
+	}
+}
+
+// ParseInference parses the given comma separated list of types to infer, in
+// the order they should be tried. An empty list infers no types.
+func ParseInference(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	types := strings.Split(s, ",")
+
+	for i, typ := range types {
+		typ = strings.TrimSpace(typ)
+
+		if _, ok := probes[typ]; !ok {
+			return nil, errors.New("cannot infer type " + typ + ", must be one of int, float, bool, or time")
+		}
+		types[i] = typ
+	}
+	return types, nil
+}
+
+// WithInference configures the Parser to infer the types of columns that are
+// not in the schema by trying each of the given types in order. By default
+// the DefaultInference types are tried.
+func WithInference(types []string) ParserOption {
+	return func(p *Parser) {
+		p.infer = inferrer(types)
+	}
+}
+
+// WithNoInfer configures the Parser to write the values of columns that are
+// not in the schema as strings exactly as they are in the CSV file, rather
+// than guessing their type. This keeps values such as "1.50" from being
+// written as the number 1.5.
+func WithNoInfer() ParserOption {
+	return func(p *Parser) {
+		p.noinfer = true
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_NoInfer(t *testing.T) {
+	s := NewSchema()
+	s.Add("id", SchemaRecord{Type: "int", Dest: "id", Unmarshal: UnmarshalInt(10)})
+
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	csv := "id,phone,score\n1,007123,1.50\n"
+
+	tests := []struct {
+		opts     []ParserOption
+		expected string
+	}{
+		{
+			nil,
+			`{"id":1,"phone":"007123","score":1.5}` + "\n",
+		},
+		{
+			[]ParserOption{WithNoInfer()},
+			`{"id":1,"phone":"007123","score":"1.50"}` + "\n",
+		},
+	}
+
+	for i, test := range tests {
+		p, err := NewParser(strings.NewReader(csv), ',', s, errh, test.opts...)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		var buf bytes.Buffer
+
+		if err := p.Parse(&buf); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if buf.String() != test.expected {
+			t.Errorf("tests[%d] - unexpected output, expected=%q, got=%q\n", i, test.expected, buf.String())
+		}
+	}
+}
+
+func Test_Inference(t *testing.T) {
+	tests := []struct {
+		types    []string
+		val      string
+		expected string
+	}{
+		{DefaultInference, "10", "10"},
+		{DefaultInference, "-1.5e3", "-1500"},
+		{DefaultInference, "007", `"007"`},
+		{DefaultInference, "+44", `"+44"`},
+		{DefaultInference, "NaN", `"NaN"`},
+		{DefaultInference, "Inf", `"Inf"`},
+		{DefaultInference, "'123", `"'123"`},
+		{DefaultInference, "true", `"true"`},
+		{DefaultInference, "2006-01-02T15:04:05Z", `"2006-01-02T15:04:05Z"`},
+		{[]string{"bool"}, "TRUE", "true"},
+		{[]string{"bool"}, "False", "false"},
+		{[]string{"bool"}, "yes", `"yes"`},
+		{[]string{"float", "int"}, "10", "10"},
+		{[]string{"int"}, "1.5", `"1.5"`},
+		{nil, "10", `"10"`},
+	}
+
+	for i, test := range tests {
+		v, err := inferrer(test.types)(test.val)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		b, err := v.MarshalJSON()
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if string(b) != test.expected {
+			t.Errorf("tests[%d] - unexpected json, expected=%q, got=%q\n", i, test.expected, string(b))
+		}
+	}
+
+	if _, err := ParseInference("int,uuid"); err == nil {
+		t.Errorf("expected error for uninferrable type, got nil\n")
+	}
+}
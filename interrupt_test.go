@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cancelEncoder cancels its context after the first record is encoded, as if
+// the conversion were interrupted.
+type cancelEncoder struct {
+	cancel  func()
+	records int
+	closed  bool
+}
+
+func (e *cancelEncoder) Encode(_ *Record) error {
+	e.records++
+	e.cancel()
+	return nil
+}
+
+func (e *cancelEncoder) Close() error {
+	e.closed = true
+	return nil
+}
+
+func Test_ParseContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	enc := &cancelEncoder{cancel: cancel}
+
+	newenc := func(_ io.Writer, _ string, _ []Field) (Encoder, error) {
+		return enc, nil
+	}
+
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	in := strings.NewReader("id,name\n1,Gordon\n2,Alyx\n3,Eli\n")
+
+	p, err := NewParser(in, ',', NewSchema(), errh, WithEncoder(newenc, "users"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.ParseContext(ctx, io.Discard); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", context.Canceled, err)
+	}
+
+	if enc.records != 1 {
+		t.Errorf("unexpected records, expected=%d, got=%d\n", 1, enc.records)
+	}
+
+	if !enc.closed {
+		t.Errorf("expected encoder to be closed when interrupted\n")
+	}
+}
+
+func Test_OutputSetPartial(t *testing.T) {
+	dir := t.TempDir()
+
+	outs := newOutputSet(dir, nil)
+
+	next := outs.chunks("users", ".json")
+
+	for i := 0; i < 2; i++ {
+		w, err := next()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := io.WriteString(w, "{}\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := outs.Partial(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"users.0001.json.partial", "users.0002.json.partial"}
+
+	for i, path := range outs.paths {
+		if name := filepath.Base(path); name != expected[i] {
+			t.Errorf("paths[%d] - unexpected path, expected=%q, got=%q\n", i, expected[i], name)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, expected[i])); err != nil {
+			t.Errorf("paths[%d] - %s\n", i, err)
+		}
+	}
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+
+	if len(names) != 0 {
+		t.Errorf("expected no complete outputs, got=%v\n", names)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "runtime"
+
+const (
+	// filesPerJob is the number of files each conversion can hold open at
+	// once, its input, its output, and its checkpoint.
+	filesPerJob = 3
+
+	// reservedFiles is the number of files kept back from the limit for the
+	// files shared between conversions, such as the error log, and the
+	// standard streams.
+	reservedFiles = 16
+)
+
+// jobslimit returns the number of inputs to convert at once, for the given
+// number of jobs and limit on open files. If jobs is 0 then the number of
+// CPUs, plus some headroom for the time spent waiting on I/O, is used. The
+// number of jobs is then lowered so the files they hold open stay within
+// maxopen, if it is set, though at least one job is always run.
+func jobslimit(jobs, maxopen int) int {
+	if jobs == 0 {
+		jobs = runtime.GOMAXPROCS(0) + 10
+	}
+
+	if maxopen > 0 {
+		n := (maxopen - reservedFiles) / filesPerJob
+
+		if n < 1 {
+			n = 1
+		}
+
+		if jobs > n {
+			jobs = n
+		}
+	}
+	return jobs
+}
+
+// partitionlimit returns the number of partitions each of the given number of
+// jobs can have open at once, for the given limit on open files. Each
+// partition holds its own output open, so these take the place of the output
+// of the job. If maxopen is not set then there is no limit, and 0 is returned.
+func partitionlimit(jobs, maxopen int) int {
+	if maxopen <= 0 {
+		return 0
+	}
+
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	n := (maxopen-reservedFiles)/jobs - (filesPerJob - 1)
+
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func Test_JobsLimit(t *testing.T) {
+	tests := []struct {
+		jobs     int
+		maxopen  int
+		expected int
+	}{
+		{0, 0, runtime.GOMAXPROCS(0) + 10},
+		{4, 0, 4},
+		{4, 1024, 4},
+		{100, reservedFiles + 10*filesPerJob, 10},
+		{100, 1, 1},
+		{0, reservedFiles + filesPerJob, 1},
+	}
+
+	for i, test := range tests {
+		if n := jobslimit(test.jobs, test.maxopen); n != test.expected {
+			t.Errorf("tests[%d] - unexpected jobs, expected=%d, got=%d\n", i, test.expected, n)
+		}
+	}
+}
+
+func Test_PartitionLimit(t *testing.T) {
+	tests := []struct {
+		jobs     int
+		maxopen  int
+		expected int
+	}{
+		{1, 0, 0},
+		{1, 1024, 1024 - reservedFiles - (filesPerJob - 1)},
+		{4, reservedFiles + 4*10, 10 - (filesPerJob - 1)},
+		{4, 1, 1},
+		{0, reservedFiles + 10, 10 - (filesPerJob - 1)},
+	}
+
+	for i, test := range tests {
+		if n := partitionlimit(test.jobs, test.maxopen); n != test.expected {
+			t.Errorf("tests[%d] - unexpected partitions, expected=%d, got=%d\n", i, test.expected, n)
+		}
+	}
+}
+
+func Test_Jobs(t *testing.T) {
+	dir := t.TempDir()
+
+	args := []string{"csv2json", "-jobs", "2", "-max-open", "20", "-o", dir}
+
+	for i := 0; i < 50; i++ {
+		in := filepath.Join(dir, "users"+strconv.Itoa(i)+".csv")
+
+		if err := os.WriteFile(in, []byte("id,name\n"+strconv.Itoa(i)+",Gordon\n"), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, in)
+	}
+
+	if err := run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(filepath.Join(dir, "users"+strconv.Itoa(i)+".json")); err != nil {
+			t.Errorf("tests[%d] - %s\n", i, err)
+		}
+	}
+
+	for _, flag := range []string{"-jobs", "-max-open"} {
+		if err := run([]string{"csv2json", flag, "-1", "-o", dir, args[len(args)-1]}); err == nil {
+			t.Errorf("expected error for negative %s\n", flag)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// jsonSchemaDraft is the dialect of the JSON Schemas exported from a schema.
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema is a JSON Schema, limited to the keywords needed to describe the
+// records written for a schema.
+type JSONSchema struct {
+	Schema     string                 `json:"$schema,omitempty"`
+	Title      string                 `json:"title,omitempty"`
+	Type       string                 `json:"type,omitempty"`
+	Format     string                 `json:"format,omitempty"`
+	Pattern    string                 `json:"pattern,omitempty"`
+	Properties map[string]*JSONSchema `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+}
+
+// jsontype returns the JSON Schema for the values of the given schema type,
+// pattern, and format. Types that are not known, such as those loaded from
+// plugins, can be any value, so an empty JSON Schema is returned.
+func jsontype(typ, pat, outfmt string) *JSONSchema {
+	switch typ {
+	case "string":
+		// The format of a string replaces what matched the pattern, so the
+		// pattern no longer describes the output.
+		if outfmt != "" {
+			pat = ""
+		}
+		return &JSONSchema{Type: "string", Pattern: pat}
+	case "raw":
+		return &JSONSchema{Type: "string"}
+	case "bool":
+		return &JSONSchema{Type: "boolean"}
+	case "int":
+		if outfmt != "" {
+			return &JSONSchema{Type: "string"}
+		}
+		return &JSONSchema{Type: "integer"}
+	case "float":
+		return &JSONSchema{Type: "number"}
+	case "time":
+		if outfmt == "" || outfmt == time.RFC3339 || outfmt == time.RFC3339Nano {
+			return &JSONSchema{Type: "string", Format: "date-time"}
+		}
+		return &JSONSchema{Type: "string"}
+	case "uuid":
+		if outfmt == "" {
+			return &JSONSchema{Type: "string", Format: "uuid"}
+		}
+		return &JSONSchema{Type: "string"}
+	case "ip":
+		switch pat {
+		case "4":
+			return &JSONSchema{Type: "string", Format: "ipv4"}
+		case "6":
+			return &JSONSchema{Type: "string", Format: "ipv6"}
+		}
+		return &JSONSchema{Type: "string"}
+	case "cidr":
+		return &JSONSchema{Type: "string"}
+	case "url":
+		return &JSONSchema{Type: "string", Format: "uri"}
+	case "email":
+		return &JSONSchema{Type: "string", Format: "email"}
+	}
+	return &JSONSchema{}
+}
+
+// JSONSchema returns the JSON Schema of the records written for the schema,
+// with the given title. Fields whose type cannot be known from the schema
+// alone, such as derived fields, or those with transforms, can be any value.
+// Columns not in the schema are still written, so other properties are
+// allowed.
+func (s *Schema) JSONSchema(title string) *JSONSchema {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	js := &JSONSchema{
+		Schema:     jsonSchemaDraft,
+		Title:      title,
+		Type:       "object",
+		Properties: make(map[string]*JSONSchema),
+	}
+
+	for _, rec := range s.recs {
+		if rec.Redact {
+			continue
+		}
+
+		if rec.Capture != nil {
+			for _, dest := range rec.Captures {
+				if dest != "_" {
+					js.Properties[dest] = &JSONSchema{Type: "string"}
+				}
+			}
+			continue
+		}
+
+		prop := jsontype(rec.Type, rec.Pattern, rec.Outfmt)
+
+		if len(rec.Transforms) > 0 {
+			prop = &JSONSchema{}
+		}
+
+		if rec.Convert != nil && len(rec.Transforms) == 0 {
+			prop = &JSONSchema{Type: "number"}
+		}
+
+		if rec.Encrypt {
+			prop = &JSONSchema{Type: "string"}
+		}
+
+		js.Properties[rec.Dest] = prop
+
+		if rec.Required {
+			js.Required = append(js.Required, rec.Dest)
+		}
+	}
+
+	for _, c := range s.combines {
+		js.Properties[c.Dest] = jsontype(c.Type, "", c.Outfmt)
+	}
+
+	for _, c := range s.concats {
+		js.Properties[c.Dest] = jsontype(c.Type, "", c.Outfmt)
+	}
+
+	for _, l := range s.lookups {
+		if l.Field == "" {
+			js.Properties[l.Dest] = &JSONSchema{Type: "object"}
+			continue
+		}
+		js.Properties[l.Dest] = &JSONSchema{}
+	}
+
+	for _, d := range s.derived {
+		js.Properties[d.Dest] = &JSONSchema{}
+	}
+
+	for _, r := range s.rollings {
+		js.Properties[r.Dest] = &JSONSchema{Type: "number"}
+	}
+
+	sort.Strings(js.Required)
+	return js
+}
+
+// jsonProperty is a property of a JSON Schema being loaded. The type can be
+// either a single type, or a list of types.
+type jsonProperty struct {
+	Type    interface{} `json:"type"`
+	Format  string      `json:"format"`
+	Pattern string      `json:"pattern"`
+}
+
+// typename returns the type of the property, ignoring null if it is one of a
+// list of types.
+func (p jsonProperty) typename() string {
+	switch v := p.Type.(type) {
+	case string:
+		return v
+	case []interface{}:
+		for _, typ := range v {
+			if s, ok := typ.(string); ok && s != "null" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// schematype returns the schema type, and pattern, for the property. If the
+// property has no equivalent type, such as objects and arrays, then false is
+// returned.
+func (p jsonProperty) schematype() (string, string, bool) {
+	switch p.typename() {
+	case "integer":
+		return "int", "_", true
+	case "number":
+		return "float", "_", true
+	case "boolean":
+		return "bool", "_", true
+	case "string":
+		switch p.Format {
+		case "date-time":
+			return "time", time.RFC3339, true
+		case "date":
+			return "time", "2006-01-02", true
+		case "uuid":
+			return "uuid", "_", true
+		case "ipv4":
+			return "ip", "4", true
+		case "ipv6":
+			return "ip", "6", true
+		case "email":
+			return "email", "_", true
+		case "uri":
+			return "url", "_", true
+		}
+
+		if p.Pattern != "" {
+			return "string", p.Pattern, true
+		}
+		return "string", "_", true
+	}
+	return "", "", false
+}
+
+// LoadJSONSchema loads the columns of the schema from the properties of the
+// JSON Schema in the given file, as the inverse of JSONSchema. Each property
+// is taken to be a column of the same name, with its type derived from the
+// type, format, and pattern of the property. Required properties become
+// required columns. Properties with no equivalent type, such as objects and
+// arrays, are left out, so their values are inferred.
+func (s *Schema) LoadJSONSchema(fname string) error {
+	b, err := os.ReadFile(fname)
+
+	if err != nil {
+		return err
+	}
+
+	var js struct {
+		Type       interface{}             `json:"type"`
+		Properties map[string]jsonProperty `json:"properties"`
+		Required   []string                `json:"required"`
+	}
+
+	if err := json.Unmarshal(b, &js); err != nil {
+		return errors.New(fname + ": " + err.Error())
+	}
+
+	if (jsonProperty{Type: js.Type}).typename() != "object" {
+		return errors.New(fname + ": json schema must be of type object")
+	}
+
+	required := make(map[string]struct{})
+
+	for _, name := range js.Required {
+		required[name] = struct{}{}
+	}
+
+	// Columns are added in alphabetical order, since the order of the
+	// properties is lost when decoded.
+	names := make([]string, 0, len(js.Properties))
+
+	for name := range js.Properties {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	retab := make(map[string]*regexp.Regexp)
+
+	for _, name := range names {
+		typ, pat, ok := js.Properties[name].schematype()
+
+		if !ok {
+			continue
+		}
+
+		unmarshal, err := unmarshaler(typ, pat, retab)
+
+		if err != nil {
+			return errors.New(fname + ": property " + name + ": " + err.Error())
+		}
+
+		rec := SchemaRecord{
+			Type:      typ,
+			Dest:      name,
+			Unmarshal: unmarshal,
+		}
+
+		if pat != "_" {
+			rec.Pattern = pat
+		}
+
+		if _, ok := required[name]; ok {
+			rec.Required = true
+		}
+		s.Add(name, rec)
+	}
+	return nil
+}
+
+// runSchema exports the given schema in another format, written to stdout.
+func runSchema(argv0 string, args []string) error {
+	usage := usageError(argv0 + " schema export -json-schema [-title title] -s <schema>")
+
+	if len(args) < 1 || args[0] != "export" {
+		return usage
+	}
+
+	var (
+		schema     string
+		title      string
+		jsonschema bool
+	)
+
+	fs := flag.NewFlagSet(argv0+" schema export", flag.ExitOnError)
+	fs.StringVar(&schema, "s", "", "the schema file to export")
+	fs.StringVar(&title, "title", "", "the title of the exported schema")
+	fs.BoolVar(&jsonschema, "json-schema", false, "export the schema as a JSON Schema describing the records written")
+
+	if args := parseflags(fs, args[1:]); len(args) > 0 || schema == "" || !jsonschema {
+		return usage
+	}
+
+	s := NewSchema()
+
+	if err := s.Load(schema); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	// Patterns are regular expressions, so they are kept readable rather than
+	// escaped for HTML.
+	enc.SetEscapeHTML(false)
+
+	return enc.Encode(s.JSONSchema(title))
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_SchemaJSONSchema(t *testing.T) {
+	dir := t.TempDir()
+	schema := filepath.Join(dir, "users.schema")
+
+	lines := "id int _ _ _ required\n" +
+		"email email\n" +
+		"code string ^[A-Z]{3}$\n" +
+		"ip ip 4\n" +
+		"created_at time 2006-01-02\n" +
+		"label = code + '-' + id\n"
+
+	if err := os.WriteFile(schema, []byte(lines), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSchema()
+
+	if err := s.Load(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(s.JSONSchema("users"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var js map[string]interface{}
+
+	if err := json.Unmarshal(b, &js); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"$schema": jsonSchemaDraft,
+		"title":   "users",
+		"type":    "object",
+		"properties": map[string]interface{}{
+			"id":         map[string]interface{}{"type": "integer"},
+			"email":      map[string]interface{}{"type": "string", "format": "email"},
+			"code":       map[string]interface{}{"type": "string", "pattern": "^[A-Z]{3}$"},
+			"ip":         map[string]interface{}{"type": "string", "format": "ipv4"},
+			"created_at": map[string]interface{}{"type": "string", "format": "date-time"},
+			"label":      map[string]interface{}{},
+		},
+		"required": []interface{}{"id"},
+	}
+
+	if !reflect.DeepEqual(js, expected) {
+		t.Errorf("unexpected json schema, expected=%v, got=%v\n", expected, js)
+	}
+}
+
+func Test_RunSchemaUsage(t *testing.T) {
+	schema := filepath.Join("testdata", "users.schema")
+
+	tests := [][]string{
+		{"export"},
+		{"export", "-s", schema},
+		{"export", schema, "-json-schema"},
+	}
+
+	for i, test := range tests {
+		var usage usageError
+
+		if err := runSchema("csv2json", test); !errors.As(err, &usage) {
+			t.Errorf("tests[%d] - expected usage error, got=%v\n", i, err)
+		}
+	}
+}
+
+func Test_RequiredColumn(t *testing.T) {
+	s := NewSchema()
+	s.Add("name", SchemaRecord{Dest: "name", Required: true, Unmarshal: UnmarshalString(nil)})
+
+	errs := 0
+
+	errh := func(line, col int, msg string) {
+		errs++
+	}
+
+	in := strings.NewReader("id,name\n1,Gordon\n2,\n3,Eli\n")
+
+	p, err := NewParser(in, ',', s, errh)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := p.Parse(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs != 1 {
+		t.Errorf("unexpected errors, expected=%d, got=%d\n", 1, errs)
+	}
+
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Errorf("unexpected records, expected=%d, got=%d\n", 2, n)
+	}
+}
+
+func Test_LoadJSONSchema(t *testing.T) {
+	dir := t.TempDir()
+	schema := filepath.Join(dir, "users.json")
+
+	js := `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"properties": {
+		"id": {"type": "integer"},
+		"name": {"type": "string", "pattern": "^[A-Z]"},
+		"verified": {"type": ["boolean", "null"]},
+		"created_at": {"type": "string", "format": "date"},
+		"tags": {"type": "array"}
+	},
+	"required": ["id"]
+}`
+
+	if err := os.WriteFile(schema, []byte(js), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSchema()
+
+	if err := s.LoadJSONSchema(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		col      string
+		typ      string
+		pat      string
+		required bool
+	}{
+		{"id", "int", "", true},
+		{"name", "string", "^[A-Z]", false},
+		{"verified", "bool", "", false},
+		{"created_at", "time", "2006-01-02", false},
+	}
+
+	for _, test := range tests {
+		rec, ok := s.Get(test.col)
+
+		if !ok {
+			t.Fatalf("%s - expected column in schema\n", test.col)
+		}
+
+		if rec.Type != test.typ || rec.Pattern != test.pat || rec.Required != test.required {
+			t.Errorf("%s - unexpected record, expected=%s %q %v, got=%s %q %v\n", test.col, test.typ, test.pat, test.required, rec.Type, rec.Pattern, rec.Required)
+		}
+	}
+
+	if _, ok := s.Get("tags"); ok {
+		t.Error("expected tags to not be in schema")
+	}
+
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	in := strings.NewReader("id,name,verified,created_at\n1,Gordon,true,1998-11-19\n")
+
+	p, err := NewParser(in, ',', s, errh)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := p.Parse(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"created_at":"1998-11-19T00:00:00Z","id":1,"name":"Gordon","verified":true}` + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("unexpected output, expected=%q, got=%q\n", expected, buf.String())
+	}
+
+	if err := os.WriteFile(schema, []byte(`{"type": "array"}`), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewSchema().LoadJSONSchema(schema); err == nil {
+		t.Fatal("expected error for json schema that is not an object, got nil")
+	}
+}
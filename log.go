@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LogFormats are the formats logs can be written in.
+var LogFormats = []string{"text", "json"}
+
+// NewLogger returns a logger that writes the events of a conversion to w, in
+// the given format, from the given level. Text logs are written in the same
+// form as errors always have been, so they can be read as they are, and JSON
+// logs are written as one object per line, for a log stack to pick up.
+func NewLogger(w io.Writer, argv0, format string, level slog.Level) (*slog.Logger, error) {
+	switch format {
+	case "", "text":
+		return slog.New(&textHandler{
+			mu:    &sync.Mutex{},
+			w:     w,
+			argv0: argv0,
+			level: level,
+		}), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})), nil
+	}
+	return nil, errors.New("unknown log format " + format)
+}
+
+// textHandler writes each log as a single line of text. A log with a file is
+// prefixed with the file rather than the program, along with the line and
+// column if it has them, for example,
+//
+//     users.csv,3:2 - id: int strconv.ParseInt: parsing "x": invalid syntax
+//
+// Any other attributes are written after the message as key=value pairs.
+type textHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	argv0 string
+	level slog.Level
+	attrs []slog.Attr
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var (
+		file      string
+		line, col string
+	)
+
+	attrs := make([]slog.Attr, 0, len(h.attrs)+r.NumAttrs())
+	attrs = append(attrs, h.attrs...)
+
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	var rest strings.Builder
+
+	for _, a := range attrs {
+		switch a.Key {
+		case "file":
+			file = a.Value.String()
+			continue
+		case "line":
+			line = a.Value.String()
+			continue
+		case "col":
+			col = a.Value.String()
+			continue
+		}
+
+		val := a.Value.String()
+
+		if strings.ContainsAny(val, " \t\"") {
+			val = strconv.Quote(val)
+		}
+		rest.WriteString(" " + a.Key + "=" + val)
+	}
+
+	var buf strings.Builder
+
+	switch {
+	case file != "" && line != "":
+		buf.WriteString(file + "," + line + ":" + col + " - ")
+	case file != "":
+		buf.WriteString(file + ": ")
+	default:
+		buf.WriteString(h.argv0 + ": ")
+	}
+
+	buf.WriteString(r.Message)
+	buf.WriteString(rest.String())
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err := io.WriteString(h.w, buf.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)
+	return &h2
+}
+
+// WithGroup returns the handler as it is, since text logs have no groups.
+func (h *textHandler) WithGroup(_ string) slog.Handler { return h }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func Test_LoggerText(t *testing.T) {
+	var buf bytes.Buffer
+
+	log, err := NewLogger(&buf, "csv2json", "text", slog.LevelInfo)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.Debug("converting", "file", "users.csv")
+	log.Error(`id: int strconv.ParseInt: parsing "x": invalid syntax`, "file", "users.csv", "line", 3, "col", 2)
+	log.Info("skipped, output is up to date", "file", "users.csv")
+	log.With("file", "users.csv").Info("converted", "records", 10, "output", "out dir/users.json")
+	log.Error("encountered errors during preflight")
+
+	expected := `users.csv,3:2 - id: int strconv.ParseInt: parsing "x": invalid syntax
+users.csv: skipped, output is up to date
+users.csv: converted records=10 output="out dir/users.json"
+csv2json: encountered errors during preflight
+`
+
+	if buf.String() != expected {
+		t.Errorf("unexpected logs, expected=%q, got=%q\n", expected, buf.String())
+	}
+}
+
+func Test_LoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	log, err := NewLogger(&buf, "csv2json", "json", slog.LevelError)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.Info("converting", "file", "users.csv")
+	log.Error("required value is empty", "file", "users.csv", "line", 4, "col", 3)
+
+	var ev struct {
+		Level string `json:"level"`
+		Msg   string `json:"msg"`
+		File  string `json:"file"`
+		Line  int    `json:"line"`
+		Col   int    `json:"col"`
+	}
+
+	if err := json.Unmarshal(buf.Bytes(), &ev); err != nil {
+		t.Fatalf("expected a single json log, got=%q: %s\n", buf.String(), err)
+	}
+
+	if ev.Level != "ERROR" || ev.Msg != "required value is empty" || ev.File != "users.csv" || ev.Line != 4 || ev.Col != 3 {
+		t.Errorf("unexpected log, got=%+v\n", ev)
+	}
+
+	if _, err := NewLogger(&buf, "csv2json", "xml", slog.LevelInfo); err == nil {
+		t.Error("expected error for unknown log format")
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// LookupTable is a table of rows from a secondary CSV file, keyed by the value
+// of one of its columns. If more than one row has the same key, then the first
+// is kept.
+type LookupTable struct {
+	headers []string
+	rows    map[string][]string
+}
+
+// LoadLookup loads the given CSV file into a LookupTable, keyed by the given
+// column.
+func LoadLookup(fname, key string) (*LookupTable, error) {
+	f, err := os.Open(fname)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	r := csv.NewReader(decodebom(f))
+
+	headers, err := r.Read()
+
+	if err != nil {
+		return nil, errors.New(fname + ": " + err.Error())
+	}
+
+	keycol := -1
+
+	for i, hdr := range headers {
+		if hdr == key {
+			keycol = i
+			break
+		}
+	}
+
+	if keycol < 0 {
+		return nil, errors.New(fname + ": no such column " + key)
+	}
+
+	t := &LookupTable{
+		headers: headers,
+		rows:    make(map[string][]string),
+	}
+
+	for {
+		row, err := r.Read()
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, errors.New(fname + ": " + err.Error())
+		}
+
+		if _, ok := t.rows[row[keycol]]; !ok {
+			t.rows[row[keycol]] = row
+		}
+	}
+	return t, nil
+}
+
+// parselookup parses the given lookup in the form of name=file:column, and
+// loads its table.
+func parselookup(s string) (string, *LookupTable, error) {
+	i := strings.Index(s, "=")
+	j := strings.LastIndex(s, ":")
+
+	if i <= 0 || j < i {
+		return "", nil, errors.New("invalid lookup " + s + ", expected name=file:column")
+	}
+
+	t, err := LoadLookup(s[i+1:j], s[j+1:])
+
+	if err != nil {
+		return "", nil, err
+	}
+	return s[:i], t, nil
+}
+
+// column returns the index of the given column in the table.
+func (t *LookupTable) column(name string) (int, bool) {
+	for i, hdr := range t.headers {
+		if hdr == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Lookup maps the value of a column through a LookupTable, setting either a
+// single field of the matched row, or the whole row as a nested object, on
+// the destination. If Drop is set, then the original column is removed when a
+// row is matched.
+type Lookup struct {
+	Column string
+	Table  string
+	Dest   string
+	Field  string // field of the matched row to set, if empty the whole row
+	Drop   bool
+}
+
+func (s *Schema) AddLookup(l Lookup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lookups = append(s.lookups, l)
+	s.addpos(l.Dest)
+}
+
+func (s *Schema) Lookups() []Lookup {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lookups
+}
+
+// loadLookup decodes the given parts of a @lookup directive into a Lookup and
+// adds it to the schema. This is in the form of,
+//
+//     @lookup column table destination [field=column] [drop]
+func (s *Schema) loadLookup(parts []string) error {
+	if len(parts) < 4 {
+		return errors.New("too few columns in lookup directive")
+	}
+
+	l := Lookup{
+		Column: parts[1],
+		Table:  parts[2],
+		Dest:   parts[3],
+	}
+
+	for _, opt := range parseopts(parts[4:]) {
+		switch opt.key {
+		case "field":
+			l.Field = opt.val
+		case "drop":
+			l.Drop = true
+		default:
+			return errors.New("unexpected option " + opt.key + " in lookup directive")
+		}
+	}
+
+	s.AddLookup(l)
+	return nil
+}
+
+// Object is a Value holding a nested record, such as the row matched by a
+// Lookup.
+type Object struct {
+	rec *Record
+}
+
+func (o *Object) Format(_ string) {}
+
+func (o *Object) MarshalJSON() ([]byte, error) { return o.rec.MarshalJSON() }
+
+// value returns the Value to set for the given matched row.
+func (l Lookup) value(t *LookupTable, row []string) (Value, error) {
+	if l.Field != "" {
+		i, _ := t.column(l.Field)
+		return unmarshalAny(row[i])
+	}
+
+	rec := NewRecord()
+
+	for i, hdr := range t.headers {
+		if i >= len(row) || row[i] == "" {
+			continue
+		}
+
+		v, err := unmarshalAny(row[i])
+
+		if err != nil {
+			return nil, err
+		}
+		rec.Set(hdr, v)
+	}
+	return &Object{rec: rec}, nil
+}
+
+// WithLookups configures the Parser to use the given tables, by name, for the
+// schema's lookups.
+func WithLookups(tables map[string]*LookupTable) ParserOption {
+	return func(p *Parser) {
+		p.lookups = tables
+	}
+}
+
+// checklookups checks that every lookup in the schema has a table, and that
+// the field it sets is in that table.
+func (p *Parser) checklookups() error {
+	for _, l := range p.schema.Lookups() {
+		t, ok := p.lookups[l.Table]
+
+		if !ok {
+			return errors.New("unknown lookup table " + l.Table + ", expected -lookup " + l.Table + "=file:column")
+		}
+
+		if l.Field != "" {
+			if _, ok := t.column(l.Field); !ok {
+				return errors.New("lookup table " + l.Table + " has no column " + l.Field)
+			}
+		}
+	}
+	return nil
+}
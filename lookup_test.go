@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Lookup(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"countries.csv": "code,name,population\nGB,United Kingdom,67000000\nUS,United States,331000000\nGB,Duplicate,0\n",
+		"users.csv":     "id,name,country_code\n1,Alyx,GB\n2,Gordon,US\n3,Eli,FR\n4,Barney,\n",
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		schema   string
+		expected string
+	}{
+		{
+			"@lookup country_code countries country field=name drop\n",
+			`{"country":"United Kingdom","id":1,"name":"Alyx"}` + "\n" +
+				`{"country":"United States","id":2,"name":"Gordon"}` + "\n" +
+				`{"country_code":"FR","id":3,"name":"Eli"}` + "\n" +
+				`{"id":4,"name":"Barney"}` + "\n",
+		},
+		{
+			"@lookup country_code countries country_code field=name\n",
+			`{"country_code":"United Kingdom","id":1,"name":"Alyx"}` + "\n" +
+				`{"country_code":"United States","id":2,"name":"Gordon"}` + "\n" +
+				`{"country_code":"FR","id":3,"name":"Eli"}` + "\n" +
+				`{"id":4,"name":"Barney"}` + "\n",
+		},
+		{
+			"@lookup country_code countries country\n",
+			`{"country":{"code":"GB","name":"United Kingdom","population":67000000},"country_code":"GB","id":1,"name":"Alyx"}` + "\n" +
+				`{"country":{"code":"US","name":"United States","population":331000000},"country_code":"US","id":2,"name":"Gordon"}` + "\n" +
+				`{"country_code":"FR","id":3,"name":"Eli"}` + "\n" +
+				`{"id":4,"name":"Barney"}` + "\n",
+		},
+	}
+
+	for i, test := range tests {
+		schema := filepath.Join(dir, "users.schema")
+
+		if err := os.WriteFile(schema, []byte(test.schema), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+
+		args := []string{
+			"csv2json",
+			"-o", dir,
+			"-s", schema,
+			"-lookup", "countries=" + filepath.Join(dir, "countries.csv") + ":code",
+			filepath.Join(dir, "users.csv"),
+		}
+
+		if err := run(args); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, "users.json"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != test.expected {
+			t.Errorf("tests[%d] - unexpected output\n\texpected=%q\n\tgot=     %q\n", i, test.expected, b)
+		}
+	}
+}
+
+func Test_LookupErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	countries := filepath.Join(dir, "countries.csv")
+
+	if err := os.WriteFile(countries, []byte("code,name\nGB,United Kingdom\n"), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := parselookup("countries=" + countries + ":iso"); err == nil {
+		t.Error("expected error for unknown key column")
+	}
+
+	if _, _, err := parselookup(countries); err == nil {
+		t.Error("expected error for lookup without a name")
+	}
+
+	s := NewSchema()
+	s.AddLookup(Lookup{Column: "country", Table: "regions", Dest: "region"})
+
+	if _, err := NewParser(nil, ',', s, nil); err == nil {
+		t.Error("expected error for unknown lookup table")
+	}
+
+	_, table, err := parselookup("countries=" + countries + ":code")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s = NewSchema()
+	s.AddLookup(Lookup{Column: "country", Table: "countries", Dest: "country", Field: "capital"})
+
+	if _, err := NewParser(nil, ',', s, nil, WithLookups(map[string]*LookupTable{"countries": table})); err == nil {
+		t.Error("expected error for unknown lookup field")
+	}
+}
@@ -2,25 +2,35 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/mail"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
-	"unicode/utf8"
 )
 
 // splitspace slices p into all substrings separated by any number of spaces
-// or tabs. Spaces or tabs wrapped in double-quotes are preserved.
+// or tabs. Spaces or tabs wrapped in double-quotes are preserved, and quotes
+// can appear anywhere in a substring, so both of "a b" and key="a b" are a
+// single substring.
 //
 // For example, the given string
 //
@@ -31,66 +41,58 @@ import (
 //    ["Hello, world", "[0-9]+", "foo"]
 //
 // double-quotes used to preserved spaces or tabs are dropped in the final
-// slice.
-func splitspace(p []byte) []string {
+// slice. A literal double-quote can be escaped as \", as can a space or tab
+// outside of quotes. Any other backslash is kept as it is, so the escapes in
+// regular expressions, such as \d, need no escaping of their own. An empty
+// pair of quotes is an empty substring. If a quote is not terminated, then the
+// substrings are returned along with an error.
+func splitspace(p []byte) ([]string, error) {
 	a := make([]string, 0)
 
 	var (
-		i int
-		r rune
-		w int  // width of current rune in string
-
+		buf    []byte
+		tok    bool // whether a substring has been started
 		quoted bool
-		trim   bool
-		start  int = -1 // where in the string we found a non-space character
 	)
 
-	for i < len(p) {
-		r = rune(p[i])
-		w = 1
+	for i := 0; i < len(p); i++ {
+		c := p[i]
 
-		if r >= utf8.RuneSelf {
-			r, w = utf8.DecodeRune(p[i:])
-		}
+		if c == '\\' && i+1 < len(p) {
+			next := p[i+1]
 
-		if r != ' ' && r != '\t' {
-			if !quoted && start < 0 {
-				start = i
+			if next == '"' || (!quoted && (next == ' ' || next == '\t')) {
+				buf = append(buf, next)
+				tok = true
+				i++
 				continue
 			}
 		}
 
-		i += w
-
-		// Set trim to true so we don't include the quotation marks in the
-		// final string.
-		if r == '"' {
+		switch {
+		case c == '"':
 			quoted = !quoted
-			trim = true
-		}
-
-		if r == ' ' || r == '\t' {
-			if !quoted && start >= 0 {
-				if trim {
-					start += 1
-					i -= 1
-					trim = false
-				}
-				a = append(a, string(p[start:i-w]))
-				start = -1
+			tok = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if tok {
+				a = append(a, string(buf))
+				buf = buf[:0]
+				tok = false
 			}
+		default:
+			buf = append(buf, c)
+			tok = true
 		}
 	}
 
-	if start > 0 {
-		if trim {
-			start += 1
-			i -= 1
-			trim = false
-		}
-		a = append(a, string(p[start:i]))
+	if tok {
+		a = append(a, string(buf))
 	}
-	return a
+
+	if quoted {
+		return a, errors.New("unterminated quote")
+	}
+	return a, nil
 }
 
 type Value interface {
@@ -101,6 +103,10 @@ type Value interface {
 
 type UnmarshalFunc func(s string) (Value, error)
 
+// TransformFunc transforms a Value that has been unmarshalled into a new
+// Value. This is applied before the Value is marshalled.
+type TransformFunc func(v Value) (Value, error)
+
 type UnmarshalError struct {
 	Type string
 	Err  error
@@ -110,21 +116,54 @@ func (e UnmarshalError) Error() string {
 	return e.Type + " " + e.Err.Error()
 }
 
+func (e UnmarshalError) Unwrap() error { return e.Err }
+
+// PatternError is the error returned when a value does not match the pattern
+// of its column.
+type PatternError struct {
+	Value   string
+	Pattern string
+}
+
+func (e PatternError) Error() string {
+	return strconv.Quote(e.Value) + " does not match pattern " + strconv.Quote(e.Pattern)
+}
+
 type String struct {
 	re   *regexp.Regexp
 	s    string
-	repl string // Replacement string used against the underlying regex.
+	repl string                  // Replacement string used against the underlying regex.
+	ops  []func(s string) string // Operations applied to the string, instead of a replacement.
 }
 
+// Format sets the output format of the string, this is either a pipeline of
+// operations such as trim|lower, or a replacement string used against the
+// string's pattern.
 func (s *String) Format(repl string) {
+	if ops, ok := parseops(repl); ok {
+		s.ops = ops
+		return
+	}
 	s.repl = repl
 }
 
+// replaced returns the string with its replacement applied, if it has one.
+// The string itself is left as it is, since a Value can be marshalled more
+// than once, such as by a filter and then the output.
+func (s *String) replaced() string {
+	if s.repl != "" && s.re != nil {
+		return s.re.ReplaceAllString(s.s, s.repl)
+	}
+	return s.s
+}
+
 func (s *String) MarshalJSON() ([]byte, error) {
-	if s.repl != "" {
-		s.s = s.re.ReplaceAllString(s.s, s.repl)
+	str := s.replaced()
+
+	for _, op := range s.ops {
+		str = op(str)
 	}
-	return json.Marshal(s.s)
+	return json.Marshal(str)
 }
 
 func UnmarshalString(re *regexp.Regexp) UnmarshalFunc {
@@ -133,7 +172,7 @@ func UnmarshalString(re *regexp.Regexp) UnmarshalFunc {
 			if !re.Match([]byte(s)) {
 				return nil, UnmarshalError{
 					Type: "string",
-					Err:  fmt.Errorf("%q does not match pattern %q", s, re.String()),
+					Err:  PatternError{Value: s, Pattern: re.String()},
 				}
 			}
 		}
@@ -148,7 +187,7 @@ type Bool struct {
 func (b Bool) Format(_ string) {}
 
 func (b Bool) MarshalJSON() ([]byte, error) {
-	return json.Marshal(b.b)
+	return strconv.AppendBool(nil, b.b), nil
 }
 
 var booltab = map[string]bool{
@@ -169,13 +208,29 @@ func UnmarshalBool(s string) (Value, error) {
 }
 
 type Int struct {
-	n int
+	n      int
+	outfmt string
 }
 
-func (i *Int) Format(_ string) {}
+// Format sets the output format of the integer, this is one of hex, oct, or
+// bin, or a verb for fmt.Sprintf such as %05d. Integers with a format are
+// written as strings.
+func (i *Int) Format(fmt string) { i.outfmt = fmt }
 
 func (i *Int) MarshalJSON() ([]byte, error) {
-	return json.Marshal(i.n)
+	switch i.outfmt {
+	case "hex":
+		return json.Marshal(strconv.FormatInt(int64(i.n), 16))
+	case "oct":
+		return json.Marshal(strconv.FormatInt(int64(i.n), 8))
+	case "bin":
+		return json.Marshal(strconv.FormatInt(int64(i.n), 2))
+	}
+
+	if strings.HasPrefix(i.outfmt, "%") {
+		return json.Marshal(fmt.Sprintf(i.outfmt, i.n))
+	}
+	return strconv.AppendInt(nil, int64(i.n), 10), nil
 }
 
 func UnmarshalInt(base int) UnmarshalFunc {
@@ -190,15 +245,95 @@ func UnmarshalInt(base int) UnmarshalFunc {
 }
 
 type Float struct {
-	n float64
+	n      float64
+	outfmt string
 }
 
-func (f *Float) Format(_ string) {}
+// Format sets the output format of the float, this is either round:N to round
+// it to N decimal places, or a verb for fmt.Sprintf such as %.2f to write it
+// with a fixed precision. Floats formatted as something other than a number,
+// such as when zero padded, are written as strings.
+func (f *Float) Format(fmt string) { f.outfmt = fmt }
 
 func (f *Float) MarshalJSON() ([]byte, error) {
+	if places, ok := strings.CutPrefix(f.outfmt, "round:"); ok {
+		if n, err := strconv.Atoi(places); err == nil && n >= 0 {
+			// Formatting the float to the number of places, then parsing it
+			// back avoids any error from rounding in binary, such as
+			// 19.900000000000002.
+			rounded, _ := strconv.ParseFloat(strconv.FormatFloat(f.n, 'f', n, 64), 64)
+			return json.Marshal(rounded)
+		}
+	}
+
+	if strings.HasPrefix(f.outfmt, "%") {
+		s := fmt.Sprintf(f.outfmt, f.n)
+
+		if !numre.MatchString(s) {
+			return json.Marshal(s)
+		}
+		return []byte(s), nil
+	}
 	return json.Marshal(f.n)
 }
 
+// checkverb checks that the given format is a verb for fmt.Sprintf that can
+// format the given value, such as %.2f for a float.
+func checkverb(format string, v interface{}) error {
+	if s := fmt.Sprintf(format, v); strings.Contains(s, "%!") {
+		return errors.New("invalid format " + format + ", gives " + s)
+	}
+	return nil
+}
+
+// checkIntFormat returns an error if the given format is not one that an Int
+// can be formatted with.
+func checkIntFormat(format string) error {
+	switch format {
+	case "hex", "oct", "bin":
+		return nil
+	}
+
+	if !strings.HasPrefix(format, "%") {
+		return errors.New("unknown int format " + format + ", expected hex, oct, bin, or a verb such as %05d")
+	}
+	return checkverb(format, 255)
+}
+
+// checkFloatFormat returns an error if the given format is not one that a
+// Float can be formatted with.
+func checkFloatFormat(format string) error {
+	if places, ok := strings.CutPrefix(format, "round:"); ok {
+		if n, err := strconv.Atoi(places); err != nil || n < 0 {
+			return errors.New("invalid format " + format + ", expected round:N to round to N decimal places")
+		}
+		return nil
+	}
+
+	if !strings.HasPrefix(format, "%") {
+		return errors.New("unknown float format " + format + ", expected round:N, or a verb such as %.2f")
+	}
+	return checkverb(format, 1.5)
+}
+
+// checkoutfmt returns an error if the given output format cannot be used
+// with the given type. This is only checked for the types whose format would
+// otherwise be ignored, or written into the output as an error from
+// fmt.Sprintf.
+func checkoutfmt(typ, format string) error {
+	if format == "" {
+		return nil
+	}
+
+	switch typ {
+	case "int":
+		return checkIntFormat(format)
+	case "float":
+		return checkFloatFormat(format)
+	}
+	return nil
+}
+
 func UnmarshalFloat(s string) (Value, error) {
 	n, err := strconv.ParseFloat(s, 64)
 
@@ -230,486 +365,2982 @@ func UnmarshalTime(layout string) UnmarshalFunc {
 	}
 }
 
-type SchemaRecord struct {
-	Outfmt    string
-	Dest      string
-	Unmarshal UnmarshalFunc
-}
+// zerodatere matches the zero dates written by databases such as MySQL, a
+// date of 0000-00-00, with an optional time that is also zero.
+var zerodatere = regexp.MustCompile(`^0000-00-00([ T]00:00(:00(\.0+)?)?)?$`)
 
-type Schema struct {
-	mu   *sync.RWMutex
-	recs map[string]SchemaRecord
+// iszerodate checks if the given string is a zero date, such as 0000-00-00,
+// or 0000-00-00 00:00:00.
+func iszerodate(s string) bool {
+	return zerodatere.MatchString(s)
 }
 
-func NewSchema() *Schema {
-	return &Schema{
-		mu:   &sync.RWMutex{},
-		recs: make(map[string]SchemaRecord),
+// UnmarshalTimeSentinel returns an UnmarshalFunc for parsing times that will
+// tolerate sentinel values that typically appear in database dumps. These are
+// zero dates (0000-00-00), the maximum date (9999-12-31), and leap seconds
+// (23:59:60). The policy determines how these are handled, this is one of,
+//
+// null - the sentinel is written as null
+// clamp - the sentinel is clamped to the nearest valid time
+// string - the sentinel is written as the original string
+func UnmarshalTimeSentinel(layout, policy string) UnmarshalFunc {
+	unmarshal := UnmarshalTime(layout)
+
+	sentinel := func(s string, t time.Time) Value {
+		switch policy {
+		case "null":
+			return Null{}
+		case "string":
+			return &String{s: s}
+		}
+		return &Time{t: t, layout: time.RFC3339}
 	}
-}
-
-func (s *Schema) Add(name string, rec SchemaRecord) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
 
-	s.recs[name] = rec
-}
+	return func(s string) (Value, error) {
+		v, err := unmarshal(s)
 
-func (s *Schema) Get(name string) (SchemaRecord, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+		if err == nil {
+			t := v.(*Time).t
 
-	rec, ok := s.recs[name]
+			if t.Year() == 9999 && t.Month() == time.December && t.Day() == 31 {
+				return sentinel(s, t), nil
+			}
+			return v, nil
+		}
 
-	return rec, ok
-}
+		if iszerodate(s) {
+			return sentinel(s, time.Time{}), nil
+		}
 
-type SchemaDecodeError struct {
-	File string
-	Line int
-	Err  error
+		// A leap second can only be the last second of the day, so the
+		// rest of the time must parse as 23:59.
+		if strings.Contains(s, ":59:60") {
+			if v, lerr := unmarshal(strings.Replace(s, ":59:60", ":59:59", 1)); lerr == nil {
+				if t := v.(*Time).t; t.Hour() == 23 && t.Minute() == 59 && t.Second() == 59 {
+					return sentinel(s, t), nil
+				}
+			}
+		}
+		return nil, err
+	}
 }
 
-func (e SchemaDecodeError) Error() string {
-	return e.File + ":" + strconv.FormatInt(int64(e.Line), 10) + " - " + e.Err.Error()
+type Array struct {
+	vals []Value
 }
 
-func parsebase(s string) (int, error) {
-	n, err := strconv.ParseInt(s, 10, 64)
-
-	if err != nil {
-		return 0, err
-	}
-
-	bases := map[int64]struct{}{
-		0:  {}, // valid for strconv.ParseInt
-		2:  {},
-		8:  {},
-		10: {},
-		16: {},
+func (a *Array) Format(fmt string) {
+	for _, v := range a.vals {
+		v.Format(fmt)
 	}
+}
 
-	if _, ok := bases[n]; !ok {
-		return 0, fmt.Errorf("invalid base %d", n)
+func (a *Array) MarshalJSON() ([]byte, error) {
+	if a.vals == nil {
+		return []byte("[]"), nil
 	}
-	return int(n), nil
+	return json.Marshal(a.vals)
 }
 
-func (s *Schema) Load(fname string) error {
-	f, err := os.Open(fname)
+type Null struct{}
 
-	if err != nil {
-		return err
-	}
+func (n Null) Format(_ string) {}
 
-	defer f.Close()
+func (n Null) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
 
-	sc := bufio.NewScanner(f)
+type UUID struct {
+	u    [16]byte
+	opts map[string]struct{} // output options taken from the schema format
+}
 
-	// Table to store any previously compiled regex.
-	retab := make(map[string]*regexp.Regexp)
+// Format sets the output options for the UUID. This is a comma separated list
+// of options, which can be any of upper, lower, nodash, braces, or urn.
+func (u *UUID) Format(fmt string) {
+	u.opts = make(map[string]struct{})
 
-	line := 0
+	for _, opt := range strings.Split(fmt, ",") {
+		u.opts[opt] = struct{}{}
+	}
+}
 
-	for sc.Scan() {
-		line++
+func (u *UUID) String() string {
+	var s string
+
+	if _, ok := u.opts["nodash"]; ok {
+		s = hex.EncodeToString(u.u[:])
+	} else {
+		b := make([]byte, 36)
+
+		hex.Encode(b[0:8], u.u[0:4])
+		b[8] = '-'
+		hex.Encode(b[9:13], u.u[4:6])
+		b[13] = '-'
+		hex.Encode(b[14:18], u.u[6:8])
+		b[18] = '-'
+		hex.Encode(b[19:23], u.u[8:10])
+		b[23] = '-'
+		hex.Encode(b[24:], u.u[10:])
+
+		s = string(b)
+	}
 
-		p := sc.Bytes()
+	if _, ok := u.opts["upper"]; ok {
+		s = strings.ToUpper(s)
+	}
+	if _, ok := u.opts["braces"]; ok {
+		s = "{" + s + "}"
+	}
+	if _, ok := u.opts["urn"]; ok {
+		s = "urn:uuid:" + s
+	}
+	return s
+}
 
-		if p[0] == '#' {
-			continue
-		}
+func (u *UUID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.String())
+}
 
-		parts := splitspace(p)
+// UnmarshalUUID returns an UnmarshalFunc for parsing UUIDs. The UUIDs can be
+// with or without dashes, wrapped in braces, or prefixed with urn:uuid:. If
+// version is greater than 0, then only UUIDs of that version will be valid.
+func UnmarshalUUID(version int) UnmarshalFunc {
+	return func(s string) (Value, error) {
+		raw := s
 
-		if len(parts) < 2 {
-			return SchemaDecodeError{
-				File: fname,
-				Line: line,
-				Err:  errors.New("too few columns in schema record"),
-			}
+		if len(s) > 9 && strings.EqualFold(s[:9], "urn:uuid:") {
+			s = s[9:]
 		}
 
-		col := parts[0]
-		typ := parts[1]
-		pat := "_"
-		fmt := ""
-		dst := col
-
-		if len(parts) >= 3 {
-			pat = parts[2]
-
-			if len(parts) >= 4 {
-				fmt = parts[3]
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			s = s[1 : len(s)-1]
+		}
 
-				if len(parts) >= 5 {
-					dst = parts[4]
+		if len(s) == 36 {
+			if s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+				return nil, UnmarshalError{
+					Type: "uuid",
+					Err:  errors.New("invalid uuid: " + raw),
 				}
 			}
+			s = s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
 		}
 
-		var unmarshal UnmarshalFunc
-
-		switch typ {
-		case "string":
-			var re *regexp.Regexp
-
-			if pat != "_" {
-				var ok bool
-
-				re, ok = retab[pat]
-
-				if !ok {
-					var err error
+		var u UUID
 
-					re, err = regexp.Compile(pat)
+		if len(s) != 32 {
+			return nil, UnmarshalError{
+				Type: "uuid",
+				Err:  errors.New("invalid uuid: " + raw),
+			}
+		}
 
-					if err != nil {
-						return SchemaDecodeError{
-							File: fname,
-							Line: line,
-							Err:  err,
-						}
-					}
-				}
+		if _, err := hex.Decode(u.u[:], []byte(s)); err != nil {
+			return nil, UnmarshalError{
+				Type: "uuid",
+				Err:  errors.New("invalid uuid: " + raw),
 			}
-			unmarshal = UnmarshalString(re)
-		case "bool":
-			unmarshal = UnmarshalBool
-		case "int":
-			base := 10
-
-			if pat != "_" {
-				n, err := parsebase(pat)
-
-				if err != nil {
-					return SchemaDecodeError{
-						File: fname,
-						Line: line,
-						Err:  err,
-					}
+		}
+
+		if version > 0 {
+			if v := int(u.u[6] >> 4); v != version {
+				return nil, UnmarshalError{
+					Type: "uuid",
+					Err:  fmt.Errorf("%q is version %d, expected version %d", raw, v, version),
 				}
-				base = n
-			}
-			unmarshal = UnmarshalInt(base)
-		case "float":
-			unmarshal = UnmarshalFloat
-		case "time":
-			if pat == "_" {
-				pat = time.RFC3339
-			}
-			unmarshal = UnmarshalTime(pat)
-		default:
-			return SchemaDecodeError{
-				File: fname,
-				Line: line,
-				Err:  errors.New("unknown schema type " + typ),
 			}
 		}
-
-		s.Add(col, SchemaRecord{
-			Outfmt:    fmt,
-			Dest:      dst,
-			Unmarshal: unmarshal,
-		})
-	}
-
-	if err := sc.Err(); err != nil {
-		return err
+		return &u, nil
 	}
-	return nil
 }
 
-type pos struct {
-	line, col int
+type IP struct {
+	addr   netip.Addr
+	outfmt string
 }
 
-type Parser struct {
-	csv    *csv.Reader
-	schema *Schema
-	errh   func(int, int, string)
-
-	headers []string // first line of the csv file
-
-	// The current record and position in that record is tracked so we can have
-	// an accurate position when emitting errors to the error handler.
-	record []string // current csv record we've scanned
-	recpos int      // position in the record we've scanner
+// Format sets the output format of the IP address, this can be either
+// expanded, or compressed.
+func (ip *IP) Format(fmt string) { ip.outfmt = fmt }
 
-	pos pos // line and colum position in the stream, incremented each time we
-	// scan in a record, or retrieve a column from a scanned record.
-	errc int
+func (ip *IP) MarshalJSON() ([]byte, error) {
+	if ip.outfmt == "expanded" {
+		return json.Marshal(ip.addr.StringExpanded())
+	}
+	return json.Marshal(ip.addr.String())
 }
 
-func NewParser(in io.Reader, delim rune, schema *Schema, errh func(int, int, string)) (*Parser, error) {
-	p := &Parser{
-		csv:    csv.NewReader(in),
-		schema: schema,
-		errh:   errh,
+// checkipversion checks that the given address matches the IP version. If the
+// version is 0 then any version is valid.
+func checkipversion(addr netip.Addr, version int) error {
+	switch version {
+	case 4:
+		if !addr.Unmap().Is4() {
+			return errors.New(addr.String() + " is not an IPv4 address")
+		}
+	case 6:
+		if !addr.Is6() || addr.Is4In6() {
+			return errors.New(addr.String() + " is not an IPv6 address")
+		}
 	}
+	return nil
+}
 
-	p.csv.Comma = delim
+// UnmarshalIP returns an UnmarshalFunc for parsing IP addresses. If version is
+// either 4 or 6, then only IP addresses of that version will be valid.
+func UnmarshalIP(version int) UnmarshalFunc {
+	return func(s string) (Value, error) {
+		addr, err := netip.ParseAddr(s)
 
-	if err := p.init(); err != nil {
-		return nil, err
+		if err != nil {
+			return nil, UnmarshalError{Type: "ip", Err: err}
+		}
+
+		if err := checkipversion(addr, version); err != nil {
+			return nil, UnmarshalError{Type: "ip", Err: err}
+		}
+		return &IP{addr: addr}, nil
 	}
-	return p, nil
 }
 
-// nextrecord reads in the next record from the underlying input stream.
-func (p *Parser) nextrecord() error {
-	record, err := p.csv.Read()
+type CIDR struct {
+	prefix netip.Prefix
+	outfmt string
+}
 
-	if err != nil {
-		return err
+// Format sets the output format of the CIDR, this can be either expanded,
+// compressed, or masked. If masked, then the CIDR is written with the host
+// bits of the address zeroed.
+func (c *CIDR) Format(fmt string) { c.outfmt = fmt }
+
+func (c *CIDR) MarshalJSON() ([]byte, error) {
+	switch c.outfmt {
+	case "expanded":
+		return json.Marshal(c.prefix.Addr().StringExpanded() + "/" + strconv.FormatInt(int64(c.prefix.Bits()), 10))
+	case "masked":
+		return json.Marshal(c.prefix.Masked().String())
 	}
+	return json.Marshal(c.prefix.String())
+}
 
-	p.record = record
-	p.recpos = 0
+// UnmarshalCIDR returns an UnmarshalFunc for parsing CIDR notation IP
+// prefixes. If version is either 4 or 6, then only prefixes of that version
+// will be valid.
+func UnmarshalCIDR(version int) UnmarshalFunc {
+	return func(s string) (Value, error) {
+		prefix, err := netip.ParsePrefix(s)
 
-	p.pos.line++
-	p.pos.col = 1
+		if err != nil {
+			return nil, UnmarshalError{Type: "cidr", Err: err}
+		}
 
-	return nil
+		if err := checkipversion(prefix.Addr(), version); err != nil {
+			return nil, UnmarshalError{Type: "cidr", Err: err}
+		}
+		return &CIDR{prefix: prefix}, nil
+	}
+}
+
+type URL struct {
+	u      *url.URL
+	outfmt string
 }
 
-// next returns the column name, and value of the next column in the current
-// record the parser has scanned in.
-func (p *Parser) next() (string, string) {
-	if p.recpos >= len(p.record) {
-		return "", ""
+// Format sets the component of the URL to write, this is one of scheme, host,
+// hostname, port, path, query, or fragment.
+func (u *URL) Format(fmt string) { u.outfmt = fmt }
+
+func (u *URL) MarshalJSON() ([]byte, error) {
+	var s string
+
+	switch u.outfmt {
+	case "scheme":
+		s = u.u.Scheme
+	case "host":
+		s = u.u.Host
+	case "hostname":
+		s = u.u.Hostname()
+	case "port":
+		s = u.u.Port()
+	case "path":
+		s = u.u.Path
+	case "query":
+		s = u.u.RawQuery
+	case "fragment":
+		s = u.u.Fragment
+	default:
+		s = u.u.String()
 	}
+	return json.Marshal(s)
+}
 
-	hdr := p.headers[p.recpos]
-	val := p.record[p.recpos]
+// UnmarshalURL returns an UnmarshalFunc for parsing absolute URLs. If any
+// schemes are given, then only URLs with one of those schemes will be valid.
+func UnmarshalURL(schemes ...string) UnmarshalFunc {
+	return func(s string) (Value, error) {
+		u, err := url.Parse(s)
 
-	// Width of column value to increment column position by.
-	w := len(val)
+		if err != nil {
+			return nil, UnmarshalError{Type: "url", Err: err}
+		}
 
-	if w == 0 {
-		w = 1
-	}
+		if u.Scheme == "" || (u.Host == "" && u.Opaque == "") {
+			return nil, UnmarshalError{
+				Type: "url",
+				Err:  errors.New("invalid absolute url: " + s),
+			}
+		}
 
-	p.recpos++
-	p.pos.col += w
+		if len(schemes) > 0 {
+			ok := false
 
-	return hdr, val
-}
+			for _, scheme := range schemes {
+				if strings.EqualFold(u.Scheme, scheme) {
+					ok = true
+					break
+				}
+			}
 
-// init will initialize the parser by reading the first line in the underlying
-// input stream and using that as the header.
-func (p *Parser) init() error {
-	if err := p.nextrecord(); err != nil {
-		return nil
+			if !ok {
+				return nil, UnmarshalError{
+					Type: "url",
+					Err:  fmt.Errorf("%q does not have scheme %s", s, strings.Join(schemes, ",")),
+				}
+			}
+		}
+		return &URL{u: u}, nil
 	}
+}
 
-	p.headers = p.record
-	return nil
+type Email struct {
+	local  string
+	domain string
+	outfmt string
 }
 
-func (p *Parser) err(err error) {
-	p.errc++
-	p.errh(p.pos.line, p.pos.col, err.Error())
+// Format sets the output format of the email address, this is one of local,
+// domain, or lower. The lower format will lowercase the domain of the address,
+// the local part is left as is, since it may be case sensitive.
+func (e *Email) Format(fmt string) { e.outfmt = fmt }
+
+func (e *Email) MarshalJSON() ([]byte, error) {
+	var s string
+
+	switch e.outfmt {
+	case "local":
+		s = e.local
+	case "domain":
+		s = e.domain
+	case "lower":
+		s = e.local + "@" + strings.ToLower(e.domain)
+	default:
+		s = e.local + "@" + e.domain
+	}
+	return json.Marshal(s)
 }
 
-func unmarshalAny(s string) (Value, error) {
-	funcs := []UnmarshalFunc{
-		UnmarshalInt(10),
-		UnmarshalFloat,
-		UnmarshalTime(time.RFC3339),
-		UnmarshalString(nil),
+func UnmarshalEmail(s string) (Value, error) {
+	addr, err := mail.ParseAddress(s)
+
+	if err != nil {
+		return nil, UnmarshalError{Type: "email", Err: err}
 	}
 
-	for _, fn := range funcs {
-		if v, err := fn(s); err == nil {
-			return v, nil
+	// Only accept bare addresses, and not those like "Name <user@host>".
+	if addr.Name != "" || addr.Address != s {
+		return nil, UnmarshalError{
+			Type: "email",
+			Err:  errors.New("invalid email address: " + s),
 		}
 	}
-	return &String{s: s}, nil
-}
 
-type ColumnError struct {
-	Col string
-	Err error
-}
+	i := strings.LastIndex(addr.Address, "@")
 
-func (e ColumnError) Error() string {
-	return e.Col + ": " + e.Err.Error()
+	return &Email{
+		local:  addr.Address[:i],
+		domain: addr.Address[i+1:],
+	}, nil
 }
 
-func (p *Parser) json() ([]byte, error) {
-	m := make(map[string]Value)
+type SchemaRecord struct {
+	Type       string // name of the type in the schema
+	Pattern    string // pattern of the type in the schema, empty if none
+	Outfmt     string
+	Dest       string
+	Required   bool              // the column must have a value
+	Aliases    []string          // other headers the column can have
+	Capture    *regexp.Regexp    // pattern to capture groups of the value with
+	Captures   []string          // destinations of each captured group, written instead of Dest
+	Map        map[string]string // values to translate the column's values to before being unmarshalled
+	Redact     bool              // the column is not written
+	Encrypt    bool              // the column is encrypted with the Parser's key
+	Unmarshal  UnmarshalFunc
+	Transforms []TransformFunc
+	Convert    *Conversion // currency conversion applied among the transforms
+
+	// Range of bytes the column occupies in a fixed width file, End is 0 if
+	// there is no range.
+	Start int
+	End   int
+}
 
-	for {
-		col, val := p.next()
+// TransformExpr returns a TransformFunc that evaluates the given expression
+// against the Value. The Value is available in the expression via the value
+// variable.
+func TransformExpr(e *Expr) TransformFunc {
+	return func(v Value) (Value, error) {
+		val, err := exprvalue(v)
 
-		if val == "" {
-			if col == "" {
-				break
-			}
-			continue
+		if err != nil {
+			return nil, err
 		}
 
-		rec, ok := p.schema.Get(col)
-
-		if !ok {
-			rec = SchemaRecord{
-				Dest:      col,
-				Unmarshal: unmarshalAny,
+		res, err := e.Eval(func(name string) (interface{}, bool) {
+			if name != "value" {
+				return nil, false
 			}
+			return val, true
+		})
+
+		if err != nil {
+			return nil, err
+		}
+		return valueof(res), nil
+	}
+}
+
+// Combine describes multiple columns in a CSV file that are combined into a
+// single value. The values of each column are joined with a space before being
+// unmarshalled.
+type Combine struct {
+	Type      string
+	Columns   []string
+	Outfmt    string
+	Dest      string
+	Drop      bool // drop the source columns from the output
+	Unmarshal UnmarshalFunc
+}
+
+// Concat describes a column that is not in the CSV file, but is the
+// concatenation of other columns. The formatted value of each column is
+// joined with the separator before being unmarshalled, columns with no value
+// are left out. If there is a template, then the values are written into it
+// instead.
+type Concat struct {
+	Type      string
+	Columns   []string
+	Sep       string
+	Template  []templatePart // template the values are written into, if any
+	Outfmt    string
+	Dest      string
+	Drop      bool // drop the source columns from the output
+	Unmarshal UnmarshalFunc
+}
+
+// Derived describes a column that is not in the CSV file, but is derived from
+// an expression. Any columns in the CSV file, and any previously derived
+// columns, can be referred to by name in the expression.
+type Derived struct {
+	Dest string
+	Expr *Expr
+}
+
+type Schema struct {
+	mu       *sync.RWMutex
+	recs     map[string]SchemaRecord
+	combines []Combine
+	concats  []Concat
+	derived  []Derived
+	checks   []Check
+	rollings []Rolling
+	lookups  []Lookup
+	filters  []*Expr
+	patterns map[string]string // named patterns defined via @pattern
+
+	// Position of each destination in the schema, in the order they were
+	// added.
+	positions map[string]int
+}
+
+func NewSchema() *Schema {
+	return &Schema{
+		mu:        &sync.RWMutex{},
+		recs:      make(map[string]SchemaRecord),
+		patterns:  make(map[string]string),
+		positions: make(map[string]int),
+	}
+}
+
+func (s *Schema) Add(name string, rec SchemaRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recs[name] = rec
+	s.addpos(rec.Dest)
+
+	for _, dest := range rec.Captures {
+		if dest != "_" {
+			s.addpos(dest)
+		}
+	}
+}
+
+// addpos records the position of the given destination in the schema, if it
+// has not already been seen. This assumes the lock is held.
+func (s *Schema) addpos(dest string) {
+	if _, ok := s.positions[dest]; !ok {
+		s.positions[dest] = len(s.positions)
+	}
+}
+
+// Position returns the position of the given destination in the schema.
+func (s *Schema) Position(dest string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.positions[dest]
+	return i, ok
+}
+
+func (s *Schema) Get(name string) (SchemaRecord, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.recs[name]
+
+	return rec, ok
+}
+
+func (s *Schema) AddCombine(c Combine) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.combines = append(s.combines, c)
+	s.addpos(c.Dest)
+}
+
+func (s *Schema) Combines() []Combine {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.combines
+}
+
+func (s *Schema) AddConcat(c Concat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.concats = append(s.concats, c)
+	s.addpos(c.Dest)
+}
+
+func (s *Schema) Concats() []Concat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.concats
+}
+
+func (s *Schema) AddDerived(d Derived) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.derived = append(s.derived, d)
+	s.addpos(d.Dest)
+}
+
+func (s *Schema) Derived() []Derived {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.derived
+}
+
+func (s *Schema) AddFilter(e *Expr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.filters = append(s.filters, e)
+}
+
+func (s *Schema) Filters() []*Expr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filters
+}
+
+type SchemaDecodeError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e SchemaDecodeError) Error() string {
+	return e.File + ":" + strconv.FormatInt(int64(e.Line), 10) + " - " + e.Err.Error()
+}
+
+func parsebase(s string) (int, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+
+	if err != nil {
+		return 0, err
+	}
+
+	bases := map[int64]struct{}{
+		0:  {}, // valid for strconv.ParseInt
+		2:  {},
+		8:  {},
+		10: {},
+		16: {},
+	}
+
+	if _, ok := bases[n]; !ok {
+		return 0, fmt.Errorf("invalid base %d", n)
+	}
+	return int(n), nil
+}
+
+// loadCombine decodes the given parts of a @combine directive into a Combine
+// and adds it to the schema.
+func (s *Schema) loadCombine(parts []string, retab map[string]*regexp.Regexp) error {
+	if len(parts) < 6 {
+		return errors.New("too few columns in combine directive")
+	}
+
+	pat, err := s.pattern(parts[3])
+
+	if err != nil {
+		return err
+	}
+
+	unmarshal, err := unmarshaler(parts[2], pat, retab)
+
+	if err != nil {
+		return err
+	}
+
+	fmt := parts[4]
+
+	if fmt == "_" {
+		fmt = ""
+	}
+
+	if err := checkoutfmt(parts[2], fmt); err != nil {
+		return err
+	}
+
+	c := Combine{
+		Type:      parts[2],
+		Columns:   strings.Split(parts[1], ","),
+		Outfmt:    fmt,
+		Dest:      parts[5],
+		Unmarshal: unmarshal,
+	}
+
+	if len(parts) >= 7 {
+		if parts[6] != "drop" {
+			return errors.New("unexpected option " + parts[6] + " in combine directive")
+		}
+		c.Drop = true
+	}
+
+	s.AddCombine(c)
+	return nil
+}
+
+// loadConcat decodes the given parts of a concat record into a Concat and adds
+// it to the schema. A concat record is in the form of either,
+//
+//   column type concat=column,... [sep=separator] [format=format] [drop]
+//   column type template=template [format=format] [drop]
+func (s *Schema) loadConcat(parts []string, retab map[string]*regexp.Regexp) error {
+	unmarshal, err := unmarshaler(parts[1], "_", retab)
+
+	if err != nil {
+		return err
+	}
+
+	c := Concat{
+		Type:      parts[1],
+		Sep:       " ",
+		Dest:      parts[0],
+		Unmarshal: unmarshal,
+	}
+
+	// Either option gives the columns, so both being given is rejected, in
+	// whichever order they are.
+	var concat, template bool
+
+	for _, opt := range parseopts(parts[2:]) {
+		switch opt.key {
+		case "concat":
+			concat = true
+			c.Columns = strings.Split(opt.val, ",")
+		case "template":
+			template = true
+			c.Template, c.Columns, err = parsetemplate(opt.val)
+
+			if err != nil {
+				return err
+			}
+		case "sep":
+			c.Sep = opt.val
+		case "format":
+			if err := checkoutfmt(c.Type, opt.val); err != nil {
+				return err
+			}
+			c.Outfmt = opt.val
+		case "drop":
+			c.Drop = true
+		default:
+			return errors.New("unknown option " + opt.key)
+		}
+	}
+
+	if concat && template {
+		return errors.New("concat and template options cannot both be given")
+	}
+
+	s.AddConcat(c)
+	return nil
+}
+
+// loadInclude loads the schema files given in the parts of an @include
+// directive, relative to the directory of the including file. This is in the
+// form of,
+//
+//     @include file...
+func (s *Schema) loadInclude(fname string, parts []string, loading map[string]struct{}, errs *SchemaErrors) error {
+	if len(parts) < 2 {
+		return errors.New("too few columns in include directive")
+	}
+
+	for _, include := range parts[1:] {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(fname), include)
+		}
+
+		abs, err := filepath.Abs(include)
+
+		if err != nil {
+			return err
+		}
+
+		if _, ok := loading[abs]; ok {
+			return errors.New("include cycle, " + include + " includes itself")
+		}
+
+		if err := s.load(include, loading, errs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPattern decodes the given parts of a @pattern directive into a named
+// pattern, and adds it to the schema. The pattern is given separately, since
+// it is taken from the raw line. This is in the form of,
+//
+//     @pattern name pattern
+func (s *Schema) loadPattern(parts []string, raw string) error {
+	if len(parts) < 3 {
+		return errors.New("too few columns in pattern directive")
+	}
+
+	name := parts[1]
+	pat := strings.TrimSpace(strings.TrimPrefix(raw, name))
+
+	// A quoted pattern is unquoted, as it would be anywhere else.
+	if strings.HasPrefix(pat, "\"") && len(parts) == 3 {
+		pat = parts[2]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.patterns[name] = pat
+	return nil
+}
+
+// pattern returns the given pattern of a schema record, resolving it to the
+// named pattern it refers to if it begins with @. A pattern that should begin
+// with a literal @ can be escaped as \@.
+func (s *Schema) pattern(pat string) (string, error) {
+	if !strings.HasPrefix(pat, "@") {
+		return pat, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	named, ok := s.patterns[pat[1:]]
+
+	if !ok {
+		return "", errors.New("unknown pattern " + pat[1:] + ", expected @pattern " + pat[1:] + " to be defined before it is used")
+	}
+	return named, nil
+}
+
+type option struct {
+	key, val string
+}
+
+// parseopts parses the given parts into a list of options. Each option is
+// either in the form of key=value, or just a key.
+func parseopts(parts []string) []option {
+	opts := make([]option, 0, len(parts))
+
+	for _, part := range parts {
+		var opt option
+
+		if i := strings.Index(part, "="); i >= 0 {
+			opt.key = part[:i]
+			opt.val = part[i+1:]
+		} else {
+			opt.key = part
+		}
+		opts = append(opts, opt)
+	}
+	return opts
+}
+
+// numeric returns the float value of the given Value if it is numeric.
+func numeric(v Value) (float64, bool) {
+	switch x := v.(type) {
+	case *Int:
+		return float64(x.n), true
+	case *Float:
+		return x.n, true
+	}
+	return 0, false
+}
+
+// TransformScale returns a TransformFunc that divides a numeric Value by the
+// given scale, for example a scale of 100 would convert cents to dollars.
+func TransformScale(scale float64) TransformFunc {
+	return func(v Value) (Value, error) {
+		n, ok := numeric(v)
+
+		if !ok {
+			return nil, errors.New("cannot scale non-numeric value")
+		}
+		return &Float{n: n / scale}, nil
+	}
+}
+
+// TransformRound returns a TransformFunc that rounds a numeric Value to the
+// given number of decimal places.
+func TransformRound(places int) TransformFunc {
+	pow := math.Pow(10, float64(places))
+
+	return func(v Value) (Value, error) {
+		if i, ok := v.(*Int); ok {
+			return i, nil
+		}
+
+		n, ok := numeric(v)
+
+		if !ok {
+			return nil, errors.New("cannot round non-numeric value")
+		}
+		return &Float{n: math.Round(n*pow) / pow}, nil
+	}
+}
+
+// applyopts applies the given options to the schema record with the given type
+// and pattern. Any files given in the options are relative to dir.
+func applyopts(dir, typ, pat string, rec *SchemaRecord, opts []option) error {
+	date := ""
+
+	for _, opt := range opts {
+		if opt.val == "" && isrange(opt.key) {
+			start, end, err := parserange(opt.key)
+
+			if err != nil {
+				return err
+			}
+
+			rec.Start = start
+			rec.End = end
+			continue
+		}
+
+		// Raw values are written exactly as they are read, so nothing can
+		// change them.
+		if typ == "raw" {
+			switch opt.key {
+			case "transform", "scale", "round", "convert":
+				return errors.New(opt.key + " option is not valid for raw")
+			}
+		}
+
+		switch opt.key {
+		case "sentinel":
+			if typ != "time" {
+				return errors.New("sentinel option is only valid for time")
+			}
+
+			switch opt.val {
+			case "null", "clamp", "string":
+			default:
+				return errors.New("invalid sentinel policy " + opt.val)
+			}
+
+			if pat == "_" {
+				pat = time.RFC3339
+			}
+			rec.Unmarshal = UnmarshalTimeSentinel(pat, opt.val)
+		case "transform":
+			e, err := CompileExpr(opt.val)
+
+			if err != nil {
+				return err
+			}
+			rec.Transforms = append(rec.Transforms, TransformExpr(e))
+		case "scale":
+			if typ != "int" && typ != "float" {
+				return errors.New("scale option is only valid for int and float")
+			}
+
+			n, err := strconv.ParseFloat(opt.val, 64)
+
+			if err != nil {
+				return err
+			}
+
+			if n == 0 {
+				return errors.New("scale cannot be zero")
+			}
+			rec.Transforms = append(rec.Transforms, TransformScale(n))
+		case "round":
+			if typ != "int" && typ != "float" {
+				return errors.New("round option is only valid for int and float")
+			}
+
+			n, err := strconv.ParseInt(opt.val, 10, 64)
+
+			if err != nil {
+				return err
+			}
+
+			if n < 0 {
+				return errors.New("round cannot be negative")
+			}
+			rec.Transforms = append(rec.Transforms, TransformRound(int(n)))
+		case "convert":
+			if typ != "int" && typ != "float" {
+				return errors.New("convert option is only valid for int and float")
+			}
+
+			c, err := parseconvert(opt.val, dir)
+
+			if err != nil {
+				return err
+			}
+			// Keep the position of the conversion, so it is applied in the
+			// order the options were given.
+			c.at = len(rec.Transforms)
+			rec.Convert = c
+		case "date":
+			date = opt.val
+		case "required":
+			rec.Required = true
+		case "aliases":
+			if opt.val == "" {
+				return errors.New("aliases option requires at least one alias")
+			}
+			rec.Aliases = strings.Split(opt.val, ",")
+		case "redact":
+			rec.Redact = true
+		case "encrypt":
+			if opt.val != "" && opt.val != "aes-gcm" {
+				return errors.New("invalid encryption " + opt.val + ", expected aes-gcm")
+			}
+			rec.Encrypt = true
+		case "mask":
+			fn, err := parsemask(opt.val)
+
+			if err != nil {
+				return err
+			}
+			rec.Transforms = append(rec.Transforms, fn)
+		case "hash":
+			fn, err := parsehash(opt.val)
+
+			if err != nil {
+				return err
+			}
+			rec.Transforms = append(rec.Transforms, fn)
+		case "map":
+			m, err := parsemap(opt.val)
+
+			if err != nil {
+				return err
+			}
+			rec.Map = m
+		case "captures":
+			if typ != "string" || pat == "_" {
+				return errors.New("captures option is only valid for string with a pattern")
+			}
+
+			re, dests, err := parsecaptures(pat, opt.val)
+
+			if err != nil {
+				return err
+			}
+
+			rec.Capture = re
+			rec.Captures = dests
+		default:
+			return errors.New("unknown option " + opt.key)
+		}
+	}
+
+	if date != "" {
+		if rec.Convert == nil {
+			return errors.New("date option requires convert")
+		}
+		rec.Convert.Date = date
+	}
+	return nil
+}
+
+// Load loads the schema file with the given name into the schema. Any schema
+// files included via @include are loaded in place of the directive. Loading
+// stops at the first line of the schema that is not valid.
+func (s *Schema) Load(fname string) error {
+	return s.load(fname, make(map[string]struct{}), nil)
+}
+
+// LoadAll loads the schema file with the given name into the schema as Load
+// does, but carries on past the lines that are not valid, so every problem
+// with the schema can be fixed at once. The errors of each line are returned
+// as SchemaErrors.
+func (s *Schema) LoadAll(fname string) error {
+	var errs SchemaErrors
+
+	if err := s.load(fname, make(map[string]struct{}), &errs); err != nil {
+		return err
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SchemaErrors are the errors of each line of a schema loaded via LoadAll.
+type SchemaErrors []error
+
+func (e SchemaErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e SchemaErrors) Unwrap() []error { return e }
+
+// loadschema loads the given file into the schema, via LoadAll if all is
+// true, otherwise via Load.
+func loadschema(s *Schema, fname string, all bool) error {
+	if all {
+		return s.LoadAll(fname)
+	}
+	return s.Load(fname)
+}
+
+// schemaerror logs each of the errors of a schema loaded via LoadAll, if the
+// given error is SchemaErrors, returning the error to exit with.
+func schemaerror(log *slog.Logger, err error) error {
+	var errs SchemaErrors
+
+	if !errors.As(err, &errs) {
+		return err
+	}
+
+	for _, err := range errs {
+		log.Error(err.Error())
+	}
+	return errors.New("encountered " + strconv.Itoa(len(errs)) + " errors in schema")
+}
+
+// load loads the given schema file, where loading is the set of files being
+// loaded that include it, so an include cycle can be detected. If errs is not
+// nil, then the errors of each line are collected into it rather than being
+// returned.
+func (s *Schema) load(fname string, loading map[string]struct{}, errs *SchemaErrors) error {
+	abs, err := filepath.Abs(fname)
+
+	if err != nil {
+		return err
+	}
+
+	loading[abs] = struct{}{}
+	defer delete(loading, abs)
+
+	f, err := os.Open(fname)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	sc := linescanner(f)
+
+	// Table to store any previously compiled regex.
+	retab := make(map[string]*regexp.Regexp)
+
+	line := 0
+
+	for sc.Scan() {
+		line++
+
+		// Lines can be indented, so comments and directives are found
+		// after any leading spaces or tabs.
+		p := bytes.TrimLeft(sc.Bytes(), " \t")
+
+		if len(bytes.TrimSpace(p)) == 0 || p[0] == '#' {
+			continue
+		}
+
+		err := s.loadline(fname, p, retab, loading, errs)
+
+		if err == nil {
+			continue
+		}
+
+		// Errors in included files are already reported with the file and
+		// line they are on.
+		var decerr SchemaDecodeError
+
+		if !errors.As(err, &decerr) {
+			err = SchemaDecodeError{
+				File: fname,
+				Line: line,
+				Err:  err,
+			}
+		}
+
+		if errs == nil {
+			return err
+		}
+		*errs = append(*errs, err)
+	}
+
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// loadline loads the given line of the schema file with the given name.
+func (s *Schema) loadline(fname string, p []byte, retab map[string]*regexp.Regexp, loading map[string]struct{}, errs *SchemaErrors) error {
+	parts, err := splitspace(p)
+
+	if len(parts) == 0 {
+		return errors.New("too few columns in schema record")
+	}
+
+	// Derived columns, filters, and named patterns are taken from the
+	// raw line, so any quotes in them are left as they are.
+	raw := parts[0] == "@filter" || parts[0] == "@pattern" || (len(parts) > 1 && parts[1] == "=")
+
+	if err != nil && !raw {
+		return err
+	}
+
+	// Environment variables are expanded once the line is split, so a value
+	// with spaces is still a single field. The pattern and format of a
+	// column are left as they are, since a replacement can refer to its
+	// groups in the same form, such as ${1} or ${name}.
+	pattern := -1
+
+	switch {
+	case raw:
+	case parts[0] == "@combine":
+		pattern = 3
+	case p[0] != '@':
+		pattern = 2
+	}
+
+	for i, part := range parts {
+		if pattern >= 0 && (i == pattern || i == pattern+1) {
+			continue
+		}
+
+		if parts[i], err = expandenv(part); err != nil {
+			return err
+		}
+	}
+
+	if raw {
+		line, err := expandenv(string(p))
+
+		if err != nil {
+			return err
+		}
+		p = []byte(line)
+	}
+
+	if p[0] == '@' {
+		var err error
+
+		switch parts[0] {
+		case "@combine":
+			err = s.loadCombine(parts, retab)
+		case "@monotonic", "@maxdelta":
+			err = s.loadCheck(parts)
+		case "@rolling":
+			err = s.loadRolling(parts)
+		case "@lookup":
+			err = s.loadLookup(parts)
+		case "@pattern":
+			// The pattern is taken from the raw line, so any spaces in
+			// it are preserved.
+			err = s.loadPattern(parts, strings.TrimSpace(string(p[len("@pattern"):])))
+		case "@include":
+			err = s.loadInclude(fname, parts, loading, errs)
+		case "@filter":
+			// As with derived columns, the expression is taken from the
+			// raw line so quotes are preserved.
+			var e *Expr
+
+			e, err = CompileExpr(strings.TrimSpace(string(p[len("@filter"):])))
+
+			if err == nil {
+				s.AddFilter(e)
+			}
+		default:
+			err = errors.New("unknown schema directive " + parts[0])
+		}
+		return err
+	}
+
+	if len(parts) < 2 {
+		return errors.New("too few columns in schema record")
+	}
+
+	// Derived columns are in the form of "column = expression". The
+	// expression is taken from the raw line, since splitspace would drop
+	// the quotes from any strings.
+	if parts[1] == "=" {
+		i := strings.Index(string(p), "=")
+
+		e, err := CompileExpr(strings.TrimSpace(string(p[i+1:])))
+
+		if err != nil {
+			return err
+		}
+
+		s.AddDerived(Derived{
+			Dest: parts[0],
+			Expr: e,
+		})
+		return nil
+	}
+
+	if len(parts) >= 3 && (strings.HasPrefix(parts[2], "concat=") || strings.HasPrefix(parts[2], "template=")) {
+		return s.loadConcat(parts, retab)
+	}
+
+	col := parts[0]
+	typ := parts[1]
+	pat := "_"
+	fmt := ""
+	dst := col
+
+	if len(parts) >= 3 {
+		pat = parts[2]
+
+		if len(parts) >= 4 {
+			fmt = parts[3]
+
+			if fmt == "_" {
+				fmt = ""
+			}
+
+			if len(parts) >= 5 && parts[4] != "_" {
+				dst = parts[4]
+			}
+		}
+	}
+
+	pat, err = s.pattern(pat)
+
+	if err != nil {
+		return err
+	}
+
+	unmarshal, err := unmarshaler(typ, pat, retab)
+
+	if err != nil {
+		return err
+	}
+
+	if typ == "raw" && fmt != "" {
+		return errors.New("raw type takes no format")
+	}
+
+	if err := checkoutfmt(typ, fmt); err != nil {
+		return err
+	}
+
+	rec := SchemaRecord{
+		Type:      typ,
+		Outfmt:    fmt,
+		Dest:      dst,
+		Unmarshal: unmarshal,
+	}
+
+	if pat != "_" {
+		rec.Pattern = pat
+	}
+
+	if len(parts) > 5 {
+		if err := applyopts(filepath.Dir(fname), typ, pat, &rec, parseopts(parts[5:])); err != nil {
+			return err
+		}
+	}
+
+	s.Add(col, rec)
+	return nil
+}
+
+type pos struct {
+	line, col int
+}
+
+// row is a single record read from the CSV file, along with its position in
+// the stream. The position is moved to each field as the record is parsed, so
+// errors can be reported at the line and column they occurred.
+type row struct {
+	seq    int   // sequence of the row in the stream, used for ordering
+	n      int   // number of records read up to and including the row
+	offset int64 // byte offset in the input after the row
+	fields []string
+	fpos   []pos // position of each field in the input
+	pos    pos
+	err    error // error encountered when reading the row
+}
+
+type Parser struct {
+	csv    *csv.Reader
+	schema *Schema
+	errh   func(int, int, string)
+	errfn  func(e ErrorRecord) // receives each error reported as a record
+
+	headers []string       // first line of the csv file
+	hdridx  map[string]int // index of each header in the record
+	colrecs []SchemaRecord // schema record of each header, by index
+
+	record []string // current csv record we've scanned
+	fpos   []pos    // positions of the fields of the current record, if reused
+
+	pos pos // line and colum position in the stream, set each time we scan in
+	// a record.
+	linebase int // lines before the input read by the csv.Reader
+
+	allowempty bool // parse inputs with no header as having no records
+	errc     int
+
+	uniquecol string  // column that must be unique across records
+	keys      *KeySet // set of values seen for uniquecol
+
+	dedupe     bool
+	dedupecols []string               // columns to dedupe on, empty for the whole row
+	dedupelast bool                   // keep the last occurrence rather than the first
+	seen       *dedupeset             // keys seen when keeping the first occurrence
+
+	sortby   string // field to sort records by, empty for the order they are read
+	sortdesc bool
+	sortmax  int64 // memory to use for sorting before spilling to disk
+
+	memmax int64 // memory to use for buffering before spilling to disk, 0 for no limit
+
+	nread       int                      // number of records read from the input
+	checkpointn int                      // records to write between each checkpoint
+	checkpoint  func(c Checkpoint) error // called with the progress of the Parser
+	resume      *Checkpoint              // checkpoint to resume from
+
+	last    []map[string]lastval // last values for each of the schema's checks
+	windows []map[string]*rollwin // windows for each of the schema's rolling aggregates
+
+	filters []*Expr // filters a record must match, in addition to the schema's
+
+	validator *Validator // JSON Schema each record must be valid against
+
+	lookups map[string]*LookupTable // tables for the schema's lookups, by name
+
+	skip    int // number of records to skip before parsing
+	skipped int
+	limit   int // maximum number of records to emit, 0 for no limit
+	emitted int
+
+	sample float64    // probability of a record being parsed, 0 for all records
+	rand   *rand.Rand // source for sampling records
+
+	meta     map[string]Value // metadata to inject into each record
+	metaline bool             // inject the line of each record as metadata
+
+	order string // order of the fields in each record, csv, schema, or alpha
+
+	dupheaders string              // how to handle duplicate headers
+	dupes      map[string]struct{} // duplicate headers collected into arrays
+
+	normhdrs bool              // match headers to the schema once normalized
+	aliases  map[string]string // columns of the schema by their aliases
+	normcols map[string]string // columns of the schema by their normalized name
+
+	infer   UnmarshalFunc // infers the types of columns not in the schema
+	noinfer bool          // write columns not in the schema as strings
+
+	enckey []byte      // key to encrypt columns with
+	aead   cipher.AEAD // cipher to encrypt columns with
+
+	workers int // number of goroutines parsing records
+
+	encoding string // character encoding of the input
+
+	tmp *TempDir // directory for temporary files spilled to disk
+
+	ragged   string // how to handle records with the wrong number of fields
+	overflow string // field to put the extra fields of long records in
+
+	skiplines int    // number of lines to skip before the header
+	hdrprefix string // prefix of the header line, lines before it are skipped
+
+	comment rune // character that begins a comment line, 0 for none
+
+	quote  rune // character used for quoting fields, 0 for the default
+	escape rune // character used for escaping characters, 0 for none
+	lazy   bool // allow quotes to appear in fields without being escaped
+
+	fixed  bool          // the input is fixed width, using the ranges in the schema
+	ranges []ColumnRange // ranges of the columns of fixed width input
+
+	newenc  EncoderFunc // creates the encoder for formats other than JSON
+	encname string      // name given to the encoder
+	enc     Encoder
+
+	ctx context.Context // context of the current parse, nil if not parsing
+	out *bufio.Writer   // buffered output of the current parse
+
+	next func() (*row, error) // rows returned via Next, nil until first called
+	done func()               // cleans up after the rows returned via Next
+
+	reuse   bool             // reuse the record, and its values, for each row
+	rec     *Record          // record reused for each row
+	cols    map[string]Value // column values reused for each row
+	colvals []colvalue       // last value of each column, by header index
+}
+
+// ParserOption is used to configure a Parser when it is created via
+// NewParser.
+type ParserOption func(p *Parser)
+
+// WithUnique configures the Parser to only emit records where the value of the
+// given column has not already been seen in the KeySet. Records with duplicate
+// values are reported to the error handler.
+func WithUnique(col string, keys *KeySet) ParserOption {
+	return func(p *Parser) {
+		p.uniquecol = col
+		p.keys = keys
+	}
+}
+
+// WithFilter configures the Parser to only emit records that match the given
+// expression. The expression must evaluate to a bool.
+func WithFilter(e *Expr) ParserOption {
+	return func(p *Parser) {
+		p.filters = append(p.filters, e)
+	}
+}
+
+// WithSkip configures the Parser to skip the first n records after the header.
+func WithSkip(n int) ParserOption {
+	return func(p *Parser) {
+		p.skip = n
+	}
+}
+
+// ErrEmptyInput is returned from NewParser when the input has no header.
+var ErrEmptyInput = errors.New("input is empty")
+
+// WithAllowEmpty configures the Parser to accept inputs with no header, which
+// are parsed as having no records, rather than returning ErrEmptyInput.
+func WithAllowEmpty() ParserOption {
+	return func(p *Parser) {
+		p.allowempty = true
+	}
+}
+
+// WithLimit configures the Parser to stop after n records have been emitted.
+func WithLimit(n int) ParserOption {
+	return func(p *Parser) {
+		p.limit = n
+	}
+}
+
+// WithSample configures the Parser to randomly sample records, where rate is
+// the probability of a record being parsed. The given seed is used for the
+// random source, so samples can be reproduced.
+func WithSample(rate float64, seed int64) ParserOption {
+	return func(p *Parser) {
+		p.sample = rate
+		p.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithMeta configures the Parser to inject metadata into each record. The
+// fields can be any of file, line, or ingested_at, and are written to the
+// record with a leading underscore, for example _file.
+func WithMeta(fname string, fields []string, ingestedAt time.Time) ParserOption {
+	return func(p *Parser) {
+		p.meta = make(map[string]Value)
+
+		for _, field := range fields {
+			switch field {
+			case "file":
+				p.meta["_file"] = &String{s: fname}
+			case "line":
+				p.metaline = true
+			case "ingested_at":
+				p.meta["_ingested_at"] = &Time{t: ingestedAt, layout: time.RFC3339}
+			}
+		}
+	}
+}
+
+// WithOrder configures the order of the fields in each record written by the
+// Parser. This is one of csv for the order of the columns in the CSV file,
+// schema for the order of the columns in the schema, or alpha for alphabetical
+// order, which is the default.
+func WithOrder(order string) ParserOption {
+	return func(p *Parser) {
+		p.order = order
+	}
+}
+
+// WithDuplicateHeaders configures how the Parser handles duplicate headers in
+// the CSV file. This is one of,
+//
+// last - the value of the last column with the header is used, the default
+// error - the file is rejected
+// suffix - duplicates are suffixed with their count, for example id_2
+// array - the values of each column with the header are collected into an
+// array
+func WithDuplicateHeaders(mode string) ParserOption {
+	return func(p *Parser) {
+		p.dupheaders = mode
+	}
+}
+
+// WithWorkers configures the Parser to parse records across n goroutines. The
+// records are still written in the order they were read.
+func WithWorkers(n int) ParserOption {
+	return func(p *Parser) {
+		p.workers = n
+	}
+}
+
+// WithEncoding configures the Parser to transcode the input from the given
+// character encoding into UTF-8.
+func WithEncoding(enc string) ParserOption {
+	return func(p *Parser) {
+		p.encoding = enc
+	}
+}
+
+// WithTempDir configures the Parser to spill temporary files into the given
+// TempDir.
+func WithTempDir(tmp *TempDir) ParserOption {
+	return func(p *Parser) {
+		p.tmp = tmp
+	}
+}
+
+// WithRagged configures how the Parser handles records that have a different
+// number of fields to the header. The mode can be one of,
+//
+// error    - the file fails to parse, this is the default
+// pad      - short records are padded with empty fields, long records are
+//            reported as errors
+// truncate - short records are padded with empty fields, and long records
+//            have their extra fields dropped
+//
+// If overflow is not empty, then the extra fields of long records are put into
+// an array under that field instead.
+func WithRagged(mode, overflow string) ParserOption {
+	return func(p *Parser) {
+		p.ragged = mode
+		p.overflow = overflow
+	}
+}
+
+// WithPreamble configures the Parser to skip over any lines before the header.
+// The given number of lines are skipped first, then if prefix is not empty,
+// lines are skipped until one is found beginning with prefix.
+func WithPreamble(lines int, prefix string) ParserOption {
+	return func(p *Parser) {
+		p.skiplines = lines
+		p.hdrprefix = prefix
+	}
+}
+
+// skipline reads over the next line from the given reader.
+func skipline(br *bufio.Reader) error {
+	for {
+		_, err := br.ReadSlice('\n')
+
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return err
+		}
+	}
+}
+
+// skippreamble reads over the lines before the header from the given reader,
+// returning the number of lines that were skipped.
+func (p *Parser) skippreamble(br *bufio.Reader) (int, error) {
+	n := 0
+
+	for ; n < p.skiplines; n++ {
+		if err := skipline(br); err != nil {
+			return n, err
+		}
+	}
+
+	if p.hdrprefix == "" {
+		return n, nil
+	}
+
+	for {
+		if b, _ := br.Peek(len(p.hdrprefix)); string(b) == p.hdrprefix {
+			return n, nil
+		}
+
+		if err := skipline(br); err != nil {
+			if errors.Is(err, io.EOF) {
+				return n, errors.New("no header found with prefix " + strconv.Quote(p.hdrprefix))
+			}
+			return n, err
+		}
+		n++
+	}
+}
+
+// WithComment configures the Parser to skip over lines that begin with the
+// given character.
+func WithComment(c rune) ParserOption {
+	return func(p *Parser) {
+		p.comment = c
+	}
+}
+
+// WithQuote configures the Parser to use the given character for quoting
+// fields, and the given character for escaping characters within fields. If
+// escape is 0, then characters cannot be escaped, and quotes must be doubled
+// within quoted fields.
+func WithQuote(quote, escape rune) ParserOption {
+	return func(p *Parser) {
+		p.quote = quote
+		p.escape = escape
+	}
+}
+
+// WithLazyQuotes configures the Parser to allow quotes to appear in unquoted
+// fields, and unescaped quotes to appear in quoted fields.
+func WithLazyQuotes() ParserOption {
+	return func(p *Parser) {
+		p.lazy = true
+	}
+}
+
+// WithFixedWidth configures the Parser to read the input as a fixed width file,
+// using the ranges of the columns in the schema.
+func WithFixedWidth() ParserOption {
+	return func(p *Parser) {
+		p.fixed = true
+	}
+}
+
+// WithEncoder configures the Parser to write records with the Encoder returned
+// by fn, instead of as JSON. The name is passed through to fn.
+func WithEncoder(fn EncoderFunc, name string) ParserOption {
+	return func(p *Parser) {
+		p.newenc = fn
+		p.encname = name
+	}
+}
+
+func NewParser(in io.Reader, delim rune, schema *Schema, errh func(int, int, string), opts ...ParserOption) (*Parser, error) {
+	p := &Parser{
+		schema: schema,
+		errh:   errh,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	p.limitmemory()
+
+	if err := p.checklookups(); err != nil {
+		return nil, err
+	}
+
+	if err := p.checkencrypt(); err != nil {
+		return nil, err
+	}
+
+	if p.encoding != "" {
+		var err error
+
+		in, err = decodeencoding(in, p.encoding)
+
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		in = decodebom(in)
+	}
+
+	in = decodecr(in)
+
+	if p.skiplines > 0 || p.hdrprefix != "" {
+		br := bufio.NewReader(in)
+
+		n, err := p.skippreamble(br)
+
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		// Keep the line numbers accurate to the original file.
+		p.linebase = n
+		in = br
+	}
+
+	// A delimiter of 0 is detected from the start of the input, after any
+	// preamble, so the preamble cannot throw it off.
+	if delim == 0 {
+		delim = ','
+
+		if !p.fixed {
+			br := bufio.NewReaderSize(in, sniffSize)
+
+			b, _ := br.Peek(sniffSize)
+
+			delim = sniffdelim(b, p.quote)
+			in = br
+		}
+	}
+
+	// The csv.Reader only understands double quotes, so anything else is
+	// rewritten into what it expects.
+	if p.fixed {
+		ranges := p.schema.Ranges()
+
+		if len(ranges) == 0 {
+			return nil, errors.New("fixed width input requires column ranges in the schema")
+		}
+		in = newFixedReader(in, ranges, delim)
+
+		p.ranges = ranges
+
+		// The header is not in the original file, so it should not count
+		// towards the line numbers.
+		p.linebase--
+	} else if (p.quote != 0 && p.quote != '"') || p.escape != 0 {
+		quote := p.quote
+
+		if quote == 0 {
+			quote = '"'
+		}
+		in = newQuoteReader(in, quote, p.escape, delim, p.comment, p.lazy)
+	}
+
+	p.csv = csv.NewReader(in)
+	p.csv.Comma = delim
+	p.csv.Comment = p.comment
+	p.csv.LazyQuotes = p.lazy
+
+	if p.ragged != "" && p.ragged != "error" {
+		p.csv.FieldsPerRecord = -1
+	}
+
+	if err := p.init(); err != nil {
+		return nil, err
+	}
+
+	// An empty input has no header to check the columns of the options
+	// against, and no records to parse.
+	if p.headers == nil {
+		return p, nil
+	}
+
+	if err := p.checkdedupe(); err != nil {
+		return nil, err
+	}
+
+	if err := p.checksortby(); err != nil {
+		return nil, err
+	}
+
+	if p.resume != nil {
+		if err := p.fastforward(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// nextrecord reads in the next record from the underlying input stream.
+func (p *Parser) nextrecord() error {
+	record, err := p.csv.Read()
+
+	if err != nil {
+		return err
+	}
+
+	p.record = record
+
+	// The records of the csv.Reader can span more than one line when fields
+	// are quoted, so the line of the record is that of its first field.
+	line, _ := p.csv.FieldPos(0)
+
+	p.pos.line = p.linebase + line
+	p.pos.col = 1
+
+	return nil
+}
+
+// fieldpos returns the position of each field of the current record in the
+// input. For fixed width input the columns are those of the schema's ranges,
+// since the csv.Reader only sees the fields once they have been cut out.
+func (p *Parser) fieldpos() []pos {
+	var fpos []pos
+
+	// Rows are not held onto if their fields are reused, so neither are the
+	// positions of the fields.
+	if p.csv.ReuseRecord {
+		fpos = p.fpos[:0]
+	}
+
+	for i := range p.record {
+		line, col := p.csv.FieldPos(i)
+
+		if p.fixed && i < len(p.ranges) {
+			col = p.ranges[i].Start + 1
+		}
+		fpos = append(fpos, pos{line: p.linebase + line, col: col})
+	}
+
+	if p.csv.ReuseRecord {
+		p.fpos = fpos
+	}
+	return fpos
+}
+
+// at returns the position of the field at the given index in the row. Rows
+// without the positions of their fields, such as those read back from disk,
+// only have the position of the record.
+func (rw *row) at(i int) pos {
+	if i < len(rw.fpos) {
+		return rw.fpos[i]
+	}
+	return rw.pos
+}
+
+// read returns the next row to be parsed, any records that should be skipped
+// or are not in the sample are read over.
+func (p *Parser) read() (*row, error) {
+	for {
+		if p.ctx != nil {
+			if err := p.ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := p.nextrecord(); err != nil {
+			return nil, err
+		}
+
+		p.nread++
+
+		if p.skipped < p.skip {
+			p.skipped++
+			continue
+		}
+
+		if p.rand != nil && p.rand.Float64() >= p.sample {
+			continue
+		}
+		return p.fit(&row{
+			n:      p.nread,
+			offset: p.csv.InputOffset(),
+			fields: p.record,
+			fpos:   p.fieldpos(),
+			pos:    p.pos,
+		}), nil
+	}
+}
+
+// fit pads or truncates the fields of the given row to the number of headers,
+// depending on how ragged records are handled.
+func (p *Parser) fit(rw *row) *row {
+	if p.ragged == "" || p.ragged == "error" {
+		return rw
+	}
+
+	n := len(p.headers)
+
+	if len(rw.fields) < n {
+		fields := make([]string, n)
+		copy(fields, rw.fields)
+
+		rw.fields = fields
+		return rw
+	}
+
+	if len(rw.fields) > n && p.ragged == "pad" && p.overflow == "" {
+		rw.err = RaggedError{Expected: n, Got: len(rw.fields)}
+	}
+	return rw
+}
+
+// init will initialize the parser by reading the first line in the underlying
+// input stream and using that as the header.
+func (p *Parser) init() error {
+	if err := p.nextrecord(); err != nil {
+		if errors.Is(err, io.EOF) {
+			if p.allowempty {
+				return nil
+			}
+			return ErrEmptyInput
+		}
+		return err
+	}
+
+	// The header is copied, since the csv.Reader can reuse the slice of the
+	// record for subsequent records.
+	p.headers = append([]string(nil), p.record...)
+	p.hdridx = make(map[string]int)
+
+	for i, hdr := range p.headers {
+		if _, ok := p.hdridx[hdr]; !ok {
+			p.hdridx[hdr] = i
+			continue
+		}
+
+		switch p.dupheaders {
+		case "error":
+			return DuplicateHeaderError{Header: hdr, Col: i + 1}
+		case "suffix":
+			n := 2
+			name := hdr + "_" + strconv.Itoa(n)
+
+			for {
+				if _, ok := p.hdridx[name]; !ok {
+					break
+				}
+				n++
+				name = hdr + "_" + strconv.Itoa(n)
+			}
+
+			p.headers[i] = name
+			p.hdridx[name] = i
+		case "array":
+			if p.dupes == nil {
+				p.dupes = make(map[string]struct{})
+			}
+			p.dupes[hdr] = struct{}{}
+		}
+	}
+
+	// Each header is resolved to its schema record once, rather than looking
+	// up the schema for each field.
+	p.colrecs = make([]SchemaRecord, len(p.headers))
+
+	for i, hdr := range p.headers {
+		col, ok := p.schemacol(i, hdr)
+
+		if !ok {
+			p.colrecs[i] = SchemaRecord{
+				Dest:      hdr,
+				Unmarshal: unmarshalAny,
+			}
+
+			if p.infer != nil {
+				p.colrecs[i].Unmarshal = p.infer
+			}
+
+			if p.noinfer {
+				p.colrecs[i].Type = "string"
+				p.colrecs[i].Unmarshal = UnmarshalString(nil)
+			}
+			continue
+		}
+
+		p.colrecs[i], _ = p.schemarec(i, hdr)
+
+		// Columns of the schema matched to a header of a different name can
+		// still be referred to by their name in the schema.
+		if _, ok := p.hdridx[col]; !ok {
+			p.hdridx[col] = i
+		}
+	}
+	return nil
+}
+
+// drop deletes the fields of the given columns from the record.
+func (p *Parser) drop(r *Record, cols []string) {
+	for _, col := range cols {
+		dst := col
+
+		if rec, ok := p.schema.Get(col); ok {
+			dst = rec.Dest
+		}
+		r.Delete(dst)
+	}
+}
+
+// column returns the value of the given column in the given fields of a
+// record. If the record has no such column then an empty string is returned.
+func (p *Parser) column(fields []string, name string) string {
+	i, ok := p.hdridx[name]
+
+	if !ok || i >= len(fields) {
+		return ""
+	}
+	return fields[i]
+}
+
+func (p *Parser) err(pos pos, err error) {
+	p.errc++
+	p.errh(pos.line, pos.col, err.Error())
+
+	if p.errfn != nil {
+		p.errfn(errorrecord(pos, err))
+	}
+}
+
+// colvalue is the last Value unmarshalled for a column, and the raw value it
+// was unmarshalled from.
+type colvalue struct {
+	raw string
+	v   Value
+}
+
+// unmarshal unmarshals the raw value of the column at index i with the given
+// schema record, formatting it if the record has an output format. Values are
+// immutable unless they are formatted, so the last Value of each column is
+// reused if the raw value is the same as the previous row. This saves an
+// allocation for columns that repeat, such as statuses or dates. Values are
+// not reused when parsing with workers, since each worker unmarshals its rows
+// at the same time as the others.
+func (p *Parser) unmarshal(i int, rec SchemaRecord, val string) (Value, error) {
+	if rec.Outfmt != "" || p.workers > 1 {
+		v, err := rec.Unmarshal(val)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if rec.Outfmt != "" {
+			v.Format(rec.Outfmt)
+		}
+		return v, nil
+	}
+
+	if p.colvals == nil {
+		p.colvals = make([]colvalue, len(p.headers))
+	}
+
+	if c := p.colvals[i]; c.v != nil && c.raw == val {
+		return c.v, nil
+	}
+
+	v, err := rec.Unmarshal(val)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.colvals[i] = colvalue{raw: val, v: v}
+	return v, nil
+}
+
+type DuplicateHeaderError struct {
+	Header string
+	Col    int
+}
+
+func (e DuplicateHeaderError) Error() string {
+	return "duplicate header " + strconv.Quote(e.Header) + " in column " + strconv.Itoa(e.Col)
+}
+
+type ColumnError struct {
+	Col string
+	Err error
+}
+
+func (e ColumnError) Error() string {
+	return e.Col + ": " + e.Err.Error()
+}
+
+func (e ColumnError) Unwrap() error { return e.Err }
+
+// errFiltered is returned from json when a record does not match the filters.
+var errFiltered = errors.New("record filtered")
+
+type FilterError struct {
+	Expr string
+	Err  error
+}
+
+func (e FilterError) Error() string {
+	return "filter " + strconv.Quote(e.Expr) + ": " + e.Err.Error()
+}
+
+// transform applies the transforms of the given schema record to the Value,
+// along with any currency conversion.
+func (p *Parser) transform(rw *row, rec SchemaRecord, v Value) (Value, error) {
+	var err error
+
+	for i := 0; i <= len(rec.Transforms); i++ {
+		if c := rec.Convert; c != nil && c.at == i {
+			date := ""
+
+			if c.Date != "" {
+				raw := p.column(rw.fields, c.Date)
+
+				var dv Value
+
+				if drec, ok := p.schema.Get(c.Date); ok && raw != "" {
+					dv, _ = drec.Unmarshal(raw)
+				}
+				date = datekey(dv, raw)
+			}
+
+			v, err = c.Apply(v, date)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if i < len(rec.Transforms) {
+			v, err = rec.Transforms[i](v)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// json parses the given row into a Record. The values of each column are
+// returned too, for performing any checks against the record.
+func (p *Parser) json(rw *row) (*Record, map[string]Value, error) {
+	var (
+		r    *Record
+		cols map[string]Value // values of each column by their name in the CSV file, used for evaluating derived columns
+	)
+
+	// Records are only reused when nothing holds onto them once emitted.
+	if p.reuse {
+		if p.rec == nil {
+			p.rec = NewRecord()
+			p.cols = make(map[string]Value, len(p.headers))
+		}
+
+		p.rec.Reset()
+		clear(p.cols)
+
+		r, cols = p.rec, p.cols
+	} else {
+		r = NewRecord()
+		cols = make(map[string]Value, len(p.headers))
+	}
+
+	if rw.err != nil {
+		return nil, nil, rw.err
+	}
+
+	for i, val := range rw.fields {
+		if i >= len(p.headers) {
+			break
+		}
+
+		col := p.headers[i]
+
+		rw.pos = rw.at(i)
+
+		rec := p.colrecs[i]
+
+		if rec.Map != nil {
+			val = mapvalue(rec.Map, val)
+		}
+
+		if val == "" {
+			if rec.Required {
+				return nil, nil, ColumnError{
+					Col: col,
+					Err: errRequired,
+				}
+			}
+
+			// Raw values are written exactly as they are read, so empty
+			// values are written rather than left out.
+			if rec.Type != "raw" {
+				continue
+			}
+		}
+
+		v, err := p.unmarshal(i, rec, val)
+
+		if err != nil {
+			return nil, nil, ColumnError{
+				Col: col,
+				Err: err,
+			}
+		}
+
+		v, err = p.transform(rw, rec, v)
+
+		if err != nil {
+			return nil, nil, ColumnError{
+				Col: col,
+				Err: err,
+			}
+		}
+		cols[col] = v
+
+		if rec.Encrypt {
+			v, err = p.encrypt(v)
+
+			if err != nil {
+				return nil, nil, ColumnError{
+					Col: col,
+					Err: err,
+				}
+			}
+		}
+
+		if rec.Redact {
+			continue
+		}
+
+		if rec.Capture != nil {
+			capture(r, rec, val)
+			continue
+		}
+
+		if _, ok := p.dupes[col]; ok {
+			arr, ok := r.Get(rec.Dest)
+
+			if !ok {
+				arr = &Array{}
+				r.Set(rec.Dest, arr)
+			}
+
+			if arr, ok := arr.(*Array); ok {
+				arr.vals = append(arr.vals, v)
+				continue
+			}
+		}
+
+		r.Set(rec.Dest, v)
+	}
+
+	if p.overflow != "" && len(rw.fields) > len(p.headers) {
+		extra := &Array{}
+
+		for _, val := range rw.fields[len(p.headers):] {
+			extra.vals = append(extra.vals, &String{s: val})
+		}
+		r.Set(p.overflow, extra)
+	}
+
+	for _, c := range p.schema.Combines() {
+		vals := make([]string, 0, len(c.Columns))
+
+		for _, col := range c.Columns {
+			if val := p.column(rw.fields, col); val != "" {
+				vals = append(vals, val)
+			}
+		}
+
+		if len(vals) != len(c.Columns) {
+			continue
+		}
+
+		v, err := c.Unmarshal(strings.Join(vals, " "))
+
+		if err != nil {
+			return nil, nil, ColumnError{
+				Col: strings.Join(c.Columns, ","),
+				Err: err,
+			}
+		}
+
+		if c.Outfmt != "" {
+			v.Format(c.Outfmt)
+		}
+
+		if c.Drop {
+			p.drop(r, c.Columns)
+		}
+		r.Set(c.Dest, v)
+	}
+
+	for _, c := range p.schema.Concats() {
+		vals := make([]string, len(c.Columns))
+		n := 0
+
+		for i, col := range c.Columns {
+			v, ok := cols[col]
+
+			if !ok {
+				continue
+			}
+
+			val, err := exprvalue(v)
+
+			if err != nil {
+				return nil, nil, ColumnError{Col: c.Dest, Err: err}
+			}
+
+			if s := exprstring(val); s != "" {
+				vals[i] = s
+				n++
+			}
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		v, err := c.Unmarshal(c.join(vals))
+
+		if err != nil {
+			return nil, nil, ColumnError{
+				Col: c.Dest,
+				Err: err,
+			}
+		}
+
+		if c.Outfmt != "" {
+			v.Format(c.Outfmt)
+		}
+
+		if c.Drop {
+			p.drop(r, c.Columns)
+		}
+
+		r.Set(c.Dest, v)
+		cols[c.Dest] = v
+	}
+
+	for _, l := range p.schema.Lookups() {
+		key := p.column(rw.fields, l.Column)
+
+		if key == "" {
+			continue
 		}
 
-		v, err := rec.Unmarshal(val)
+		t := p.lookups[l.Table]
+
+		match, ok := t.rows[key]
+
+		if !ok {
+			continue
+		}
+
+		v, err := l.value(t, match)
 
 		if err != nil {
-			return nil, ColumnError{
-				Col: col,
+			return nil, nil, ColumnError{
+				Col: l.Column,
 				Err: err,
 			}
 		}
 
-		if rec.Outfmt != "" {
-			v.Format(rec.Outfmt)
+		if l.Drop {
+			dst := l.Column
+
+			if rec, ok := p.schema.Get(l.Column); ok {
+				dst = rec.Dest
+			}
+			r.Delete(dst)
 		}
-		m[rec.Dest] = v
+
+		r.Set(l.Dest, v)
+		cols[l.Dest] = v
 	}
-	return json.Marshal(m)
-}
 
-func (p *Parser) Parse(out io.Writer) error {
-	for {
-		if err := p.nextrecord(); err != nil {
-			if !errors.Is(err, io.EOF) {
-				return err
+	env := func(name string) (interface{}, bool) {
+		v, ok := cols[name]
+
+		if !ok {
+			// Columns with no value are treated as null.
+			if _, ok := p.hdridx[name]; ok {
+				return nil, true
 			}
-			break
+			return nil, false
 		}
 
-		b, err := p.json()
+		val, err := exprvalue(v)
 
 		if err != nil {
-			p.err(err)
-			continue
+			return nil, false
 		}
+		return val, true
+	}
 
-		if _, err := out.Write(append(b, '\n')); err != nil {
-			return err
+	for _, d := range p.schema.Derived() {
+		res, err := d.Expr.Eval(env)
+
+		if err != nil {
+			return nil, nil, ColumnError{
+				Col: d.Dest,
+				Err: err,
+			}
 		}
+
+		v := valueof(res)
+
+		r.Set(d.Dest, v)
+		cols[d.Dest] = v
 	}
-	return nil
+
+	for _, filters := range [][]*Expr{p.schema.Filters(), p.filters} {
+		for _, e := range filters {
+			res, err := e.Eval(env)
+
+			if err != nil {
+				return nil, nil, FilterError{Expr: e.String(), Err: err}
+			}
+
+			ok, isbool := res.(bool)
+
+			if !isbool {
+				return nil, nil, FilterError{
+					Expr: e.String(),
+					Err:  fmt.Errorf("expected bool, got %v", res),
+				}
+			}
+
+			if !ok {
+				return nil, nil, errFiltered
+			}
+		}
+	}
+
+	for k, v := range p.meta {
+		r.Set(k, v)
+	}
+
+	if p.metaline {
+		r.Set("_line", &Int{n: rw.pos.line})
+	}
+
+	return r, cols, nil
 }
 
-var errTooFewArgs = errors.New("too few arguments")
+// bufpool holds the buffers records are marshalled into, so a new buffer is
+// not allocated for each record.
+var bufpool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
 
-func run(args []string) error {
-	argv0 := args[0]
+// marshal orders the fields of the given record, and marshals it to JSON
+// followed by a newline. The buffer is taken from bufpool, and should be put
+// back once written.
+func (p *Parser) marshal(r *Record) (*bytes.Buffer, error) {
+	p.sort(r)
 
-	var (
-		schema string
-		delim  string
-	)
+	buf := bufpool.Get().(*bytes.Buffer)
+	buf.Reset()
 
-	fs := flag.NewFlagSet(argv0, flag.ExitOnError)
-	fs.StringVar(&schema, "s", "", "the schema file to use")
-	fs.StringVar(&delim, "d", ",", "the csv delimeter")
-	fs.Parse(args[1:])
+	if err := r.encode(buf); err != nil {
+		bufpool.Put(buf)
+		return nil, err
+	}
+
+	buf.WriteByte('\n')
+	return buf, nil
+}
 
-	d, _ := utf8.DecodeRuneInString(delim)
+// sort orders the fields of the given record by the Parser's order.
+func (p *Parser) sort(r *Record) {
+	switch p.order {
+	case "csv":
+	case "schema":
+		// Fields not in the schema are placed after those that are, in the
+		// order they were in the CSV file.
+		r.Sort(func(a, b string) bool {
+			i, ok := p.schema.Position(a)
+
+			if !ok {
+				return false
+			}
+
+			j, ok := p.schema.Position(b)
 
-	if d == utf8.RuneError {
-		return errors.New("invalid utf-8 character for delimeter, must be a single character\n")
+			if !ok {
+				return true
+			}
+			return i < j
+		})
+	default:
+		r.Sort(func(a, b string) bool { return a < b })
 	}
+}
 
-	args = fs.Args()
+// result is the result of parsing a row.
+type result struct {
+	rw   *row
+	r    *Record
+	buf  *bytes.Buffer // marshalled record, nil if not yet marshalled
+	cols map[string]Value
+	err  error
+}
 
-	if len(args) < 1 {
-		return errTooFewArgs
-		os.Exit(1)
+// accept returns true if the given result passes all checks, otherwise the
+// error is reported.
+func (p *Parser) accept(res result) (bool, error) {
+	if res.err != nil {
+		if !errors.Is(res.err, errFiltered) {
+			p.err(res.rw.pos, res.err)
+		}
+		return false, nil
+	}
+
+	if err := p.check(res.rw, res.cols); err != nil {
+		p.err(res.rw.pos, err)
+		return false, nil
 	}
 
-	s := NewSchema()
+	if p.dedupe && !p.dedupelast {
+		dup, err := p.duplicate(res.rw)
 
-	if schema != "" {
-		s.Load(schema)
+		if err != nil {
+			return false, err
+		}
+
+		if dup {
+			return false, nil
+		}
 	}
 
-	sems := make(chan struct{}, runtime.GOMAXPROCS(0)+10)
-	errs := make(chan error)
+	if p.keys != nil {
+		if key := p.column(res.rw.fields, p.uniquecol); key != "" {
+			ok, err := p.keys.Add(key)
 
-	wg := sync.WaitGroup{}
-	wg.Add(len(args))
+			if err != nil {
+				return false, err
+			}
+
+			if !ok {
+				p.err(res.rw.pos, ColumnError{
+					Col: p.uniquecol,
+					Err: errors.New("duplicate value " + strconv.Quote(key)),
+				})
+				return false, nil
+			}
+		}
+	}
+
+	if err := p.roll(res.rw, res.r, res.cols); err != nil {
+		p.err(res.rw.pos, err)
+		return false, nil
+	}
 
-	for _, fname := range args {
-		errh := func(line, col int, msg string) {
-			fmt.Fprintf(os.Stderr, "%s,%d:%d - %s\n", fname, line, col, msg)
+	if p.validator != nil {
+		if err := p.validator.Validate(res.r); err != nil {
+			p.err(res.rw.pos, err)
+			return false, nil
 		}
+	}
+	return true, nil
+}
 
-		go func(fname string) {
-			sems <- struct{}{}
+// emit writes the given result to out if it passes all checks, otherwise the
+// error is reported. This returns true if the limit has been reached.
+func (p *Parser) emit(out io.Writer, res result) (bool, error) {
+	ok, err := p.accept(res)
 
-			defer func() {
-				wg.Done()
-				<-sems
-			}()
+	if err != nil || !ok {
+		return false, err
+	}
 
-			f, err := os.Open(fname)
+	if p.enc != nil {
+		p.sort(res.r)
 
-			if err != nil {
-				errs <- err
-				return
+		if err := p.enc.Encode(res.r); err != nil {
+			var encerr EncodeError
+
+			if errors.As(err, &encerr) {
+				p.err(res.rw.pos, ColumnError{Col: encerr.Field, Err: encerr.Err})
+				return false, nil
 			}
+			return false, err
+		}
+
+		p.emitted++
 
-			defer f.Close()
+		return p.limit > 0 && p.emitted >= p.limit, nil
+	}
+
+	buf := res.buf
+
+	if buf == nil {
+		buf, err = p.marshal(res.r)
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	_, err = out.Write(buf.Bytes())
 
-			outname := filepath.Base(f.Name())
+	bufpool.Put(buf)
 
-			if strings.HasSuffix(outname, ".csv") {
-				outname = outname[:len(outname)-4]
+	if err != nil {
+		return false, err
+	}
+
+	p.emitted++
+
+	if p.checkpoint != nil && p.emitted%p.checkpointn == 0 {
+		c := Checkpoint{
+			Records: res.rw.n,
+			Offset:  res.rw.offset,
+			Emitted: p.emitted,
+		}
+
+		// The output must be flushed for the checkpoint to know how much
+		// has been written.
+		if p.out != nil {
+			if err := p.out.Flush(); err != nil {
+				return false, err
 			}
-			outname += ".json"
+		}
+
+		if err := p.checkpoint(c); err != nil {
+			return false, err
+		}
+	}
 
-			out, err := os.OpenFile(outname, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644))
+	return p.limit > 0 && p.emitted >= p.limit, nil
+}
 
-			if err != nil {
-				errs <- err
+// parseParallel parses the rows across the Parser's workers. Each row is given
+// a sequence number when read, and the results are held in a reorder buffer
+// until they can be emitted in sequence. The number of rows in flight is
+// bounded, so a slow row cannot cause the buffer to grow without limit.
+func (p *Parser) parseParallel(out io.Writer) error {
+	window := p.workers * 4
+
+	tokens := make(chan struct{}, window)
+	jobs := make(chan *row)
+	results := make(chan result, window)
+	done := make(chan struct{})
+
+	var readerr error
+
+	go func() {
+		defer close(jobs)
+
+		for seq := 0; ; seq++ {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
 				return
 			}
 
-			defer out.Close()
-
-			p, err := NewParser(f, d, s, errh)
+			rw, err := p.read()
 
 			if err != nil {
-				errs <- err
+				if !errors.Is(err, io.EOF) {
+					readerr = err
+				}
 				return
 			}
 
-			if err := p.Parse(out); err != nil {
-				errs <- err
+			rw.seq = seq
+
+			select {
+			case jobs <- rw:
+			case <-done:
 				return
 			}
-			fmt.Println(outname)
-		}(fname)
+		}
+	}()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			// Records can only be marshalled here if there are no rolling
+			// aggregates, since these are set in order. Encoders are always
+			// written to in order.
+			marshal := len(p.schema.Rollings()) == 0 && p.enc == nil
+
+			for rw := range jobs {
+				r, cols, err := p.json(rw)
+
+				res := result{rw: rw, r: r, cols: cols, err: err}
+
+				if err == nil && marshal {
+					res.buf, res.err = p.marshal(r)
+				}
+				results <- res
+			}
+		}()
 	}
 
 	go func() {
 		wg.Wait()
-		close(errs)
+		close(results)
 	}()
 
-	errc := 0
+	pending := make(map[int]result)
+	next := 0
+	stopped := false
 
-	for err := range errs {
-		fmt.Fprintf(os.Stderr, "%s: %s\n", argv0, err)
-		errc++
+	var err error
+
+	for res := range results {
+		pending[res.rw.seq] = res
+
+		for {
+			res, ok := pending[next]
+
+			if !ok {
+				break
+			}
+
+			delete(pending, next)
+			next++
+
+			<-tokens
+
+			if stopped {
+				continue
+			}
+
+			var stop bool
+
+			stop, err = p.emit(out, res)
+
+			if stop || err != nil {
+				stopped = true
+				close(done)
+			}
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+	return readerr
+}
+
+// Parse parses each record from the input and writes it to out. If the Parser
+// has an encoder, then the encoder is closed once all records are written.
+func (p *Parser) Parse(out io.Writer) error {
+	return p.ParseContext(context.Background(), out)
+}
+
+// ParseContext is like Parse, but stops parsing once the given context is
+// done, returning the context's error. The encoder is still closed if parsing
+// is stopped, so the records written up to that point are flushed.
+func (p *Parser) ParseContext(ctx context.Context, out io.Writer) error {
+	p.ctx = ctx
+
+	// Records are written through a buffer, rather than each being written
+	// to the output as it is parsed.
+	p.out = bufio.NewWriterSize(out, 64<<10)
+
+	defer func() {
+		p.ctx = nil
+		p.out = nil
+	}()
+
+	if err := p.parseencode(p.out); err != nil {
+		if ctx.Err() != nil {
+			p.out.Flush()
+		}
+		return err
+	}
+	return p.out.Flush()
+}
+
+// parseencode parses each record into out, via the Parser's encoder if it has
+// one.
+func (p *Parser) parseencode(out io.Writer) error {
+	if p.newenc == nil {
+		return p.parse(out)
+	}
+
+	enc, err := p.newenc(out, p.encname, p.fields())
+
+	if err != nil {
+		return err
+	}
+
+	p.enc = enc
+
+	if err := p.parse(out); err != nil {
+		if p.ctx.Err() != nil {
+			enc.Close()
+		}
+		return err
+	}
+	return enc.Close()
+}
+
+func (p *Parser) parse(out io.Writer) error {
+	// Records can only be reused if each is marshalled as soon as it is
+	// parsed, since encoders, sorting, and workers all hold onto records.
+	p.reuse = p.enc == nil && p.sortby == "" && p.workers <= 1
+
+	// Likewise the fields of each row can only be reused if rows are not
+	// held onto by workers or sorting.
+	p.csv.ReuseRecord = p.sortby == "" && p.workers <= 1
+
+	read := p.read
+
+	if p.seen != nil {
+		defer p.seen.remove()
+	}
+
+	if p.dedupe && p.dedupelast {
+		rows, done, err := p.lastrows(read)
+
+		if err != nil {
+			return err
+		}
+
+		defer done()
+
+		read = rows
+	}
+
+	if p.sortby != "" {
+		return p.parseSorted(out, read)
 	}
 
-	if errc > 0 {
-		return errors.New("encountered errors during generation")
+	if p.workers > 1 && !p.dedupelast {
+		return p.parseParallel(out)
+	}
+	return p.parserows(out, read)
+}
+
+// parserows parses each row returned by read, and emits it.
+func (p *Parser) parserows(out io.Writer, read func() (*row, error)) error {
+	for {
+		if p.limit > 0 && p.emitted >= p.limit {
+			break
+		}
+
+		rw, err := read()
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+			break
+		}
+
+		r, cols, err := p.json(rw)
+
+		stop, err := p.emit(out, result{rw: rw, r: r, cols: cols, err: err})
+
+		if err != nil {
+			return err
+		}
+
+		if stop {
+			break
+		}
 	}
 	return nil
 }
 
+var errTooFewArgs = errors.New("too few arguments")
+
+// listFlag is a flag that can be given more than once, collecting each value.
+type listFlag []string
+
+func (f *listFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *listFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+func run(args []string) error {
+	argv0 := args[0]
+
+	if len(args) > 1 {
+		switch args[1] {
+		case "split":
+			return runSplit(argv0, args[2:])
+		case "cat":
+			return runCat(argv0, args[2:])
+		case "conformance":
+			return runConformance(argv0, args[2:])
+		case "check":
+			return runCheck(argv0, args[2:])
+		case "schema":
+			return runSchema(argv0, args[2:])
+		case "bench":
+			return runBench(argv0, args[2:])
+		case "run":
+			return runPipelines(argv0, args[2:])
+		}
+	}
+
+	var o options
+
+	fs := flag.NewFlagSet(argv0, flag.ExitOnError)
+	o.flags(fs)
+	fs.Parse(args[1:])
+
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	args = fs.Args()
+
+	if len(args) < 1 {
+		return errTooFewArgs
+	}
+
+	c, err := newConverter(argv0, &o, args)
+
+	if err != nil {
+		return err
+	}
+
+	defer c.close()
+	return c.run()
+}
+
 func main() {
 	argv0 := os.Args[0]
 
@@ -719,7 +3350,14 @@ func main() {
 			os.Exit(1)
 		}
 
+		var usage usageError
+
+		if errors.As(err, &usage) {
+			fmt.Fprintln(os.Stderr, string(usage))
+			os.Exit(1)
+		}
+
 		fmt.Fprintf(os.Stderr, "%s: %s\n", argv0, err)
-		os.Exit(1)
+		os.Exit(exitcode(err))
 	}
 }
@@ -2,12 +2,18 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func checkCsv(t *testing.T, expected io.Reader, actual string) {
@@ -64,11 +70,10 @@ func checkCsv(t *testing.T, expected io.Reader, actual string) {
 				t.Fatalf("%s - could not find column %q\n", actual, k)
 			}
 
-			typ := reflect.TypeOf(v)
-			typ2 := reflect.TypeOf(v2)
-
-			kind := typ.Kind()
-			kind2 := typ2.Kind()
+			// Use the kind of the value, since the type of a null value will
+			// be nil.
+			kind := reflect.ValueOf(v).Kind()
+			kind2 := reflect.ValueOf(v2).Kind()
 
 			if kind != kind2 {
 				t.Fatalf("%s - unexpected column type for column %q, expected=%q, got=%q\n", actual, k, kind, kind2)
@@ -84,6 +89,10 @@ func checkCsv(t *testing.T, expected io.Reader, actual string) {
 	if err := sc.Err(); err != nil {
 		t.Fatal(err)
 	}
+
+	if i != len(records) {
+		t.Fatalf("%s - unexpected number of records, expected=%d, got=%d\n", actual, len(records), i)
+	}
 }
 
 func Test_Main(t *testing.T) {
@@ -112,10 +121,112 @@ func Test_Main(t *testing.T) {
 			filepath.Join("testdata", "numbers2.schema"),
 			filepath.Join("testdata", "numbers2.golden"),
 		},
+		{
+			filepath.Join("testdata", "uuids.csv"),
+			filepath.Join("testdata", "uuids.schema"),
+			filepath.Join("testdata", "uuids.golden"),
+		},
+		{
+			filepath.Join("testdata", "addrs.csv"),
+			filepath.Join("testdata", "addrs.schema"),
+			filepath.Join("testdata", "addrs.golden"),
+		},
+		{
+			filepath.Join("testdata", "events.csv"),
+			filepath.Join("testdata", "events.schema"),
+			filepath.Join("testdata", "events.golden"),
+		},
+		{
+			filepath.Join("testdata", "dump.csv"),
+			filepath.Join("testdata", "dump.schema"),
+			filepath.Join("testdata", "dump.golden"),
+		},
+		{
+			filepath.Join("testdata", "contacts.csv"),
+			filepath.Join("testdata", "contacts.schema"),
+			filepath.Join("testdata", "contacts.golden"),
+		},
+		{
+			filepath.Join("testdata", "products.csv"),
+			filepath.Join("testdata", "products.schema"),
+			filepath.Join("testdata", "products.golden"),
+		},
+		{
+			filepath.Join("testdata", "orders.csv"),
+			filepath.Join("testdata", "orders.schema"),
+			filepath.Join("testdata", "orders.golden"),
+		},
+		{
+			filepath.Join("testdata", "people.csv"),
+			filepath.Join("testdata", "people.schema"),
+			filepath.Join("testdata", "people.golden"),
+		},
+		{
+			filepath.Join("testdata", "names.csv"),
+			filepath.Join("testdata", "names.schema"),
+			filepath.Join("testdata", "names.golden"),
+		},
+		{
+			filepath.Join("testdata", "fullnames.csv"),
+			filepath.Join("testdata", "fullnames.schema"),
+			filepath.Join("testdata", "fullnames.golden"),
+		},
+		{
+			filepath.Join("testdata", "payments.csv"),
+			filepath.Join("testdata", "payments.schema"),
+			filepath.Join("testdata", "payments.golden"),
+		},
+		{
+			filepath.Join("testdata", "readings.csv"),
+			filepath.Join("testdata", "readings.schema"),
+			filepath.Join("testdata", "readings.golden"),
+		},
+		{
+			filepath.Join("testdata", "sales.csv"),
+			filepath.Join("testdata", "sales.schema"),
+			filepath.Join("testdata", "sales.golden"),
+		},
+		{
+			filepath.Join("testdata", "staff.csv"),
+			filepath.Join("testdata", "staff.schema"),
+			filepath.Join("testdata", "staff.golden"),
+		},
+		{
+			filepath.Join("testdata", "bom.csv"),
+			filepath.Join("testdata", "bom.schema"),
+			filepath.Join("testdata", "bom.golden"),
+		},
+		{
+			filepath.Join("testdata", "bom16.csv"),
+			filepath.Join("testdata", "bom.schema"),
+			filepath.Join("testdata", "bom16.golden"),
+		},
+		{
+			filepath.Join("testdata", "formats.csv"),
+			filepath.Join("testdata", "formats.schema"),
+			filepath.Join("testdata", "formats.golden"),
+		},
+		{
+			filepath.Join("testdata", "logs.csv"),
+			filepath.Join("testdata", "logs.schema"),
+			filepath.Join("testdata", "logs.golden"),
+		},
+		{
+			filepath.Join("testdata", "statuses.csv"),
+			filepath.Join("testdata", "statuses.schema"),
+			filepath.Join("testdata", "statuses.golden"),
+		},
+		{
+			filepath.Join("testdata", "customers.csv"),
+			filepath.Join("testdata", "customers.schema"),
+			filepath.Join("testdata", "customers.golden"),
+		},
 	}
 
 	for i, test := range tests {
-		if err := run([]string{"csv2json", "-s", test.schemafile, test.csvfile}); err != nil {
+		// Some of the inputs have records that are expected to fail, which
+		// are left out of the golden files.
+		if err := run([]string{"csv2json", "-s", test.schemafile, test.csvfile}); err != nil && exitcode(err) != exitInvalid {
 			t.Fatalf("tests[%d] - %s\n", i, err)
 		}
 
@@ -136,3 +247,1512 @@ func Test_Main(t *testing.T) {
 		}()
 	}
 }
+
+// countLines returns the number of lines in the given file.
+func countLines(t *testing.T, fname string) int {
+	f, err := os.Open(fname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	n := 0
+
+	sc := bufio.NewScanner(f)
+
+	for sc.Scan() {
+		n++
+	}
+
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func Test_Unique(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "state")
+
+	files := []string{
+		filepath.Join("testdata", "accounts1.csv"),
+		filepath.Join("testdata", "accounts2.csv"),
+	}
+
+	defer os.RemoveAll("accounts1.json")
+	defer os.RemoveAll("accounts2.json")
+
+	tests := []struct {
+		expected int
+	}{
+		{4}, // first run, the duplicate ids 2 and 3 are dropped
+		{0}, // second run, all ids are in the state file
+	}
+
+	for i, test := range tests {
+		args := append([]string{"csv2json", "-unique", "id", "-unique-state", state}, files...)
+
+		// Duplicates are reported as records that were not converted.
+		if err := run(args); exitcode(err) != exitInvalid {
+			t.Fatalf("tests[%d] - unexpected error, expected=%d records not converted, got=%v\n", i, 6-test.expected, err)
+		}
+
+		n := countLines(t, "accounts1.json") + countLines(t, "accounts2.json")
+
+		if n != test.expected {
+			t.Fatalf("tests[%d] - unexpected number of records, expected=%d, got=%d\n", i, test.expected, n)
+		}
+	}
+}
+
+func Test_Filter(t *testing.T) {
+	defer os.RemoveAll("users.json")
+
+	tests := []struct {
+		filter   string
+		expected int
+	}{
+		{`verified`, 4},
+		{`!verified`, 1},
+		{`id > 1 && id < 5`, 3},
+		{`name == "G-Man" || id == 5`, 2},
+	}
+
+	for i, test := range tests {
+		args := []string{
+			"csv2json",
+			"-s", filepath.Join("testdata", "users.schema"),
+			"-filter", test.filter,
+			filepath.Join("testdata", "users.csv"),
+		}
+
+		if err := run(args); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if n := countLines(t, "users.json"); n != test.expected {
+			t.Fatalf("tests[%d] - unexpected number of records, expected=%d, got=%d\n", i, test.expected, n)
+		}
+	}
+}
+
+func Test_StringReplaceOnce(t *testing.T) {
+	tests := []struct {
+		schema   string
+		args     []string
+		expected string
+	}{
+		{`code string "([0-9])" "$1$1"`, nil, `{"code":"ab55"}`},
+		{`code string "([0-9])" "$1$1"`, []string{"-filter", `code != ""`}, `{"code":"ab55"}`},
+		{"code string \"([0-9])\" \"$1$1\"\nlabel = code", nil, `{"code":"ab55","label":"ab55"}`},
+		{`code string "([0-9])" "$1$1"`, []string{"-sort-by", "code"}, `{"code":"ab55"}`},
+	}
+
+	for i, test := range tests {
+		dir := t.TempDir()
+
+		schema := filepath.Join(dir, "codes.schema")
+		csvfile := filepath.Join(dir, "codes.csv")
+
+		if err := os.WriteFile(schema, []byte(test.schema+"\n"), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(csvfile, []byte("code\nab5\n"), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+
+		args := append([]string{"csv2json", "-o", dir, "-s", schema}, test.args...)
+
+		if err := run(append(args, csvfile)); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, "codes.json"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if out := strings.TrimSpace(string(b)); out != test.expected {
+			t.Errorf("tests[%d] - unexpected output, expected=%q, got=%q\n", i, test.expected, out)
+		}
+	}
+}
+
+func Test_SkipLimitSample(t *testing.T) {
+	defer os.RemoveAll("users.json")
+
+	tests := []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{"-limit", "2"}, 2},
+		{[]string{"-skip", "4"}, 1},
+		{[]string{"-skip", "1", "-limit", "3"}, 3},
+		{[]string{"-skip", "10"}, 0},
+		{[]string{"-sample", "1"}, 5},
+		{[]string{"-sample", "0.5", "-seed", "1", "-limit", "1"}, 1},
+	}
+
+	for i, test := range tests {
+		args := append([]string{"csv2json"}, test.args...)
+		args = append(args, filepath.Join("testdata", "users.csv"))
+
+		if err := run(args); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if n := countLines(t, "users.json"); n != test.expected {
+			t.Fatalf("tests[%d] - unexpected number of records, expected=%d, got=%d\n", i, test.expected, n)
+		}
+	}
+}
+
+func Test_Meta(t *testing.T) {
+	csvfile := filepath.Join("testdata", "staff.csv")
+
+	if err := run([]string{"csv2json", "-meta", "file,line,ingested_at", csvfile}); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll("staff.json")
+
+	f, err := os.Open("staff.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+
+	line := 1
+
+	for sc.Scan() {
+		line++
+
+		var m map[string]interface{}
+
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+
+		if m["_file"] != csvfile {
+			t.Fatalf("unexpected _file, expected=%q, got=%q\n", csvfile, m["_file"])
+		}
+
+		if m["_line"] != float64(line) {
+			t.Fatalf("unexpected _line, expected=%d, got=%v\n", line, m["_line"])
+		}
+
+		if _, ok := m["_ingested_at"].(string); !ok {
+			t.Fatalf("expected _ingested_at string, got=%v\n", m["_ingested_at"])
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Estimate(t *testing.T) {
+	csvfile := filepath.Join("testdata", "users.csv")
+	schemafile := filepath.Join("testdata", "users.schema")
+
+	s := NewSchema()
+
+	if err := s.Load(schemafile); err != nil {
+		t.Fatal(err)
+	}
+
+	est, err := estimate(csvfile, ',', s, "", nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"csv2json", "-s", schemafile, csvfile}); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll("users.json")
+
+	info, err := os.Stat("users.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is small enough to be read in full, so the estimate should be
+	// exact.
+	if est.Rows != 5 {
+		t.Fatalf("unexpected number of rows, expected=%d, got=%d\n", 5, est.Rows)
+	}
+
+	if est.Size != info.Size() {
+		t.Fatalf("unexpected size, expected=%d, got=%d\n", info.Size(), est.Size)
+	}
+
+	if est.Gzip <= 0 || est.Gzip >= est.Size {
+		t.Fatalf("unexpected gzip size %d for size %d\n", est.Gzip, est.Size)
+	}
+}
+
+func Test_Order(t *testing.T) {
+	csvfile := filepath.Join("testdata", "users.csv")
+	schemafile := filepath.Join("testdata", "users.schema")
+
+	defer os.RemoveAll("users.json")
+
+	tests := []struct {
+		order    string
+		expected string
+	}{
+		{"csv", `{"id":1,"name":"Gordon Freeman","verified":true,"created_at":"1998-11-19T00:00:00Z"}`},
+		{"schema", `{"id":1,"verified":true,"created_at":"1998-11-19T00:00:00Z","name":"Gordon Freeman"}`},
+		{"alpha", `{"created_at":"1998-11-19T00:00:00Z","id":1,"name":"Gordon Freeman","verified":true}`},
+	}
+
+	for i, test := range tests {
+		if err := run([]string{"csv2json", "-order", test.order, "-s", schemafile, csvfile}); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		f, err := os.Open("users.json")
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		sc := bufio.NewScanner(f)
+		sc.Scan()
+
+		line := sc.Text()
+
+		f.Close()
+
+		if line != test.expected {
+			t.Fatalf("tests[%d] - unexpected record, expected=%s, got=%s\n", i, test.expected, line)
+		}
+	}
+}
+
+func Test_DuplicateHeaders(t *testing.T) {
+	csvfile := filepath.Join("testdata", "dupes.csv")
+
+	defer os.RemoveAll("dupes.json")
+
+	tests := []struct {
+		mode     string
+		expected string
+	}{
+		{"last", `{"id":100,"name":"Gordon","tag":"b"}`},
+		{"suffix", `{"id":1,"name":"Gordon","id_2":100,"tag":"a","tag_2":"b"}`},
+		{"array", `{"id":[1,100],"name":"Gordon","tag":["a","b"]}`},
+	}
+
+	for i, test := range tests {
+		if err := run([]string{"csv2json", "-order", "csv", "-duplicate-headers", test.mode, csvfile}); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		f, err := os.Open("dupes.json")
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		sc := bufio.NewScanner(f)
+		sc.Scan()
+
+		line := sc.Text()
+
+		f.Close()
+
+		if line != test.expected {
+			t.Fatalf("tests[%d] - unexpected record, expected=%s, got=%s\n", i, test.expected, line)
+		}
+	}
+
+	if err := run([]string{"csv2json", "-duplicate-headers", "error", csvfile}); err == nil {
+		t.Fatal("expected error for duplicate headers")
+	}
+}
+
+func Test_Workers(t *testing.T) {
+	csvfile := filepath.Join(t.TempDir(), "workers.csv")
+
+	var buf bytes.Buffer
+
+	buf.WriteString("id,name\n")
+
+	for i := 1; i <= 1000; i++ {
+		fmt.Fprintf(&buf, "%d,user%d\n", i, i)
+	}
+
+	if err := os.WriteFile(csvfile, buf.Bytes(), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll("workers.json")
+
+	tests := []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{"-workers", "8"}, 1000},
+		{[]string{"-workers", "8", "-limit", "10"}, 10},
+		{[]string{"-workers", "8", "-filter", "id % 2 == 0"}, 500},
+	}
+
+	for i, test := range tests {
+		args := append([]string{"csv2json"}, test.args...)
+		args = append(args, csvfile)
+
+		if err := run(args); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		f, err := os.Open("workers.json")
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		sc := bufio.NewScanner(f)
+
+		n := 0
+		last := 0
+
+		for sc.Scan() {
+			var rec struct {
+				ID int
+			}
+
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				t.Fatalf("tests[%d] - %s\n", i, err)
+			}
+
+			if rec.ID <= last {
+				t.Fatalf("tests[%d] - record out of order, id %d after %d\n", i, rec.ID, last)
+			}
+
+			last = rec.ID
+			n++
+		}
+
+		f.Close()
+
+		if n != test.expected {
+			t.Fatalf("tests[%d] - unexpected number of records, expected=%d, got=%d\n", i, test.expected, n)
+		}
+	}
+}
+
+func Test_Encoding(t *testing.T) {
+	csvfile := filepath.Join("testdata", "latin1.csv")
+
+	defer os.RemoveAll("latin1.json")
+
+	tests := []struct {
+		encoding string
+		expected []map[string]string
+	}{
+		{"latin1", []map[string]string{
+			{"name": "José", "city": "München"},
+			{"name": "\u0093Quoted\u0094", "city": "Café \u0080"},
+		}},
+		{"windows-1252", []map[string]string{
+			{"name": "José", "city": "München"},
+			{"name": "“Quoted”", "city": "Café €"},
+		}},
+	}
+
+	for i, test := range tests {
+		if err := run([]string{"csv2json", "-encoding", test.encoding, csvfile}); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		f, err := os.Open("latin1.json")
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		recs := make([]map[string]string, 0)
+
+		dec := json.NewDecoder(f)
+
+		for {
+			var m map[string]string
+
+			if err := dec.Decode(&m); err != nil {
+				if err == io.EOF {
+					break
+				}
+				t.Fatalf("tests[%d] - %s\n", i, err)
+			}
+			recs = append(recs, m)
+		}
+
+		f.Close()
+
+		if !reflect.DeepEqual(recs, test.expected) {
+			t.Fatalf("tests[%d] - unexpected records, expected=%v, got=%v\n", i, test.expected, recs)
+		}
+	}
+
+	if err := run([]string{"csv2json", "-encoding", "ebcdic", csvfile}); err == nil {
+		t.Fatal("expected error for unknown encoding")
+	}
+}
+
+func Test_Preflight(t *testing.T) {
+	dir := t.TempDir()
+
+	users := filepath.Join(dir, "users.csv")
+
+	b, err := os.ReadFile(filepath.Join("testdata", "users.csv"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(users, b, os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := [][]string{
+		{filepath.Join("testdata", "users.csv"), users},
+		{filepath.Join("testdata", "users.csv"), filepath.Join(dir, "missing.csv")},
+		{filepath.Join("testdata", "users.csv"), dir},
+	}
+
+	for i, test := range tests {
+		errs := preflight(test, ".", ".json")
+
+		if len(errs) != 1 {
+			t.Fatalf("tests[%d] - expected 1 error, got=%v\n", i, errs)
+		}
+
+		if err := run(append([]string{"csv2json"}, test...)); err == nil {
+			t.Fatalf("tests[%d] - expected error\n", i)
+		}
+
+		// Nothing should be converted if the preflight fails.
+		if _, err := os.Stat("users.json"); err == nil {
+			os.RemoveAll("users.json")
+			t.Fatalf("tests[%d] - expected no output to be written\n", i)
+		}
+	}
+}
+
+func Test_PreflightSample(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"ids.schema": "id int\n",
+		"good.csv":   "id,name\n1,Gordon\n2,Alyx\nx,Eli\n4,Barney\n",
+		"bad.csv":    "id,name\na,Gordon\nb,Alyx\nc,Eli\nd,Barney\n",
+		"late.csv":   "id,name\n1,Gordon\n2,Alyx\nx,Eli\ny,Barney\n",
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		fname string
+		args  []string
+		ok    bool
+	}{
+		{"good.csv", []string{"-preflight", "100", "-preflight-max-failures", "0.25"}, true},
+		{"good.csv", []string{"-preflight", "100", "-preflight-max-failures", "0.2"}, false},
+		{"bad.csv", []string{"-preflight", "100"}, false},
+		{"late.csv", []string{"-preflight", "2"}, true},
+	}
+
+	for i, test := range tests {
+		out := t.TempDir()
+
+		args := append([]string{"csv2json", "-o", out, "-s", filepath.Join(dir, "ids.schema")}, test.args...)
+
+		err := run(append(args, filepath.Join(dir, test.fname)))
+
+		if test.ok {
+			if err != nil && exitcode(err) != exitInvalid {
+				t.Fatalf("tests[%d] - %s\n", i, err)
+			}
+			continue
+		}
+
+		if err == nil || err.Error() != "encountered errors during preflight" {
+			t.Fatalf("tests[%d] - unexpected error, expected=%q, got=%v\n", i, "encountered errors during preflight", err)
+		}
+
+		// Nothing should be converted if the preflight fails.
+		if _, err := os.Stat(filepath.Join(out, outputname(test.fname, ".json"))); err == nil {
+			t.Fatalf("tests[%d] - expected no output to be written\n", i)
+		}
+	}
+}
+
+func Test_Ragged(t *testing.T) {
+	csvfile := filepath.Join("testdata", "ragged.csv")
+
+	defer os.RemoveAll("ragged.json")
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-ragged", "pad"}, `{"id":2,"name":"Alyx"}`},
+		{[]string{"-ragged", "truncate"}, `{"id":3,"name":"Barney","email":"barney@blackmesa.com"}`},
+		{[]string{"-ragged", "truncate", "-ragged-overflow", "_extra"}, `{"id":3,"name":"Barney","email":"barney@blackmesa.com","_extra":["security","extra"]}`},
+	}
+
+	for i, test := range tests {
+		args := append([]string{"csv2json", "-order", "csv"}, test.args...)
+		args = append(args, csvfile)
+
+		// Padding long records is an error, so they are not converted.
+		if err := run(args); err != nil && exitcode(err) != exitInvalid {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		f, err := os.Open("ragged.json")
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		sc := bufio.NewScanner(f)
+
+		var line string
+
+		for sc.Scan() {
+			line = sc.Text()
+		}
+
+		f.Close()
+
+		if line != test.expected {
+			t.Fatalf("tests[%d] - unexpected last record, expected=%s, got=%s\n", i, test.expected, line)
+		}
+	}
+
+	if err := run([]string{"csv2json", csvfile}); err == nil {
+		t.Fatal("expected error for ragged records")
+	}
+}
+
+func Test_Preamble(t *testing.T) {
+	csvfile := filepath.Join("testdata", "preamble.csv")
+
+	defer os.RemoveAll("preamble.json")
+
+	tests := [][]string{
+		{"-skip-lines", "3"},
+		{"-header-prefix", "id,"},
+		{"-skip-lines", "1", "-header-prefix", "id,"},
+	}
+
+	for i, test := range tests {
+		args := append([]string{"csv2json", "-meta", "line"}, test...)
+		args = append(args, csvfile)
+
+		if err := run(args); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		f, err := os.Open("preamble.json")
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		sc := bufio.NewScanner(f)
+		sc.Scan()
+
+		line := sc.Text()
+
+		f.Close()
+
+		// The line numbers should still be those of the original file.
+		expected := `{"_line":5,"amount":10.5,"id":1}`
+
+		if line != expected {
+			t.Fatalf("tests[%d] - unexpected record, expected=%s, got=%s\n", i, expected, line)
+		}
+	}
+
+	if err := run([]string{"csv2json", "-header-prefix", "name,", csvfile}); err == nil {
+		t.Fatal("expected error for missing header")
+	}
+}
+
+func Test_Comment(t *testing.T) {
+	defer os.RemoveAll("comments.json")
+
+	if err := run([]string{"csv2json", "-comment", "#", filepath.Join("testdata", "comments.csv")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countLines(t, "comments.json"); n != 2 {
+		t.Fatalf("unexpected number of records, expected=%d, got=%d\n", 2, n)
+	}
+
+	if err := run([]string{"csv2json", "-comment", ",", filepath.Join("testdata", "comments.csv")}); err == nil {
+		t.Fatal("expected error for comment character matching delimeter")
+	}
+}
+
+func Test_Quote(t *testing.T) {
+	tests := []struct {
+		args     []string
+		csvfile  string
+		expected []string
+	}{
+		{
+			[]string{"-quote", "'"},
+			"single.csv",
+			[]string{
+				`{"id":1,"name":"Freeman, Gordon","note":"He said 'hi'"}`,
+				`{"id":2,"name":"Alyx","note":"multi\nline"}`,
+				`{"id":3,"name":"Barney","note":"say \"yes\""}`,
+			},
+		},
+		{
+			[]string{"-escape", `\`},
+			"escaped.csv",
+			[]string{
+				`{"id":1,"name":"Freeman, Gordon","note":"He said \"hi\""}`,
+				`{"id":2,"name":"Alyx","note":"back\\slash"}`,
+			},
+		},
+		{
+			[]string{"-lazy-quotes"},
+			"lazy.csv",
+			[]string{
+				`{"id":1,"name":"Gordon \"Doc\" Freeman"}`,
+			},
+		},
+		{
+			[]string{"-lazy-quotes", "-quote", "'"},
+			"lazy.csv",
+			[]string{
+				`{"id":1,"name":"Gordon \"Doc\" Freeman"}`,
+			},
+		},
+	}
+
+	for i, test := range tests {
+		args := append([]string{"csv2json", "-order", "csv"}, test.args...)
+		args = append(args, filepath.Join("testdata", test.csvfile))
+
+		if err := run(args); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		outname := outputname(test.csvfile, ".json")
+
+		b, err := os.ReadFile(outname)
+
+		os.RemoveAll(outname)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+
+		if !reflect.DeepEqual(lines, test.expected) {
+			t.Fatalf("tests[%d] - unexpected records, expected=%v, got=%v\n", i, test.expected, lines)
+		}
+	}
+
+	defer os.RemoveAll("lazy.json")
+
+	if err := run([]string{"csv2json", filepath.Join("testdata", "lazy.csv")}); err == nil {
+		t.Fatal("expected error for bare quote")
+	}
+}
+
+func Test_Xlsx(t *testing.T) {
+	xlsxfile := filepath.Join("testdata", "workbook.xlsx")
+
+	defer os.RemoveAll("workbook.json")
+
+	tests := []struct {
+		sheet    string
+		expected []string
+	}{
+		{"", []string{
+			`{"id":1,"name":"Gordon Freeman","verified":"true","created_at":"1998-11-19"}`,
+			`{"id":2,"name":"Alyx Vance","created_at":"1998-11-19 12:00:00"}`,
+			`{"id":3,"name":"Barney, Calhoun"}`,
+		}},
+		{"Notes", []string{
+			`{"note":"hello"}`,
+		}},
+	}
+
+	for i, test := range tests {
+		args := []string{"csv2json", "-order", "csv"}
+
+		if test.sheet != "" {
+			args = append(args, "-sheet", test.sheet)
+		}
+		args = append(args, xlsxfile)
+
+		if err := run(args); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		b, err := os.ReadFile("workbook.json")
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+
+		if !reflect.DeepEqual(lines, test.expected) {
+			t.Fatalf("tests[%d] - unexpected records, expected=%v, got=%v\n", i, test.expected, lines)
+		}
+	}
+
+	if err := run([]string{"csv2json", "-sheet", "Missing", xlsxfile}); err == nil {
+		t.Fatal("expected error for missing sheet")
+	}
+}
+
+func Test_Fixed(t *testing.T) {
+	txtfile := filepath.Join("testdata", "accounts.txt")
+	schemafile := filepath.Join("testdata", "accounts.schema")
+
+	if err := run([]string{"csv2json", "-fixed", "-s", schemafile, txtfile}); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll("accounts.txt.json")
+
+	f, err := os.Open(filepath.Join("testdata", "accounts.golden"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	checkCsv(t, f, "accounts.txt.json")
+
+	if err := run([]string{"csv2json", "-fixed", txtfile}); err == nil {
+		t.Fatal("expected error for schema without ranges")
+	}
+}
+
+func Test_LargeCells(t *testing.T) {
+	dir := t.TempDir()
+
+	// Larger than the default buffer of a bufio.Scanner, and the csv.Reader.
+	doc := strings.Repeat("Gordon Freeman ", 1<<17)
+
+	schema := filepath.Join(dir, "docs.schema")
+
+	lines := "id    int     _  _  _  0:4\n" +
+		"body  string  _  _  _  4:" + strconv.Itoa(4+len(doc)) + "\n"
+
+	if err := os.WriteFile(schema, []byte(lines), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fixed width column is trimmed of its padding.
+	tests := []struct {
+		fname   string
+		content string
+		args    []string
+		body    string
+	}{
+		{"plain.csv", "id,body\n1,\"" + doc + "\"\n", nil, doc},
+		{"quote.csv", "id,body\n1,'" + doc + "'\n", []string{"-quote", "'"}, doc},
+		{"fixed.txt", "1   " + doc + "\n", []string{"-fixed", "-s", schema}, strings.TrimSpace(doc)},
+	}
+
+	for i, test := range tests {
+		in := filepath.Join(dir, test.fname)
+
+		if err := os.WriteFile(in, []byte(test.content), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+
+		args := append([]string{"csv2json", "-o", dir}, test.args...)
+
+		if err := run(append(args, in)); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, outputname(test.fname, ".json")))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := `{"body":"` + test.body + `","id":1}` + "\n"
+
+		if string(b) != expected {
+			t.Errorf("tests[%d] - unexpected output, expected %d bytes, got %d\n", i, len(expected), len(b))
+		}
+	}
+}
+
+func Test_SchemaLongLines(t *testing.T) {
+	dir := t.TempDir()
+	schema := filepath.Join(dir, "names.schema")
+
+	// A pattern longer than the default token limit of a bufio.Scanner.
+	names := make([]string, 0, 1<<13)
+
+	for i := 0; i < cap(names); i++ {
+		names = append(names, "name"+strconv.Itoa(i))
+	}
+
+	pat := "^(" + strings.Join(names, "|") + ")$"
+
+	lines := "\n" +
+		"  # The names that are allowed.\n" +
+		"  \t\n" +
+		"\t@pattern name " + pat + "\n" +
+		"name  string  @name\n" +
+		"\n"
+
+	if err := os.WriteFile(schema, []byte(lines), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSchema()
+
+	if err := s.Load(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	if cols := strings.Join(s.Columns(), ","); cols != "name" {
+		t.Fatalf("unexpected columns, expected=%q, got=%q\n", "name", cols)
+	}
+
+	errs := make([]string, 0)
+
+	errh := func(line, col int, msg string) {
+		errs = append(errs, msg)
+	}
+
+	p, err := NewParser(strings.NewReader("name\nname42\nGordon\n"), ',', s, errh)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Parse(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(errs) != 1 {
+		t.Errorf("unexpected errors, expected=%d, got=%d\n", 1, len(errs))
+	}
+}
+
+func Test_SchemaInclude(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"common/ids.schema": "id int\n",
+		"common.schema":     "@include common/ids.schema\ncreated_at time 2006-01-02\n",
+		"users.schema":      "@include common.schema\nid string\nverified bool\n",
+		"cycle.schema":      "@include cycle2.schema\n",
+		"cycle2.schema":     "@include cycle.schema\n",
+		"bad.schema":        "@include common.schema\n@include missing.schema\n",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewSchema()
+
+	if err := s.Load(filepath.Join(dir, "users.schema")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records after an include replace those that were included.
+	expected := map[string]string{
+		"id":         "string",
+		"created_at": "time",
+		"verified":   "bool",
+	}
+
+	for col, typ := range expected {
+		rec, ok := s.Get(col)
+
+		if !ok {
+			t.Fatalf("expected column %s in schema\n", col)
+		}
+
+		if rec.Type != typ {
+			t.Errorf("%s - unexpected type, expected=%s, got=%s\n", col, typ, rec.Type)
+		}
+	}
+
+	if err := NewSchema().Load(filepath.Join(dir, "cycle.schema")); err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("expected include cycle error, got=%v\n", err)
+	}
+
+	err := NewSchema().Load(filepath.Join(dir, "bad.schema"))
+
+	var decerr SchemaDecodeError
+
+	if !errors.As(err, &decerr) {
+		t.Fatalf("unexpected error, expected=SchemaDecodeError, got=%v\n", err)
+	}
+
+	if decerr.Line != 2 {
+		t.Errorf("unexpected error line, expected=%d, got=%d\n", 2, decerr.Line)
+	}
+}
+
+func Test_SchemaLoadAll(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"common.schema": "id int\nborn date\n",
+		"users.schema":  "@include common.schema\nname string ([a-z\nage int\n@unknown\nemail email _ _ _ bogus=1\n",
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fname := filepath.Join(dir, "users.schema")
+
+	s := NewSchema()
+
+	err := s.LoadAll(fname)
+
+	var errs SchemaErrors
+
+	if !errors.As(err, &errs) {
+		t.Fatalf("unexpected error, expected=SchemaErrors, got=%v\n", err)
+	}
+
+	expected := []struct {
+		file string
+		line int
+	}{
+		{filepath.Join(dir, "common.schema"), 2},
+		{fname, 2},
+		{fname, 4},
+		{fname, 5},
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("unexpected number of errors, expected=%d, got=%d\n%s\n", len(expected), len(errs), err)
+	}
+
+	for i, err := range errs {
+		var decerr SchemaDecodeError
+
+		if !errors.As(err, &decerr) {
+			t.Fatalf("errors[%d] - unexpected error, expected=SchemaDecodeError, got=%v\n", i, err)
+		}
+
+		if decerr.File != expected[i].file || decerr.Line != expected[i].line {
+			t.Errorf("errors[%d] - unexpected position, expected=%s:%d, got=%s:%d\n", i, expected[i].file, expected[i].line, decerr.File, decerr.Line)
+		}
+	}
+
+	// The valid lines are still loaded.
+	for _, col := range []string{"id", "age"} {
+		if _, ok := s.Get(col); !ok {
+			t.Errorf("expected column %s in schema\n", col)
+		}
+	}
+
+	if err := NewSchema().Load(fname); errors.As(err, &errs) {
+		t.Errorf("expected Load to stop at the first error, got=%v\n", err)
+	}
+
+	csvfile := filepath.Join("testdata", "ips.csv")
+
+	if err := run([]string{"csv2json", "-o", dir, "-s", fname, csvfile}); err == nil {
+		t.Error("expected error for invalid schema")
+	}
+
+	err = run([]string{"csv2json", "-o", dir, "-all-schema-errors", "-s", fname, csvfile})
+
+	if err == nil || err.Error() != "encountered 4 errors in schema" {
+		t.Errorf("unexpected error, expected=%q, got=%v\n", "encountered 4 errors in schema", err)
+	}
+}
+
+func Test_SchemaPattern(t *testing.T) {
+	dir := t.TempDir()
+	schema := filepath.Join(dir, "people.schema")
+
+	lines := `@pattern name ^[A-Z][a-z]+ [A-Z][a-z]+$
+@pattern date 2006-01-02
+name      string  @name
+manager   string  @name
+born      time    @date
+handle    string  \@
+`
+
+	if err := os.WriteFile(schema, []byte(lines), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSchema()
+
+	if err := s.Load(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make([]string, 0)
+
+	errh := func(line, col int, msg string) {
+		errs = append(errs, msg)
+	}
+
+	in := strings.NewReader("name,manager,born,handle\n" +
+		"Gordon Freeman,Eli Vance,1998-11-19,@gordon\n" +
+		"Alyx,Eli Vance,2004-11-16,@alyx\n")
+
+	p, err := NewParser(in, ',', s, errh)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := p.Parse(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"born":"1998-11-19T00:00:00Z","handle":"@gordon","manager":"Eli Vance","name":"Gordon Freeman"}` + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("unexpected output, expected=%q, got=%q\n", expected, buf.String())
+	}
+
+	if len(errs) != 1 {
+		t.Errorf("unexpected errors, expected=%d, got=%v\n", 1, errs)
+	}
+
+	if err := os.WriteFile(schema, []byte("name string @unknown\n"), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewSchema().Load(schema); err == nil {
+		t.Fatal("expected error for unknown pattern, got nil")
+	}
+}
+
+func Test_SplitSpace(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []string
+		err      bool
+	}{
+		{`     "Hello, world"     [0-9]+   foo    `, []string{"Hello, world", "[0-9]+", "foo"}, false},
+		{`foo`, []string{"foo"}, false},
+		{"a\tb \t c", []string{"a", "b", "c"}, false},
+		{`name  string  "^[A-Z][a-z]+ [A-Z][a-z]+$"`, []string{"name", "string", "^[A-Z][a-z]+ [A-Z][a-z]+$"}, false},
+		{`born  time  "2006-01-02	15:04"`, []string{"born", "time", "2006-01-02\t15:04"}, false},
+		{`price  float  _  _  _  transform="value * 100"`, []string{"price", "float", "_", "_", "_", "transform=value * 100"}, false},
+		{`code  string  ^\d+\ \w+$`, []string{"code", "string", `^\d+ \w+$`}, false},
+		{`quote  string  "^\"[a-z]+\"$"`, []string{"quote", "string", `^"[a-z]+"$`}, false},
+		{`path  string  ^C:\\dir$`, []string{"path", "string", `^C:\\dir$`}, false},
+		{`sep  string  ""  _`, []string{"sep", "string", "", "_"}, false},
+		{`name  string  "^[a-z]+`, []string{"name", "string", "^[a-z]+"}, true},
+	}
+
+	for i, test := range tests {
+		parts, err := splitspace([]byte(test.in))
+
+		if test.err {
+			if err == nil {
+				t.Errorf("tests[%d] - expected error, got nil\n", i)
+			}
+		} else if err != nil {
+			t.Errorf("tests[%d] - %s\n", i, err)
+		}
+
+		if !reflect.DeepEqual(parts, test.expected) {
+			t.Errorf("tests[%d] - unexpected parts, expected=%q, got=%q\n", i, test.expected, parts)
+		}
+	}
+}
+
+func Test_SchemaQuoting(t *testing.T) {
+	dir := t.TempDir()
+	schema := filepath.Join(dir, "people.schema")
+
+	lines := "# Comment\n" +
+		"\n" +
+		"name  string  \"^[A-Z][a-z]+ [A-Z][a-z]+$\"\n" +
+		"born  time    \"2006-01-02\t15:04\"  \"Jan 2 2006 at 15:04\"\n" +
+		"   \t\n" +
+		"role  string  ^\\\"[a-z]+\\\"$\n"
+
+	if err := os.WriteFile(schema, []byte(lines), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSchema()
+
+	if err := s.Load(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make([]string, 0)
+
+	errh := func(line, col int, msg string) {
+		errs = append(errs, msg)
+	}
+
+	in := strings.NewReader("name,born,role\n" +
+		"Gordon Freeman,1998-11-19\t09:30,\"\"\"physicist\"\"\"\n" +
+		"Alyx,2004-11-16\t10:00,\"\"\"engineer\"\"\"\n")
+
+	p, err := NewParser(in, ',', s, errh)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := p.Parse(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"born":"Nov 19 1998 at 09:30","name":"Gordon Freeman","role":"\"physicist\""}` + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("unexpected output, expected=%q, got=%q\n", expected, buf.String())
+	}
+
+	if len(errs) != 1 {
+		t.Errorf("unexpected errors, expected=%d, got=%v\n", 1, errs)
+	}
+
+	if err := os.WriteFile(schema, []byte("name  string  \"^[a-z]+\n"), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewSchema().Load(schema); err == nil {
+		t.Fatal("expected error for unterminated quote, got nil")
+	}
+}
+
+func Test_SchemaRaw(t *testing.T) {
+	dir := t.TempDir()
+	schema := filepath.Join(dir, "contacts.schema")
+
+	if err := os.WriteFile(schema, []byte("phone  raw\nnote   raw\n"), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSchema()
+
+	if err := s.Load(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	in := strings.NewReader("id,phone,note\n1,007123, padded \n2,+44 20,\n")
+
+	p, err := NewParser(in, ',', s, errh)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := p.Parse(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"id":1,"note":" padded ","phone":"007123"}` + "\n" +
+		`{"id":2,"note":"","phone":"+44 20"}` + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("unexpected output, expected=%q, got=%q\n", expected, buf.String())
+	}
+
+	invalid := []string{
+		"phone  raw  ^[0-9]+$\n",
+		"phone  raw  _  x\n",
+		"phone  raw  _  _  _  \"transform=value + '1'\"\n",
+	}
+
+	for i, src := range invalid {
+		if err := os.WriteFile(schema, []byte(src), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := NewSchema().Load(schema); err == nil {
+			t.Errorf("invalid[%d] - expected error, got nil\n", i)
+		}
+	}
+}
+
+func Test_SchemaFormats(t *testing.T) {
+	tests := []struct {
+		src   string
+		valid bool
+	}{
+		{"code  int  _  %05d\n", true},
+		{"code  int  _  %x\n", true},
+		{"code  int  16  hex\n", true},
+		{"code  int  _  oct\n", true},
+		{"code  int  _  bin\n", true},
+		{"code  int  _  %f\n", false},
+		{"code  int  _  %s\n", false},
+		{"code  int  _  %d-%d\n", false},
+		{"code  int  _  bogus\n", false},
+		{"@combine  a,b  int  _  %f  code\n", false},
+		{"price  float  _  %.2f\n", true},
+		{"price  float  _  %08.2f\n", true},
+		{"price  float  _  round:2\n", true},
+		{"price  float  _  round:0\n", true},
+		{"price  float  _  round:x\n", false},
+		{"price  float  _  round:-1\n", false},
+		{"price  float  _  %d\n", false},
+		{"price  float  _  %.2f%s\n", false},
+		{"price  float  _  bogus\n", false},
+		{"@combine  a,b  float  _  %d  total\n", false},
+		{"total  float  concat=a,b  sep=.  format=round:x\n", false},
+	}
+
+	for i, test := range tests {
+		schema := filepath.Join(t.TempDir(), "formats.schema")
+
+		if err := os.WriteFile(schema, []byte(test.src), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+
+		err := NewSchema().Load(schema)
+
+		if test.valid && err != nil {
+			t.Errorf("tests[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if !test.valid && err == nil {
+			t.Errorf("tests[%d] - expected error for %q, got nil\n", i, test.src)
+		}
+	}
+}
+
+func Test_SchemaConcatTemplate(t *testing.T) {
+	tests := []struct {
+		src   string
+		valid bool
+	}{
+		{"full  string  concat=first,last\n", true},
+		{"full  string  \"template={first}-{last}\"\n", true},
+		{"full  string  concat=last,first  \"template={first}-{last}\"\n", false},
+		{"full  string  \"template={first}-{last}\"  concat=last,first\n", false},
+	}
+
+	for i, test := range tests {
+		schema := filepath.Join(t.TempDir(), "concat.schema")
+
+		if err := os.WriteFile(schema, []byte(test.src), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+
+		err := NewSchema().Load(schema)
+
+		if test.valid && err != nil {
+			t.Errorf("tests[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if !test.valid && (err == nil || !strings.Contains(err.Error(), "concat and template options cannot both be given")) {
+			t.Errorf("tests[%d] - expected error for %q, got=%v\n", i, test.src, err)
+		}
+	}
+}
+
+func Test_TimeSentinel(t *testing.T) {
+	unmarshal := UnmarshalTimeSentinel("2006-01-02 15:04:05", "clamp")
+
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{"2016-12-31 23:59:60", `"2016-12-31T23:59:59Z"`},
+		{"0000-00-00 00:00:00", `"0001-01-01T00:00:00Z"`},
+		{"9999-12-31 00:00:00", `"9999-12-31T00:00:00Z"`},
+		{"2021-01-02 10:00:00", `"2021-01-02T10:00:00Z"`},
+		{"2021-01-02 10:60:00", ""},
+		{"2021-01-02 12:34:60", ""},
+		{"2021-01-02 12:59:60", ""},
+		{"0", ""},
+		{"00", ""},
+		{"0000-00-00 00:00:01", ""},
+	}
+
+	for i, test := range tests {
+		v, err := unmarshal(test.in)
+
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("tests[%d] - expected error for %q, got nil\n", i, test.in)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("tests[%d] - unexpected error: %s\n", i, err)
+			continue
+		}
+
+		b, err := v.MarshalJSON()
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if string(b) != test.expected {
+			t.Errorf("tests[%d] - unexpected time, expected=%s, got=%s\n", i, test.expected, string(b))
+		}
+	}
+
+	for i, s := range []string{"0000-00-00", "0000-00-00 00:00:00", "0000-00-00T00:00:00.000"} {
+		if !iszerodate(s) {
+			t.Errorf("zero[%d] - expected %q to be a zero date\n", i, s)
+		}
+	}
+}
+
+func Test_ErrorPositions(t *testing.T) {
+	tests := []struct {
+		in       string
+		opts     []ParserOption
+		expected []string
+	}{
+		{
+			"id,bio,age\n1,\"Gordon\nphysicist\",27\n2,\"Alyx\",x\n",
+			nil,
+			[]string{"4:10"},
+		},
+		{
+			"id,bio,age\n1,\"Gordon\nphysicist\",x\n2,Alyx,y\n",
+			nil,
+			[]string{"3:12", "4:8"},
+		},
+		{
+			"# export\nid,bio,age\n1,Gordon,x\n",
+			[]ParserOption{WithPreamble(1, "")},
+			[]string{"3:10"},
+		},
+	}
+
+	for i, test := range tests {
+		errs := make([]string, 0)
+
+		errh := func(line, col int, msg string) {
+			errs = append(errs, strconv.Itoa(line)+":"+strconv.Itoa(col))
+		}
+
+		s := NewSchema()
+		s.Add("age", SchemaRecord{Dest: "age", Unmarshal: UnmarshalInt(10)})
+
+		p, err := NewParser(strings.NewReader(test.in), ',', s, errh, test.opts...)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := p.Parse(io.Discard); err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Join(errs, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("tests[%d] - unexpected positions, expected=%v, got=%v\n", i, test.expected, errs)
+		}
+	}
+}
+
+func Test_EmptyInput(t *testing.T) {
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	if _, err := NewParser(strings.NewReader(""), ',', NewSchema(), errh); !errors.Is(err, ErrEmptyInput) {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", ErrEmptyInput, err)
+	}
+
+	readerr := errors.New("read failed")
+
+	if _, err := NewParser(iotest.ErrReader(readerr), ',', NewSchema(), errh, WithAllowEmpty()); !errors.Is(err, readerr) {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", readerr, err)
+	}
+
+	// The columns of options are not checked against an empty input, since
+	// it has no header.
+	p, err := NewParser(strings.NewReader(""), ',', NewSchema(), errh, WithAllowEmpty(), WithDedupe([]string{"id"}, false))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := p.Parse(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("unexpected output, expected=%q, got=%q\n", "", buf.String())
+	}
+
+	dir := t.TempDir()
+
+	csvfile := filepath.Join(dir, "empty.csv")
+
+	if err := os.WriteFile(csvfile, nil, os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"csv2json", "-o", dir, csvfile}); err == nil {
+		t.Error("expected error for empty input")
+	}
+
+	if err := run([]string{"csv2json", "-o", dir, "-allow-empty", csvfile}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countLines(t, filepath.Join(dir, "empty.json")); n != 0 {
+		t.Errorf("unexpected number of rows, expected=%d, got=%d\n", 0, n)
+	}
+}
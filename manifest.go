@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ManifestEntry describes an output written by a conversion.
+type ManifestEntry struct {
+	Output  string   `json:"output"`
+	SHA256  string   `json:"sha256"`
+	Records int      `json:"records"`
+	Sources []string `json:"sources"`
+}
+
+// Manifest is the list of outputs written by a conversion, along with their
+// checksums, so they can be checked by whatever loads them. It is safe for
+// concurrent use, since inputs are converted concurrently.
+type Manifest struct {
+	mu      sync.Mutex
+	Outputs []ManifestEntry `json:"outputs"`
+}
+
+// Add adds the given entries to the manifest.
+func (m *Manifest) Add(entries ...ManifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Outputs = append(m.Outputs, entries...)
+}
+
+// Write writes the manifest as JSON to w, with the outputs ordered by their
+// path, so the manifest is the same regardless of the order the inputs were
+// converted in.
+func (m *Manifest) Write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sort.Slice(m.Outputs, func(i, j int) bool {
+		return m.Outputs[i].Output < m.Outputs[j].Output
+	})
+
+	if m.Outputs == nil {
+		m.Outputs = []ManifestEntry{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(m)
+}
+
+// hashWriter hashes everything written to the underlying output. This is used
+// for objects, which cannot be read back once written.
+type hashWriter struct {
+	io.WriteCloser
+
+	h hash.Hash
+}
+
+func (w *hashWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.h.Write(p[:n])
+	return n, err
+}
+
+// Abort aborts the underlying output if it can be, otherwise it is closed.
+func (w *hashWriter) Abort() {
+	if a, ok := w.WriteCloser.(aborter); ok {
+		a.Abort()
+		return
+	}
+	w.WriteCloser.Close()
+}
+
+// sha256file returns the hex encoded SHA-256 of the file at the given path.
+func sha256file(path string) (string, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// countEncoder counts the records encoded into an output of a set.
+type countEncoder struct {
+	Encoder
+
+	set *outputSet
+	i   int
+}
+
+func (e countEncoder) Encode(r *Record) error {
+	if err := e.Encoder.Encode(r); err != nil {
+		return err
+	}
+	e.set.records[e.i]++
+	return nil
+}
+
+// counted returns an EncoderFunc that counts the records encoded by the given
+// EncoderFunc into each output of the set. The encoder for an output is always
+// created straight after the output itself, so the records are counted
+// against the last output created.
+func (s *outputSet) counted(enc EncoderFunc) EncoderFunc {
+	return func(w io.Writer, name string, fields []Field) (Encoder, error) {
+		e, err := enc(w, name, fields)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(s.records) == 0 {
+			return e, nil
+		}
+		return countEncoder{Encoder: e, set: s, i: len(s.records) - 1}, nil
+	}
+}
+
+// written sets the number of records written to the only output of the set,
+// for when the output is written to directly by a Parser rather than through
+// an Encoder.
+func (s *outputSet) written(n int) {
+	if len(s.records) == 1 {
+		s.records[0] = n
+	}
+}
+
+// entries returns the manifest entries of the outputs in the set, which are
+// expected to have been closed. Outputs on disk are read back to be hashed.
+func (s *outputSet) entries(sources ...string) ([]ManifestEntry, error) {
+	entries := make([]ManifestEntry, 0, len(s.paths))
+
+	for i, path := range s.paths {
+		var sum string
+
+		if h := s.sums[i]; h != nil {
+			sum = hex.EncodeToString(h.Sum(nil))
+		} else {
+			var err error
+
+			sum, err = sha256file(path)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, ManifestEntry{
+			Output:  path,
+			SHA256:  sum,
+			Records: s.records[i],
+			Sources: sources,
+		})
+	}
+	return entries, nil
+}
+
+// writemanifest writes the manifest to the given path, which can be either a
+// file, or an object.
+func writemanifest(path string, m *Manifest, fetch *Fetcher) error {
+	w, err := createoutput(path, fetch)
+
+	if err != nil {
+		return err
+	}
+
+	err = m.Write(w)
+
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readManifest(t *testing.T, path string) []ManifestEntry {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m struct {
+		Outputs []ManifestEntry `json:"outputs"`
+	}
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m.Outputs
+}
+
+func Test_Manifest(t *testing.T) {
+	tests := []struct {
+		args    []string
+		outputs []string
+		records []int
+		sources []string
+	}{
+		{
+			[]string{filepath.Join("testdata", "ips.csv")},
+			[]string{"ips.json"},
+			[]int{10},
+			[]string{filepath.Join("testdata", "ips.csv")},
+		},
+		{
+			[]string{"-split-rows", "4", filepath.Join("testdata", "ips.csv")},
+			[]string{"ips.0001.json", "ips.0002.json", "ips.0003.json"},
+			[]int{4, 4, 2},
+			[]string{filepath.Join("testdata", "ips.csv")},
+		},
+		{
+			[]string{"-format", "yaml", filepath.Join("testdata", "ips.csv")},
+			[]string{"ips.yaml"},
+			[]int{10},
+			[]string{filepath.Join("testdata", "ips.csv")},
+		},
+	}
+
+	for i, test := range tests {
+		dir := t.TempDir()
+		manifest := filepath.Join(t.TempDir(), "manifest.json")
+
+		args := append([]string{"csv2json", "-o", dir, "-manifest", manifest}, test.args...)
+
+		if err := run(args); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		entries := readManifest(t, manifest)
+
+		if len(entries) != len(test.outputs) {
+			t.Fatalf("tests[%d] - unexpected outputs, expected=%d, got=%d\n", i, len(test.outputs), len(entries))
+		}
+
+		for j, ent := range entries {
+			path := filepath.Join(dir, test.outputs[j])
+
+			if ent.Output != path {
+				t.Errorf("tests[%d][%d] - unexpected output, expected=%q, got=%q\n", i, j, path, ent.Output)
+			}
+
+			b, err := os.ReadFile(path)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sum := sha256.Sum256(b)
+
+			if ent.SHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("tests[%d][%d] - unexpected sha256, expected=%q, got=%q\n", i, j, hex.EncodeToString(sum[:]), ent.SHA256)
+			}
+
+			if ent.Records != test.records[j] {
+				t.Errorf("tests[%d][%d] - unexpected records, expected=%d, got=%d\n", i, j, test.records[j], ent.Records)
+			}
+
+			if strings.Join(ent.Sources, ",") != strings.Join(test.sources, ",") {
+				t.Errorf("tests[%d][%d] - unexpected sources, expected=%v, got=%v\n", i, j, test.sources, ent.Sources)
+			}
+		}
+	}
+}
+
+func Test_ManifestMerge(t *testing.T) {
+	dir := t.TempDir()
+
+	out := filepath.Join(dir, "combined.json")
+	manifest := filepath.Join(dir, "manifest.json")
+
+	args := []string{
+		"csv2json",
+		"-merge",
+		"-o", out,
+		"-manifest", manifest,
+		filepath.Join("testdata", "accounts2.csv"),
+		filepath.Join("testdata", "accounts1.csv"),
+	}
+
+	if err := run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readManifest(t, manifest)
+
+	if len(entries) != 1 {
+		t.Fatalf("unexpected outputs, expected=%d, got=%d\n", 1, len(entries))
+	}
+
+	if entries[0].Records != 6 {
+		t.Errorf("unexpected records, expected=%d, got=%d\n", 6, entries[0].Records)
+	}
+
+	sources := filepath.Join("testdata", "accounts2.csv") + "," + filepath.Join("testdata", "accounts1.csv")
+
+	if strings.Join(entries[0].Sources, ",") != sources {
+		t.Errorf("unexpected sources, expected=%q, got=%q\n", sources, strings.Join(entries[0].Sources, ","))
+	}
+}
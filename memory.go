@@ -0,0 +1,49 @@
+package main
+
+// WithMaxMemory configures the Parser to use at most roughly max bytes of
+// memory for the records and keys it buffers, such as when sorting, or
+// deduplicating, before spilling them to the Parser's TempDir. The memory is
+// split between each of these that is used, and replaces the memory given to
+// WithSortBy if it is lower. If max is 0 then there is no limit.
+func WithMaxMemory(max int64) ParserOption {
+	return func(p *Parser) {
+		p.memmax = max
+	}
+}
+
+// limitmemory splits the memory of the Parser between the buffers that can be
+// spilled to disk.
+func (p *Parser) limitmemory() {
+	if p.memmax <= 0 {
+		return
+	}
+
+	n := int64(0)
+
+	if p.sortby != "" {
+		n++
+	}
+
+	if p.seen != nil {
+		n++
+	}
+
+	if n == 0 {
+		return
+	}
+
+	share := p.memmax / n
+
+	if share < 1 {
+		share = 1
+	}
+
+	if p.sortby != "" && (p.sortmax == 0 || p.sortmax > share) {
+		p.sortmax = share
+	}
+
+	if p.seen != nil {
+		p.seen.max = share
+		p.seen.tmp = p.tmp
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func Test_LimitMemory(t *testing.T) {
+	tests := []struct {
+		opts    []ParserOption
+		sortmax int64
+		seenmax int64
+	}{
+		{[]ParserOption{WithMaxMemory(100), WithSortBy("id", false, 256)}, 100, 0},
+		{[]ParserOption{WithMaxMemory(100), WithSortBy("id", false, 50)}, 50, 0},
+		{[]ParserOption{WithMaxMemory(100), WithSortBy("id", false, 0)}, 100, 0},
+		{[]ParserOption{WithMaxMemory(100), WithDedupe(nil, false)}, 0, 100},
+		{[]ParserOption{WithMaxMemory(100), WithDedupe(nil, false), WithSortBy("id", false, 256)}, 50, 50},
+		{[]ParserOption{WithDedupe(nil, false), WithSortBy("id", false, 256)}, 256, 0},
+	}
+
+	for i, test := range tests {
+		p := &Parser{}
+
+		for _, opt := range test.opts {
+			opt(p)
+		}
+
+		p.limitmemory()
+
+		if p.sortmax != test.sortmax {
+			t.Errorf("tests[%d] - unexpected sort memory, expected=%d, got=%d\n", i, test.sortmax, p.sortmax)
+		}
+
+		if p.seen != nil && p.seen.max != test.seenmax {
+			t.Errorf("tests[%d] - unexpected dedupe memory, expected=%d, got=%d\n", i, test.seenmax, p.seen.max)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import "io"
+
+// mergefields returns the fields of the records written for each of the given
+// inputs, in the order they are first seen. If a field has a different type
+// across inputs, then its type is left empty.
+func mergefields(inputs [][]Field) []Field {
+	fields := make([]Field, 0)
+	index := make(map[string]int)
+
+	for _, input := range inputs {
+		for _, f := range input {
+			i, ok := index[f.Name]
+
+			if !ok {
+				index[f.Name] = len(fields)
+				fields = append(fields, f)
+				continue
+			}
+
+			if fields[i].Type != f.Type || fields[i].Array != f.Array {
+				fields[i].Type = ""
+				fields[i].Array = false
+			}
+		}
+	}
+	return fields
+}
+
+// mergeEncoder is an Encoder shared by multiple Parsers. Closing it does
+// nothing, so it can be closed by each Parser, and the underlying Encoder is
+// closed once all of them are done.
+type mergeEncoder struct {
+	Encoder
+}
+
+func (mergeEncoder) Close() error { return nil }
+
+// sharedEncoder returns an EncoderFunc that returns the given Encoder, for
+// sharing between Parsers.
+func sharedEncoder(enc Encoder) EncoderFunc {
+	return func(_ io.Writer, _ string, _ []Field) (Encoder, error) {
+		return mergeEncoder{enc}, nil
+	}
+}
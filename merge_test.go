@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Merge(t *testing.T) {
+	dir := t.TempDir()
+
+	out := filepath.Join(dir, "combined.json")
+
+	// The second file is given first, to check that the argument order is
+	// kept.
+	args := []string{
+		"csv2json",
+		"-merge",
+		"-o", out,
+		"-meta", "file",
+		filepath.Join("testdata", "accounts2.csv"),
+		filepath.Join("testdata", "accounts1.csv"),
+	}
+
+	if err := run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	ids := make([]string, 0)
+	files := make([]string, 0)
+
+	sc := bufio.NewScanner(f)
+
+	for sc.Scan() {
+		var rec map[string]interface{}
+
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, fmt.Sprint(rec["id"]))
+		files = append(files, filepath.Base(rec["_file"].(string)))
+	}
+
+	if s := strings.Join(ids, ","); s != "3,4,1,2,2,3" {
+		t.Errorf("unexpected ids, expected=%q, got=%q\n", "3,4,1,2,2,3", s)
+	}
+
+	if files[0] != "accounts2.csv" || files[len(files)-1] != "accounts1.csv" {
+		t.Errorf("unexpected files, got=%v\n", files)
+	}
+}
+
+func Test_MergeFormat(t *testing.T) {
+	dir := t.TempDir()
+
+	args := []string{
+		"csv2json",
+		"-merge",
+		"-o", filepath.Join(dir, "accounts.sql"),
+		"-format", "sql",
+		"-sql-style", "copy",
+		filepath.Join("testdata", "accounts1.csv"),
+		filepath.Join("testdata", "accounts2.csv"),
+	}
+
+	if err := run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "accounts.sql"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A single COPY statement should hold the records of both files.
+	if n := strings.Count(string(b), "COPY "); n != 1 {
+		t.Errorf("unexpected number of COPY statements, expected=%d, got=%d\n", 1, n)
+	}
+
+	if n := strings.Count(string(b), "\n") - 2; n != 6 {
+		t.Errorf("unexpected number of rows, expected=%d, got=%d\n", 6, n)
+	}
+}
+
+func Test_MergeRequiresOutput(t *testing.T) {
+	args := []string{"csv2json", "-merge", filepath.Join("testdata", "accounts1.csv")}
+
+	if err := run(args); err == nil {
+		t.Fatal("expected error for -merge without -o")
+	}
+}
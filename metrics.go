@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metricsBuckets are the upper bounds, in seconds, of the buckets of the
+// histogram of how long each file took to convert.
+var metricsBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// Metrics are the counts of the files and records converted, along with how
+// long each file took, written in the Prometheus text format so they can be
+// picked up by the textfile collector of the node exporter, or anything else
+// that reads the format.
+type Metrics struct {
+	mu sync.Mutex
+
+	converted int64
+	failed    int64
+	records   int64
+	errors    int64
+
+	buckets []int64 // number of files in each of metricsBuckets
+	sum     float64 // total seconds spent converting
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		buckets: make([]int64, len(metricsBuckets)),
+	}
+}
+
+// Converted records a file that was converted with the given number of
+// records and errors, and how long it took.
+func (m *Metrics) Converted(d time.Duration, records, errors int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.converted++
+	m.records += int64(records)
+	m.errors += int64(errors)
+
+	secs := d.Seconds()
+
+	for i, le := range metricsBuckets {
+		if secs <= le {
+			m.buckets[i]++
+		}
+	}
+	m.sum += secs
+}
+
+// Failed records a file that could not be converted.
+func (m *Metrics) Failed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failed++
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format, along with
+// the time they were written, so a conversion that has stopped running can be
+// told apart from one that converted nothing.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	var n int64
+
+	write := func(s string) {
+		written, _ := bw.WriteString(s)
+		n += int64(written)
+	}
+
+	itoa := func(i int64) string { return strconv.FormatInt(i, 10) }
+	ftoa := func(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
+
+	write("# HELP csv2json_files_total Number of files converted, by status.\n")
+	write("# TYPE csv2json_files_total counter\n")
+	write(`csv2json_files_total{status="converted"} ` + itoa(m.converted) + "\n")
+	write(`csv2json_files_total{status="failed"} ` + itoa(m.failed) + "\n")
+
+	write("# HELP csv2json_records_total Number of records converted.\n")
+	write("# TYPE csv2json_records_total counter\n")
+	write("csv2json_records_total " + itoa(m.records) + "\n")
+
+	write("# HELP csv2json_record_errors_total Number of records that could not be converted.\n")
+	write("# TYPE csv2json_record_errors_total counter\n")
+	write("csv2json_record_errors_total " + itoa(m.errors) + "\n")
+
+	write("# HELP csv2json_file_duration_seconds Time taken to convert each file.\n")
+	write("# TYPE csv2json_file_duration_seconds histogram\n")
+
+	for i, le := range metricsBuckets {
+		write(`csv2json_file_duration_seconds_bucket{le="` + ftoa(le) + `"} ` + itoa(m.buckets[i]) + "\n")
+	}
+
+	write(`csv2json_file_duration_seconds_bucket{le="+Inf"} ` + itoa(m.converted) + "\n")
+	write("csv2json_file_duration_seconds_sum " + ftoa(m.sum) + "\n")
+	write("csv2json_file_duration_seconds_count " + itoa(m.converted) + "\n")
+
+	write("# HELP csv2json_last_run_timestamp_seconds Time the metrics were last written.\n")
+	write("# TYPE csv2json_last_run_timestamp_seconds gauge\n")
+	write("csv2json_last_run_timestamp_seconds " + itoa(time.Now().Unix()) + "\n")
+
+	return n, bw.Flush()
+}
+
+// writemetrics writes the given metrics to the file at the given path. The
+// metrics are written to a temporary file first, and then renamed, so anything
+// reading the file never sees it half written.
+func writemetrics(path string, m *Metrics) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := m.WriteTo(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), os.FileMode(0644)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Metrics(t *testing.T) {
+	dir := t.TempDir()
+
+	empty := filepath.Join(dir, "empty.csv")
+
+	if err := os.WriteFile(empty, nil, os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := filepath.Join(dir, "csv2json.prom")
+
+	args := []string{"csv2json", "-o", dir, "-metrics", metrics, filepath.Join("testdata", "ips.csv"), empty}
+
+	if err := run(args); err == nil {
+		t.Fatal("expected error for empty input")
+	}
+
+	b, err := os.ReadFile(metrics)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`csv2json_files_total{status="converted"} 1`,
+		`csv2json_files_total{status="failed"} 1`,
+		"csv2json_records_total 10",
+		"csv2json_record_errors_total 0",
+		`csv2json_file_duration_seconds_bucket{le="+Inf"} 1`,
+		"csv2json_file_duration_seconds_count 1",
+		"# TYPE csv2json_file_duration_seconds histogram",
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(string(b), line+"\n") {
+			t.Errorf("expected %q in metrics\n%s\n", line, string(b))
+		}
+	}
+}
+
+func Test_MetricsBuckets(t *testing.T) {
+	m := NewMetrics()
+	m.Converted(300*time.Millisecond, 1, 0)
+	m.Converted(2*time.Minute, 1, 0)
+
+	var buf strings.Builder
+
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`csv2json_file_duration_seconds_bucket{le="0.1"} 0`,
+		`csv2json_file_duration_seconds_bucket{le="0.5"} 1`,
+		`csv2json_file_duration_seconds_bucket{le="60"} 1`,
+		`csv2json_file_duration_seconds_bucket{le="300"} 2`,
+		`csv2json_file_duration_seconds_bucket{le="+Inf"} 2`,
+		"csv2json_file_duration_seconds_sum 120.3",
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected %q in metrics\n%s\n", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// msgpackEncoder writes each record to the underlying writer as a MessagePack
+// map, one after the other.
+type msgpackEncoder struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// NewMsgpackEncoder returns an Encoder that writes records to w as a stream of
+// MessagePack maps.
+func NewMsgpackEncoder(w io.Writer, _ string, _ []Field) (Encoder, error) {
+	return &msgpackEncoder{w: w}, nil
+}
+
+// head writes the header for a string, array, or map of length n. The fix
+// byte is used if n fits in it, otherwise the 8, 16, or 32 bit codes are used,
+// with 0 meaning there is no 8 bit code.
+func (e *msgpackEncoder) head(n int, fix, max byte, codes [3]byte) {
+	switch {
+	case n <= int(max):
+		e.buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint8 && codes[0] != 0:
+		e.buf.WriteByte(codes[0])
+		e.buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		e.buf.WriteByte(codes[1])
+		binary.Write(&e.buf, binary.BigEndian, uint16(n))
+	default:
+		e.buf.WriteByte(codes[2])
+		binary.Write(&e.buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) string(s string) {
+	e.head(len(s), 0xa0, 31, [3]byte{0xd9, 0xda, 0xdb})
+	e.buf.WriteString(s)
+}
+
+func (e *msgpackEncoder) int(n int64) {
+	switch {
+	case n >= 0 && n <= math.MaxInt8:
+		e.buf.WriteByte(byte(n))
+	case n >= -32 && n < 0:
+		e.buf.WriteByte(byte(int8(n)))
+	case n >= 0 && n <= math.MaxUint8:
+		e.buf.WriteByte(0xcc)
+		e.buf.WriteByte(byte(n))
+	case n >= 0 && n <= math.MaxUint16:
+		e.buf.WriteByte(0xcd)
+		binary.Write(&e.buf, binary.BigEndian, uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		e.buf.WriteByte(0xce)
+		binary.Write(&e.buf, binary.BigEndian, uint32(n))
+	case n >= 0:
+		e.buf.WriteByte(0xcf)
+		binary.Write(&e.buf, binary.BigEndian, uint64(n))
+	case n >= math.MinInt8:
+		e.buf.WriteByte(0xd0)
+		e.buf.WriteByte(byte(int8(n)))
+	case n >= math.MinInt16:
+		e.buf.WriteByte(0xd1)
+		binary.Write(&e.buf, binary.BigEndian, int16(n))
+	case n >= math.MinInt32:
+		e.buf.WriteByte(0xd2)
+		binary.Write(&e.buf, binary.BigEndian, int32(n))
+	default:
+		e.buf.WriteByte(0xd3)
+		binary.Write(&e.buf, binary.BigEndian, n)
+	}
+}
+
+func (e *msgpackEncoder) value(v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		e.buf.WriteByte(0xc0)
+	case bool:
+		if x {
+			e.buf.WriteByte(0xc3)
+		} else {
+			e.buf.WriteByte(0xc2)
+		}
+	case int64:
+		e.int(x)
+	case float64:
+		e.buf.WriteByte(0xcb)
+		binary.Write(&e.buf, binary.BigEndian, math.Float64bits(x))
+	case string:
+		e.string(x)
+	case []interface{}:
+		e.head(len(x), 0x90, 15, [3]byte{0, 0xdc, 0xdd})
+
+		for _, item := range x {
+			if err := e.value(item); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("cannot encode value")
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) Encode(r *Record) error {
+	e.buf.Reset()
+
+	keys := r.Keys()
+
+	e.head(len(keys), 0x80, 15, [3]byte{0, 0xde, 0xdf})
+
+	for _, key := range keys {
+		v, _ := r.Get(key)
+
+		val, err := native(v)
+
+		if err != nil {
+			return EncodeError{Field: key, Err: err}
+		}
+
+		e.string(key)
+
+		if err := e.value(val); err != nil {
+			return EncodeError{Field: key, Err: err}
+		}
+	}
+
+	_, err := e.w.Write(e.buf.Bytes())
+	return err
+}
+
+func (e *msgpackEncoder) Close() error { return nil }
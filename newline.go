@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+)
+
+// crReader rewrites the line endings of an input that ends its lines with a
+// lone carriage return, as classic Mac OS did, into newlines, so it can be
+// read by a csv.Reader. A carriage return followed by a newline is kept as a
+// single newline.
+type crReader struct {
+	r  io.Reader
+	cr bool // whether the last byte read was a carriage return
+}
+
+func (r *crReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.r.Read(p)
+
+		j := 0
+
+		for _, c := range p[:n] {
+			if c == '\n' && r.cr {
+				r.cr = false
+				continue
+			}
+
+			r.cr = c == '\r'
+
+			if r.cr {
+				c = '\n'
+			}
+
+			p[j] = c
+			j++
+		}
+
+		// A read of only the newline of a CRLF would otherwise look like the
+		// end of the input to some readers.
+		if j > 0 || n == 0 || err != nil {
+			return j, err
+		}
+	}
+}
+
+// crlines reports whether the lines in the given sample from the start of an
+// input end with a lone carriage return. This is only the case when the first
+// line ending is a carriage return not followed by a newline. If eof is false,
+// then a carriage return at the end of the sample cannot be told apart from
+// the start of a CRLF, so it is not counted.
+func crlines(b []byte, eof bool) bool {
+	i := bytes.IndexAny(b, "\r\n")
+
+	if i < 0 || b[i] == '\n' {
+		return false
+	}
+
+	if i+1 == len(b) {
+		return eof
+	}
+	return b[i+1] != '\n'
+}
+
+// decodecr returns a reader that rewrites the line endings of in if they are
+// lone carriage returns, otherwise in is read as it is.
+func decodecr(in io.Reader) io.Reader {
+	br := bufio.NewReaderSize(in, sniffSize)
+
+	b, err := br.Peek(sniffSize)
+
+	if crlines(b, err != nil) {
+		return &crReader{r: br}
+	}
+	return br
+}
+
+// scanlines is a bufio.SplitFunc like bufio.ScanLines, that also ends lines
+// on a lone carriage return.
+func scanlines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+
+		// The newline of a CRLF may not have been read yet.
+		if i+1 == len(data) && !atEOF {
+			return 0, nil, nil
+		}
+
+		if i+1 < len(data) && data[i+1] == '\n' {
+			return i + 2, data[:i], nil
+		}
+		return i + 1, data[:i], nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// linescanner returns a bufio.Scanner over the lines of r, split by scanlines.
+// Lines have no limit on their length, since a single line can hold anything
+// from a long pattern in a schema, to a document embedded in a column.
+func linescanner(r io.Reader) *bufio.Scanner {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, math.MaxInt)
+	sc.Split(scanlines)
+	return sc
+}
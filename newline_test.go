@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func Test_LineEndings(t *testing.T) {
+	endings := []struct {
+		name string
+		eol  string
+	}{
+		{"lf", "\n"},
+		{"crlf", "\r\n"},
+		{"cr", "\r"},
+	}
+
+	// Each set of arguments is given an input with the preamble, the
+	// header, and the records separated by the line ending, so all of the
+	// readers an input can go through are covered.
+	options := []struct {
+		name string
+		args []string
+	}{
+		{"plain", nil},
+		{"quote", []string{"-quote", "'"}},
+		{"skiplines", []string{"-skip-lines", "1"}},
+		{"prefix", []string{"-header-prefix", "id"}},
+		{"comment", []string{"-comment", "#"}},
+	}
+
+	expected := `{"id":1,"name":"Gordon Freeman"}` + "\n" +
+		`{"id":2,"name":"Alyx\nVance"}` + "\n" +
+		`{"id":3,"name":"Eli Vance"}` + "\n"
+
+	// An input can end with the line ending, without it, or with only the
+	// carriage return of a CRLF, as some exporters cut it short.
+	finals := []struct {
+		name  string
+		final func(eol string) string
+	}{
+		{"", func(eol string) string { return eol }},
+		{"_notrailing", func(string) string { return "" }},
+		{"_trailingcr", func(string) string { return "\r" }},
+	}
+
+	for _, end := range endings {
+		for _, opt := range options {
+			for _, final := range finals {
+				name := end.name + "_" + opt.name + final.name
+
+				lines := []string{"id,name", "1,Gordon Freeman", `2,"Alyx` + end.eol + `Vance"`, "3,Eli Vance"}
+
+				switch opt.name {
+				case "quote":
+					lines[2] = "2,'Alyx" + end.eol + "Vance'"
+				case "skiplines", "prefix":
+					lines = append([]string{"exported by black mesa"}, lines...)
+				case "comment":
+					lines = append(lines[:2:2], "# Eli Vance", lines[2], lines[3])
+				}
+
+				csv := strings.Join(lines, end.eol) + final.final(end.eol)
+
+				t.Run(name, func(t *testing.T) {
+					dir := t.TempDir()
+
+					in := filepath.Join(dir, "users.csv")
+
+					if err := os.WriteFile(in, []byte(csv), os.FileMode(0644)); err != nil {
+						t.Fatal(err)
+					}
+
+					args := append([]string{"csv2json", "-o", dir}, opt.args...)
+
+					if err := run(append(args, in)); err != nil {
+						t.Fatal(err)
+					}
+
+					b, err := os.ReadFile(filepath.Join(dir, "users.json"))
+
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if string(b) != expected {
+						t.Errorf("unexpected output, expected=%q, got=%q\n", expected, string(b))
+					}
+				})
+			}
+		}
+	}
+}
+
+func Test_CRReader(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{"a\rb\r", "a\nb\n"},
+		{"a\r\nb\r\n", "a\nb\n"},
+		{"a\r\rb", "a\n\nb"},
+		{"a\nb", "a\nb"},
+	}
+
+	for i, test := range tests {
+		b, err := io.ReadAll(&crReader{r: iotest.OneByteReader(strings.NewReader(test.in))})
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if string(b) != test.expected {
+			t.Errorf("tests[%d] - unexpected output, expected=%q, got=%q\n", i, test.expected, string(b))
+		}
+	}
+}
+
+func Test_CRLines(t *testing.T) {
+	tests := []struct {
+		in       string
+		eof      bool
+		expected bool
+	}{
+		{"id,name\r1,Gordon\r", true, true},
+		{"id,name\r\n1,Gordon\r\n", true, false},
+		{"id,name\n1,Gordon\n", true, false},
+		{"id,name\r", true, true},
+		{"id,name\r", false, false},
+		{"id,name", true, false},
+	}
+
+	for i, test := range tests {
+		if cr := crlines([]byte(test.in), test.eof); cr != test.expected {
+			t.Errorf("tests[%d] - unexpected result, expected=%v, got=%v\n", i, test.expected, cr)
+		}
+	}
+}
+
+func Test_ScanLines(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []string
+	}{
+		{"a\nb\n", []string{"a", "b"}},
+		{"a\r\nb", []string{"a", "b"}},
+		{"a\rb\r", []string{"a", "b"}},
+		{"a\r\rb", []string{"a", "", "b"}},
+		{"\n\na", []string{"", "", "a"}},
+	}
+
+	for i, test := range tests {
+		sc := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(test.in)))
+		sc.Split(scanlines)
+
+		lines := make([]string, 0)
+
+		for sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+
+		if err := sc.Err(); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if strings.Join(lines, "|") != strings.Join(test.expected, "|") || len(lines) != len(test.expected) {
+			t.Errorf("tests[%d] - unexpected lines, expected=%q, got=%q\n", i, test.expected, lines)
+		}
+	}
+}
+
+func Test_SchemaLineEndings(t *testing.T) {
+	for _, eol := range []string{"\n", "\r\n", "\r"} {
+		fname := filepath.Join(t.TempDir(), "schema")
+
+		schema := strings.Join([]string{"# Column  Type", "id  int", "", "name  string"}, eol)
+
+		if err := os.WriteFile(fname, []byte(schema), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+
+		s := NewSchema()
+
+		if err := s.Load(fname); err != nil {
+			t.Fatalf("%q - %s\n", eol, err)
+		}
+
+		if cols := strings.Join(s.Columns(), ","); cols != "id,name" {
+			t.Errorf("%q - unexpected columns, expected=%q, got=%q\n", eol, "id,name", cols)
+		}
+	}
+}
@@ -0,0 +1,706 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// objectSchemes are the URL schemes of the object stores that inputs can be
+// read from, and outputs written to.
+var objectSchemes = []string{"s3://", "gs://", "az://"}
+
+// isobject checks if the given input is the URL of an object in an object
+// store, in the form of scheme://bucket/key.
+func isobject(fname string) bool {
+	for _, scheme := range objectSchemes {
+		if strings.HasPrefix(fname, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// isremote checks if the given input is read over the network, rather than
+// from disk.
+func isremote(fname string) bool { return isurl(fname) || isobject(fname) }
+
+// splitobject splits the given object URL into its scheme, bucket, and key.
+func splitobject(rawurl string) (string, string, string, error) {
+	i := strings.Index(rawurl, "://")
+
+	scheme := rawurl[:i]
+	rest := rawurl[i+3:]
+
+	bucket, key := rest, ""
+
+	if j := strings.Index(rest, "/"); j >= 0 {
+		bucket, key = rest[:j], rest[j+1:]
+	}
+
+	if bucket == "" || key == "" {
+		return "", "", "", errors.New(rawurl + ": object url must be in the form of " + scheme + "://bucket/key")
+	}
+	return scheme, bucket, key, nil
+}
+
+// ObjectStore is a store of objects, such as S3.
+type ObjectStore interface {
+	// Open opens the given object for reading. The object is streamed as it
+	// is read.
+	Open(bucket, key string) (io.ReadCloser, error)
+
+	// Create creates the given object. The object is uploaded in parts as it
+	// is written, and is only complete once it has been closed.
+	Create(bucket, key string) (io.WriteCloser, error)
+}
+
+// ObjectError is the error returned when an object store responds with an
+// unexpected status.
+type ObjectError struct {
+	Op     string
+	Object string
+	Status int
+	Msg    string
+}
+
+func (e ObjectError) Error() string {
+	s := e.Op + " " + e.Object + ": unexpected status " + strconv.Itoa(e.Status)
+
+	if e.Msg != "" {
+		s += ": " + e.Msg
+	}
+	return s
+}
+
+// objecterror returns an ObjectError for the given response, using the message
+// in its body if it has one.
+func objecterror(op, object string, resp *http.Response) error {
+	var body struct {
+		Message string
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	xml.Unmarshal(b, &body)
+
+	return ObjectError{
+		Op:     op,
+		Object: object,
+		Status: resp.StatusCode,
+		Msg:    body.Message,
+	}
+}
+
+// aborter is implemented by the writers of objects that can be abandoned
+// before they are complete.
+type aborter interface {
+	Abort()
+}
+
+// errAborted is returned when writing to an object that has been aborted.
+var errAborted = errors.New("upload aborted")
+
+// objectPartSize is the size of each part an object is uploaded in. This must
+// be at least 5MB for S3.
+const objectPartSize = 8 * 1024 * 1024
+
+// uriencode escapes the given string as required for signing requests. Every
+// byte other than the unreserved characters is escaped, and slashes are kept
+// if path is true.
+func uriencode(s string, path bool) string {
+	var buf strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && path:
+			buf.WriteByte(c)
+		default:
+			buf.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return buf.String()
+}
+
+// s3Store is an object store that speaks the S3 API. This is also used for
+// Google Cloud Storage, via its S3 compatible XML API.
+type s3Store struct {
+	client   *http.Client
+	endpoint string // if empty then virtual hosted URLs are used
+	region   string
+	access   string
+	secret   string
+	token    string
+	partsize int
+	now      func() time.Time
+}
+
+// newS3Store returns an s3Store configured from the standard AWS environment
+// variables. AWS_ENDPOINT_URL can be set to use an S3 compatible store, in
+// which case path style URLs are used.
+func newS3Store(client *http.Client) *s3Store {
+	region := os.Getenv("AWS_REGION")
+
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &s3Store{
+		client:   client,
+		endpoint: strings.TrimSuffix(os.Getenv("AWS_ENDPOINT_URL"), "/"),
+		region:   region,
+		access:   os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret:   os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:    os.Getenv("AWS_SESSION_TOKEN"),
+		partsize: objectPartSize,
+		now:      time.Now,
+	}
+}
+
+// newGCSStore returns an s3Store for Google Cloud Storage, authenticating with
+// the HMAC key in GCS_ACCESS_KEY_ID and GCS_SECRET_ACCESS_KEY.
+func newGCSStore(client *http.Client) *s3Store {
+	endpoint := os.Getenv("GCS_ENDPOINT_URL")
+
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+
+	return &s3Store{
+		client:   client,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		region:   "auto",
+		access:   os.Getenv("GCS_ACCESS_KEY_ID"),
+		secret:   os.Getenv("GCS_SECRET_ACCESS_KEY"),
+		partsize: objectPartSize,
+		now:      time.Now,
+	}
+}
+
+func (s *s3Store) url(bucket, key string) string {
+	if s.endpoint != "" {
+		return s.endpoint + "/" + bucket + "/" + uriencode(key, true)
+	}
+	return "https://" + bucket + ".s3." + s.region + ".amazonaws.com/" + uriencode(key, true)
+}
+
+// sign signs the given request with AWS Signature Version 4. Every header set
+// on the request is signed, along with the host.
+func (s *s3Store) sign(req *http.Request, payload []byte) {
+	now := s.now().UTC()
+
+	amzdate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	sum := sha256.Sum256(payload)
+	hash := hex.EncodeToString(sum[:])
+
+	req.Header.Set("X-Amz-Date", amzdate)
+	req.Header.Set("X-Amz-Content-Sha256", hash)
+
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+
+	for name, vals := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(vals, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonhdrs strings.Builder
+
+	for _, name := range names {
+		canonhdrs.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signed := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	params := make([]string, 0, len(keys))
+
+	for _, k := range keys {
+		params = append(params, uriencode(k, false)+"="+uriencode(query.Get(k), false))
+	}
+
+	path := req.URL.EscapedPath()
+
+	if path == "" {
+		path = "/"
+	}
+
+	canon := strings.Join([]string{
+		req.Method,
+		path,
+		strings.Join(params, "&"),
+		canonhdrs.String(),
+		signed,
+		hash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+
+	canonsum := sha256.Sum256([]byte(canon))
+
+	tosign := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" + hex.EncodeToString(canonsum[:])
+
+	key := hmacsha256([]byte("AWS4"+s.secret), date)
+	key = hmacsha256(key, s.region)
+	key = hmacsha256(key, "s3")
+	key = hmacsha256(key, "aws4_request")
+
+	sig := hex.EncodeToString(hmacsha256(key, tosign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.access+"/"+scope+", SignedHeaders="+signed+", Signature="+sig)
+}
+
+// do sends a signed request with the given body, returning the response if it
+// has one of the expected statuses.
+func (s *s3Store) do(op, method, rawurl string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawurl, bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.sign(req, body)
+
+	resp, err := s.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, objecterror(op, req.URL.Path, resp)
+	}
+	return resp, nil
+}
+
+func (s *s3Store) Open(bucket, key string) (io.ReadCloser, error) {
+	resp, err := s.do("get", "GET", s.url(bucket, key), nil)
+
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Store) Create(bucket, key string) (io.WriteCloser, error) {
+	return &s3Writer{
+		s:   s,
+		url: s.url(bucket, key),
+	}, nil
+}
+
+// s3Writer uploads an object in parts as it is written. Objects smaller than
+// a single part are uploaded with a single request when closed.
+type s3Writer struct {
+	s      *s3Store
+	url    string
+	buf    bytes.Buffer
+	upload string   // ID of the multipart upload, once started
+	etags  []string // ETags of the uploaded parts
+	err    error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	w.buf.Write(p)
+
+	for w.buf.Len() >= w.s.partsize {
+		if err := w.part(w.buf.Next(w.s.partsize)); err != nil {
+			w.abort(err)
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// part uploads the given part, starting the multipart upload if this is the
+// first.
+func (w *s3Writer) part(b []byte) error {
+	if w.upload == "" {
+		resp, err := w.s.do("create upload", "POST", w.url+"?uploads", nil)
+
+		if err != nil {
+			return err
+		}
+
+		defer resp.Body.Close()
+
+		var res struct {
+			UploadID string `xml:"UploadId"`
+		}
+
+		if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+			return err
+		}
+		w.upload = res.UploadID
+	}
+
+	n := len(w.etags) + 1
+
+	resp, err := w.s.do("upload part", "PUT", w.url+"?partNumber="+strconv.Itoa(n)+"&uploadId="+url.QueryEscape(w.upload), b)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	w.etags = append(w.etags, resp.Header.Get("ETag"))
+	return nil
+}
+
+// abort aborts the multipart upload, so the parts uploaded so far are not
+// kept.
+func (w *s3Writer) abort(err error) {
+	w.err = err
+
+	if w.upload == "" {
+		return
+	}
+
+	if resp, err := w.s.do("abort upload", "DELETE", w.url+"?uploadId="+url.QueryEscape(w.upload), nil); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// Abort aborts the upload, so the object is not created.
+func (w *s3Writer) Abort() {
+	if w.err == nil {
+		w.abort(errAborted)
+	}
+}
+
+func (w *s3Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.upload == "" {
+		resp, err := w.s.do("put", "PUT", w.url, w.buf.Bytes())
+
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if w.buf.Len() > 0 {
+		if err := w.part(w.buf.Bytes()); err != nil {
+			w.abort(err)
+			return err
+		}
+	}
+
+	var body bytes.Buffer
+
+	body.WriteString("<CompleteMultipartUpload>")
+
+	for i, etag := range w.etags {
+		body.WriteString("<Part><PartNumber>" + strconv.Itoa(i+1) + "</PartNumber><ETag>")
+		xml.EscapeText(&body, []byte(etag))
+		body.WriteString("</ETag></Part>")
+	}
+
+	body.WriteString("</CompleteMultipartUpload>")
+
+	resp, err := w.s.do("complete upload", "POST", w.url+"?uploadId="+url.QueryEscape(w.upload), body.Bytes())
+
+	if err != nil {
+		w.abort(err)
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	// A complete can fail after the response has started, in which case the
+	// error is in the body.
+	b, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return err
+	}
+
+	if bytes.Contains(b, []byte("<Error>")) {
+		var res struct {
+			Message string
+		}
+
+		xml.Unmarshal(b, &res)
+
+		err := ObjectError{Op: "complete upload", Object: w.url, Status: resp.StatusCode, Msg: res.Message}
+		w.abort(err)
+		return err
+	}
+	return nil
+}
+
+// azureStore is an object store for Azure Blob Storage, where the bucket is
+// the container. Requests are authenticated with the account's shared key.
+type azureStore struct {
+	client   *http.Client
+	endpoint string
+	account  string
+	key      []byte
+	partsize int
+	now      func() time.Time
+}
+
+// newAzureStore returns an azureStore configured from AZURE_STORAGE_ACCOUNT
+// and AZURE_STORAGE_KEY.
+func newAzureStore(client *http.Client) (*azureStore, error) {
+	account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("AZURE_STORAGE_KEY"))
+
+	if err != nil {
+		return nil, errors.New("invalid AZURE_STORAGE_KEY: " + err.Error())
+	}
+
+	endpoint := os.Getenv("AZURE_STORAGE_ENDPOINT")
+
+	if endpoint == "" {
+		endpoint = "https://" + account + ".blob.core.windows.net"
+	}
+
+	return &azureStore{
+		client:   client,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		account:  account,
+		key:      key,
+		partsize: objectPartSize,
+		now:      time.Now,
+	}, nil
+}
+
+func (s *azureStore) url(container, blob string) string {
+	return s.endpoint + "/" + container + "/" + uriencode(blob, true)
+}
+
+// sign signs the given request with the account's shared key.
+func (s *azureStore) sign(req *http.Request, n int) {
+	req.Header.Set("X-Ms-Date", s.now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", "2020-10-02")
+
+	length := ""
+
+	if n > 0 {
+		length = strconv.Itoa(n)
+	}
+
+	names := make([]string, 0)
+
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			names = append(names, lower)
+		}
+	}
+
+	sort.Strings(names)
+
+	var canon strings.Builder
+
+	for _, name := range names {
+		canon.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	// The account is included in the path for the default endpoint, where it
+	// is part of the host.
+	canon.WriteString("/" + s.account + req.URL.EscapedPath())
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+
+	for k := range query {
+		keys = append(keys, strings.ToLower(k))
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		vals := query[k]
+		sort.Strings(vals)
+
+		canon.WriteString("\n" + k + ":" + strings.Join(vals, ","))
+	}
+
+	tosign := strings.Join([]string{
+		req.Method,
+		"", // Content-Encoding
+		"", // Content-Language
+		length,
+		"", // Content-MD5
+		req.Header.Get("Content-Type"),
+		"", // Date
+		"", // If-Modified-Since
+		"", // If-Match
+		"", // If-None-Match
+		"", // If-Unmodified-Since
+		"", // Range
+		canon.String(),
+	}, "\n")
+
+	sig := base64.StdEncoding.EncodeToString(hmacsha256(s.key, tosign))
+
+	req.Header.Set("Authorization", "SharedKey "+s.account+":"+sig)
+}
+
+func (s *azureStore) do(op, method, rawurl string, hdr map[string]string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawurl, bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+
+	s.sign(req, len(body))
+
+	resp, err := s.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, objecterror(op, req.URL.Path, resp)
+	}
+	return resp, nil
+}
+
+func (s *azureStore) Open(container, blob string) (io.ReadCloser, error) {
+	resp, err := s.do("get", "GET", s.url(container, blob), nil, nil)
+
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *azureStore) Create(container, blob string) (io.WriteCloser, error) {
+	return &azureWriter{
+		s:   s,
+		url: s.url(container, blob),
+	}, nil
+}
+
+// azureWriter uploads a blob in blocks as it is written, and commits the list
+// of blocks when closed.
+type azureWriter struct {
+	s      *azureStore
+	url    string
+	buf    bytes.Buffer
+	blocks []string
+	err    error
+}
+
+func (w *azureWriter) block(b []byte) error {
+	// Block IDs must all be the same length.
+	id := base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(1e9+int64(len(w.blocks)), 10)))
+
+	resp, err := w.s.do("put block", "PUT", w.url+"?comp=block&blockid="+url.QueryEscape(id), nil, b)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	w.blocks = append(w.blocks, id)
+	return nil
+}
+
+func (w *azureWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	w.buf.Write(p)
+
+	for w.buf.Len() >= w.s.partsize {
+		if err := w.block(w.buf.Next(w.s.partsize)); err != nil {
+			w.err = err
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Abort abandons the upload. Blocks that are never committed are removed by
+// Azure, so nothing needs to be sent.
+func (w *azureWriter) Abort() {
+	w.err = errAborted
+}
+
+func (w *azureWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.buf.Len() > 0 || len(w.blocks) == 0 {
+		if err := w.block(w.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	var body bytes.Buffer
+
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+
+	for _, id := range w.blocks {
+		body.WriteString("<Latest>" + id + "</Latest>")
+	}
+
+	body.WriteString("</BlockList>")
+
+	resp, err := w.s.do("put block list", "PUT", w.url+"?comp=blocklist", map[string]string{"Content-Type": "application/xml"}, body.Bytes())
+
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+// openlimit is not supported on this platform, so the number of jobs is not
+// limited by the number of open files.
+func openlimit() int {
+	return 0
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// options are the flags given to convert with. The values parsed from them,
+// such as the delimiter, are set once they are validated.
+type options struct {
+	schema       string
+	delim        string
+	unique       string
+	uniquestate  string
+	dedupekey    string
+	dedupekeep   string
+	sortby       string
+	sortmem      string
+	filter       string
+	skip         int
+	limit        int
+	sample       float64
+	seed         int64
+	meta         string
+	estimate     bool
+	order        string
+	dupheaders   string
+	normhdrs     bool
+	noinfer      bool
+	infer        string
+	enckey       string
+	workers      int
+	encoding     string
+	tmpdir       string
+	tmpmax       string
+	ragged       string
+	overflow     string
+	skiplines    int
+	hdrprefix    string
+	comment      string
+	quote        string
+	escape       string
+	lazyquotes   bool
+	sheet        string
+	fixed        bool
+	outfmt       string
+	yamlseq      bool
+	table        string
+	sqlstyle     string
+	db           string
+	sqlitedb     string
+	httpauth     string
+	httpretries  int
+	dest         string
+	posturl      string
+	splitrows    string
+	splitbytes   string
+	partitionby  string
+	merge        bool
+	lookups      listFlag
+	plugins      listFlag
+	fromjson     bool
+	validatefile string
+	schemadir    string
+	groupby      string
+	aggregates   string
+	batch        int
+	checkpoint   bool
+	cpevery      int
+	noclobber    bool
+	manifest     string
+	skipexist    bool
+	errorsjson   string
+	verbose      bool
+	quiet        bool
+	logformat    string
+	allschema    bool
+	allowempty   bool
+	reportfile   string
+	metricsfile  string
+	presample    int
+	jobs         int
+	maxopen      int
+	maxmem       string
+	premax       float64
+	neweronly    bool
+
+	level      slog.Level
+	d          rune // delimiter, or 0 if it is detected for each input
+	q          rune
+	esc        rune
+	cmt        rune
+	inference  []string
+	tmpsize    int64
+	maxmemsize int64
+	chunkrows  int
+	chunksize  int64
+	metafields []string
+	groupcols  []string
+	groupaggs  []Aggregate
+	sortfield  string
+	sortdesc   bool
+	sortmax    int64
+
+	// With -merge, -o is the output to write to, rather than the
+	// destination of each output.
+	mergedest string
+	mergename string
+}
+
+// flags defines the flags of the options in the given set.
+func (o *options) flags(fs *flag.FlagSet) {
+	fs.StringVar(&o.schema, "s", "", "the schema file to use")
+	fs.StringVar(&o.schemadir, "schema-dir", "", "the directory of schema files for each file, named after the file with a .schema extension, falling back to -s")
+	fs.BoolVar(&o.allschema, "all-schema-errors", false, "report every error in the schema, rather than stopping at the first")
+	fs.BoolVar(&o.fromjson, "from-json-schema", false, "the schema file is a JSON Schema to derive the column types from")
+	fs.StringVar(&o.delim, "d", ",", "the csv delimeter, or auto to detect it for each file")
+	fs.StringVar(&o.unique, "unique", "", "the column that must be unique across all files")
+	fs.StringVar(&o.uniquestate, "unique-state", "", "the file to persist unique values to across runs")
+	fs.StringVar(&o.dedupekey, "dedupe-key", "", "the comma separated columns to drop duplicate records by, or * for the whole row")
+	fs.StringVar(&o.dedupekeep, "dedupe-keep", "first", "the occurrence of each duplicate record to keep, one of first, or last")
+	fs.StringVar(&o.sortby, "sort-by", "", "the field to sort records by, with an optional :asc or :desc suffix")
+	fs.StringVar(&o.sortmem, "sort-memory", "256M", "the memory to use for sorting before spilling to temporary files, with an optional K, M, or G suffix")
+	fs.StringVar(&o.validatefile, "validate", "", "the JSON Schema each record must be valid against to be converted")
+	fs.StringVar(&o.filter, "filter", "", "the expression records must match to be converted")
+	fs.IntVar(&o.skip, "skip", 0, "the number of records to skip in each file")
+	fs.IntVar(&o.limit, "limit", 0, "the maximum number of records to convert in each file")
+	fs.Float64Var(&o.sample, "sample", 0, "the fraction of records to randomly sample, between 0 and 1")
+	fs.Int64Var(&o.seed, "seed", 0, "the seed to use for sampling, defaults to the current time")
+	fs.StringVar(&o.order, "order", "alpha", "the order of fields in the output, one of csv, schema, or alpha")
+	fs.BoolVar(&o.normhdrs, "normalize-headers", false, "match headers to the schema regardless of case, surrounding whitespace, and byte order marks")
+	fs.StringVar(&o.infer, "infer", strings.Join(DefaultInference, ","), "the comma separated types to infer for columns not in the schema, in the order they are tried, from int, float, bool, and time")
+	fs.StringVar(&o.enckey, "encrypt-key", "", "the base64 encoded AES key to encrypt columns with the encrypt option, defaults to $"+EncryptKeyEnv)
+	fs.BoolVar(&o.noinfer, "no-infer", false, "write columns not in the schema as strings, rather than guessing their type")
+	fs.StringVar(&o.dupheaders, "duplicate-headers", "last", "how to handle duplicate headers, one of last, error, suffix, or array")
+	fs.StringVar(&o.encoding, "encoding", "", "the character encoding of the input, one of "+strings.Join(Encodings, ", "))
+	fs.StringVar(&o.tmpdir, "tmpdir", "", "the directory to create temporary files in, defaults to the system temp directory")
+	fs.StringVar(&o.tmpmax, "tmpdir-max", "", "the maximum size of temporary files, with an optional K, M, or G suffix")
+	fs.StringVar(&o.ragged, "ragged", "error", "how to handle records with the wrong number of fields, one of error, pad, or truncate")
+	fs.StringVar(&o.overflow, "ragged-overflow", "", "the field to put the extra fields of long records in")
+	fs.BoolVar(&o.allowempty, "allow-empty", false, "convert empty inputs into empty outputs, rather than failing")
+	fs.IntVar(&o.skiplines, "skip-lines", 0, "the number of lines to skip before the header in each file")
+	fs.StringVar(&o.hdrprefix, "header-prefix", "", "the prefix of the header line, any lines before it are skipped")
+	fs.StringVar(&o.comment, "comment", "", "the character that begins a comment line")
+	fs.StringVar(&o.quote, "quote", "\"", "the character used for quoting fields")
+	fs.StringVar(&o.escape, "escape", "", "the character used for escaping characters within fields")
+	fs.BoolVar(&o.lazyquotes, "lazy-quotes", false, "allow unescaped quotes to appear in fields")
+	fs.StringVar(&o.sheet, "sheet", "", "the sheet to convert from Excel workbooks, defaults to the first sheet")
+	fs.StringVar(&o.dest, "o", ".", "the directory, or s3://, gs://, or az:// prefix to write outputs to")
+	fs.StringVar(&o.httpauth, "http-auth", "", "the user:password to use for basic authentication when requesting urls")
+	fs.IntVar(&o.httpretries, "http-retries", 3, "the number of times to retry failed requests when reading from urls")
+	fs.BoolVar(&o.fixed, "fixed", false, "the input is fixed width, using the column ranges in the schema")
+	fs.StringVar(&o.outfmt, "format", "json", "the format of the output, one of "+strings.Join(formatnames(), ", "))
+	fs.BoolVar(&o.yamlseq, "yaml-sequence", false, "write YAML output as a single sequence, instead of a stream of documents")
+	fs.StringVar(&o.table, "table", "", "the table to insert into for sql output, defaults to the name of the output")
+	fs.StringVar(&o.db, "db", "", "the postgres:// url of the database to copy records into, instead of writing files")
+	fs.StringVar(&o.sqlitedb, "sqlite", "", "the SQLite database to create, with a table for each file, instead of writing files")
+	fs.StringVar(&o.posturl, "post-url", "", "the url to post records to in batches, instead of writing files")
+	fs.IntVar(&o.batch, "batch", 500, "the number of records in each batch posted to -post-url")
+	fs.StringVar(&o.splitrows, "split-rows", "", "the number of rows in each chunk to split outputs into, can have a K, M, or G suffix")
+	fs.StringVar(&o.splitbytes, "split-bytes", "", "the size of each chunk to split outputs into, with an optional K, M, or G suffix")
+	fs.StringVar(&o.groupby, "group-by", "", "the comma separated columns to group records by, writing one record per group")
+	fs.StringVar(&o.aggregates, "agg", "count()", "the aggregates of each group, any of count(), count(col), sum(col), avg(col), min(col), or max(col)")
+	fs.Var(&o.plugins, "plugin", "a Go plugin exporting schema types to load, can be given more than once")
+	fs.Var(&o.lookups, "lookup", "a table for @lookup directives in the form of name=file:column, can be given more than once")
+	fs.BoolVar(&o.merge, "merge", false, "convert all inputs into the single output given via -o, instead of an output for each")
+	fs.StringVar(&o.partitionby, "partition-by", "", "the column to partition outputs by, writing each value to its own directory")
+	fs.BoolVar(&o.noclobber, "no-clobber", false, "refuse to overwrite outputs that already exist")
+	fs.BoolVar(&o.skipexist, "skip-existing", false, "skip inputs whose output already exists")
+	fs.BoolVar(&o.neweronly, "newer-only", false, "skip inputs whose output already exists, and is not older than the input")
+	fs.BoolVar(&o.checkpoint, "checkpoint", false, "save the progress of each file, so an interrupted conversion resumes where it left off")
+	fs.StringVar(&o.errorsjson, "errors-json", "", "the file to write each parse error to as newline delimited JSON, as well as stderr")
+	fs.StringVar(&o.reportfile, "report", "", "the file to write a report of the number of errors by column, and kind of error to")
+	fs.StringVar(&o.metricsfile, "metrics", "", "the file to write metrics of the conversion to, in the prometheus text format")
+	fs.StringVar(&o.manifest, "manifest", "", "the file to write a manifest of the outputs to, with the checksum, record count, and sources of each")
+	fs.IntVar(&o.cpevery, "checkpoint-every", 10000, "the number of records to write between each checkpoint")
+	fs.StringVar(&o.sqlstyle, "sql-style", "insert", "the style of sql output, one of insert, or copy")
+	fs.IntVar(&o.workers, "workers", 1, "the number of workers parsing the records of each file")
+	fs.IntVar(&o.jobs, "jobs", 0, "the number of files to convert at once, defaults to the number of CPUs plus 10")
+	fs.IntVar(&o.maxopen, "max-open", 0, "the number of files that can be open at once, defaults to the limit of the process")
+	fs.StringVar(&o.maxmem, "max-memory", "", "the memory to use for buffering records before spilling to temporary files, with an optional K, M, or G suffix")
+	fs.IntVar(&o.presample, "preflight", 0, "the number of records of each file to parse before converting, failing if too many of them fail")
+	fs.Float64Var(&o.premax, "preflight-max-failures", 0.1, "the fraction of the records parsed by -preflight that can fail, between 0 and 1")
+	fs.BoolVar(&o.estimate, "estimate", false, "estimate the size of the output without converting")
+	fs.StringVar(&o.meta, "meta", "", "the metadata to inject into each record, any of file, line, or ingested_at")
+	fs.BoolVar(&o.verbose, "v", false, "log when each file starts and finishes converting, along with how long it took")
+	fs.BoolVar(&o.quiet, "q", false, "only log errors")
+	fs.StringVar(&o.logformat, "log-format", "text", "the format of logs written to stderr, one of "+strings.Join(LogFormats, ", "))
+}
+
+// sink reports whether records are written to a sink, such as -db, rather
+// than to output files.
+func (o *options) sink() bool {
+	return o.db != "" || o.sqlitedb != "" || o.posturl != ""
+}
+
+// validate checks that the options can be used together, and parses the
+// values given to them.
+func (o *options) validate() error {
+	switch {
+	case o.verbose && o.quiet:
+		return errors.New("-v and -q cannot be used together")
+	case o.verbose:
+		o.level = slog.LevelDebug
+	case o.quiet:
+		o.level = slog.LevelError
+	default:
+		o.level = slog.LevelInfo
+	}
+
+	d, _ := utf8.DecodeRuneInString(o.delim)
+
+	if d == utf8.RuneError {
+		return errors.New("invalid utf-8 character for delimeter, must be a single character\n")
+	}
+
+	// The delimiter of each input is detected as it is parsed.
+	if o.delim == "auto" {
+		d = 0
+	}
+	o.d = d
+
+	if o.uniquestate != "" && o.unique == "" {
+		return errors.New("-unique-state requires -unique")
+	}
+
+	if o.dedupekeep != "first" && o.dedupekeep != "last" {
+		return errors.New("invalid -dedupe-keep " + o.dedupekeep)
+	}
+
+	switch o.order {
+	case "csv", "schema", "alpha":
+	default:
+		return errors.New("invalid -order " + o.order)
+	}
+
+	inference, err := ParseInference(o.infer)
+
+	if err != nil {
+		return errors.New("invalid -infer: " + err.Error())
+	}
+	o.inference = inference
+
+	switch o.dupheaders {
+	case "last", "error", "suffix", "array":
+	default:
+		return errors.New("invalid -duplicate-headers " + o.dupheaders)
+	}
+
+	if o.encoding != "" && encodingname(o.encoding) == "" {
+		return errors.New("invalid -encoding " + o.encoding)
+	}
+
+	if o.tmpmax != "" {
+		o.tmpsize, err = parsesize(o.tmpmax)
+
+		if err != nil {
+			return errors.New("invalid -tmpdir-max " + o.tmpmax)
+		}
+	}
+
+	switch o.ragged {
+	case "error", "pad", "truncate":
+		if o.ragged == "error" && o.overflow != "" {
+			return errors.New("-ragged-overflow requires -ragged pad or truncate")
+		}
+	default:
+		return errors.New("invalid -ragged " + o.ragged)
+	}
+
+	if o.skiplines < 0 {
+		return errors.New("-skip-lines cannot be negative")
+	}
+
+	if o.comment != "" {
+		c, n := utf8.DecodeRuneInString(o.comment)
+
+		if c == utf8.RuneError || n != len(o.comment) {
+			return errors.New("invalid -comment, must be a single character")
+		}
+
+		if c == d || c == '"' || c == '\r' || c == '\n' {
+			return errors.New("invalid -comment, cannot be the delimeter, a quote, or a newline")
+		}
+		o.cmt = c
+	}
+
+	q, n := utf8.DecodeRuneInString(o.quote)
+
+	if n != len(o.quote) || !validquote(q, d) {
+		return errors.New("invalid -quote, must be a single character that is not the delimeter")
+	}
+	o.q = q
+
+	if o.escape != "" {
+		o.esc, n = utf8.DecodeRuneInString(o.escape)
+
+		if n != len(o.escape) || !validquote(o.esc, d) {
+			return errors.New("invalid -escape, must be a single character that is not the delimeter")
+		}
+	}
+
+	if _, ok := formats[o.outfmt]; !ok {
+		return errors.New("invalid -format " + o.outfmt)
+	}
+
+	if o.yamlseq && o.outfmt != "yaml" {
+		return errors.New("-yaml-sequence requires -format yaml")
+	}
+
+	switch o.sqlstyle {
+	case "insert", "copy":
+	default:
+		return errors.New("invalid -sql-style " + o.sqlstyle)
+	}
+
+	if o.db != "" && o.outfmt != "json" {
+		return errors.New("-db cannot be used with -format")
+	}
+
+	if o.sqlitedb != "" && (o.outfmt != "json" || o.db != "" || o.table != "") {
+		return errors.New("-sqlite cannot be used with -format, -db, or -table")
+	}
+
+	if o.outfmt != "sql" && (o.sqlstyle != "insert" || (o.table != "" && o.db == "")) {
+		return errors.New("-table and -sql-style require -format sql, or -db")
+	}
+
+	if o.workers < 1 {
+		return errors.New("-workers must be at least 1")
+	}
+
+	if o.skip < 0 || o.limit < 0 {
+		return errors.New("-skip and -limit cannot be negative")
+	}
+
+	if o.sample < 0 || o.sample > 1 {
+		return errors.New("-sample must be between 0 and 1")
+	}
+
+	if o.premax < 0 || o.premax > 1 {
+		return errors.New("-preflight-max-failures must be between 0 and 1")
+	}
+
+	if o.jobs < 0 {
+		return errors.New("-jobs cannot be negative")
+	}
+
+	if o.maxopen < 0 {
+		return errors.New("-max-open cannot be negative")
+	}
+
+	if o.maxmem != "" {
+		o.maxmemsize, err = parsesize(o.maxmem)
+
+		if err != nil {
+			return errors.New("invalid -max-memory: " + err.Error())
+		}
+	}
+
+	if o.meta != "" {
+		o.metafields = strings.Split(o.meta, ",")
+
+		for _, field := range o.metafields {
+			switch field {
+			case "file", "line", "ingested_at":
+			default:
+				return errors.New("unknown metadata field " + field)
+			}
+		}
+	}
+
+	if o.httpretries < 0 {
+		return errors.New("-http-retries cannot be negative")
+	}
+
+	if o.posturl != "" {
+		if o.outfmt != "json" || o.db != "" || o.sqlitedb != "" || o.table != "" {
+			return errors.New("-post-url cannot be used with -format, -db, -sqlite, or -table")
+		}
+
+		if o.batch < 1 {
+			return errors.New("-batch must be at least 1")
+		}
+	}
+
+	if o.groupby != "" {
+		o.groupcols = strings.Split(o.groupby, ",")
+		o.groupaggs, err = ParseAggregates(o.aggregates)
+
+		if err != nil {
+			return errors.New("invalid -agg: " + err.Error())
+		}
+	}
+
+	if o.merge {
+		if o.dest == "." || o.sink() {
+			return errors.New("-merge requires the output to be given via -o, and cannot be used with -db, -sqlite, or -post-url")
+		}
+
+		if isobject(o.dest) {
+			i := strings.LastIndex(o.dest, "/")
+			o.mergedest, o.mergename = o.dest[:i], o.dest[i+1:]
+		} else {
+			o.mergedest, o.mergename = filepath.Dir(o.dest), filepath.Base(o.dest)
+		}
+	}
+
+	if o.partitionby != "" && o.sink() {
+		return errors.New("-partition-by cannot be used with -db, -sqlite, or -post-url")
+	}
+
+	if o.splitrows != "" || o.splitbytes != "" {
+		if o.sink() {
+			return errors.New("-split-rows and -split-bytes cannot be used with -db, -sqlite, or -post-url")
+		}
+
+		if o.splitrows != "" {
+			o.chunkrows, err = parsecount(o.splitrows)
+
+			if err != nil {
+				return errors.New("invalid -split-rows: " + err.Error())
+			}
+		}
+
+		if o.splitbytes != "" {
+			o.chunksize, err = parsesize(o.splitbytes)
+
+			if err != nil || o.chunksize == 0 {
+				return errors.New("invalid -split-bytes " + o.splitbytes)
+			}
+		}
+	}
+
+	if o.checkpoint {
+		switch {
+		case o.outfmt != "json" || o.sink():
+			return errors.New("-checkpoint is only supported for json output")
+		case isobject(o.dest):
+			return errors.New("-checkpoint cannot be used with object storage")
+		case o.merge, o.partitionby != "", o.chunkrows > 0, o.chunksize > 0, o.groupcols != nil:
+			return errors.New("-checkpoint cannot be used with -merge, -partition-by, -split-rows, -split-bytes, or -group-by")
+		case o.sortby != "", o.dedupekeep == "last":
+			return errors.New("-checkpoint cannot be used with -sort-by, or -dedupe-keep last")
+		}
+
+		if o.cpevery < 1 {
+			return errors.New("invalid -checkpoint-every, must be at least 1")
+		}
+	}
+
+	if o.skipexist || o.neweronly {
+		switch {
+		case o.sink():
+			return errors.New("-skip-existing and -newer-only cannot be used without an output")
+		case isobject(o.dest):
+			return errors.New("-skip-existing and -newer-only cannot be used with object storage")
+		case o.merge, o.partitionby != "", o.chunkrows > 0, o.chunksize > 0:
+			return errors.New("-skip-existing and -newer-only cannot be used with -merge, -partition-by, -split-rows, or -split-bytes")
+		}
+	}
+
+	if o.sortby != "" {
+		o.sortfield, o.sortdesc, err = parsesortby(o.sortby)
+
+		if err != nil {
+			return errors.New("invalid -sort-by: " + err.Error())
+		}
+
+		o.sortmax, err = parsesize(o.sortmem)
+
+		if err != nil {
+			return errors.New("invalid -sort-memory: " + err.Error())
+		}
+	}
+	return nil
+}
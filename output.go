@@ -0,0 +1,335 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// outputSet is the set of outputs written for a single input, of which there
+// can be more than one if the output is split, or partitioned. Outputs are
+// kept open until they are closed, or the set is aborted.
+//
+// Outputs on disk are written to a temporary file alongside them, with a .tmp
+// suffix, which is only renamed once the whole set is closed. This way a
+// failed conversion never leaves a half written output behind.
+type outputSet struct {
+	dest  string
+	fetch *Fetcher
+
+	noclobber bool // refuse to overwrite outputs that already exist
+	keep      bool // keep temporary files if aborted, so they can be resumed
+	hash      bool // hash objects as they are written, for the manifest
+
+	open    []io.WriteCloser
+	paths   []string    // paths of all the outputs created
+	temps   []string    // temporary files of each output, empty for objects
+	records []int       // records encoded into each output
+	sums    []hash.Hash // hashes of each object, nil for files
+	dirs    []string    // directories created for the outputs, in order
+}
+
+func newOutputSet(dest string, fetch *Fetcher) *outputSet {
+	return &outputSet{
+		dest:  dest,
+		fetch: fetch,
+	}
+}
+
+// temppath returns the path of the temporary file the given output is written
+// to.
+func temppath(path string) string {
+	return path + ".tmp"
+}
+
+// clobbered returns an error for each of the given outputs that already
+// exists on disk.
+func clobbered(paths []string) []error {
+	errs := make([]error, 0)
+
+	for _, path := range paths {
+		if isobject(path) {
+			continue
+		}
+
+		if _, err := os.Stat(path); err == nil {
+			errs = append(errs, errors.New(path+": already exists"))
+		}
+	}
+	return errs
+}
+
+// create creates the output with the given name in the set's destination.
+// The name can contain directories, which are created if the destination is
+// on disk.
+func (s *outputSet) create(name string) (io.WriteCloser, error) {
+	path := outputpath(s.dest, name)
+
+	if isobject(path) {
+		w, err := s.fetch.Create(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if s.hash {
+			w = &hashWriter{WriteCloser: w, h: sha256.New()}
+		}
+
+		s.add(w, path, "")
+		return w, nil
+	}
+
+	if s.noclobber {
+		if errs := clobbered([]string{path}); len(errs) > 0 {
+			return nil, errs[0]
+		}
+	}
+
+	if err := s.mkdir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	tmp := temppath(path)
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644))
+
+	// The directory can be shared with the partitions of another input,
+	// which could have removed it when it was aborted.
+	if errors.Is(err, os.ErrNotExist) {
+		if err := s.mkdir(filepath.Dir(path)); err != nil {
+			return nil, err
+		}
+		f, err = os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.add(f, path, tmp)
+	return f, nil
+}
+
+// reopen reopens the temporary file of the output with the given name in the
+// set's destination, to resume writing to it. The file is truncated to the
+// given size, so anything written after the checkpoint it is resumed from is
+// discarded.
+func (s *outputSet) reopen(name string, size int64) (io.WriteCloser, error) {
+	path := outputpath(s.dest, name)
+	tmp := temppath(path)
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY, os.FileMode(0644))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	s.add(f, path, tmp)
+	return f, nil
+}
+
+// mkdir creates the given directory, and any of its parents, keeping track of
+// those within the destination that did not already exist, so they can be
+// removed if the set is aborted. The destination itself is never removed,
+// since it can be shared with other inputs.
+func (s *outputSet) mkdir(dir string) error {
+	missing := make([]string, 0)
+
+	dest := filepath.Clean(s.dest)
+
+	for d := dir; d != dest; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+
+		missing = append(missing, d)
+
+		if parent := filepath.Dir(d); parent == d {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return err
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		s.dirs = append(s.dirs, missing[i])
+	}
+	return nil
+}
+
+func (s *outputSet) add(w io.WriteCloser, path, tmp string) {
+	s.open = append(s.open, w)
+	s.paths = append(s.paths, path)
+	s.temps = append(s.temps, tmp)
+	s.records = append(s.records, 0)
+
+	var h hash.Hash
+
+	if hw, ok := w.(*hashWriter); ok {
+		h = hw.h
+	}
+	s.sums = append(s.sums, h)
+}
+
+// close closes the given output, which is expected to be open.
+func (s *outputSet) close(w io.WriteCloser) error {
+	for i, open := range s.open {
+		if open == w {
+			s.open = append(s.open[:i], s.open[i+1:]...)
+			break
+		}
+	}
+	return w.Close()
+}
+
+// chunks returns a function that creates numbered chunks of the output with
+// the given name and extension, for example users.0001.json. Each call closes
+// the previous chunk.
+func (s *outputSet) chunks(name, ext string) func() (io.Writer, error) {
+	var (
+		prev io.WriteCloser
+		n    int
+	)
+
+	return func() (io.Writer, error) {
+		if prev != nil {
+			if err := s.close(prev); err != nil {
+				return nil, err
+			}
+		}
+
+		n++
+
+		w, err := s.create(fmt.Sprintf("%s.%04d%s", name, n, ext))
+
+		if err != nil {
+			return nil, err
+		}
+
+		prev = w
+		return w, nil
+	}
+}
+
+// commit renames the temporary file of the given output to the output. If the
+// set does not clobber outputs, then the file is linked instead, which fails
+// if the output was created in the meantime.
+func (s *outputSet) commit(path, tmp string) error {
+	if !s.noclobber {
+		return os.Rename(tmp, path)
+	}
+
+	if err := os.Link(tmp, path); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return errors.New(path + ": already exists")
+		}
+		return err
+	}
+	return os.Remove(tmp)
+}
+
+// Close closes all of the open outputs, and renames their temporary files,
+// returning the first error.
+func (s *outputSet) Close() error {
+	var err error
+
+	for _, w := range s.open {
+		if cerr := w.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	s.open = nil
+
+	if err != nil {
+		return err
+	}
+
+	for i, tmp := range s.temps {
+		if tmp == "" {
+			continue
+		}
+
+		if err := s.commit(s.paths[i], tmp); err != nil {
+			return err
+		}
+		s.temps[i] = ""
+	}
+	return nil
+}
+
+// abandon closes all of the open outputs without completing them. Objects are
+// aborted, so they are not created, and files are closed as they are.
+func (s *outputSet) abandon() {
+	for _, w := range s.open {
+		if a, ok := w.(aborter); ok {
+			a.Abort()
+			continue
+		}
+		w.Close()
+	}
+	s.open = nil
+}
+
+// Partial closes all of the open outputs after an interrupted conversion. The
+// outputs written to disk are renamed with a .partial suffix, so they are not
+// mistaken for complete outputs. Objects are aborted, so only those already
+// complete are left.
+func (s *outputSet) Partial() error {
+	s.abandon()
+
+	for i, tmp := range s.temps {
+		if tmp == "" {
+			continue
+		}
+
+		path := s.paths[i] + ".partial"
+
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+
+		s.paths[i] = path
+		s.temps[i] = ""
+	}
+	return nil
+}
+
+// Abort abandons all of the open outputs, and removes their temporary files,
+// unless they are being kept to be resumed. Outputs already completed via
+// Close are left as they are. Any directories created for the outputs are
+// removed if they are left empty.
+func (s *outputSet) Abort() {
+	s.abandon()
+
+	for i, tmp := range s.temps {
+		if tmp != "" && !s.keep {
+			os.Remove(tmp)
+		}
+		s.temps[i] = ""
+	}
+
+	if !s.keep {
+		for i := len(s.dirs) - 1; i >= 0; i-- {
+			os.Remove(s.dirs[i])
+		}
+	}
+	s.dirs = nil
+}
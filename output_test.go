@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_OutputSetClose(t *testing.T) {
+	dir := t.TempDir()
+
+	outs := newOutputSet(dir, nil)
+
+	w, err := outs.create("users.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.WriteString(w, "{}\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "users.json")
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected output to not exist before close, got=%v\n", err)
+	}
+
+	if err := outs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Aborting after a successful close should leave the outputs as they are.
+	outs.Abort()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(temppath(path)); !os.IsNotExist(err) {
+		t.Fatalf("expected temporary file to be renamed, got=%v\n", err)
+	}
+}
+
+func Test_OutputSetAbort(t *testing.T) {
+	dir := t.TempDir()
+
+	outs := newOutputSet(dir, nil)
+
+	next := outs.chunks("users", ".json")
+
+	for i := 0; i < 2; i++ {
+		if _, err := next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outs.Abort()
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+
+	if len(names) != 0 {
+		t.Fatalf("expected no outputs after abort, got=%v\n", names)
+	}
+}
+
+func Test_OutputSetNoClobber(t *testing.T) {
+	dir := t.TempDir()
+
+	outs := newOutputSet(dir, nil)
+	outs.noclobber = true
+
+	if _, err := outs.create("users.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "users.json")
+
+	// Create the output while it is being written, as if by another process.
+	if err := os.WriteFile(path, []byte("{}\n"), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := outs.Close(); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected error for existing output, got=%v\n", err)
+	}
+
+	if _, err := outs.create("users.json"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected error for existing output, got=%v\n", err)
+	}
+}
+
+func Test_NoClobber(t *testing.T) {
+	dir := t.TempDir()
+
+	args := []string{"csv2json", "-o", dir, "-no-clobber", filepath.Join("testdata", "visits.csv")}
+
+	if err := run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run(args); err == nil || !strings.Contains(err.Error(), "preflight") {
+		t.Fatalf("expected preflight error for existing output, got=%v\n", err)
+	}
+
+	if n := countLines(t, filepath.Join(dir, "visits.json")); n != 5 {
+		t.Fatalf("unexpected number of rows, expected=%d, got=%d\n", 5, n)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// partitionDefault is the partition of records with no value for the column
+// being partitioned on, as used by Hive.
+const partitionDefault = "__HIVE_DEFAULT_PARTITION__"
+
+// partitionvalue returns the given value as it would appear in the name of a
+// partition. Characters that are not safe in a path are escaped as %XX.
+func partitionvalue(v Value) string {
+	var s string
+
+	switch x := v.(type) {
+	case nil, Null:
+		return partitionDefault
+	case *String:
+		s = x.s
+	default:
+		b, err := v.MarshalJSON()
+
+		if err != nil {
+			return partitionDefault
+		}
+		s = strings.Trim(string(b), `"`)
+	}
+
+	if s == "" {
+		return partitionDefault
+	}
+
+	var buf strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == ' ':
+			buf.WriteByte(c)
+		default:
+			buf.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return buf.String()
+}
+
+// partitionEncoder routes records to an Encoder for each value of a column.
+type partitionEncoder struct {
+	col    string
+	part   func(value string) (io.Writer, EncoderFunc, error)
+	name   string
+	fields []Field
+
+	max   int // most partitions that can be created, 0 for no limit
+	encs  map[string]Encoder
+	order []string // values in the order their partitions were created
+}
+
+// NewPartitionEncoder returns an EncoderFunc that partitions records by the
+// value of the given column. The part function is called the first time a
+// value is seen, and returns the writer and EncoderFunc for the partition of
+// that value. The value is already escaped for use in a path. Each partition
+// is kept open until the encoder is closed, so if max is set, then a value
+// that would create more than max partitions is an error, rather than running
+// out of open files part way through.
+func NewPartitionEncoder(col string, max int, part func(value string) (io.Writer, EncoderFunc, error)) EncoderFunc {
+	return func(_ io.Writer, name string, fields []Field) (Encoder, error) {
+		found := false
+
+		for _, f := range fields {
+			if f.Name == col {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, errors.New("cannot partition by unknown column " + col)
+		}
+
+		return &partitionEncoder{
+			col:    col,
+			part:   part,
+			name:   name,
+			fields: fields,
+			max:    max,
+			encs:   make(map[string]Encoder),
+		}, nil
+	}
+}
+
+func (e *partitionEncoder) Encode(r *Record) error {
+	v, _ := r.Get(e.col)
+
+	value := partitionvalue(v)
+
+	enc, ok := e.encs[value]
+
+	if !ok {
+		if e.max > 0 && len(e.order) >= e.max {
+			return errors.New("cannot partition by " + e.col + " into more than " + strconv.Itoa(e.max) + " partitions, the limit of open files, see -max-open")
+		}
+
+		w, newenc, err := e.part(value)
+
+		if err != nil {
+			return err
+		}
+
+		enc, err = newenc(w, e.name, e.fields)
+
+		if err != nil {
+			return err
+		}
+
+		e.encs[value] = enc
+		e.order = append(e.order, value)
+	}
+	return enc.Encode(r)
+}
+
+func (e *partitionEncoder) Close() error {
+	for _, value := range e.order {
+		if err := e.encs[value].Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
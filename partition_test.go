@@ -0,0 +1,126 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_PartitionBy(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected map[string]int
+	}{
+		{
+			[]string{"-partition-by", "store"},
+			map[string]int{
+				"store=a/sales.json": 4,
+				"store=b/sales.json": 2,
+			},
+		},
+		{
+			[]string{"-partition-by", "day", "-split-rows", "1"},
+			map[string]int{
+				"day=2021-12-01T00%3A00%3A00Z/sales.0001.json": 1,
+				"day=2021-12-01T00%3A00%3A00Z/sales.0002.json": 1,
+				"day=2021-12-02T00%3A00%3A00Z/sales.0001.json": 1,
+				"day=2021-12-03T00%3A00%3A00Z/sales.0001.json": 1,
+				"day=2021-12-05T00%3A00%3A00Z/sales.0001.json": 1,
+				"day=2021-12-09T00%3A00%3A00Z/sales.0001.json": 1,
+			},
+		},
+	}
+
+	for i, test := range tests {
+		dir := t.TempDir()
+
+		args := append([]string{"csv2json", "-o", dir, "-s", filepath.Join("testdata", "sales.schema")}, test.args...)
+
+		if err := run(append(args, filepath.Join("testdata", "sales.csv"))); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		names, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(names) != len(test.expected) {
+			t.Fatalf("tests[%d] - unexpected outputs, expected=%d, got=%v\n", i, len(test.expected), names)
+		}
+
+		for name, expected := range test.expected {
+			if n := countLines(t, filepath.Join(dir, name)); n != expected {
+				t.Errorf("tests[%d] - unexpected number of rows in %s, expected=%d, got=%d\n", i, name, expected, n)
+			}
+		}
+	}
+}
+
+func Test_PartitionValue(t *testing.T) {
+	tests := []struct {
+		val      Value
+		expected string
+	}{
+		{&String{s: "US"}, "US"},
+		{&String{s: "a/b"}, "a%2Fb"},
+		{&String{s: ""}, partitionDefault},
+		{Null{}, partitionDefault},
+		{nil, partitionDefault},
+		{&Int{n: 10}, "10"},
+		{Bool{b: true}, "true"},
+	}
+
+	for i, test := range tests {
+		if s := partitionvalue(test.val); s != test.expected {
+			t.Errorf("tests[%d] - unexpected value, expected=%q, got=%q\n", i, test.expected, s)
+		}
+	}
+}
+
+func Test_PartitionUnknownColumn(t *testing.T) {
+	dir := t.TempDir()
+
+	args := []string{
+		"csv2json",
+		"-o", dir,
+		"-partition-by", "country",
+		filepath.Join("testdata", "sales.csv"),
+	}
+
+	if err := run(args); err == nil || !strings.Contains(err.Error(), "encountered errors") {
+		t.Fatalf("expected error for unknown column, got=%v\n", err)
+	}
+}
+
+func Test_PartitionOpenLimit(t *testing.T) {
+	dir := t.TempDir()
+
+	// Only two partitions can be open within 20 files, so partitioning by
+	// store works, but not by day.
+	args := []string{"csv2json", "-o", dir, "-max-open", "20", "-s", filepath.Join("testdata", "sales.schema")}
+
+	if err := run(append(args, "-partition-by", "store", filepath.Join("testdata", "sales.csv"))); err != nil {
+		t.Fatal(err)
+	}
+
+	dir = t.TempDir()
+	args[2] = dir
+
+	err := run(append(args, "-partition-by", "day", filepath.Join("testdata", "sales.csv")))
+
+	if err == nil {
+		t.Fatal("expected error for too many partitions")
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 0 {
+		t.Errorf("expected directories of failed partitions to be removed, got=%v\n", names)
+	}
+}
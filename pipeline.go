@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultPipelines is the file pipelines are loaded from when none is given.
+const defaultPipelines = "csv2json.pipelines"
+
+// Pipeline is a named set of arguments to convert with, so a conversion that
+// is run often can be run by name, rather than being spelled out each time.
+type Pipeline struct {
+	Name string
+	Args []string
+}
+
+// PipelineError is the error of a pipeline that failed to run.
+type PipelineError struct {
+	Name string
+	Err  error
+}
+
+func (e PipelineError) Error() string { return e.Name + ": " + e.Err.Error() }
+
+func (e PipelineError) Unwrap() error { return e.Err }
+
+// LoadPipelines loads the pipelines from the given file. Each pipeline starts
+// on an unindented line with its name, followed by the arguments to convert
+// with, as they would be given on the command line. Any indented line that
+// follows continues the arguments of the pipeline, so long pipelines can be
+// split across lines. Arguments can be quoted, and can refer to environment
+// variables as ${NAME}, as in a schema file. Blank lines, and lines starting
+// with #, are ignored.
+func LoadPipelines(fname string) ([]Pipeline, error) {
+	f, err := os.Open(fname)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	pipelines := make([]Pipeline, 0)
+	names := make(map[string]int)
+
+	sc := linescanner(f)
+
+	for nline := 1; sc.Scan(); nline++ {
+		line := sc.Text()
+		trimmed := strings.TrimLeft(line, " \t")
+
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+
+		lineerr := func(err error) error {
+			return errors.New(fname + ":" + strconv.Itoa(nline) + " - " + err.Error())
+		}
+
+		parts, err := splitspace([]byte(trimmed))
+
+		if err != nil {
+			return nil, lineerr(err)
+		}
+
+		for i, part := range parts {
+			val, err := expandenv(part)
+
+			if err != nil {
+				return nil, lineerr(err)
+			}
+			parts[i] = val
+		}
+
+		if len(trimmed) < len(line) {
+			if len(pipelines) == 0 {
+				return nil, lineerr(errors.New("arguments given before any pipeline"))
+			}
+
+			p := &pipelines[len(pipelines)-1]
+			p.Args = append(p.Args, parts...)
+			continue
+		}
+
+		name := parts[0]
+
+		if strings.HasPrefix(name, "-") {
+			return nil, lineerr(errors.New("invalid pipeline name " + name))
+		}
+
+		if prev, ok := names[name]; ok {
+			return nil, lineerr(errors.New("pipeline " + name + " already defined on line " + strconv.Itoa(prev)))
+		}
+
+		names[name] = nline
+		pipelines = append(pipelines, Pipeline{Name: name, Args: parts[1:]})
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return pipelines, nil
+}
+
+// expandglobs expands each of the arguments that is a glob pattern into the
+// files it matches, in the way a shell would, since the arguments of a
+// pipeline are not given to a shell. Flags are left as they are, along with
+// any pattern that matches nothing, so the value of a flag, such as a filter,
+// is not mistaken for a pattern.
+func expandglobs(args []string) ([]string, error) {
+	expanded := make([]string, 0, len(args))
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") || !strings.ContainsAny(arg, "*?[") {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		matches, err := filepath.Glob(arg)
+
+		if err != nil {
+			return nil, errors.New("invalid pattern " + arg + ": " + err.Error())
+		}
+
+		if len(matches) == 0 {
+			expanded = append(expanded, arg)
+			continue
+		}
+		expanded = append(expanded, matches...)
+	}
+	return expanded, nil
+}
+
+// runPipelines runs each of the named pipelines in the order given, stopping
+// at the first that fails.
+func runPipelines(argv0 string, args []string) error {
+	var (
+		fname string
+		list  bool
+	)
+
+	fs := flag.NewFlagSet(argv0+" run", flag.ExitOnError)
+	fs.StringVar(&fname, "f", defaultPipelines, "the file to load the pipelines from")
+	fs.BoolVar(&list, "l", false, "list the pipelines in the file")
+
+	args = parseflags(fs, args)
+
+	if len(args) < 1 && !list {
+		return usageError(argv0 + " run [-f file] [-l] <pipeline,...>")
+	}
+
+	pipelines, err := LoadPipelines(fname)
+
+	if err != nil {
+		return err
+	}
+
+	if list {
+		for _, p := range pipelines {
+			fmt.Println(p.Name, strings.Join(p.Args, " "))
+		}
+		return nil
+	}
+
+	byname := make(map[string]Pipeline, len(pipelines))
+
+	for _, p := range pipelines {
+		byname[p.Name] = p
+	}
+
+	selected := make([]Pipeline, 0, len(args))
+
+	for _, name := range args {
+		p, ok := byname[name]
+
+		if !ok {
+			return errors.New("no such pipeline " + name + " in " + fname)
+		}
+
+		if len(p.Args) > 0 && p.Args[0] == "run" {
+			return PipelineError{Name: name, Err: errors.New("cannot run other pipelines")}
+		}
+		selected = append(selected, p)
+	}
+
+	for _, p := range selected {
+		pargs, err := expandglobs(p.Args)
+
+		if err != nil {
+			return PipelineError{Name: p.Name, Err: err}
+		}
+
+		if err := run(append([]string{argv0}, pargs...)); err != nil {
+			return PipelineError{Name: p.Name, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_LoadPipelines(t *testing.T) {
+	t.Setenv("CSV2JSON_OUT", "out")
+
+	tests := []struct {
+		src      string
+		expected []Pipeline
+		errmsg   string
+	}{
+		{
+			"# Pipelines\nusers -s users.schema users.csv\n\naccounts -merge\n    -o ${CSV2JSON_OUT}/accounts.json\n\t# Inputs\n\taccounts/*.csv\n",
+			[]Pipeline{
+				{Name: "users", Args: []string{"-s", "users.schema", "users.csv"}},
+				{Name: "accounts", Args: []string{"-merge", "-o", "out/accounts.json", "accounts/*.csv"}},
+			},
+			"",
+		},
+		{
+			"events -filter \"amount > 10\" -o ${CSV2JSON_DIR:-.} events.csv\n",
+			[]Pipeline{
+				{Name: "events", Args: []string{"-filter", "amount > 10", "-o", ".", "events.csv"}},
+			},
+			"",
+		},
+		{"  -o out users.csv\n", nil, ":1 - arguments given before any pipeline"},
+		{"-o out users.csv\n", nil, ":1 - invalid pipeline name -o"},
+		{"users users.csv\nusers users2.csv\n", nil, ":2 - pipeline users already defined on line 1"},
+		{"users -o ${CSV2JSON_UNSET} users.csv\n", nil, ":1 - environment variable CSV2JSON_UNSET is not set"},
+	}
+
+	for i, test := range tests {
+		fname := filepath.Join(t.TempDir(), defaultPipelines)
+
+		if err := os.WriteFile(fname, []byte(test.src), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+
+		pipelines, err := LoadPipelines(fname)
+
+		if test.errmsg != "" {
+			if err == nil || !strings.HasSuffix(err.Error(), test.errmsg) {
+				t.Errorf("tests[%d] - unexpected error, expected=%q, got=%v\n", i, test.errmsg, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if len(pipelines) != len(test.expected) {
+			t.Fatalf("tests[%d] - unexpected pipelines, expected=%v, got=%v\n", i, test.expected, pipelines)
+		}
+
+		for j, p := range pipelines {
+			expected := test.expected[j]
+
+			if p.Name != expected.Name || strings.Join(p.Args, "|") != strings.Join(expected.Args, "|") {
+				t.Errorf("tests[%d] - unexpected pipeline, expected=%q, got=%q\n", i, expected, p)
+			}
+		}
+	}
+}
+
+func Test_ExpandGlobs(t *testing.T) {
+	args := []string{"-filter", "amount * 2 > 10", "-o", "out", filepath.Join("testdata", "accounts*.csv"), filepath.Join("testdata", "none*.csv")}
+
+	expected := []string{
+		"-filter", "amount * 2 > 10", "-o", "out",
+		filepath.Join("testdata", "accounts1.csv"),
+		filepath.Join("testdata", "accounts2.csv"),
+		filepath.Join("testdata", "none*.csv"),
+	}
+
+	expanded, err := expandglobs(args)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(expanded, "|") != strings.Join(expected, "|") {
+		t.Errorf("unexpected args, expected=%q, got=%q\n", expected, expanded)
+	}
+}
+
+func Test_RunPipelines(t *testing.T) {
+	dir := t.TempDir()
+
+	fname := filepath.Join(dir, defaultPipelines)
+
+	src := "accounts -s " + filepath.Join("testdata", "accounts.schema") + "\n" +
+		"    -o " + dir + "\n" +
+		"    " + filepath.Join("testdata", "accounts*.csv") + "\n" +
+		"broken -o " + dir + " " + filepath.Join("testdata", "none.csv") + "\n" +
+		"loop run accounts\n"
+
+	if err := os.WriteFile(fname, []byte(src), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runPipelines("csv2json", []string{"-f", fname, "accounts"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"accounts1.json", "accounts2.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be written, %s\n", name, err)
+		}
+	}
+
+	tests := []struct {
+		args   []string
+		errmsg string
+	}{
+		{[]string{"-f", fname, "nightly"}, "no such pipeline nightly in " + fname},
+		{[]string{"-f", fname, "broken"}, "broken: "},
+		{[]string{"-f", fname, "loop"}, "loop: cannot run other pipelines"},
+	}
+
+	for i, test := range tests {
+		err := runPipelines("csv2json", test.args)
+
+		if err == nil || !strings.HasPrefix(err.Error(), test.errmsg) {
+			t.Errorf("tests[%d] - unexpected error, expected=%q, got=%v\n", i, test.errmsg, err)
+		}
+	}
+
+	var perr PipelineError
+
+	if err := runPipelines("csv2json", []string{"-f", fname, "broken"}); !errors.As(err, &perr) || perr.Name != "broken" {
+		t.Errorf("expected PipelineError for broken, got=%v\n", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"plugin"
+	"regexp"
+)
+
+// pluginValue is a Value returned by a plugin. Plugins cannot refer to the
+// types of this program, so values are returned as plain Go values, which are
+// marshalled via encoding/json.
+type pluginValue struct {
+	v interface{}
+}
+
+func (v pluginValue) Format(_ string) {}
+
+func (v pluginValue) MarshalJSON() ([]byte, error) { return json.Marshal(v.v) }
+
+// pluginUnmarshal returns an UnmarshalFunc for the schema type with the given
+// name, that unmarshals values via the given PluginFunc.
+func pluginUnmarshal(name string, fn func(s string) (interface{}, error)) UnmarshalFunc {
+	return func(s string) (Value, error) {
+		v, err := fn(s)
+
+		if err != nil {
+			return nil, UnmarshalError{
+				Type: name,
+				Err:  err,
+			}
+		}
+
+		if v == nil {
+			return Null{}, nil
+		}
+		return pluginValue{v: v}, nil
+	}
+}
+
+// LoadPlugin opens the Go plugin at the given path, and registers the schema
+// types it exports. A plugin exports its types via either, or both, of the
+// following variables,
+//
+//     var Types = map[string]func(s string) (interface{}, error){...}
+//     var TypeFuncs = map[string]func(pat string) (func(s string) (interface{}, error), error){...}
+//
+// Types are for schema types that take no pattern, and TypeFuncs are for
+// those that do.
+func LoadPlugin(path string) error {
+	p, err := plugin.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	n := 0
+
+	if sym, err := p.Lookup("Types"); err == nil {
+		types, ok := sym.(*map[string]func(s string) (interface{}, error))
+
+		if !ok {
+			return errors.New(path + ": Types is not a map[string]func(string) (interface{}, error)")
+		}
+
+		for name, fn := range *types {
+			if err := RegisterType(name, pluginUnmarshal(name, fn)); err != nil {
+				return errors.New(path + ": " + err.Error())
+			}
+			n++
+		}
+	}
+
+	if sym, err := p.Lookup("TypeFuncs"); err == nil {
+		typefuncs, ok := sym.(*map[string]func(pat string) (func(s string) (interface{}, error), error))
+
+		if !ok {
+			return errors.New(path + ": TypeFuncs is not a map[string]func(string) (func(string) (interface{}, error), error)")
+		}
+
+		for name, fn := range *typefuncs {
+			if err := RegisterTypeFunc(name, pluginTypeFunc(name, fn)); err != nil {
+				return errors.New(path + ": " + err.Error())
+			}
+			n++
+		}
+	}
+
+	if n == 0 {
+		return errors.New(path + ": plugin exports no Types or TypeFuncs")
+	}
+	return nil
+}
+
+// pluginTypeFunc returns a TypeFunc for the schema type with the given name,
+// that gets the function to unmarshal values with for each pattern from the
+// given function from a plugin.
+func pluginTypeFunc(name string, fn func(pat string) (func(s string) (interface{}, error), error)) TypeFunc {
+	return func(pat string, _ map[string]*regexp.Regexp) (UnmarshalFunc, error) {
+		unmarshal, err := fn(pat)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if unmarshal == nil {
+			return nil, errors.New("no function for schema type " + name + " with pattern " + pat)
+		}
+		return pluginUnmarshal(name, unmarshal), nil
+	}
+}
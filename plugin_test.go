@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func Test_PluginUnmarshal(t *testing.T) {
+	fn := pluginUnmarshal("employee_id", func(s string) (interface{}, error) {
+		if !strings.HasPrefix(s, "E-") {
+			return nil, errors.New("invalid employee id " + s)
+		}
+
+		if s == "E-" {
+			return nil, nil
+		}
+		return map[string]interface{}{"id": strings.TrimPrefix(s, "E-")}, nil
+	})
+
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{"E-1234", `{"id":"1234"}`},
+		{"E-", "null"},
+	}
+
+	for i, test := range tests {
+		v, err := fn(test.in)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		b, err := v.MarshalJSON()
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if string(b) != test.expected {
+			t.Errorf("tests[%d] - unexpected json, expected=%q, got=%q\n", i, test.expected, string(b))
+		}
+	}
+
+	_, err := fn("1234")
+
+	var uerr UnmarshalError
+
+	if !errors.As(err, &uerr) {
+		t.Fatalf("unexpected error, expected=UnmarshalError, got=%v\n", err)
+	}
+
+	if uerr.Type != "employee_id" {
+		t.Errorf("unexpected type, expected=%q, got=%q\n", "employee_id", uerr.Type)
+	}
+}
+
+func Test_PluginTypeFunc(t *testing.T) {
+	fn := pluginTypeFunc("prefixed", func(pat string) (func(s string) (interface{}, error), error) {
+		if pat == "_" {
+			return nil, errors.New("prefixed requires a prefix")
+		}
+
+		return func(s string) (interface{}, error) {
+			return strings.TrimPrefix(s, pat), nil
+		}, nil
+	})
+
+	retab := make(map[string]*regexp.Regexp)
+
+	if _, err := fn("_", retab); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	unmarshal, err := fn("ID-", retab)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := unmarshal("ID-42")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := v.MarshalJSON()
+
+	if string(b) != `"42"` {
+		t.Errorf("unexpected json, expected=%q, got=%q\n", `"42"`, string(b))
+	}
+}
+
+func Test_LoadPlugin(t *testing.T) {
+	if err := LoadPlugin(filepath.Join(t.TempDir(), "missing.so")); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pgServer is a fake PostgreSQL server that accepts a single connection, and
+// records the queries and copied rows sent to it.
+type pgServer struct {
+	t  *testing.T
+	ln net.Listener
+
+	user, pass string
+
+	queries []string
+	rows    bytes.Buffer
+	done    chan struct{}
+}
+
+func newPgServer(t *testing.T, user, pass string) *pgServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &pgServer{
+		t:    t,
+		ln:   ln,
+		user: user,
+		pass: pass,
+		done: make(chan struct{}),
+	}
+
+	go s.serve()
+	return s
+}
+
+func (s *pgServer) url() string {
+	return "postgres://" + s.user + ":" + s.pass + "@" + s.ln.Addr().String() + "/test"
+}
+
+func (s *pgServer) send(w *bufio.Writer, typ byte, body []byte) {
+	w.WriteByte(typ)
+	binary.Write(w, binary.BigEndian, int32(len(body)+4))
+	w.Write(body)
+	w.Flush()
+}
+
+func (s *pgServer) ready(w *bufio.Writer) {
+	s.send(w, 'Z', []byte{'I'})
+}
+
+func (s *pgServer) serve() {
+	defer close(s.done)
+
+	conn, err := s.ln.Accept()
+
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+
+	readstartup := func() []byte {
+		var n int32
+
+		if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+			return nil
+		}
+
+		b := make([]byte, n-4)
+		io.ReadFull(br, b)
+		return b
+	}
+
+	startup := readstartup()
+
+	// Refuse the SSLRequest, and read the real startup message.
+	if binary.BigEndian.Uint32(startup) == 80877103 {
+		conn.Write([]byte{'N'})
+		startup = readstartup()
+	}
+
+	if !bytes.Contains(startup, []byte("user\x00"+s.user+"\x00")) {
+		s.send(bw, 'E', []byte("SFATAL\x00C28000\x00Munknown user\x00\x00"))
+		return
+	}
+
+	salt := []byte{1, 2, 3, 4}
+
+	s.send(bw, 'R', append([]byte{0, 0, 0, 5}, salt...))
+
+	for {
+		typ, err := br.ReadByte()
+
+		if err != nil {
+			return
+		}
+
+		var n int32
+		binary.Read(br, binary.BigEndian, &n)
+
+		body := make([]byte, n-4)
+		io.ReadFull(br, body)
+
+		switch typ {
+		case 'p':
+			if string(bytes.TrimRight(body, "\x00")) != pgmd5(s.user, s.pass, salt) {
+				s.send(bw, 'E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00"))
+				return
+			}
+			s.send(bw, 'R', []byte{0, 0, 0, 0})
+			s.ready(bw)
+		case 'Q':
+			query := string(bytes.TrimRight(body, "\x00"))
+			s.queries = append(s.queries, query)
+
+			if strings.HasPrefix(query, "COPY") {
+				s.send(bw, 'G', []byte{0, 0, 0})
+				continue
+			}
+			s.send(bw, 'C', []byte("CREATE TABLE\x00"))
+			s.ready(bw)
+		case 'd':
+			s.rows.Write(body)
+		case 'c':
+			s.send(bw, 'C', []byte("COPY\x00"))
+			s.ready(bw)
+		case 'X':
+			return
+		}
+	}
+}
+
+func Test_PostgresSink(t *testing.T) {
+	s := newPgServer(t, "csv2json", "secret")
+
+	defer s.ln.Close()
+
+	args := []string{
+		"csv2json",
+		"-db", s.url(),
+		"-table", "sales",
+		"-s", filepath.Join("testdata", "sales.schema"),
+		filepath.Join("testdata", "sales.csv"),
+	}
+
+	if err := run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	<-s.done
+
+	if len(s.queries) != 2 {
+		t.Fatalf("unexpected number of queries, expected=%d, got=%d\n", 2, len(s.queries))
+	}
+
+	if !strings.HasPrefix(s.queries[0], `CREATE TABLE IF NOT EXISTS "sales" (`) {
+		t.Errorf("unexpected create query, got=%s\n", s.queries[0])
+	}
+
+	for _, col := range []string{`"amount" bigint`, `"day" timestamptz`, `"store" text`, `"week_count" bigint`, `"avg_2" double precision`} {
+		if !strings.Contains(s.queries[0], col) {
+			t.Errorf("expected create query to contain %q, got=%s\n", col, s.queries[0])
+		}
+	}
+
+	if !strings.HasPrefix(s.queries[1], `COPY "sales" (`) || !strings.HasSuffix(s.queries[1], ") FROM STDIN") {
+		t.Errorf("unexpected copy query, got=%s\n", s.queries[1])
+	}
+
+	lines := strings.Split(strings.TrimSuffix(s.rows.String(), "\n"), "\n")
+
+	if len(lines) != 6 {
+		t.Fatalf("unexpected number of rows, expected=%d, got=%d\n", 6, len(lines))
+	}
+
+	if !strings.Contains(lines[0], "2021-12-01T00:00:00Z") {
+		t.Errorf("unexpected first row, got=%q\n", lines[0])
+	}
+}
+
+func Test_PostgresKeywords(t *testing.T) {
+	s := newPgServer(t, "csv2json", "secret")
+
+	defer s.ln.Close()
+
+	kw := filepath.Join(t.TempDir(), "kw.csv")
+
+	if err := os.WriteFile(kw, []byte("user,order,group\nme,1,admin\n"), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"csv2json", "-db", s.url(), "-order", "csv", kw}); err != nil {
+		t.Fatal(err)
+	}
+
+	<-s.done
+
+	if len(s.queries) != 2 {
+		t.Fatalf("unexpected number of queries, expected=%d, got=%d\n", 2, len(s.queries))
+	}
+
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS "kw" ("user" text, "order" text, "group" text)`,
+		`COPY "kw" ("user", "order", "group") FROM STDIN`,
+	}
+
+	for i, expected := range queries {
+		if s.queries[i] != expected {
+			t.Errorf("queries[%d] - unexpected query, expected=%s, got=%s\n", i, expected, s.queries[i])
+		}
+	}
+}
+
+func Test_PostgresAuthFailure(t *testing.T) {
+	s := newPgServer(t, "csv2json", "secret")
+
+	defer s.ln.Close()
+
+	dsn := strings.Replace(s.url(), ":secret@", ":wrong@", 1)
+
+	_, err := dialPostgres(dsn)
+
+	pgerr, ok := err.(PostgresError)
+
+	if !ok {
+		t.Fatalf("expected PostgresError, got=%v\n", err)
+	}
+
+	if pgerr.Code != "28P01" {
+		t.Fatalf("unexpected error code, expected=%q, got=%q\n", "28P01", pgerr.Code)
+	}
+}
+
+func Test_Scram(t *testing.T) {
+	// The example exchange from RFC 7677, with the nonce fixed.
+	s := &pgscram{
+		pass:  "pencil",
+		nonce: "rOprNGfwEbeRWgbNEkqO",
+		bare:  "n=user,r=rOprNGfwEbeRWgbNEkqO",
+	}
+
+	final, err := s.final("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+
+	if final != expected {
+		t.Fatalf("unexpected client final message\n\texpected=%q\n\tgot=     %q\n", expected, final)
+	}
+
+	if !s.verify("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=") {
+		t.Fatal("expected server signature to verify")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// outputname returns the name of the file the given CSV file is converted to,
+// with the given extension.
+func outputname(fname, ext string) string {
+	outname := filepath.Base(fname)
+
+	if isremote(fname) {
+		outname = urlbase(fname)
+	}
+
+	for _, inext := range []string{".csv", ".xlsx"} {
+		if strings.HasSuffix(outname, inext) {
+			outname = outname[:len(outname)-len(inext)]
+			break
+		}
+	}
+	return outname + ext
+}
+
+// outputpath returns the path of the given output in the given destination,
+// which is either a directory, or the URL of a prefix in an object store.
+func outputpath(dest, name string) string {
+	if isobject(dest) {
+		return strings.TrimSuffix(dest, "/") + "/" + name
+	}
+	return filepath.Join(dest, name)
+}
+
+// createoutput creates the output at the given path, which is uploaded with
+// the given Fetcher if it is an object.
+func createoutput(path string, fetch *Fetcher) (io.WriteCloser, error) {
+	if isobject(path) {
+		return fetch.Create(path)
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644))
+}
+
+// preflight checks that each of the given CSV files can be read, and that each
+// of their outputs, with the given extension, can be written to the given
+// destination before any conversion starts. If the extension is empty then
+// nothing is written to disk, so only the inputs are checked. Objects are only
+// written once they are converted, so only the names of their outputs are
+// checked. All of the problems found are returned, so they can be reported at
+// once.
+func preflight(args []string, dest, ext string) []error {
+	errs := make([]error, 0)
+
+	var insize int64
+
+	outputs := make(map[string]string)
+
+	for _, fname := range args {
+		// URLs are only requested once they are converted, so there is
+		// nothing to check about the input.
+		if !isremote(fname) {
+			info, err := os.Stat(fname)
+
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			if info.IsDir() {
+				errs = append(errs, errors.New(fname+": is a directory"))
+				continue
+			}
+
+			f, err := os.Open(fname)
+
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			f.Close()
+
+			insize += info.Size()
+		}
+
+		if ext == "" {
+			continue
+		}
+
+		outname := outputpath(dest, outputname(fname, ext))
+
+		if prev, ok := outputs[outname]; ok {
+			errs = append(errs, errors.New(fname+": output "+outname+" would overwrite the output of "+prev))
+			continue
+		}
+		outputs[outname] = fname
+
+		if isobject(dest) {
+			continue
+		}
+
+		info, err := os.Stat(outname)
+
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		if info.IsDir() {
+			errs = append(errs, errors.New(outname+": output is a directory"))
+			continue
+		}
+
+		// Open without truncating, so the existing output is left intact if
+		// another check fails.
+		out, err := os.OpenFile(outname, os.O_WRONLY, 0)
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		out.Close()
+	}
+
+	if ext == "" || isobject(dest) {
+		return errs
+	}
+
+	tmp, err := os.CreateTemp(dest, ".csv2json-")
+
+	if err != nil {
+		errs = append(errs, errors.New("output directory is not writable: "+err.Error()))
+		return errs
+	}
+
+	tmp.Close()
+	os.Remove(tmp.Name())
+
+	// The JSON output is almost always larger than the CSV input, so the size
+	// of the input is used as the least amount of space needed.
+	if free, err := diskfree(dest); err == nil && free < insize {
+		errs = append(errs, errors.New("not enough disk space for output, need at least "+formatsize(insize)+", have "+formatsize(free)))
+	}
+	return errs
+}
+
+// Sample is the result of parsing a sample of the records of an input before
+// it is converted.
+type Sample struct {
+	Records int    // records parsed
+	Failed  int    // records that failed to parse
+	First   string // first error reported, along with its position
+}
+
+// Rate returns the fraction of the records in the sample that failed.
+func (s Sample) Rate() float64 {
+	if s.Records == 0 {
+		return 0
+	}
+	return float64(s.Failed) / float64(s.Records)
+}
+
+// samplefile parses the first n records of the given file against the schema,
+// without writing them anywhere, so a schema that does not fit the file can be
+// caught before the conversion starts.
+func samplefile(fname string, delim rune, schema *Schema, sheet string, fetch *Fetcher, opts []ParserOption, n int) (Sample, error) {
+	f, err := openinput(fname, sheet, delim, fetch)
+
+	if err != nil {
+		return Sample{}, err
+	}
+
+	defer f.Close()
+
+	var smp Sample
+
+	errh := func(line, col int, msg string) {
+		if smp.First == "" {
+			smp.First = strconv.Itoa(line) + ":" + strconv.Itoa(col) + " - " + msg
+		}
+	}
+
+	p, err := NewParser(f, delim, schema, errh, inputopts(fname, opts)...)
+
+	if err != nil {
+		return Sample{}, err
+	}
+
+	for smp.Records < n {
+		rw, err := p.read()
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return Sample{}, err
+		}
+
+		smp.Records++
+
+		r, cols, err := p.json(rw)
+
+		if _, err := p.accept(result{rw: rw, r: r, cols: cols, err: err}); err != nil {
+			return Sample{}, err
+		}
+	}
+
+	smp.Failed = p.errc
+	return smp, nil
+}
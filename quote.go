@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// quoteReader rewrites CSV that uses a different quote character, or escape
+// character, into CSV that can be read by a csv.Reader. Each record is read in
+// full, and then written back out with the standard quoting.
+type quoteReader struct {
+	r *bufio.Reader
+
+	quote   rune
+	escape  rune // 0 if there is no escape character
+	comma   rune
+	comment rune // 0 if there is no comment character
+	lazy    bool
+
+	buf  bytes.Buffer // rewritten records yet to be read
+	line int
+	err  error
+}
+
+func newQuoteReader(r io.Reader, quote, escape, comma, comment rune, lazy bool) *quoteReader {
+	return &quoteReader{
+		r:       bufio.NewReader(r),
+		quote:   quote,
+		escape:  escape,
+		comma:   comma,
+		comment: comment,
+		lazy:    lazy,
+		line:    1,
+	}
+}
+
+func (q *quoteReader) Read(p []byte) (int, error) {
+	for q.buf.Len() == 0 {
+		if q.err != nil {
+			return 0, q.err
+		}
+		q.err = q.record()
+	}
+	return q.buf.Read(p)
+}
+
+func (q *quoteReader) parseErr(start, col int, err error) error {
+	return &csv.ParseError{
+		StartLine: start,
+		Line:      q.line,
+		Column:    col,
+		Err:       err,
+	}
+}
+
+// next reads the next rune, keeping track of the line.
+func (q *quoteReader) next() (rune, error) {
+	r, _, err := q.r.ReadRune()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if r == '\n' {
+		q.line++
+	}
+	return r, nil
+}
+
+// peek returns the next rune without reading it.
+func (q *quoteReader) peek() (rune, error) {
+	r, _, err := q.r.ReadRune()
+
+	if err != nil {
+		return 0, err
+	}
+
+	q.r.UnreadRune()
+	return r, nil
+}
+
+// record reads in the next record, and writes it to the buffer.
+func (q *quoteReader) record() error {
+	if q.comment != 0 {
+		if r, err := q.peek(); err == nil && r == q.comment {
+			line, err := q.r.ReadString('\n')
+
+			q.buf.WriteString(line)
+
+			if err != nil {
+				return err
+			}
+
+			q.line++
+			return nil
+		}
+	}
+
+	start := q.line
+
+	for i := 0; ; i++ {
+		val, end, err := q.field(start)
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+
+			// Only write out the final field if the input did not end with a
+			// newline.
+			if i > 0 || val != "" {
+				q.writeField(val, i == 0)
+				q.buf.WriteByte('\n')
+			}
+			return io.EOF
+		}
+
+		q.writeField(val, i == 0)
+
+		if end == '\n' {
+			q.buf.WriteByte('\n')
+			return nil
+		}
+		q.buf.WriteRune(q.comma)
+	}
+}
+
+// writeField writes the given field to the buffer, quoting it if needed.
+func (q *quoteReader) writeField(val string, first bool) {
+	quoted := strings.ContainsAny(val, "\"\r\n") || strings.ContainsRune(val, q.comma)
+
+	// A first field beginning with the comment character would otherwise be
+	// treated as a comment.
+	if first && q.comment != 0 && strings.HasPrefix(val, string(q.comment)) {
+		quoted = true
+	}
+
+	if !quoted {
+		q.buf.WriteString(val)
+		return
+	}
+
+	q.buf.WriteByte('"')
+	q.buf.WriteString(strings.ReplaceAll(val, `"`, `""`))
+	q.buf.WriteByte('"')
+}
+
+// field reads in the next field of the record, returning the field and the
+// character that ended it, either the comma or a newline.
+func (q *quoteReader) field(start int) (string, rune, error) {
+	var buf strings.Builder
+
+	r, err := q.peek()
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	col := 1
+
+	if r == q.quote {
+		q.next()
+
+		for {
+			r, err := q.next()
+
+			if err != nil {
+				if errors.Is(err, io.EOF) && !q.lazy {
+					return "", 0, q.parseErr(start, col, csv.ErrQuote)
+				}
+				return buf.String(), 0, err
+			}
+
+			col++
+
+			if q.escape != 0 && q.escape != q.quote && r == q.escape {
+				r, err = q.next()
+
+				if err != nil {
+					return "", 0, q.parseErr(start, col, csv.ErrQuote)
+				}
+				buf.WriteRune(r)
+				continue
+			}
+
+			if r != q.quote {
+				buf.WriteRune(r)
+				continue
+			}
+
+			next, err := q.peek()
+
+			if err != nil {
+				// The quoted field ended at the end of the input.
+				return buf.String(), 0, err
+			}
+
+			if next == q.quote {
+				q.next()
+				buf.WriteRune(q.quote)
+				continue
+			}
+
+			if next == q.comma || next == '\n' || next == '\r' {
+				return buf.String(), q.end(), nil
+			}
+
+			if !q.lazy {
+				return "", 0, q.parseErr(start, col, csv.ErrQuote)
+			}
+			buf.WriteRune(r)
+		}
+	}
+
+	for {
+		r, err := q.next()
+
+		if err != nil {
+			// The final line may still end with the carriage return of a
+			// CRLF, even without the newline.
+			return strings.TrimSuffix(buf.String(), "\r"), 0, err
+		}
+
+		col++
+
+		switch {
+		case r == q.comma:
+			return buf.String(), q.comma, nil
+		case r == '\n':
+			return strings.TrimSuffix(buf.String(), "\r"), '\n', nil
+		case q.escape != 0 && r == q.escape:
+			r, err = q.next()
+
+			if err != nil {
+				return buf.String(), 0, err
+			}
+			buf.WriteRune(r)
+		case r == q.quote && !q.lazy:
+			return "", 0, q.parseErr(start, col, csv.ErrBareQuote)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+// end reads the comma or newline at the end of a quoted field, returning what
+// ended it.
+func (q *quoteReader) end() rune {
+	r, _ := q.next()
+
+	if r == '\r' {
+		if next, err := q.peek(); err == nil && next == '\n' {
+			r, _ = q.next()
+		}
+	}
+
+	if r == '\r' {
+		return '\n'
+	}
+	return r
+}
+
+// validquote checks that the given character can be used for quoting or
+// escaping, alongside the given delimeter.
+func validquote(r, delim rune) bool {
+	return r != utf8.RuneError && r != delim && r != '\r' && r != '\n'
+}
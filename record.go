@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// Record is a single record parsed from a CSV file. The fields of a Record are
+// kept in the order they were set.
+type Record struct {
+	keys []string
+	vals map[string]Value
+}
+
+func NewRecord() *Record {
+	return &Record{
+		keys: make([]string, 0),
+		vals: make(map[string]Value),
+	}
+}
+
+// Set sets the field to the given Value. If the field is new, then it is added
+// after all of the existing fields.
+func (r *Record) Set(key string, v Value) {
+	if _, ok := r.vals[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.vals[key] = v
+}
+
+func (r *Record) Get(key string) (Value, bool) {
+	v, ok := r.vals[key]
+	return v, ok
+}
+
+func (r *Record) Delete(key string) {
+	if _, ok := r.vals[key]; !ok {
+		return
+	}
+
+	delete(r.vals, key)
+
+	for i, k := range r.keys {
+		if k == key {
+			r.keys = append(r.keys[:i], r.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Reset removes all of the fields from the Record, so it can be reused.
+func (r *Record) Reset() {
+	r.keys = r.keys[:0]
+	clear(r.vals)
+}
+
+// Keys returns the fields of the Record in order.
+func (r *Record) Keys() []string { return r.keys }
+
+func (r *Record) Len() int { return len(r.keys) }
+
+// Sort sorts the fields of the Record with the given less function.
+func (r *Record) Sort(less func(a, b string) bool) {
+	sort.SliceStable(r.keys, func(i, j int) bool {
+		return less(r.keys[i], r.keys[j])
+	})
+}
+
+// MarshalJSON marshals the Record into a JSON object, with the fields in the
+// order of the Record.
+func (r *Record) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := r.encode(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encode writes the Record as a JSON object to the given buffer.
+func (r *Record) encode(buf *bytes.Buffer) error {
+	buf.WriteByte('{')
+
+	for i, key := range r.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		if err := encodekey(buf, key); err != nil {
+			return err
+		}
+
+		buf.WriteByte(':')
+
+		v := r.vals[key]
+
+		if v == nil {
+			buf.WriteString("null")
+			continue
+		}
+
+		b, err := v.MarshalJSON()
+
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	}
+
+	buf.WriteByte('}')
+	return nil
+}
+
+// encodekey writes the given key as a JSON string to the buffer. Keys are
+// almost always plain ASCII, so these are written as they are, and only keys
+// that need escaping are marshalled.
+func encodekey(buf *bytes.Buffer, key string) error {
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; c < 0x20 || c >= 0x7f || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			b, err := json.Marshal(key)
+
+			if err != nil {
+				return err
+			}
+
+			buf.Write(b)
+			return nil
+		}
+	}
+
+	buf.WriteByte('"')
+	buf.WriteString(key)
+	buf.WriteByte('"')
+	return nil
+}
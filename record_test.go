@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func Test_RecordMarshal(t *testing.T) {
+	r := NewRecord()
+	r.Set("id", &Int{n: 1})
+	r.Set(`"quoted"`, &String{s: "a"})
+	r.Set("<tag>", Bool{b: true})
+	r.Set("naïve", nil)
+
+	b, err := r.MarshalJSON()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"id":1,"\"quoted\"":"a","\u003ctag\u003e":true,"naïve":null}`
+
+	if string(b) != expected {
+		t.Fatalf("unexpected json\n\texpected=%s\n\tgot=%s\n", expected, b)
+	}
+
+	r.Reset()
+
+	if r.Len() != 0 {
+		t.Fatalf("expected empty record after reset, got=%d fields\n", r.Len())
+	}
+
+	r.Set("id", &Int{n: 2})
+
+	if b, _ := r.MarshalJSON(); string(b) != `{"id":2}` {
+		t.Fatalf("unexpected json after reset, got=%s\n", b)
+	}
+}
+
+func Test_ParseRepeatedValues(t *testing.T) {
+	in := strings.NewReader("id,status\n1,active\n1,active\n2,\n2,closed\n")
+
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	p, err := NewParser(in, ',', NewSchema(), errh)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := p.Parse(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`{"id":1,"status":"active"}`,
+		`{"id":1,"status":"active"}`,
+		`{"id":2}`,
+		`{"id":2,"status":"closed"}`,
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if len(lines) != len(expected) {
+		t.Fatalf("unexpected number of records, expected=%d, got=%d\n", len(expected), len(lines))
+	}
+
+	for i, line := range lines {
+		if line != expected[i] {
+			t.Errorf("records[%d] - unexpected record, expected=%s, got=%s\n", i, expected[i], line)
+		}
+
+		var rec map[string]interface{}
+
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Errorf("records[%d] - %s\n", i, err)
+		}
+	}
+}
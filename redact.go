@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// hashes are the hash functions that can be given to the hash option, by
+// name.
+var hashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// valuestring returns the given Value as a string, as it would be written. If
+// the Value is null then false is returned.
+func valuestring(v Value) (string, bool, error) {
+	val, err := exprvalue(v)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	if val == nil {
+		return "", false, nil
+	}
+	return exprstring(val), true, nil
+}
+
+// TransformMask returns a TransformFunc that replaces all but the last n
+// characters of a Value with *. The masked Value is always a string.
+func TransformMask(n int) TransformFunc {
+	return func(v Value) (Value, error) {
+		s, ok, err := valuestring(v)
+
+		if err != nil || !ok {
+			return v, err
+		}
+
+		keep := utf8.RuneCountInString(s) - n
+
+		var buf strings.Builder
+
+		for i := range s {
+			if keep > 0 {
+				buf.WriteByte('*')
+				keep--
+				continue
+			}
+			buf.WriteString(s[i:])
+			break
+		}
+		return &String{s: buf.String()}, nil
+	}
+}
+
+// TransformHash returns a TransformFunc that replaces a Value with the hex
+// encoded hash of the salt followed by the Value.
+func TransformHash(newhash func() hash.Hash, salt string) TransformFunc {
+	return func(v Value) (Value, error) {
+		s, ok, err := valuestring(v)
+
+		if err != nil || !ok {
+			return v, err
+		}
+
+		h := newhash()
+		h.Write([]byte(salt + s))
+
+		return &String{s: hex.EncodeToString(h.Sum(nil))}, nil
+	}
+}
+
+// parsemask parses the value of a mask option, the number of characters to
+// leave unmasked at the end of the value. An empty value masks everything.
+func parsemask(s string) (TransformFunc, error) {
+	if s == "" {
+		return TransformMask(0), nil
+	}
+
+	n, err := strconv.Atoi(s)
+
+	if err != nil || n < 0 {
+		return nil, errors.New("invalid mask " + s + ", expected the number of characters to keep")
+	}
+	return TransformMask(n), nil
+}
+
+// parsehash parses the value of a hash option, in the form of algorithm[:salt].
+func parsehash(s string) (TransformFunc, error) {
+	name, salt, _ := strings.Cut(s, ":")
+
+	newhash, ok := hashes[name]
+
+	if !ok {
+		return nil, errors.New("invalid hash " + name + ", expected one of sha256, or sha512")
+	}
+	return TransformHash(newhash, salt), nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TypeFunc returns the UnmarshalFunc for a schema type with the given pattern.
+// A pattern of _ means no pattern was given. Any regular expressions that are
+// compiled should be stored in retab, so they can be reused by subsequent
+// schema records.
+type TypeFunc func(pat string, retab map[string]*regexp.Regexp) (UnmarshalFunc, error)
+
+// registry is the set of types that can be used in a schema, by name.
+var registry = struct {
+	mu    sync.RWMutex
+	types map[string]TypeFunc
+}{
+	types: make(map[string]TypeFunc),
+}
+
+// registerType adds the given type to the registry, replacing any type with
+// the same name.
+func registerType(name string, fn TypeFunc) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.types[name] = fn
+}
+
+// RegisterType adds a schema type with the given name that unmarshals values
+// via fn, and takes no pattern. Registering a type with the same name as an
+// existing type replaces it, including the built in types.
+func RegisterType(name string, fn UnmarshalFunc) error {
+	if fn == nil {
+		return errors.New("no function for schema type " + name)
+	}
+	return RegisterTypeFunc(name, typeOf(fn))
+}
+
+// RegisterTypeFunc adds a schema type with the given name, which returns the
+// UnmarshalFunc for the pattern given in the schema via fn. This is like
+// RegisterType, but for types whose values depend on their pattern.
+func RegisterTypeFunc(name string, fn TypeFunc) error {
+	if name == "" || name == "=" || strings.ContainsAny(name, " \t") {
+		return errors.New("invalid schema type name " + strconv.Quote(name))
+	}
+
+	if fn == nil {
+		return errors.New("no function for schema type " + name)
+	}
+
+	registerType(name, fn)
+	return nil
+}
+
+// typenames returns the names of all types in the registry, in alphabetical
+// order.
+func typenames() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	names := make([]string, 0, len(registry.types))
+
+	for name := range registry.types {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// unmarshaler returns the UnmarshalFunc for the given schema type and pattern
+// from the registry.
+func unmarshaler(typ, pat string, retab map[string]*regexp.Regexp) (UnmarshalFunc, error) {
+	registry.mu.RLock()
+	fn, ok := registry.types[typ]
+	registry.mu.RUnlock()
+
+	if !ok {
+		return nil, errors.New("unknown schema type " + typ + ", expected one of " + strings.Join(typenames(), ", "))
+	}
+	return fn(pat, retab)
+}
+
+func typeString(pat string, retab map[string]*regexp.Regexp) (UnmarshalFunc, error) {
+	var re *regexp.Regexp
+
+	if pat != "_" {
+		var ok bool
+
+		re, ok = retab[pat]
+
+		if !ok {
+			var err error
+
+			re, err = regexp.Compile(pat)
+
+			if err != nil {
+				return nil, err
+			}
+			retab[pat] = re
+		}
+	}
+	return UnmarshalString(re), nil
+}
+
+func typeInt(pat string, _ map[string]*regexp.Regexp) (UnmarshalFunc, error) {
+	base := 10
+
+	if pat != "_" {
+		n, err := parsebase(pat)
+
+		if err != nil {
+			return nil, err
+		}
+		base = n
+	}
+	return UnmarshalInt(base), nil
+}
+
+func typeTime(pat string, _ map[string]*regexp.Regexp) (UnmarshalFunc, error) {
+	if pat == "_" {
+		pat = time.RFC3339
+	}
+	return UnmarshalTime(pat), nil
+}
+
+func typeUUID(pat string, _ map[string]*regexp.Regexp) (UnmarshalFunc, error) {
+	version := 0
+
+	if pat != "_" {
+		n, err := strconv.ParseInt(pat, 10, 64)
+
+		if err != nil || n < 1 || n > 8 {
+			return nil, errors.New("invalid uuid version " + pat)
+		}
+		version = int(n)
+	}
+	return UnmarshalUUID(version), nil
+}
+
+// ipversion parses the given pattern as an IP version, either 4 or 6.
+func ipversion(pat string) (int, error) {
+	if pat == "_" {
+		return 0, nil
+	}
+
+	if pat != "4" && pat != "6" {
+		return 0, errors.New("invalid ip version " + pat)
+	}
+	return int(pat[0] - '0'), nil
+}
+
+func typeIP(pat string, _ map[string]*regexp.Regexp) (UnmarshalFunc, error) {
+	version, err := ipversion(pat)
+
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalIP(version), nil
+}
+
+func typeCIDR(pat string, _ map[string]*regexp.Regexp) (UnmarshalFunc, error) {
+	version, err := ipversion(pat)
+
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalCIDR(version), nil
+}
+
+func typeURL(pat string, _ map[string]*regexp.Regexp) (UnmarshalFunc, error) {
+	if pat == "_" {
+		return UnmarshalURL(), nil
+	}
+	return UnmarshalURL(strings.Split(pat, ",")...), nil
+}
+
+// typeRaw returns the UnmarshalFunc for raw values. Raw values are written
+// exactly as they are read, so there is no pattern to match them against.
+func typeRaw(pat string, _ map[string]*regexp.Regexp) (UnmarshalFunc, error) {
+	if pat != "_" {
+		return nil, errors.New("raw type takes no pattern")
+	}
+	return UnmarshalString(nil), nil
+}
+
+// typeOf returns a TypeFunc for a type that takes no pattern.
+func typeOf(fn UnmarshalFunc) TypeFunc {
+	return func(_ string, _ map[string]*regexp.Regexp) (UnmarshalFunc, error) {
+		return fn, nil
+	}
+}
+
+func init() {
+	registerType("string", typeString)
+	registerType("raw", typeRaw)
+	registerType("bool", typeOf(UnmarshalBool))
+	registerType("int", typeInt)
+	registerType("float", typeOf(UnmarshalFloat))
+	registerType("time", typeTime)
+	registerType("uuid", typeUUID)
+	registerType("ip", typeIP)
+	registerType("cidr", typeCIDR)
+	registerType("url", typeURL)
+	registerType("email", typeOf(UnmarshalEmail))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func Test_Registry(t *testing.T) {
+	expected := []string{"bool", "cidr", "email", "float", "int", "ip", "raw", "string", "time", "url", "uuid"}
+
+	if names := typenames(); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected types, expected=%v, got=%v\n", expected, names)
+	}
+
+	retab := make(map[string]*regexp.Regexp)
+
+	for _, name := range expected {
+		if _, err := unmarshaler(name, "_", retab); err != nil {
+			t.Fatalf("%s - %s\n", name, err)
+		}
+	}
+
+	_, err := unmarshaler("money", "_", retab)
+
+	if err == nil {
+		t.Fatal("expected error for unknown type")
+	}
+
+	if !strings.Contains(err.Error(), strings.Join(expected, ", ")) {
+		t.Fatalf("expected error to list the known types, got=%q\n", err)
+	}
+}
+
+func Test_RegisterType(t *testing.T) {
+	defer func() {
+		registry.mu.Lock()
+		delete(registry.types, "upper")
+		delete(registry.types, "prefix")
+		registry.mu.Unlock()
+	}()
+
+	upper := func(s string) (Value, error) {
+		return &String{s: strings.ToUpper(s)}, nil
+	}
+
+	if err := RegisterType("upper", upper); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := func(pat string, _ map[string]*regexp.Regexp) (UnmarshalFunc, error) {
+		return func(s string) (Value, error) {
+			if !strings.HasPrefix(s, pat) {
+				return nil, errors.New("expected prefix " + pat)
+			}
+			return &String{s: s}, nil
+		}, nil
+	}
+
+	if err := RegisterTypeFunc("prefix", prefix); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"", "=", "two words"} {
+		if err := RegisterType(name, upper); err == nil {
+			t.Errorf("%q - expected error, got nil\n", name)
+		}
+	}
+
+	if err := RegisterType("none", nil); err == nil {
+		t.Error("expected error for nil function, got nil")
+	}
+
+	dir := t.TempDir()
+	schema := filepath.Join(dir, "schema")
+
+	if err := os.WriteFile(schema, []byte("name upper\ncode prefix ID-\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSchema()
+
+	if err := s.Load(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make([]string, 0)
+
+	errh := func(line, col int, msg string) {
+		errs = append(errs, msg)
+	}
+
+	in := strings.NewReader("name,code\ngordon,ID-1\nalyx,2\n")
+
+	p, err := NewParser(in, ',', s, errh)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := p.Parse(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"code":"ID-1","name":"GORDON"}` + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("unexpected output, expected=%q, got=%q\n", expected, buf.String())
+	}
+
+	if len(errs) != 1 {
+		t.Errorf("unexpected errors, expected=%d, got=%v\n", 1, errs)
+	}
+}
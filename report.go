@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Report is the number of errors reported across a conversion, by the kind of
+// error, the column they were for, and the file they were in. This is used to
+// find the columns of a schema, or the data, that need fixing the most. It is
+// safe for concurrent use.
+type Report struct {
+	mu      sync.Mutex
+	Errors  int                          `json:"errors"`
+	Kinds   map[ErrorKind]int            `json:"kinds"`
+	Columns map[string]map[ErrorKind]int `json:"columns"`
+	Files   map[string]int               `json:"files"`
+}
+
+func NewReport() *Report {
+	return &Report{
+		Kinds:   make(map[ErrorKind]int),
+		Columns: make(map[string]map[ErrorKind]int),
+		Files:   make(map[string]int),
+	}
+}
+
+// Add counts the given error in the report. Errors not for a single column
+// are only counted by their kind and file.
+func (r *Report) Add(e ErrorRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Errors++
+	r.Kinds[e.Kind]++
+	r.Files[e.File]++
+
+	if e.Column == "" {
+		return
+	}
+
+	kinds, ok := r.Columns[e.Column]
+
+	if !ok {
+		kinds = make(map[ErrorKind]int)
+		r.Columns[e.Column] = kinds
+	}
+	kinds[e.Kind]++
+}
+
+// Write writes the report as JSON to w.
+func (r *Report) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(r)
+}
+
+// writereport writes the report to the given path, which can be either a file,
+// or an object.
+func writereport(path string, r *Report, fetch *Fetcher) error {
+	w, err := createoutput(path, fetch)
+
+	if err != nil {
+		return err
+	}
+
+	err = r.Write(w)
+
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_Report(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"users.schema": "id     int\nemail  string  ^.+@.+$  _  _  required\n",
+		"users.csv":    "id,email\n1,gordon@example.com\nx,alyx@example.com\n3,\n4,barney\n5,eli\n",
+	}
+
+	for name, s := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(s), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := filepath.Join(dir, "report.json")
+
+	args := []string{
+		"csv2json",
+		"-o", dir,
+		"-report", report,
+		"-s", filepath.Join(dir, "users.schema"),
+		filepath.Join(dir, "users.csv"),
+	}
+
+	if err := run(args); exitcode(err) != exitInvalid {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", RecordsError{N: 4}, err)
+	}
+
+	b, err := os.ReadFile(report)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r Report
+
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Errors != 4 {
+		t.Errorf("unexpected errors, expected=%d, got=%d\n", 4, r.Errors)
+	}
+
+	kinds := map[ErrorKind]int{
+		TypeMismatch:    1,
+		MissingColumn:   1,
+		PatternMismatch: 2,
+	}
+
+	if !reflect.DeepEqual(r.Kinds, kinds) {
+		t.Errorf("unexpected kinds, expected=%v, got=%v\n", kinds, r.Kinds)
+	}
+
+	columns := map[string]map[ErrorKind]int{
+		"id":    {TypeMismatch: 1},
+		"email": {MissingColumn: 1, PatternMismatch: 2},
+	}
+
+	if !reflect.DeepEqual(r.Columns, columns) {
+		t.Errorf("unexpected columns, expected=%v, got=%v\n", columns, r.Columns)
+	}
+
+	if n := r.Files[filepath.Join(dir, "users.csv")]; n != 4 {
+		t.Errorf("unexpected file errors, expected=%d, got=%d\n", 4, n)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rolling is an aggregate of a numeric column computed over a window of the
+// preceding records, including the current one, and emitted as an extra field.
+// The window is either a number of records, a span of time measured against
+// the Time column, or unbounded for a running aggregate. If By is set, then
+// the window only includes records with the same value for the By column.
+type Rolling struct {
+	Func   string // one of sum, avg, min, max, or count
+	Column string
+	Dest   string
+	By     string
+	Time   string        // time column for windows measured by a span of time
+	Rows   int           // number of records in the window, 0 if unbounded
+	Span   time.Duration // span of time of the window, 0 if unbounded
+}
+
+// rollentry is a single value in a rolling window.
+type rollentry struct {
+	t time.Time
+	n float64
+}
+
+// rollwin is the window of values for a Rolling aggregate.
+type rollwin struct {
+	entries []rollentry
+
+	// Running aggregates, for unbounded windows there are no entries kept.
+	count    int
+	sum      float64
+	min, max float64
+}
+
+func (s *Schema) AddRolling(r Rolling) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rollings = append(s.rollings, r)
+	s.addpos(r.Dest)
+}
+
+func (s *Schema) Rollings() []Rolling {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.rollings
+}
+
+// parsespan parses the given span of time. This is the same as a
+// time.Duration, with the addition of a d suffix for days.
+func parsespan(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+
+		if err != nil {
+			return 0, errors.New("invalid span " + s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// loadRolling decodes the given parts of a @rolling directive into a Rolling
+// and adds it to the schema. This is in the form of,
+//
+//     @rolling func column window destination [by=column] [time=column]
+func (s *Schema) loadRolling(parts []string) error {
+	if len(parts) < 5 {
+		return errors.New("too few columns in rolling directive")
+	}
+
+	r := Rolling{
+		Func:   parts[1],
+		Column: parts[2],
+		Dest:   parts[4],
+	}
+
+	switch r.Func {
+	case "sum", "avg", "min", "max", "count":
+	default:
+		return errors.New("unknown rolling function " + r.Func)
+	}
+
+	for _, opt := range parseopts(parts[5:]) {
+		switch opt.key {
+		case "by":
+			r.By = opt.val
+		case "time":
+			r.Time = opt.val
+		default:
+			return errors.New("unexpected option " + opt.key + " in rolling directive")
+		}
+	}
+
+	if window := parts[3]; window != "*" {
+		if r.Time != "" {
+			span, err := parsespan(window)
+
+			if err != nil {
+				return err
+			}
+
+			if span <= 0 {
+				return errors.New("rolling window must be greater than zero")
+			}
+			r.Span = span
+		} else {
+			n, err := strconv.ParseInt(window, 10, 64)
+
+			if err != nil {
+				return errors.New("invalid rolling window " + window + ", expected a number of records, or a span with time=column")
+			}
+
+			if n <= 0 {
+				return errors.New("rolling window must be greater than zero")
+			}
+			r.Rows = int(n)
+		}
+	}
+
+	s.AddRolling(r)
+	return nil
+}
+
+// add adds the given value to the window, evicting any values that fall out
+// of the window.
+func (w *rollwin) add(r Rolling, t time.Time, n float64) {
+	if w.count == 0 || n < w.min {
+		w.min = n
+	}
+
+	if w.count == 0 || n > w.max {
+		w.max = n
+	}
+
+	w.count++
+	w.sum += n
+
+	if r.Rows == 0 && r.Span == 0 {
+		return
+	}
+
+	w.entries = append(w.entries, rollentry{t: t, n: n})
+
+	evict := 0
+
+	if r.Rows > 0 && len(w.entries) > r.Rows {
+		evict = len(w.entries) - r.Rows
+	}
+
+	if r.Span > 0 {
+		for evict < len(w.entries) && !w.entries[evict].t.After(t.Add(-r.Span)) {
+			evict++
+		}
+	}
+
+	if evict == 0 {
+		return
+	}
+
+	w.entries = w.entries[evict:]
+
+	w.count = len(w.entries)
+	w.sum = 0
+
+	for i, e := range w.entries {
+		if i == 0 || e.n < w.min {
+			w.min = e.n
+		}
+
+		if i == 0 || e.n > w.max {
+			w.max = e.n
+		}
+		w.sum += e.n
+	}
+}
+
+// value returns the aggregate of the window.
+func (w *rollwin) value(r Rolling) Value {
+	switch r.Func {
+	case "count":
+		return &Int{n: w.count}
+	case "sum":
+		return &Float{n: w.sum}
+	case "avg":
+		return &Float{n: w.sum / float64(w.count)}
+	case "min":
+		return &Float{n: w.min}
+	case "max":
+		return &Float{n: w.max}
+	}
+	return &Float{n: math.NaN()}
+}
+
+// roll adds the values of the given record to each of the schema's rolling
+// windows, and sets the aggregates on the record. Records without a value for
+// the column are not added to the window, but still have the aggregate set if
+// the window is not empty.
+func (p *Parser) roll(rw *row, r *Record, cols map[string]Value) error {
+	rollings := p.schema.Rollings()
+
+	if len(rollings) == 0 {
+		return nil
+	}
+
+	if p.windows == nil {
+		p.windows = make([]map[string]*rollwin, len(rollings))
+
+		for i := range p.windows {
+			p.windows[i] = make(map[string]*rollwin)
+		}
+	}
+
+	for i, roll := range rollings {
+		key := p.column(rw.fields, roll.By)
+
+		w, ok := p.windows[i][key]
+
+		if !ok {
+			w = &rollwin{}
+			p.windows[i][key] = w
+		}
+
+		if v, ok := cols[roll.Column]; ok {
+			n, ok := numeric(v)
+
+			if !ok {
+				return ColumnError{
+					Col: roll.Column,
+					Err: errors.New("cannot aggregate non-numeric value"),
+				}
+			}
+
+			var t time.Time
+
+			if roll.Time != "" {
+				tv, ok := cols[roll.Time].(*Time)
+
+				if !ok {
+					return ColumnError{
+						Col: roll.Time,
+						Err: errors.New("rolling window requires a time value"),
+					}
+				}
+				t = tv.t
+			}
+			w.add(roll, t, n)
+		}
+
+		if w.count > 0 {
+			r.Set(roll.Dest, w.value(roll))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// loadschemas loads the schema for each of the given inputs from the given
+// directory, where the schema of an input has the same name as its output,
+// with a .schema extension. Inputs without a schema in the directory are left
+// out, and each schema is only loaded once. If all is true, then every error
+// in a schema is returned, rather than only the first.
+func loadschemas(dir string, inputs []string, all bool) (map[string]*Schema, error) {
+	schemas := make(map[string]*Schema)
+	loaded := make(map[string]*Schema)
+
+	for _, fname := range inputs {
+		path := filepath.Join(dir, outputname(fname, ".schema"))
+
+		if s, ok := loaded[path]; ok {
+			schemas[fname] = s
+			continue
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		s := NewSchema()
+
+		if err := loadschema(s, path, all); err != nil {
+			return nil, err
+		}
+
+		loaded[path] = s
+		schemas[fname] = s
+	}
+	return schemas, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_SchemaDir(t *testing.T) {
+	dir := t.TempDir()
+	schemadir := t.TempDir()
+
+	b, err := os.ReadFile(filepath.Join("testdata", "numbers.schema"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(schemadir, "numbers.schema"), b, os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	// numbers2.csv has no schema in the directory, so it falls back to -s.
+	args := []string{
+		"csv2json",
+		"-schema-dir", schemadir,
+		"-s", filepath.Join("testdata", "numbers2.schema"),
+		"-o", dir,
+		filepath.Join("testdata", "numbers.csv"),
+		filepath.Join("testdata", "numbers2.csv"),
+	}
+
+	if err := run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"numbers", "numbers2"} {
+		f, err := os.Open(filepath.Join("testdata", name+".golden"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkCsv(t, f, filepath.Join(dir, name+".json"))
+		f.Close()
+	}
+
+	if err := os.WriteFile(filepath.Join(schemadir, "numbers.schema"), []byte("binary money\n"), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadschemas(schemadir, []string{filepath.Join("testdata", "numbers.csv")}, false); err == nil {
+		t.Fatal("expected error for invalid schema, got nil")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// uptodate reports whether the output at the given path already exists for
+// the given input, so converting it again can be skipped. If newer is true,
+// then the output must also have been modified no earlier than the input.
+// Inputs that are not on disk, such as URLs, have no modification time, so
+// only the existence of their output is checked.
+func uptodate(fname, path string, newer bool) (bool, error) {
+	out, err := os.Stat(path)
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if !newer || isremote(fname) {
+		return true, nil
+	}
+
+	in, err := os.Stat(fname)
+
+	if err != nil {
+		return false, err
+	}
+	return !out.ModTime().Before(in.ModTime()), nil
+}
+
+// skipinputs returns the inputs whose outputs, with the given extension, in
+// the given destination are not up to date, along with those that are.
+func skipinputs(args []string, dest, ext string, newer bool) ([]string, []string, error) {
+	convert := make([]string, 0, len(args))
+	skipped := make([]string, 0)
+
+	for _, fname := range args {
+		ok, err := uptodate(fname, outputpath(dest, outputname(fname, ext)), newer)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if ok {
+			skipped = append(skipped, fname)
+			continue
+		}
+		convert = append(convert, fname)
+	}
+	return convert, skipped, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_SkipExisting(t *testing.T) {
+	dir := t.TempDir()
+
+	in := filepath.Join(dir, "ips.csv")
+
+	b, err := os.ReadFile(filepath.Join("testdata", "ips.csv"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(in, b, os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "ips.json")
+
+	tests := []struct {
+		flag    string
+		outtime time.Time
+		skipped bool
+	}{
+		{"-skip-existing", time.Now().Add(-time.Hour), true},
+		{"-newer-only", time.Now().Add(time.Hour), true},
+		{"-newer-only", time.Now().Add(-time.Hour), false},
+	}
+
+	for i, test := range tests {
+		if err := os.WriteFile(out, []byte("stale"), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(out, test.outtime, test.outtime); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := run([]string{"csv2json", test.flag, "-o", dir, in}); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		b, err := os.ReadFile(out)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if skipped := string(b) == "stale"; skipped != test.skipped {
+			t.Errorf("tests[%d] - unexpected skip, expected=%v, got=%v\n", i, test.skipped, skipped)
+		}
+	}
+
+	os.Remove(out)
+
+	if err := run([]string{"csv2json", "-skip-existing", "-o", dir, in}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countLines(t, out); n != 10 {
+		t.Errorf("unexpected number of rows, expected=%d, got=%d\n", 10, n)
+	}
+}
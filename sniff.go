@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// sniffSize is the number of bytes at the start of an input that are looked
+// at to detect its delimiter.
+const sniffSize = 4096
+
+// Delimiters are the delimiters that can be detected with -d auto, in order
+// of preference for when more than one could be the delimiter.
+var Delimiters = []rune{',', '\t', ';', '|'}
+
+// sniffdelim returns the delimiter of the CSV at the start of b, out of the
+// known Delimiters. Each delimiter is counted on each line, outside of quotes,
+// and the delimiter that appears the same number of times on every line is
+// taken, with the one appearing the most winning. If no delimiter is
+// consistent across lines, then the one appearing most in the header is
+// taken instead, and if none appear at all, then a comma is assumed.
+func sniffdelim(b []byte, quote rune) rune {
+	if quote == 0 {
+		quote = '"'
+	}
+
+	// The last line is likely cut short if the sample filled the buffer.
+	if len(b) >= sniffSize {
+		if i := bytes.LastIndexByte(b, '\n'); i > 0 {
+			b = b[:i]
+		}
+	}
+
+	counts := make([][]int, len(Delimiters))
+
+	line := make([]int, len(Delimiters))
+	quoted := false
+	blank := true
+
+	// Blank lines are skipped by the csv.Reader, so they are not counted.
+	endline := func() {
+		if !blank {
+			for i, n := range line {
+				counts[i] = append(counts[i], n)
+			}
+		}
+
+		for i := range line {
+			line[i] = 0
+		}
+		blank = true
+	}
+
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		b = b[size:]
+
+		if r != '\n' && r != '\r' {
+			blank = false
+		}
+
+		switch {
+		case r == quote:
+			quoted = !quoted
+		case quoted:
+			continue
+		case r == '\n':
+			endline()
+			continue
+		}
+
+		for i, delim := range Delimiters {
+			if r == delim {
+				line[i]++
+			}
+		}
+	}
+
+	if !quoted {
+		endline()
+	}
+
+	best, bestn := -1, 0
+
+	for i, lines := range counts {
+		if len(lines) == 0 {
+			continue
+		}
+
+		n, ok := lines[0], true
+
+		for _, c := range lines[1:] {
+			if c != n {
+				ok = false
+				break
+			}
+		}
+
+		if ok && n > bestn {
+			best, bestn = i, n
+		}
+	}
+
+	if best >= 0 {
+		return Delimiters[best]
+	}
+
+	for i, lines := range counts {
+		if len(lines) > 0 && lines[0] > bestn {
+			best, bestn = i, lines[0]
+		}
+	}
+
+	if best >= 0 {
+		return Delimiters[best]
+	}
+	return ','
+}
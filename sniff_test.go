@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_SniffDelim(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected rune
+	}{
+		{"id,name\n1,Gordon\n", ','},
+		{"id\tname\n1\tGordon\n", '\t'},
+		{"id;name\n1;Gordon\n", ';'},
+		{"id|name\n1|Gordon\n", '|'},
+		{"id;name;email\r\n1;Gordon;gordon@blackmesa.com\r\n", ';'},
+		{"id,name\n\n1,Gordon\n", ','},
+		{"id;name\n1;\"Freeman, Gordon\"\n2;\"Vance, Alyx\"\n", ';'},
+		{"id,note\n1,a;b;c\n2,d\n", ','},
+		{"id|name\n1|Freeman, Gordon\n", '|'},
+		{"id\n1\n", ','},
+		{"", ','},
+	}
+
+	for i, test := range tests {
+		if delim := sniffdelim([]byte(test.in), 0); delim != test.expected {
+			t.Errorf("tests[%d] - unexpected delimiter, expected=%q, got=%q\n", i, test.expected, delim)
+		}
+	}
+}
+
+func Test_SniffDelimTruncated(t *testing.T) {
+	csv := "id;name\n" + strings.Repeat("1;Gordon Freeman\n", sniffSize/len("1;Gordon Freeman\n")+1)
+
+	// The sample ends part way through a line, which should not count.
+	if delim := sniffdelim([]byte(csv[:sniffSize]), 0); delim != ';' {
+		t.Errorf("unexpected delimiter, expected=%q, got=%q\n", ';', delim)
+	}
+}
+
+func Test_AutoDelim(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"comma.csv": "id,name\n1,Gordon\n",
+		"tab.csv":   "id\tname\n1\tGordon\n",
+		"semi.csv":  "id;name\n1;Gordon\n",
+		"pipe.csv":  "id|name\n1|Gordon\n",
+	}
+
+	args := []string{"csv2json", "-d", "auto", "-o", dir}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		if err := os.WriteFile(path, []byte(content), os.FileMode(0644)); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, path)
+	}
+
+	if err := run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range files {
+		b, err := os.ReadFile(filepath.Join(dir, strings.TrimSuffix(name, ".csv")+".json"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := `{"id":1,"name":"Gordon"}` + "\n"; string(b) != expected {
+			t.Errorf("%s - unexpected output, expected=%q, got=%q\n", name, expected, string(b))
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// WithSortBy configures the Parser to emit records sorted by the value of the
+// given field, in descending order if desc is true. Records are held in memory
+// until roughly max bytes of them have been read, at which point they are
+// sorted and spilled to the Parser's TempDir as a run. The runs are then merged
+// once all records have been read. If max is 0 then records are only ever held
+// in memory.
+func WithSortBy(field string, desc bool, max int64) ParserOption {
+	return func(p *Parser) {
+		p.sortby = field
+		p.sortdesc = desc
+		p.sortmax = max
+	}
+}
+
+// parsesortby parses the given field to sort by, with an optional :asc or
+// :desc suffix.
+func parsesortby(s string) (string, bool, error) {
+	field := s
+	desc := false
+
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		field = s[:i]
+
+		switch s[i+1:] {
+		case "asc":
+		case "desc":
+			desc = true
+		default:
+			return "", false, errors.New("invalid sort order " + s[i+1:] + ", expected asc or desc")
+		}
+	}
+
+	if field == "" {
+		return "", false, errors.New("no field to sort by")
+	}
+	return field, desc, nil
+}
+
+// checksortby checks that the field being sorted by is in the output.
+func (p *Parser) checksortby() error {
+	if p.sortby == "" {
+		return nil
+	}
+
+	for _, f := range p.fields() {
+		if f.Name == p.sortby {
+			return nil
+		}
+	}
+	return errors.New("cannot sort by unknown field " + p.sortby)
+}
+
+const (
+	sortNumber byte = iota
+	sortString
+	sortNull
+)
+
+// sortkey is the value of the field a record is sorted by. Numbers and times
+// sort before strings, and nulls sort last regardless of the order.
+type sortkey struct {
+	kind byte
+	n    float64
+	s    string
+}
+
+func sortkeyof(v Value) sortkey {
+	switch x := v.(type) {
+	case nil, Null:
+		return sortkey{kind: sortNull}
+	case *String:
+		return sortkey{kind: sortString, s: x.replaced()}
+	case Bool:
+		if x.b {
+			return sortkey{kind: sortNumber, n: 1}
+		}
+		return sortkey{kind: sortNumber}
+	}
+
+	if n, ok := checkvalue(v); ok {
+		return sortkey{kind: sortNumber, n: n}
+	}
+
+	b, err := v.MarshalJSON()
+
+	if err != nil {
+		return sortkey{kind: sortNull}
+	}
+	return sortkey{kind: sortString, s: string(b)}
+}
+
+func (k sortkey) less(other sortkey, desc bool) bool {
+	if k.kind != other.kind {
+		return k.kind < other.kind
+	}
+
+	switch k.kind {
+	case sortNumber:
+		if desc {
+			return k.n > other.n
+		}
+		return k.n < other.n
+	case sortString:
+		if desc {
+			return k.s > other.s
+		}
+		return k.s < other.s
+	}
+	return false
+}
+
+func writesortkey(w *bufio.Writer, k sortkey) {
+	var n [binary.MaxVarintLen64]byte
+
+	w.WriteByte(k.kind)
+
+	switch k.kind {
+	case sortNumber:
+		binary.BigEndian.PutUint64(n[:8], math.Float64bits(k.n))
+		w.Write(n[:8])
+	case sortString:
+		w.Write(n[:binary.PutUvarint(n[:], uint64(len(k.s)))])
+		w.WriteString(k.s)
+	}
+}
+
+func readsortkey(r *bufio.Reader) (sortkey, error) {
+	var k sortkey
+
+	kind, err := r.ReadByte()
+
+	if err != nil {
+		return k, err
+	}
+
+	k.kind = kind
+
+	switch kind {
+	case sortNumber:
+		var b [8]byte
+
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return k, err
+		}
+		k.n = math.Float64frombits(binary.BigEndian.Uint64(b[:]))
+	case sortString:
+		n, err := binary.ReadUvarint(r)
+
+		if err != nil {
+			return k, err
+		}
+
+		b := make([]byte, n)
+
+		if _, err := io.ReadFull(r, b); err != nil {
+			return k, err
+		}
+		k.s = string(b)
+	}
+	return k, nil
+}
+
+// sortentry is a record waiting to be sorted.
+type sortentry struct {
+	key sortkey
+	res result
+}
+
+// rowsize returns a rough estimate of the memory held for a parsed row.
+func rowsize(rw *row) int64 {
+	n := int64(64)
+
+	for _, fld := range rw.fields {
+		n += 2*int64(len(fld)) + 32
+	}
+	return n
+}
+
+// sortrun is a run of sorted rows spilled to disk.
+type sortrun struct {
+	f   *TempFile
+	r   *bufio.Reader
+	n   int // rows left in the run
+	key sortkey
+	rw  *row
+}
+
+// next reads the next row of the run, returning false if there are none left.
+func (r *sortrun) next() (bool, error) {
+	if r.n == 0 {
+		return false, nil
+	}
+
+	r.n--
+
+	key, err := readsortkey(r.r)
+
+	if err != nil {
+		return false, err
+	}
+
+	rw, err := readrow(r.r)
+
+	if err != nil {
+		return false, err
+	}
+
+	r.key = key
+	r.rw = rw
+	return true, nil
+}
+
+// sortheap is a min heap of runs by their current key. Runs with the same key
+// are ordered by the order they were spilled in, so the sort is stable.
+type sortheap struct {
+	runs []*sortrun
+	idx  map[*sortrun]int
+	desc bool
+}
+
+func (h *sortheap) Len() int { return len(h.runs) }
+
+func (h *sortheap) Less(i, j int) bool {
+	a, b := h.runs[i], h.runs[j]
+
+	if a.key.less(b.key, h.desc) {
+		return true
+	}
+	if b.key.less(a.key, h.desc) {
+		return false
+	}
+	return h.idx[a] < h.idx[b]
+}
+
+func (h *sortheap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+
+func (h *sortheap) Push(x interface{}) { h.runs = append(h.runs, x.(*sortrun)) }
+
+func (h *sortheap) Pop() interface{} {
+	run := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return run
+}
+
+// spill writes the given sorted entries to a new run in the Parser's TempDir.
+func (p *Parser) spill(tmp *TempDir, entries []sortentry) (*sortrun, error) {
+	f, err := tmp.Create()
+
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+
+	for _, e := range entries {
+		writesortkey(w, e.key)
+
+		if err := writerow(w, e.res.rw); err != nil {
+			f.Remove()
+			return nil, err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Remove()
+		return nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Remove()
+		return nil, err
+	}
+
+	return &sortrun{
+		f: f,
+		r: bufio.NewReader(f),
+		n: len(entries),
+	}, nil
+}
+
+// parseSorted parses each row returned by read, and emits the records sorted
+// by the Parser's sort field. Records that cannot be parsed are reported as
+// they are read. Rows spilled to disk are parsed again when they are merged,
+// since only the raw row is written.
+func (p *Parser) parseSorted(out io.Writer, read func() (*row, error)) error {
+	tmp := p.tmp
+
+	if tmp == nil {
+		tmp = NewTempDir("", 0)
+		defer tmp.Remove()
+	}
+
+	entries := make([]sortentry, 0)
+	runs := make([]*sortrun, 0)
+
+	defer func() {
+		for _, run := range runs {
+			run.f.Remove()
+		}
+	}()
+
+	sortentries := func() {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].key.less(entries[j].key, p.sortdesc)
+		})
+	}
+
+	var size int64
+
+	for {
+		rw, err := read()
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+			break
+		}
+
+		r, cols, err := p.json(rw)
+
+		if err != nil {
+			if _, err := p.emit(out, result{rw: rw, err: err}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		v, _ := r.Get(p.sortby)
+
+		entries = append(entries, sortentry{
+			key: sortkeyof(v),
+			res: result{rw: rw, r: r, cols: cols},
+		})
+
+		size += rowsize(rw)
+
+		if p.sortmax > 0 && size >= p.sortmax {
+			sortentries()
+
+			run, err := p.spill(tmp, entries)
+
+			if err != nil {
+				return err
+			}
+
+			runs = append(runs, run)
+			entries = entries[:0]
+			size = 0
+		}
+	}
+
+	sortentries()
+
+	if len(runs) == 0 {
+		for _, e := range entries {
+			stop, err := p.emit(out, e.res)
+
+			if err != nil {
+				return err
+			}
+
+			if stop {
+				break
+			}
+		}
+		return nil
+	}
+
+	if len(entries) > 0 {
+		run, err := p.spill(tmp, entries)
+
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+	}
+
+	entries = nil
+
+	h := &sortheap{
+		idx:  make(map[*sortrun]int),
+		desc: p.sortdesc,
+	}
+
+	for i, run := range runs {
+		ok, err := run.next()
+
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			h.idx[run] = i
+			h.runs = append(h.runs, run)
+		}
+	}
+
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		run := h.runs[0]
+
+		r, cols, err := p.json(run.rw)
+
+		stop, err := p.emit(out, result{rw: run.rw, r: r, cols: cols, err: err})
+
+		if err != nil {
+			return err
+		}
+
+		if stop {
+			break
+		}
+
+		ok, err := run.next()
+
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_SortBy(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-sort-by", "amount:desc"}, "40:40,30:70,20:90,15:105,10:115,5:120"},
+		{[]string{"-sort-by", "amount"}, "5:5,10:15,15:30,20:50,30:80,40:120"},
+		{[]string{"-sort-by", "store"}, "10:10,20:30,30:60,40:100,5:105,15:120"},
+		{[]string{"-sort-by", "day:desc", "-limit", "2"}, "40:40,15:55"},
+		{[]string{"-sort-by", "amount", "-max-memory", "1"}, "5:5,10:15,15:30,20:50,30:80,40:120"},
+	}
+
+	for i, test := range tests {
+		// Each test is run with the records held in memory, and with every
+		// record spilled to its own run.
+		for _, mem := range []string{"256M", "1"} {
+			dir := t.TempDir()
+
+			args := append([]string{
+				"csv2json",
+				"-o", dir,
+				"-tmpdir", dir,
+				"-sort-memory", mem,
+				"-s", filepath.Join("testdata", "sales.schema"),
+			}, test.args...)
+
+			if err := run(append(args, filepath.Join("testdata", "sales.csv"))); err != nil {
+				t.Fatalf("tests[%d] - %s\n", i, err)
+			}
+
+			f, err := os.Open(filepath.Join(dir, "sales.json"))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sales := make([]string, 0)
+
+			sc := bufio.NewScanner(f)
+
+			for sc.Scan() {
+				var rec map[string]interface{}
+
+				if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+					t.Fatal(err)
+				}
+				sales = append(sales, fmt.Sprint(rec["amount"])+":"+fmt.Sprint(rec["running_total"]))
+			}
+			f.Close()
+
+			if s := strings.Join(sales, ","); s != test.expected {
+				t.Errorf("tests[%d] - unexpected records with -sort-memory %s, expected=%q, got=%q\n", i, mem, test.expected, s)
+			}
+
+			tmps, _ := filepath.Glob(filepath.Join(dir, "csv2json-*", "*"))
+
+			if len(tmps) != 0 {
+				t.Errorf("tests[%d] - expected temporary files to be removed, got=%v\n", i, tmps)
+			}
+		}
+	}
+}
+
+func Test_SortByDedupe(t *testing.T) {
+	dir := t.TempDir()
+
+	args := []string{
+		"csv2json",
+		"-o", dir,
+		"-dedupe-key", "id",
+		"-dedupe-keep", "last",
+		"-sort-by", "page",
+		filepath.Join("testdata", "visits.csv"),
+	}
+
+	if err := run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "visits.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	pages := make([]string, 0)
+
+	sc := bufio.NewScanner(f)
+
+	for sc.Scan() {
+		var rec map[string]interface{}
+
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, fmt.Sprint(rec["page"]))
+	}
+
+	if s := strings.Join(pages, ","); s != "about,home,pricing" {
+		t.Errorf("unexpected pages, expected=%q, got=%q\n", "about,home,pricing", s)
+	}
+}
+
+func Test_ParseSortBy(t *testing.T) {
+	tests := []struct {
+		s     string
+		field string
+		desc  bool
+		err   bool
+	}{
+		{"created_at", "created_at", false, false},
+		{"created_at:desc", "created_at", true, false},
+		{"created_at:asc", "created_at", false, false},
+		{"created_at:down", "", false, true},
+		{":desc", "", false, true},
+	}
+
+	for i, test := range tests {
+		field, desc, err := parsesortby(test.s)
+
+		if test.err {
+			if err == nil {
+				t.Errorf("tests[%d] - expected error for %q\n", i, test.s)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("tests[%d] - %s\n", i, err)
+			continue
+		}
+
+		if field != test.field || desc != test.desc {
+			t.Errorf("tests[%d] - unexpected sort, expected=%s:%v, got=%s:%v\n", i, test.field, test.desc, field, desc)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// usageError is returned when a subcommand is given too few arguments, and
+// holds the usage of that subcommand.
+type usageError string
+
+func (e usageError) Error() string { return "usage: " + string(e) }
+
+// parsecount parses the given count, which can have a K, M, or G suffix for
+// thousands, millions, or billions respectively.
+func parsecount(s string) (int, error) {
+	mul := 1
+
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			mul = 1000
+		case 'm', 'M':
+			mul = 1000000
+		case 'g', 'G':
+			mul = 1000000000
+		}
+	}
+
+	if mul > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if n <= 0 {
+		return 0, errors.New("count must be greater than zero")
+	}
+	return int(n) * mul, nil
+}
+
+// chunkWriter writes lines to a series of numbered files, moving on to the
+// next file once the current one has the maximum number of rows. The files are
+// named after the base name with the chunk number before the extension, for
+// example users.0001.json.
+type chunkWriter struct {
+	base string
+	ext  string
+	rows int
+
+	n     int // number of rows written to the current chunk
+	chunk int
+
+	f *os.File
+	w *bufio.Writer
+
+	names []string // names of the files written
+}
+
+func newChunkWriter(fname string, rows int) *chunkWriter {
+	ext := filepath.Ext(fname)
+
+	return &chunkWriter{
+		base: strings.TrimSuffix(fname, ext),
+		ext:  ext,
+		rows: rows,
+	}
+}
+
+// next closes the current chunk, if any, and opens the next one.
+func (c *chunkWriter) next() error {
+	if err := c.Close(); err != nil {
+		return err
+	}
+
+	c.chunk++
+	c.n = 0
+
+	fname := fmt.Sprintf("%s.%04d%s", c.base, c.chunk, c.ext)
+
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0644))
+
+	if err != nil {
+		return err
+	}
+
+	c.f = f
+	c.w = bufio.NewWriter(f)
+	c.names = append(c.names, fname)
+	return nil
+}
+
+// WriteLine writes the given line to the current chunk. The line is expected
+// to end with a newline.
+func (c *chunkWriter) WriteLine(line []byte) error {
+	if c.f == nil || c.n >= c.rows {
+		if err := c.next(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.w.Write(line); err != nil {
+		return err
+	}
+	c.n++
+	return nil
+}
+
+// Close flushes and closes the current chunk.
+func (c *chunkWriter) Close() error {
+	if c.f == nil {
+		return nil
+	}
+
+	f := c.f
+	c.f = nil
+
+	if err := c.w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// split splits the given NDJSON file into chunks with the given number of
+// rows. The names of the chunks written are returned.
+func split(fname string, rows int) ([]string, error) {
+	f, err := os.Open(fname)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	c := newChunkWriter(filepath.Base(fname), rows)
+
+	br := bufio.NewReader(f)
+
+	for {
+		line, err := br.ReadBytes('\n')
+
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+
+			if err := c.WriteLine(line); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				c.Close()
+				return nil, err
+			}
+			break
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		return nil, err
+	}
+	return c.names, nil
+}
+
+// splitEncoder encodes records into a series of chunks, moving on to the next
+// chunk once the current one has the maximum number of rows, or bytes. Each
+// chunk is encoded with its own Encoder, so formats with a header or trailer
+// are written in full in every chunk.
+type splitEncoder struct {
+	newenc EncoderFunc
+	next   func() (io.Writer, error)
+	name   string
+	fields []Field
+	rows   int
+	size   int64
+
+	enc   Encoder
+	count *countWriter // bytes written to the current chunk
+	n     int          // number of rows encoded into the current chunk
+}
+
+// NewSplitEncoder returns an EncoderFunc that splits the records encoded by
+// the given EncoderFunc across chunks of at most the given number of rows, or
+// the given number of bytes. If either is zero then there is no limit on it.
+// The size of a chunk is only checked between records, and formats that
+// buffer may write more before it is noticed, so chunks can be larger than
+// the given size. The next function is called for the writer of each chunk,
+// and should close the previous one.
+func NewSplitEncoder(newenc EncoderFunc, next func() (io.Writer, error), rows int, size int64) EncoderFunc {
+	return func(_ io.Writer, name string, fields []Field) (Encoder, error) {
+		e := &splitEncoder{
+			newenc: newenc,
+			next:   next,
+			name:   name,
+			fields: fields,
+			rows:   rows,
+			size:   size,
+		}
+
+		// The first chunk is created up front, so there is an output even
+		// if there are no records.
+		if err := e.chunk(); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+}
+
+// chunk closes the Encoder of the current chunk, if any, and starts the next
+// one.
+func (e *splitEncoder) chunk() error {
+	if e.enc != nil {
+		if err := e.enc.Close(); err != nil {
+			return err
+		}
+	}
+
+	w, err := e.next()
+
+	if err != nil {
+		return err
+	}
+
+	e.count = &countWriter{}
+	e.n = 0
+
+	e.enc, err = e.newenc(io.MultiWriter(w, e.count), e.name, e.fields)
+	return err
+}
+
+func (e *splitEncoder) Encode(r *Record) error {
+	if e.n > 0 && ((e.rows > 0 && e.n >= e.rows) || (e.size > 0 && e.count.n >= e.size)) {
+		if err := e.chunk(); err != nil {
+			return err
+		}
+	}
+
+	if err := e.enc.Encode(r); err != nil {
+		return err
+	}
+	e.n++
+	return nil
+}
+
+func (e *splitEncoder) Close() error { return e.enc.Close() }
+
+func runSplit(argv0 string, args []string) error {
+	var rows string
+
+	fs := flag.NewFlagSet(argv0+" split", flag.ExitOnError)
+	fs.StringVar(&rows, "rows", "100K", "the number of rows in each chunk, can have a K, M, or G suffix")
+
+	args = parseflags(fs, args)
+
+	if len(args) < 1 {
+		return usageError(argv0 + " split [-rows n] <file,...>")
+	}
+
+	n, err := parsecount(rows)
+
+	if err != nil {
+		return errors.New("invalid -rows: " + err.Error())
+	}
+
+	errc := 0
+
+	for _, fname := range args {
+		names, err := split(fname, n)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", argv0, err)
+			errc++
+			continue
+		}
+
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	}
+
+	if errc > 0 {
+		return errors.New("encountered errors during split")
+	}
+	return nil
+}
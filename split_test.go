@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Split(t *testing.T) {
+	if err := run([]string{"csv2json", filepath.Join("testdata", "ips.csv")}); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll("ips.json")
+
+	tests := []struct {
+		rows     string
+		after    bool // whether the flags are given after the file
+		expected []int
+	}{
+		{"3", false, []int{3, 3, 3, 1}},
+		{"5", false, []int{5, 5}},
+		{"1K", false, []int{10}},
+		{"3", true, []int{3, 3, 3, 1}},
+	}
+
+	for i, test := range tests {
+		args := []string{"csv2json", "split", "-rows", test.rows, "ips.json"}
+
+		if test.after {
+			args = []string{"csv2json", "split", "ips.json", "-rows", test.rows}
+		}
+
+		if err := run(args); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		for j, expected := range test.expected {
+			name := "ips.000" + string(rune('1'+j)) + ".json"
+
+			if n := countLines(t, name); n != expected {
+				t.Fatalf("tests[%d] - unexpected number of rows in %s, expected=%d, got=%d\n", i, name, expected, n)
+			}
+			os.RemoveAll(name)
+		}
+
+		next := "ips.000" + string(rune('1'+len(test.expected))) + ".json"
+
+		if _, err := os.Stat(next); err == nil {
+			os.RemoveAll(next)
+			t.Fatalf("tests[%d] - unexpected chunk %s\n", i, next)
+		}
+	}
+}
+
+func Test_SplitOutput(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected []int
+	}{
+		{[]string{"-split-rows", "3"}, []int{3, 3, 3, 1}},
+		{[]string{"-split-rows", "1K"}, []int{10}},
+		// Each record of ips.csv is between 20 and 40 bytes, so a chunk is
+		// full after two records.
+		{[]string{"-split-bytes", "40"}, []int{2, 2, 2, 2, 2}},
+		{[]string{"-split-rows", "3", "-split-bytes", "40"}, []int{2, 2, 2, 2, 2}},
+	}
+
+	for i, test := range tests {
+		dir := t.TempDir()
+
+		args := append([]string{"csv2json", "-o", dir}, test.args...)
+
+		if err := run(append(args, filepath.Join("testdata", "ips.csv"))); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		names, err := filepath.Glob(filepath.Join(dir, "*"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(names) != len(test.expected) {
+			t.Fatalf("tests[%d] - unexpected number of chunks, expected=%d, got=%v\n", i, len(test.expected), names)
+		}
+
+		for j, expected := range test.expected {
+			name := filepath.Join(dir, "ips.000"+string(rune('1'+j))+".json")
+
+			if n := countLines(t, name); n != expected {
+				t.Fatalf("tests[%d] - unexpected number of rows in %s, expected=%d, got=%d\n", i, name, expected, n)
+			}
+		}
+	}
+}
+
+func Test_SplitOutputFormat(t *testing.T) {
+	dir := t.TempDir()
+
+	args := []string{
+		"csv2json",
+		"-o", dir,
+		"-format", "sql",
+		"-sql-style", "copy",
+		"-split-rows", "4",
+		"-s", filepath.Join("testdata", "sales.schema"),
+		filepath.Join("testdata", "sales.csv"),
+	}
+
+	if err := run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each chunk is a complete COPY statement of its own.
+	for _, name := range []string{"sales.0001.sql", "sales.0002.sql"} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(string(b), `COPY "sales" (`) || !strings.HasSuffix(string(b), "\\.\n") {
+			t.Errorf("expected %s to be a complete COPY statement, got=%q\n", name, b)
+		}
+	}
+}
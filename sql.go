@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sqlident quotes the given identifier. Every identifier is quoted, so
+// columns named after reserved words, such as order, can still be used.
+func sqlident(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// sqlEncoder writes records as SQL, either as INSERT statements, or as the
+// rows of a PostgreSQL COPY.
+type sqlEncoder struct {
+	w      io.Writer
+	copy   bool
+	table  string
+	fields []Field
+	cols   string // quoted column list
+	n      int    // number of records written
+	buf    bytes.Buffer
+}
+
+// NewSQLEncoder returns an EncoderFunc for writing records as SQL into the
+// given table. If table is empty then the name of the output is used. The
+// style is either insert for INSERT statements, or copy for a PostgreSQL COPY
+// in text format.
+func NewSQLEncoder(table, style string) EncoderFunc {
+	return func(w io.Writer, name string, fields []Field) (Encoder, error) {
+		tbl := table
+
+		if tbl == "" {
+			tbl = name
+		}
+
+		if len(fields) == 0 {
+			return nil, errors.New("cannot write sql for a file with no columns")
+		}
+
+		cols := make([]string, 0, len(fields))
+
+		for _, f := range fields {
+			cols = append(cols, sqlident(f.Name))
+		}
+
+		return &sqlEncoder{
+			w:      w,
+			copy:   style == "copy",
+			table:  sqlident(tbl),
+			fields: fields,
+			cols:   strings.Join(cols, ", "),
+		}, nil
+	}
+}
+
+// sqlstring quotes the given string as a SQL string literal.
+func sqlstring(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// copyEscaper escapes the characters that are special in the PostgreSQL COPY
+// text format.
+var copyEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"\t", `\t`,
+	"\n", `\n`,
+	"\r", `\r`,
+)
+
+// literal returns the given value as it should be written in SQL. Arrays are
+// written as their JSON, so they can be loaded into json columns.
+func (e *sqlEncoder) literal(v interface{}) (string, error) {
+	var s string
+
+	switch x := v.(type) {
+	case nil:
+		if e.copy {
+			return `\N`, nil
+		}
+		return "NULL", nil
+	case bool:
+		s = "FALSE"
+
+		if x {
+			s = "TRUE"
+		}
+
+		if e.copy {
+			s = strings.ToLower(s[:1])
+		}
+		return s, nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			s = strconv.FormatFloat(x, 'g', -1, 64)
+
+			if s == "+Inf" {
+				s = "Infinity"
+			} else if s == "-Inf" {
+				s = "-Infinity"
+			}
+
+			if e.copy {
+				return s, nil
+			}
+			return sqlstring(s), nil
+		}
+		return strconv.FormatFloat(x, 'g', -1, 64), nil
+	case string:
+		s = x
+	case []interface{}:
+		b, err := json.Marshal(x)
+
+		if err != nil {
+			return "", err
+		}
+		s = string(b)
+	default:
+		return "", errors.New("cannot encode value")
+	}
+
+	if e.copy {
+		return copyEscaper.Replace(s), nil
+	}
+	return sqlstring(s), nil
+}
+
+// row returns the given record as either an INSERT statement, or a line of a
+// COPY.
+func (e *sqlEncoder) row(r *Record) (string, error) {
+	vals := make([]string, 0, len(e.fields))
+
+	for _, f := range e.fields {
+		var val interface{}
+
+		if v, ok := r.Get(f.Name); ok {
+			var err error
+
+			val, err = native(v)
+
+			if err != nil {
+				return "", EncodeError{Field: f.Name, Err: err}
+			}
+		}
+
+		s, err := e.literal(val)
+
+		if err != nil {
+			return "", EncodeError{Field: f.Name, Err: err}
+		}
+		vals = append(vals, s)
+	}
+
+	if e.copy {
+		return strings.Join(vals, "\t") + "\n", nil
+	}
+	return "INSERT INTO " + e.table + " (" + e.cols + ") VALUES (" + strings.Join(vals, ", ") + ");\n", nil
+}
+
+func (e *sqlEncoder) Encode(r *Record) error {
+	row, err := e.row(r)
+
+	if err != nil {
+		return err
+	}
+
+	e.buf.Reset()
+
+	if e.copy && e.n == 0 {
+		e.buf.WriteString("COPY " + e.table + " (" + e.cols + ") FROM stdin;\n")
+	}
+
+	e.buf.WriteString(row)
+
+	if _, err := e.w.Write(e.buf.Bytes()); err != nil {
+		return err
+	}
+
+	e.n++
+	return nil
+}
+
+// Close terminates the COPY, if any records were written.
+func (e *sqlEncoder) Close() error {
+	if e.copy && e.n > 0 {
+		_, err := io.WriteString(e.w, "\\.\n")
+		return err
+	}
+	return nil
+}
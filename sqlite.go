@@ -0,0 +1,543 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+// sqlitePageSize is the size of each page in the SQLite databases written.
+const sqlitePageSize = 4096
+
+// sqlitevarint appends the given number to b as a SQLite varint. These are
+// big-endian, with the high bit of each byte set if another follows, except
+// for the ninth byte which uses all 8 bits.
+func sqlitevarint(b []byte, n uint64) []byte {
+	if n > 0x00ffffffffffffff {
+		var buf [9]byte
+
+		buf[8] = byte(n)
+		n >>= 8
+
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(n&0x7f) | 0x80
+			n >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+
+	var buf [8]byte
+
+	i := len(buf) - 1
+	buf[i] = byte(n & 0x7f)
+	n >>= 7
+
+	for n > 0 {
+		i--
+		buf[i] = byte(n&0x7f) | 0x80
+		n >>= 7
+	}
+	return append(b, buf[i:]...)
+}
+
+// sqliterecord encodes the given values in the SQLite record format. Bools
+// are stored as integers, and arrays as their JSON.
+func sqliterecord(vals []interface{}) ([]byte, error) {
+	hdr := make([]byte, 0, len(vals)+1)
+	body := make([]byte, 0)
+
+	for _, v := range vals {
+		switch x := v.(type) {
+		case nil:
+			hdr = sqlitevarint(hdr, 0)
+		case bool:
+			if x {
+				hdr = sqlitevarint(hdr, 9)
+			} else {
+				hdr = sqlitevarint(hdr, 8)
+			}
+		case int64:
+			var b [8]byte
+
+			binary.BigEndian.PutUint64(b[:], uint64(x))
+
+			// Serial types 1 to 6 store the integer in 1, 2, 3, 4, 6, or 8
+			// bytes.
+			switch {
+			case x == 0:
+				hdr = sqlitevarint(hdr, 8)
+			case x == 1:
+				hdr = sqlitevarint(hdr, 9)
+			case x >= math.MinInt8 && x <= math.MaxInt8:
+				hdr = sqlitevarint(hdr, 1)
+				body = append(body, b[7:]...)
+			case x >= math.MinInt16 && x <= math.MaxInt16:
+				hdr = sqlitevarint(hdr, 2)
+				body = append(body, b[6:]...)
+			case x >= -1<<23 && x < 1<<23:
+				hdr = sqlitevarint(hdr, 3)
+				body = append(body, b[5:]...)
+			case x >= math.MinInt32 && x <= math.MaxInt32:
+				hdr = sqlitevarint(hdr, 4)
+				body = append(body, b[4:]...)
+			case x >= -1<<47 && x < 1<<47:
+				hdr = sqlitevarint(hdr, 5)
+				body = append(body, b[2:]...)
+			default:
+				hdr = sqlitevarint(hdr, 6)
+				body = append(body, b[:]...)
+			}
+		case float64:
+			var b [8]byte
+
+			binary.BigEndian.PutUint64(b[:], math.Float64bits(x))
+
+			hdr = sqlitevarint(hdr, 7)
+			body = append(body, b[:]...)
+		case string:
+			hdr = sqlitevarint(hdr, uint64(len(x))*2+13)
+			body = append(body, x...)
+		case []interface{}:
+			b, err := json.Marshal(x)
+
+			if err != nil {
+				return nil, err
+			}
+
+			hdr = sqlitevarint(hdr, uint64(len(b))*2+13)
+			body = append(body, b...)
+		default:
+			return nil, errors.New("cannot encode value")
+		}
+	}
+
+	// The size of the header includes the varint of the size itself.
+	n := len(hdr) + 1
+
+	for len(hdr)+len(sqlitevarint(nil, uint64(n))) != n {
+		n = len(hdr) + len(sqlitevarint(nil, uint64(n)))
+	}
+
+	rec := sqlitevarint(make([]byte, 0, n+len(body)), uint64(n))
+	rec = append(rec, hdr...)
+	return append(rec, body...), nil
+}
+
+// sqlitepage lays out a b-tree page with the given cells. The header begins at
+// off, which is 100 for the first page of the database, since this holds the
+// database header.
+func sqlitepage(flag byte, cells [][]byte, right uint32, off int) []byte {
+	page := make([]byte, sqlitePageSize)
+
+	hdrsize := 8
+
+	if flag == 0x05 {
+		hdrsize = 12
+		binary.BigEndian.PutUint32(page[off+8:], right)
+	}
+
+	page[off] = flag
+	binary.BigEndian.PutUint16(page[off+3:], uint16(len(cells)))
+
+	end := sqlitePageSize
+	ptr := off + hdrsize
+
+	for _, cell := range cells {
+		end -= len(cell)
+		copy(page[end:], cell)
+
+		binary.BigEndian.PutUint16(page[ptr:], uint16(end))
+		ptr += 2
+	}
+
+	// A content area starting at the end of a 65536 byte page is written as
+	// zero, but that doesn't matter for these page sizes.
+	binary.BigEndian.PutUint16(page[off+5:], uint16(end))
+	return page
+}
+
+// sqliteChild is a child page in a b-tree, along with the largest rowid in
+// it.
+type sqliteChild struct {
+	page  uint32
+	rowid int64
+}
+
+// sqliteTree builds a table b-tree. Rows are added in rowid order, and packed
+// into leaf pages that are written as they are filled. The interior pages are
+// written once all of the rows have been added.
+type sqliteTree struct {
+	db    *SQLiteDB
+	off   int // offset of the header in the root page
+	cells [][]byte
+	size  int // size of the cells, including their pointers
+	rowid int64
+
+	leaves []sqliteChild
+}
+
+// fits checks if a cell of the given size would fit in the current leaf.
+func (t *sqliteTree) fits(n int) bool {
+	return t.off+8+t.size+n+2 <= sqlitePageSize
+}
+
+func (t *sqliteTree) flush() error {
+	page, err := t.db.write(sqlitepage(0x0D, t.cells, 0, 0))
+
+	if err != nil {
+		return err
+	}
+
+	t.leaves = append(t.leaves, sqliteChild{page: page, rowid: t.rowid})
+	t.cells = t.cells[:0]
+	t.size = 0
+
+	return nil
+}
+
+// add adds a row with the given record to the tree. Records that are too large
+// to fit in a leaf are spilled into overflow pages.
+func (t *sqliteTree) add(rec []byte) error {
+	rowid := t.rowid + 1
+
+	cell := sqlitevarint(nil, uint64(len(rec)))
+	cell = sqlitevarint(cell, uint64(rowid))
+
+	// The thresholds for how much of a record is kept in the leaf are the
+	// ones given in the file format.
+	usable := sqlitePageSize
+	maxlocal := usable - 35
+	minlocal := (usable-12)*32/255 - 23
+
+	if len(rec) <= maxlocal {
+		cell = append(cell, rec...)
+	} else {
+		local := minlocal + (len(rec)-minlocal)%(usable-4)
+
+		if local > maxlocal {
+			local = minlocal
+		}
+
+		first, err := t.db.overflow(rec[local:])
+
+		if err != nil {
+			return err
+		}
+
+		cell = append(cell, rec[:local]...)
+		cell = append(cell, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(cell[len(cell)-4:], first)
+	}
+
+	if len(t.cells) > 0 && !t.fits(len(cell)) {
+		if err := t.flush(); err != nil {
+			return err
+		}
+	}
+
+	t.rowid = rowid
+	t.cells = append(t.cells, cell)
+	t.size += len(cell) + 2
+
+	return nil
+}
+
+// finish writes the rest of the tree, returning the page number of the root.
+// If root is not zero, then the root is written to that page.
+func (t *sqliteTree) finish(root uint32) (uint32, error) {
+	if len(t.leaves) == 0 && root != 0 {
+		return root, t.db.writeAt(root, sqlitepage(0x0D, t.cells, 0, t.off))
+	}
+
+	// Only the root can be an empty leaf.
+	if len(t.cells) > 0 || len(t.leaves) == 0 {
+		if err := t.flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	// Each interior cell is at most 13 bytes, plus its pointer, and the last
+	// child of a page is its right-most pointer rather than a cell.
+	per := (sqlitePageSize-t.off-12)/15 + 1
+
+	level := t.leaves
+
+	for len(level) > 1 {
+		npages := (len(level) + per - 1) / per
+
+		// Spread the children evenly, so no page is left with only its
+		// right-most pointer.
+		next := make([]sqliteChild, 0, npages)
+
+		for i := 0; i < npages; i++ {
+			children := level[i*len(level)/npages : (i+1)*len(level)/npages]
+			cells := make([][]byte, 0, len(children)-1)
+
+			for _, child := range children[:len(children)-1] {
+				cell := make([]byte, 4, 13)
+				binary.BigEndian.PutUint32(cell, child.page)
+				cells = append(cells, sqlitevarint(cell, uint64(child.rowid)))
+			}
+
+			right := children[len(children)-1]
+
+			if npages == 1 && root != 0 {
+				return root, t.db.writeAt(root, sqlitepage(0x05, cells, right.page, t.off))
+			}
+
+			pgno, err := t.db.write(sqlitepage(0x05, cells, right.page, 0))
+
+			if err != nil {
+				return 0, err
+			}
+			next = append(next, sqliteChild{page: pgno, rowid: right.rowid})
+		}
+		level = next
+	}
+	return level[0].page, nil
+}
+
+// sqliteTable is a table written to a SQLite database.
+type sqliteTable struct {
+	name string
+	root uint32
+	sql  string
+}
+
+// SQLiteDB is a SQLite database being written. Each table is written as its
+// own b-tree, and the schema is written to the first page when the database
+// is closed. The database is written from scratch, so any existing file is
+// overwritten.
+type SQLiteDB struct {
+	mu     sync.Mutex
+	f      *os.File
+	npages uint32
+	tables []sqliteTable
+	names  map[string]struct{}
+}
+
+// CreateSQLite creates a new SQLite database in the given file.
+func CreateSQLite(fname string) (*SQLiteDB, error) {
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.FileMode(0644))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQLiteDB{
+		f:      f,
+		npages: 1, // the first page is written on close
+		names:  make(map[string]struct{}),
+	}, nil
+}
+
+func (db *SQLiteDB) writeAt(pgno uint32, page []byte) error {
+	_, err := db.f.WriteAt(page, int64(pgno-1)*sqlitePageSize)
+	return err
+}
+
+// write writes the given page after the last page of the database, returning
+// its page number.
+func (db *SQLiteDB) write(page []byte) (uint32, error) {
+	db.mu.Lock()
+	db.npages++
+	pgno := db.npages
+	db.mu.Unlock()
+
+	return pgno, db.writeAt(pgno, page)
+}
+
+// overflow writes the given data across a chain of overflow pages, returning
+// the page number of the first. The pages are written from the end of the
+// chain, so each page knows the next.
+func (db *SQLiteDB) overflow(b []byte) (uint32, error) {
+	chunk := sqlitePageSize - 4
+	pages := make([][]byte, 0, len(b)/chunk+1)
+
+	for len(b) > 0 {
+		n := chunk
+
+		if len(b) < n {
+			n = len(b)
+		}
+
+		page := make([]byte, sqlitePageSize)
+		copy(page[4:], b[:n])
+
+		pages = append(pages, page)
+		b = b[n:]
+	}
+
+	var next uint32
+
+	for i := len(pages) - 1; i >= 0; i-- {
+		binary.BigEndian.PutUint32(pages[i], next)
+
+		pgno, err := db.write(pages[i])
+
+		if err != nil {
+			return 0, err
+		}
+		next = pgno
+	}
+	return next, nil
+}
+
+// sqlitetype returns the column type for the given field. Fields with no
+// known type are given no column type, so values keep the type they are
+// stored with.
+func sqlitetype(f Field) string {
+	if f.Array {
+		return " TEXT"
+	}
+
+	switch f.Type {
+	case "":
+		return ""
+	case "bool", "int":
+		return " INTEGER"
+	case "float":
+		return " REAL"
+	}
+	return " TEXT"
+}
+
+// sqliteEncoder writes records as the rows of a table.
+type sqliteEncoder struct {
+	db     *SQLiteDB
+	table  sqliteTable
+	fields []Field
+	tree   *sqliteTree
+}
+
+// Encoder returns an EncoderFunc that writes the records of each file to a
+// table in the database, named after the output. The writer given to the
+// EncoderFunc is not used.
+func (db *SQLiteDB) Encoder() EncoderFunc {
+	return func(_ io.Writer, name string, fields []Field) (Encoder, error) {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		if _, ok := db.names[strings.ToLower(name)]; ok {
+			return nil, errors.New("sqlite: table " + name + " already exists")
+		}
+
+		if len(fields) == 0 {
+			return nil, errors.New("cannot create a table for a file with no columns")
+		}
+
+		db.names[strings.ToLower(name)] = struct{}{}
+
+		cols := make([]string, 0, len(fields))
+
+		for _, f := range fields {
+			cols = append(cols, sqlident(f.Name)+sqlitetype(f))
+		}
+
+		return &sqliteEncoder{
+			db: db,
+			table: sqliteTable{
+				name: name,
+				sql:  "CREATE TABLE " + sqlident(name) + " (" + strings.Join(cols, ", ") + ")",
+			},
+			fields: fields,
+			tree:   &sqliteTree{db: db},
+		}, nil
+	}
+}
+
+func (e *sqliteEncoder) Encode(r *Record) error {
+	vals := make([]interface{}, 0, len(e.fields))
+
+	for _, f := range e.fields {
+		var val interface{}
+
+		if v, ok := r.Get(f.Name); ok {
+			var err error
+
+			val, err = native(v)
+
+			if err != nil {
+				return EncodeError{Field: f.Name, Err: err}
+			}
+		}
+		vals = append(vals, val)
+	}
+
+	rec, err := sqliterecord(vals)
+
+	if err != nil {
+		return err
+	}
+	return e.tree.add(rec)
+}
+
+// Close writes the rest of the table, and adds it to the database schema.
+func (e *sqliteEncoder) Close() error {
+	root, err := e.tree.finish(0)
+
+	if err != nil {
+		return err
+	}
+
+	e.table.root = root
+
+	e.db.mu.Lock()
+	e.db.tables = append(e.db.tables, e.table)
+	e.db.mu.Unlock()
+
+	return nil
+}
+
+// Close writes the schema of the database, and closes the file. This should
+// only be called once all of the tables have been written.
+func (db *SQLiteDB) Close() error {
+	defer db.f.Close()
+
+	schema := &sqliteTree{db: db, off: 100}
+
+	for _, tbl := range db.tables {
+		rec, err := sqliterecord([]interface{}{"table", tbl.name, tbl.name, int64(tbl.root), tbl.sql})
+
+		if err != nil {
+			return err
+		}
+
+		if err := schema.add(rec); err != nil {
+			return err
+		}
+	}
+
+	if _, err := schema.finish(1); err != nil {
+		return err
+	}
+
+	// The root of the schema is written after the first 100 bytes of the
+	// first page, which hold the database header.
+	page := make([]byte, 100)
+
+	copy(page, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(page[16:], sqlitePageSize)
+	page[18] = 1 // file format write version, legacy
+	page[19] = 1 // file format read version, legacy
+	page[21] = 64
+	page[22] = 32
+	page[23] = 32
+	binary.BigEndian.PutUint32(page[24:], 1) // file change counter
+	binary.BigEndian.PutUint32(page[28:], db.npages)
+	binary.BigEndian.PutUint32(page[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(page[44:], 4) // schema format number
+	binary.BigEndian.PutUint32(page[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(page[92:], 1) // version-valid-for
+	binary.BigEndian.PutUint32(page[96:], 3037000)
+
+	if _, err := db.f.WriteAt(page, 0); err != nil {
+		return err
+	}
+	return db.f.Close()
+}
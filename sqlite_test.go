@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sqliteReader reads the rows of the tables in a SQLite database written by
+// SQLiteDB.
+type sqliteReader struct {
+	t *testing.T
+	b []byte
+}
+
+func (r *sqliteReader) page(pgno uint32) []byte {
+	off := int(pgno-1) * sqlitePageSize
+	return r.b[off : off+sqlitePageSize]
+}
+
+func readvarint(b []byte) (uint64, int) {
+	var n uint64
+
+	for i := 0; i < 8; i++ {
+		n = n<<7 | uint64(b[i]&0x7f)
+
+		if b[i]&0x80 == 0 {
+			return n, i + 1
+		}
+	}
+	return n<<8 | uint64(b[8]), 9
+}
+
+// payload returns the record in the given leaf cell, following any overflow
+// pages.
+func (r *sqliteReader) payload(cell []byte) []byte {
+	size, n := readvarint(cell)
+	cell = cell[n:]
+
+	_, n = readvarint(cell)
+	cell = cell[n:]
+
+	if int(size) <= sqlitePageSize-35 {
+		return cell[:size]
+	}
+
+	minlocal := (sqlitePageSize-12)*32/255 - 23
+	local := minlocal + (int(size)-minlocal)%(sqlitePageSize-4)
+
+	if local > sqlitePageSize-35 {
+		local = minlocal
+	}
+
+	rec := append([]byte(nil), cell[:local]...)
+	next := binary.BigEndian.Uint32(cell[local:])
+
+	for next != 0 {
+		page := r.page(next)
+		next = binary.BigEndian.Uint32(page)
+
+		n := int(size) - len(rec)
+
+		if n > sqlitePageSize-4 {
+			n = sqlitePageSize - 4
+		}
+		rec = append(rec, page[4:4+n]...)
+	}
+	return rec
+}
+
+func (r *sqliteReader) record(rec []byte) []interface{} {
+	hdrsize, n := readvarint(rec)
+	hdr := rec[n:hdrsize]
+	body := rec[hdrsize:]
+
+	vals := make([]interface{}, 0)
+
+	for len(hdr) > 0 {
+		typ, n := readvarint(hdr)
+		hdr = hdr[n:]
+
+		switch {
+		case typ == 0:
+			vals = append(vals, nil)
+		case typ >= 1 && typ <= 6:
+			size := []int{1, 2, 3, 4, 6, 8}[typ-1]
+
+			var x int64
+
+			for _, b := range body[:size] {
+				x = x<<8 | int64(b)
+			}
+
+			// Sign extend from the size of the integer.
+			shift := uint(64 - size*8)
+			vals = append(vals, x<<shift>>shift)
+			body = body[size:]
+		case typ == 7:
+			vals = append(vals, math.Float64frombits(binary.BigEndian.Uint64(body)))
+			body = body[8:]
+		case typ == 8, typ == 9:
+			vals = append(vals, int64(typ-8))
+		case typ >= 13 && typ%2 == 1:
+			size := int(typ-13) / 2
+			vals = append(vals, string(body[:size]))
+			body = body[size:]
+		default:
+			r.t.Fatalf("unexpected serial type %d\n", typ)
+		}
+	}
+	return vals
+}
+
+// rows returns the rows of the table b-tree rooted at the given page.
+func (r *sqliteReader) rows(pgno uint32) [][]interface{} {
+	page := r.page(pgno)
+	off := 0
+
+	if pgno == 1 {
+		off = 100
+	}
+
+	ncells := int(binary.BigEndian.Uint16(page[off+3:]))
+
+	rows := make([][]interface{}, 0)
+
+	switch page[off] {
+	case 0x0D:
+		for i := 0; i < ncells; i++ {
+			ptr := binary.BigEndian.Uint16(page[off+8+i*2:])
+			rows = append(rows, r.record(r.payload(page[ptr:])))
+		}
+	case 0x05:
+		for i := 0; i < ncells; i++ {
+			ptr := binary.BigEndian.Uint16(page[off+12+i*2:])
+			rows = append(rows, r.rows(binary.BigEndian.Uint32(page[ptr:]))...)
+		}
+		rows = append(rows, r.rows(binary.BigEndian.Uint32(page[off+8:]))...)
+	default:
+		r.t.Fatalf("unexpected page type %x on page %d\n", page[off], pgno)
+	}
+	return rows
+}
+
+func Test_SQLite(t *testing.T) {
+	dir := t.TempDir()
+
+	// Enough records to span multiple pages, with some large enough to
+	// overflow.
+	var buf bytes.Buffer
+
+	buf.WriteString("id,name,bio\n")
+
+	for i := 0; i < 2000; i++ {
+		bio := "short"
+
+		if i%100 == 0 {
+			bio = strings.Repeat("long ", 2000)
+		}
+		buf.WriteString(strconv.Itoa(i-1000) + ",user" + strconv.Itoa(i) + "," + bio + "\n")
+	}
+
+	users := filepath.Join(dir, "users.csv")
+
+	if err := os.WriteFile(users, buf.Bytes(), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	dbname := filepath.Join(dir, "out.db")
+
+	args := []string{
+		"csv2json",
+		"-sqlite", dbname,
+		"-s", filepath.Join("testdata", "sales.schema"),
+		"-order", "csv",
+		filepath.Join("testdata", "sales.csv"),
+		users,
+	}
+
+	if err := run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(dbname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(b, []byte("SQLite format 3\x00")) {
+		t.Fatal("expected sqlite header")
+	}
+
+	if n := binary.BigEndian.Uint32(b[28:]); int(n)*sqlitePageSize != len(b) {
+		t.Fatalf("unexpected database size, header=%d pages, file=%d bytes\n", n, len(b))
+	}
+
+	r := &sqliteReader{t: t, b: b}
+
+	tables := make(map[string][]interface{})
+
+	for _, row := range r.rows(1) {
+		tables[row[1].(string)] = row
+	}
+
+	sales, ok := tables["sales"]
+
+	if !ok {
+		t.Fatal("expected sales table in schema")
+	}
+
+	if sql := sales[4].(string); !strings.HasPrefix(sql, `CREATE TABLE "sales" ("store", "day" TEXT, "amount" INTEGER`) {
+		t.Errorf("unexpected sql for sales, got=%s\n", sql)
+	}
+
+	rows := r.rows(uint32(sales[3].(int64)))
+
+	if len(rows) != 6 {
+		t.Fatalf("unexpected number of sales rows, expected=%d, got=%d\n", 6, len(rows))
+	}
+
+	if rows[0][0] != "a" || rows[0][1] != "2021-12-01T00:00:00Z" || rows[0][2] != int64(10) {
+		t.Errorf("unexpected first sales row, got=%v\n", rows[0])
+	}
+
+	rows = r.rows(uint32(tables["users"][3].(int64)))
+
+	if len(rows) != 2000 {
+		t.Fatalf("unexpected number of users rows, expected=%d, got=%d\n", 2000, len(rows))
+	}
+
+	for i, row := range rows {
+		if row[0] != int64(i-1000) {
+			t.Fatalf("unexpected id for row %d, expected=%d, got=%v\n", i, i-1000, row[0])
+		}
+
+		if i%100 == 0 && len(row[2].(string)) != 10000 {
+			t.Fatalf("unexpected bio length for row %d, expected=%d, got=%d\n", i, 10000, len(row[2].(string)))
+		}
+	}
+}
+
+func Test_SQLiteKeywords(t *testing.T) {
+	dir := t.TempDir()
+
+	kw := filepath.Join(dir, "kw.csv")
+
+	if err := os.WriteFile(kw, []byte("user,order,when\nme,1,now\n"), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	dbname := filepath.Join(dir, "out.db")
+
+	if err := run([]string{"csv2json", "-sqlite", dbname, "-order", "csv", kw}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(dbname)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &sqliteReader{t: t, b: b}
+
+	expected := `CREATE TABLE "kw" ("user", "order", "when")`
+
+	if sql := r.rows(1)[0][4].(string); sql != expected {
+		t.Errorf("unexpected sql for kw, expected=%s, got=%s\n", expected, sql)
+	}
+
+	// The stored schema is parsed by SQLite once the database is opened, so
+	// check that it can be, if sqlite3 is installed.
+	sqlite3, err := exec.LookPath("sqlite3")
+
+	if err != nil {
+		t.Skip("sqlite3 not found, skipping reopening the database")
+	}
+
+	out, err := exec.Command(sqlite3, dbname, "PRAGMA integrity_check; SELECT \"user\", \"order\", \"when\" FROM kw;").CombinedOutput()
+
+	if err != nil {
+		t.Fatalf("failed to open database, %s: %s\n", err, out)
+	}
+
+	if string(out) != "ok\nme|1|now\n" {
+		t.Errorf("unexpected output of sqlite3, expected=%q, got=%q\n", "ok\nme|1|now\n", out)
+	}
+}
+
+func Test_SQLiteVarint(t *testing.T) {
+	tests := []struct {
+		n        uint64
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x81, 0x00}},
+		{16383, []byte{0xff, 0x7f}},
+		{math.MaxUint64, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	}
+
+	for _, test := range tests {
+		b := sqlitevarint(nil, test.n)
+
+		if !bytes.Equal(b, test.expected) {
+			t.Errorf("unexpected varint for %d, expected=%x, got=%x\n", test.n, test.expected, b)
+		}
+
+		if n, _ := readvarint(append(b, make([]byte, 9)...)); n != test.n {
+			t.Errorf("unexpected round trip for %d, got=%d\n", test.n, n)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// funcEncoder is an Encoder that passes each record to a function, rather
+// than writing it anywhere.
+type funcEncoder struct {
+	fn func(r *Record) error
+}
+
+func (e funcEncoder) Encode(r *Record) error { return e.fn(r) }
+
+func (e funcEncoder) Close() error { return nil }
+
+// ParseFunc parses each record from the input and calls fn with it, instead of
+// writing it to an io.Writer. Records are passed to fn in the order they would
+// be written by Parse, and with the same checks applied. If fn returns an
+// error then parsing stops, and that error is returned. An EncodeError is
+// reported to the error handler instead, and the record is skipped.
+//
+// The records passed to fn are not reused by the Parser, so they can be held
+// onto once fn returns.
+func (p *Parser) ParseFunc(fn func(r *Record) error) error {
+	p.enc = funcEncoder{fn: fn}
+
+	defer func() {
+		p.enc = nil
+	}()
+
+	return p.parse(io.Discard)
+}
+
+// Next returns the next record from the input that passes all of the Parser's
+// checks. Records that fail are reported to the error handler and read over,
+// as they are with Parse. Once there are no more records, or the limit has
+// been reached, io.EOF is returned.
+//
+// Records are returned in the order they are read, so Next cannot be used if
+// the Parser sorts records, and rows are not parsed across workers.
+func (p *Parser) Next() (*Record, error) {
+	if p.sortby != "" {
+		return nil, errors.New("cannot sort records returned via Next, use ParseFunc instead")
+	}
+
+	if p.next == nil {
+		p.next = p.read
+
+		if p.dedupe && p.dedupelast {
+			rows, done, err := p.lastrows(p.read)
+
+			if err != nil {
+				return nil, err
+			}
+
+			p.next = rows
+			p.done = done
+		}
+	}
+
+	for {
+		if p.limit > 0 && p.emitted >= p.limit {
+			return nil, p.eof(io.EOF)
+		}
+
+		rw, err := p.next()
+
+		if err != nil {
+			return nil, p.eof(err)
+		}
+
+		r, cols, err := p.json(rw)
+
+		ok, err := p.accept(result{rw: rw, r: r, cols: cols, err: err})
+
+		if err != nil {
+			return nil, p.eof(err)
+		}
+
+		if !ok {
+			continue
+		}
+
+		p.sort(r)
+		p.emitted++
+
+		return r, nil
+	}
+}
+
+// eof cleans up after the rows returned via Next once there are no more to
+// return, returning the given error.
+func (p *Parser) eof(err error) error {
+	if p.done != nil {
+		p.done()
+		p.done = nil
+	}
+
+	if p.seen != nil {
+		p.seen.remove()
+	}
+
+	p.next = func() (*row, error) { return nil, err }
+	return err
+}
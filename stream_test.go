@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test_Next(t *testing.T) {
+	errs := 0
+
+	errh := func(line, col int, msg string) {
+		errs++
+	}
+
+	s := NewSchema()
+	s.Add("id", SchemaRecord{Dest: "id", Unmarshal: UnmarshalInt(10)})
+
+	in := strings.NewReader("id,name\n1,Gordon\nx,Alyx\n3,Eli\n4,Barney\n")
+
+	p, err := NewParser(in, ',', s, errh, WithLimit(2))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recs := make([]*Record, 0)
+
+	for {
+		r, err := p.Next()
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Fatal(err)
+			}
+			break
+		}
+		recs = append(recs, r)
+	}
+
+	if _, err := p.Next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", io.EOF, err)
+	}
+
+	if errs != 1 {
+		t.Errorf("unexpected errors, expected=%d, got=%d\n", 1, errs)
+	}
+
+	expected := []string{
+		`{"id":1,"name":"Gordon"}`,
+		`{"id":3,"name":"Eli"}`,
+	}
+
+	if len(recs) != len(expected) {
+		t.Fatalf("unexpected records, expected=%d, got=%d\n", len(expected), len(recs))
+	}
+
+	for i, r := range recs {
+		b, err := r.MarshalJSON()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != expected[i] {
+			t.Errorf("record[%d] - unexpected json, expected=%q, got=%q\n", i, expected[i], string(b))
+		}
+	}
+}
+
+func Test_NextDedupeLast(t *testing.T) {
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	in := strings.NewReader("id,page\n1,home\n2,about\n1,pricing\n")
+
+	p, err := NewParser(in, ',', NewSchema(), errh, WithDedupe([]string{"id"}, true))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages := make([]string, 0)
+
+	for {
+		r, err := p.Next()
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Fatal(err)
+			}
+			break
+		}
+
+		v, _ := r.Get("page")
+
+		b, _ := v.MarshalJSON()
+		pages = append(pages, string(b))
+	}
+
+	if strings.Join(pages, ",") != `"about","pricing"` {
+		t.Errorf("unexpected pages, expected=%q, got=%q\n", `"about","pricing"`, strings.Join(pages, ","))
+	}
+}
+
+func Test_NextSortBy(t *testing.T) {
+	errh := func(line, col int, msg string) {}
+
+	in := strings.NewReader("id,name\n1,Gordon\n")
+
+	p, err := NewParser(in, ',', NewSchema(), errh, WithSortBy("id", false, 0))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Next(); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func Test_ParseFunc(t *testing.T) {
+	errh := func(line, col int, msg string) {
+		t.Errorf("%d:%d - %s\n", line, col, msg)
+	}
+
+	in := strings.NewReader("id,name\n3,Eli\n1,Gordon\n2,Alyx\n")
+
+	p, err := NewParser(in, ',', NewSchema(), errh, WithSortBy("id", false, 0))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recs := make([]*Record, 0)
+
+	err = p.ParseFunc(func(r *Record) error {
+		recs = append(recs, r)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, 0, len(recs))
+
+	for _, r := range recs {
+		v, _ := r.Get("id")
+
+		b, _ := v.MarshalJSON()
+		ids = append(ids, string(b))
+	}
+
+	if strings.Join(ids, ",") != "1,2,3" {
+		t.Errorf("unexpected ids, expected=%q, got=%q\n", "1,2,3", strings.Join(ids, ","))
+	}
+
+	stop := errors.New("stop")
+
+	in = strings.NewReader("id,name\n1,Gordon\n2,Alyx\n")
+
+	p, err = NewParser(in, ',', NewSchema(), errh)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+
+	err = p.ParseFunc(func(r *Record) error {
+		n++
+		return stop
+	})
+
+	if !errors.Is(err, stop) {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", stop, err)
+	}
+
+	if n != 1 {
+		t.Errorf("unexpected records, expected=%d, got=%d\n", 1, n)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// stringops are the operations that can be given as the format of a string,
+// by name.
+var stringops = map[string]func(s string) string{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"title": titlecase,
+	"trim":  strings.TrimSpace,
+}
+
+// parseops parses the given format as a pipeline of string operations, such
+// as trim|lower, which are applied from left to right. If any part of the
+// pipeline is not an operation, then false is returned, and the format is
+// taken to be a replacement string instead.
+func parseops(format string) ([]func(s string) string, bool) {
+	parts := strings.Split(format, "|")
+	ops := make([]func(s string) string, 0, len(parts))
+
+	for _, part := range parts {
+		op, ok := stringops[strings.TrimSpace(part)]
+
+		if !ok {
+			return nil, false
+		}
+		ops = append(ops, op)
+	}
+	return ops, true
+}
+
+// titlecase uppercases the first letter of each word in the given string, and
+// lowercases the rest. A word starts after anything that is not a letter,
+// digit, or apostrophe, so "o'neil-SMITH" becomes "O'neil-Smith".
+func titlecase(s string) string {
+	var buf strings.Builder
+
+	buf.Grow(len(s))
+
+	prev := ' '
+
+	for len(s) > 0 {
+		r, n := utf8.DecodeRuneInString(s)
+		s = s[n:]
+
+		if unicode.IsLetter(prev) || unicode.IsDigit(prev) || prev == '\'' {
+			buf.WriteRune(unicode.ToLower(r))
+		} else {
+			buf.WriteRune(unicode.ToUpper(r))
+		}
+		prev = r
+	}
+	return buf.String()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// templatePart is either literal text, or a column, in a concat template.
+type templatePart struct {
+	text string
+	col  string
+}
+
+// parsetemplate parses the given concat template, where columns are referred
+// to by name in braces, such as "{first} {last}". Literal braces are escaped
+// by doubling them. This returns the parts of the template, and the columns
+// referred to in the order they appear.
+func parsetemplate(tmpl string) ([]templatePart, []string, error) {
+	parts := make([]templatePart, 0)
+	cols := make([]string, 0)
+
+	var text strings.Builder
+
+	for i := 0; i < len(tmpl); i++ {
+		c := tmpl[i]
+
+		if c == '}' {
+			if i+1 < len(tmpl) && tmpl[i+1] == '}' {
+				text.WriteByte('}')
+				i++
+				continue
+			}
+			return nil, nil, errors.New("unexpected } in template, literal braces must be doubled")
+		}
+
+		if c != '{' {
+			text.WriteByte(c)
+			continue
+		}
+
+		if i+1 < len(tmpl) && tmpl[i+1] == '{' {
+			text.WriteByte('{')
+			i++
+			continue
+		}
+
+		end := strings.IndexByte(tmpl[i+1:], '}')
+
+		if end < 0 {
+			return nil, nil, errors.New("unterminated { in template")
+		}
+
+		col := tmpl[i+1 : i+1+end]
+
+		if col == "" {
+			return nil, nil, errors.New("empty column in template")
+		}
+
+		if text.Len() > 0 {
+			parts = append(parts, templatePart{text: text.String()})
+			text.Reset()
+		}
+
+		parts = append(parts, templatePart{col: col})
+		cols = append(cols, col)
+
+		i += end + 1
+	}
+
+	if text.Len() > 0 {
+		parts = append(parts, templatePart{text: text.String()})
+	}
+
+	if len(cols) == 0 {
+		return nil, nil, errors.New("template refers to no columns")
+	}
+	return parts, cols, nil
+}
+
+// join joins the given values of the concat's columns, either into its
+// template, or with its separator. Empty values are left out when joined with
+// the separator.
+func (c Concat) join(vals []string) string {
+	if c.Template == nil {
+		nonempty := make([]string, 0, len(vals))
+
+		for _, val := range vals {
+			if val != "" {
+				nonempty = append(nonempty, val)
+			}
+		}
+		return strings.Join(nonempty, c.Sep)
+	}
+
+	var buf strings.Builder
+
+	i := 0
+
+	for _, part := range c.Template {
+		if part.col == "" {
+			buf.WriteString(part.text)
+			continue
+		}
+
+		buf.WriteString(vals[i])
+		i++
+	}
+	return buf.String()
+}
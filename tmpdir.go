@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+)
+
+var errTempFull = errors.New("temp directory has reached its maximum size")
+
+// TempDir is the directory temporary files are spilled to during a single
+// invocation. The directory is only created once the first file is created
+// within it, and the total size of the files within it is tracked so it can
+// be limited.
+type TempDir struct {
+	mu     sync.Mutex
+	parent string
+	dir    string
+	max    int64 // maximum size of all files, 0 for no limit
+	size   int64
+}
+
+// TempFile is a temporary file created within a TempDir. Writes to the file
+// count towards the size of the TempDir.
+type TempFile struct {
+	*os.File
+
+	dir  *TempDir
+	size int64
+}
+
+// NewTempDir returns a TempDir that will be created within the given parent
+// directory. If parent is empty, then the default directory for temporary
+// files is used.
+func NewTempDir(parent string, max int64) *TempDir {
+	if parent == "" {
+		parent = os.TempDir()
+	}
+
+	return &TempDir{
+		parent: parent,
+		max:    max,
+	}
+}
+
+// parsesize parses the given size in bytes, with an optional K, M, or G
+// suffix, which can be followed by a B, as in 256MB.
+func parsesize(s string) (int64, error) {
+	var mul int64 = 1
+
+	if len(s) > 1 && (s[len(s)-1] == 'b' || s[len(s)-1] == 'B') {
+		switch s[len(s)-2] {
+		case 'k', 'K', 'm', 'M', 'g', 'G':
+			s = s[:len(s)-1]
+		}
+	}
+
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			mul = 1 << 10
+		case 'm', 'M':
+			mul = 1 << 20
+		case 'g', 'G':
+			mul = 1 << 30
+		}
+	}
+
+	if mul > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if n < 0 {
+		return 0, errors.New("size cannot be negative")
+	}
+	return n * mul, nil
+}
+
+// Create creates a new temporary file in the TempDir.
+func (d *TempDir) Create() (*TempFile, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.dir == "" {
+		dir, err := os.MkdirTemp(d.parent, "csv2json-")
+
+		if err != nil {
+			return nil, err
+		}
+		d.dir = dir
+	}
+
+	f, err := os.CreateTemp(d.dir, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &TempFile{
+		File: f,
+		dir:  d,
+	}, nil
+}
+
+// Size returns the total size of the files in the TempDir.
+func (d *TempDir) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.size
+}
+
+// reserve adds n bytes to the size of the TempDir, if this would exceed the
+// maximum size then errTempFull is returned.
+func (d *TempDir) reserve(n int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.max > 0 && d.size+n > d.max {
+		return errTempFull
+	}
+
+	d.size += n
+	return nil
+}
+
+func (d *TempDir) release(n int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.size -= n
+}
+
+// Remove removes the TempDir and all of the files within it.
+func (d *TempDir) Remove() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.dir == "" {
+		return nil
+	}
+
+	err := os.RemoveAll(d.dir)
+
+	d.dir = ""
+	d.size = 0
+
+	return err
+}
+
+func (f *TempFile) Write(p []byte) (int, error) {
+	if err := f.dir.reserve(int64(len(p))); err != nil {
+		return 0, err
+	}
+
+	n, err := f.File.Write(p)
+
+	f.size += int64(n)
+
+	if n < len(p) {
+		f.dir.release(int64(len(p) - n))
+	}
+	return n, err
+}
+
+// Remove closes and removes the TempFile, the size of the TempFile no longer
+// counts towards the size of the TempDir.
+func (f *TempFile) Remove() error {
+	f.File.Close()
+
+	err := os.Remove(f.Name())
+
+	f.dir.release(f.size)
+	f.size = 0
+
+	return err
+}
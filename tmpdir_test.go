@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func Test_TempDir(t *testing.T) {
+	parent := t.TempDir()
+
+	d := NewTempDir(parent, 10)
+
+	ents, err := os.ReadDir(parent)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ents) != 0 {
+		t.Fatalf("expected temp dir to be created lazily, got=%d entries\n", len(ents))
+	}
+
+	f, err := d.Create()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write([]byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write([]byte("123")); !errors.Is(err, errTempFull) {
+		t.Fatalf("expected errTempFull, got=%v\n", err)
+	}
+
+	if n := d.Size(); n != 8 {
+		t.Fatalf("unexpected size, expected=%d, got=%d\n", 8, n)
+	}
+
+	if err := f.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := d.Size(); n != 0 {
+		t.Fatalf("unexpected size, expected=%d, got=%d\n", 0, n)
+	}
+
+	if _, err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	ents, err = os.ReadDir(parent)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ents) != 0 {
+		t.Fatalf("expected temp dir to be removed, got=%d entries\n", len(ents))
+	}
+}
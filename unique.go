@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// KeySet is a set of keys that have been seen, used for enforcing uniqueness
+// of a column across multiple files. A KeySet can be persisted to a state file
+// so uniqueness is enforced across multiple runs too.
+type KeySet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+
+	f *os.File
+	w *bufio.Writer
+}
+
+func NewKeySet() *KeySet {
+	return &KeySet{
+		keys: make(map[string]struct{}),
+	}
+}
+
+// LoadKeySet loads the keys from the given state file, creating it if it does
+// not exist. Any new keys added to the set are appended to the state file.
+// Each key is stored on a separate line as a quoted string.
+func LoadKeySet(fname string) (*KeySet, error) {
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_RDWR|os.O_APPEND, os.FileMode(0644))
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewKeySet()
+
+	sc := linescanner(f)
+
+	for sc.Scan() {
+		key, err := strconv.Unquote(sc.Text())
+
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		s.keys[key] = struct{}{}
+	}
+
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	s.f = f
+	s.w = bufio.NewWriter(f)
+
+	return s, nil
+}
+
+// Add adds the given key to the set. This returns false if the key was already
+// in the set.
+func (s *KeySet) Add(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key]; ok {
+		return false, nil
+	}
+
+	s.keys[key] = struct{}{}
+
+	if s.w != nil {
+		if _, err := s.w.WriteString(strconv.Quote(key) + "\n"); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Close flushes any new keys to the underlying state file, if any.
+func (s *KeySet) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+
+	if err := s.w.Flush(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
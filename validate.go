@@ -0,0 +1,727 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Validator validates records against a JSON Schema. Only the keywords of the
+// draft 2020-12 validation vocabulary most commonly used are supported,
+//
+//     type, enum, const, properties, required, additionalProperties,
+//     patternProperties, dependentRequired, minProperties, maxProperties,
+//     items, prefixItems, minItems, maxItems, uniqueItems, minLength,
+//     maxLength, pattern, format, minimum, maximum, exclusiveMinimum,
+//     exclusiveMaximum, multipleOf, allOf, anyOf, oneOf, not, if, then,
+//     else, and $ref to the schema's own $defs
+//
+// The date-time, date, time, email, uuid, ipv4, ipv6, and uri formats are
+// asserted, any other format is ignored.
+type Validator struct {
+	root  interface{}
+	retab map[string]*regexp.Regexp
+}
+
+// LoadValidator loads the JSON Schema in the given file into a Validator.
+func LoadValidator(fname string) (*Validator, error) {
+	b, err := os.ReadFile(fname)
+
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := NewValidator(b)
+
+	if err != nil {
+		return nil, errors.New(fname + ": " + err.Error())
+	}
+	return v, nil
+}
+
+// NewValidator returns a Validator for the given JSON Schema. Any patterns in
+// the schema are compiled up front, so an invalid pattern is reported here
+// rather than when validating.
+func NewValidator(b []byte) (*Validator, error) {
+	root, err := decodejson(b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch root.(type) {
+	case bool, map[string]interface{}:
+	default:
+		return nil, errors.New("json schema must be an object or a bool")
+	}
+
+	v := &Validator{
+		root:  root,
+		retab: make(map[string]*regexp.Regexp),
+	}
+
+	if err := v.compile(root); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// decodejson decodes the given JSON, keeping numbers as json.Number so they
+// can be compared exactly.
+func decodejson(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var v interface{}
+
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// compile compiles the patterns in the given schema, and each of its
+// subschemas.
+func (v *Validator) compile(schema interface{}) error {
+	switch s := schema.(type) {
+	case map[string]interface{}:
+		for key, val := range s {
+			switch key {
+			case "pattern":
+				if pat, ok := val.(string); ok {
+					if err := v.compilepattern(pat); err != nil {
+						return err
+					}
+				}
+			case "patternProperties":
+				if m, ok := val.(map[string]interface{}); ok {
+					for pat := range m {
+						if err := v.compilepattern(pat); err != nil {
+							return err
+						}
+					}
+				}
+			case "enum", "const":
+				continue
+			}
+
+			if err := v.compile(val); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, val := range s {
+			if err := v.compile(val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *Validator) compilepattern(pat string) error {
+	if _, ok := v.retab[pat]; ok {
+		return nil
+	}
+
+	re, err := regexp.Compile(pat)
+
+	if err != nil {
+		return errors.New("invalid pattern " + strconv.Quote(pat) + ": " + err.Error())
+	}
+
+	v.retab[pat] = re
+	return nil
+}
+
+// match returns true if the given string matches the given pattern. Patterns
+// are compiled when the Validator is created, but one nested where it could
+// not be told apart from a keyword is compiled here instead.
+func (v *Validator) match(pat, s string) (bool, error) {
+	re, ok := v.retab[pat]
+
+	if !ok {
+		var err error
+
+		re, err = regexp.Compile(pat)
+
+		if err != nil {
+			return false, errors.New("invalid pattern " + strconv.Quote(pat) + ": " + err.Error())
+		}
+	}
+	return re.MatchString(s), nil
+}
+
+// Validate validates the given record against the Validator's schema.
+func (v *Validator) Validate(r *Record) error {
+	b, err := r.MarshalJSON()
+
+	if err != nil {
+		return err
+	}
+
+	doc, err := decodejson(b)
+
+	if err != nil {
+		return err
+	}
+	return v.validate(v.root, doc, "")
+}
+
+// ValidationError is the error returned when a value does not match a JSON
+// Schema. Path is the JSON pointer of the value in the record.
+type ValidationError struct {
+	Path string
+	Err  error
+}
+
+func (e ValidationError) Error() string {
+	path := e.Path
+
+	if path == "" {
+		path = "/"
+	}
+	return "json schema validation failed at " + path + ": " + e.Err.Error()
+}
+
+// jsontypeof returns the JSON Schema type of the given decoded value.
+func jsontypeof(val interface{}) string {
+	switch x := val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if f, err := x.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return ""
+}
+
+// istype returns true if the given value is of the given JSON Schema type.
+func istype(val interface{}, typ string) bool {
+	actual := jsontypeof(val)
+	return actual == typ || (typ == "number" && actual == "integer")
+}
+
+// jsonequal returns true if the given decoded values are equal, comparing
+// numbers by their value rather than how they are written.
+func jsonequal(a, b interface{}) bool {
+	if x, ok := a.(json.Number); ok {
+		y, ok := b.(json.Number)
+
+		if !ok {
+			return false
+		}
+
+		f, _ := x.Float64()
+		g, _ := y.Float64()
+		return f == g
+	}
+
+	switch x := a.(type) {
+	case []interface{}:
+		y, ok := b.([]interface{})
+
+		if !ok || len(x) != len(y) {
+			return false
+		}
+
+		for i := range x {
+			if !jsonequal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+
+		if !ok || len(x) != len(y) {
+			return false
+		}
+
+		for k, xv := range x {
+			yv, ok := y[k]
+
+			if !ok || !jsonequal(xv, yv) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// multipleof reports whether x is a multiple of n. This is checked with the
+// exact values of each, since a division of floats would be off for decimals
+// such as 19.99 and 0.01. A multiple that is not a positive number is ignored.
+func multipleof(x, n json.Number) bool {
+	rx, ok := new(big.Rat).SetString(x.String())
+
+	if !ok {
+		return true
+	}
+
+	rn, ok := new(big.Rat).SetString(n.String())
+
+	if !ok || rn.Sign() <= 0 {
+		return true
+	}
+	return new(big.Rat).Quo(rx, rn).IsInt()
+}
+
+// number returns the float value of the given keyword in the schema.
+func number(s map[string]interface{}, key string) (float64, bool) {
+	n, ok := s[key].(json.Number)
+
+	if !ok {
+		return 0, false
+	}
+
+	f, err := n.Float64()
+
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// checkformat checks the given string against one of the formats that are
+// asserted, returning false if it does not match.
+func checkformat(format, s string) bool {
+	var err error
+
+	switch format {
+	case "date-time":
+		_, err = time.Parse(time.RFC3339Nano, s)
+	case "date":
+		_, err = time.Parse("2006-01-02", s)
+	case "time":
+		_, err = time.Parse("15:04:05Z07:00", s)
+
+		if err != nil {
+			_, err = time.Parse("15:04:05.999999999Z07:00", s)
+		}
+	case "email":
+		var addr *mail.Address
+
+		addr, err = mail.ParseAddress(s)
+
+		if err == nil && addr.Address != s {
+			return false
+		}
+	case "uuid":
+		if len(s) != 36 {
+			return false
+		}
+
+		_, err = UnmarshalUUID(0)(s)
+	case "ipv4", "ipv6":
+		var addr netip.Addr
+
+		addr, err = netip.ParseAddr(s)
+
+		if err == nil {
+			return (format == "ipv4") == addr.Is4()
+		}
+	case "uri":
+		var u *url.URL
+
+		u, err = url.Parse(s)
+
+		if err == nil && !u.IsAbs() {
+			return false
+		}
+	}
+	return err == nil
+}
+
+// ref resolves the given reference to one of the root schema's $defs, or the
+// root schema itself.
+func (v *Validator) ref(ref string) (interface{}, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+
+	name, ok := strings.CutPrefix(ref, "#/$defs/")
+
+	if !ok {
+		return nil, errors.New("unsupported $ref " + ref + ", only references to #/$defs are supported")
+	}
+
+	root, _ := v.root.(map[string]interface{})
+	defs, _ := root["$defs"].(map[string]interface{})
+
+	schema, ok := defs[name]
+
+	if !ok {
+		return nil, errors.New("unknown $ref " + ref)
+	}
+	return schema, nil
+}
+
+// validate validates the given value at the given path against the given
+// schema.
+func (v *Validator) validate(schema, val interface{}, path string) error {
+	fail := func(msg string) error {
+		return ValidationError{Path: path, Err: errors.New(msg)}
+	}
+
+	if b, ok := schema.(bool); ok {
+		if !b {
+			return fail("no value is allowed")
+		}
+		return nil
+	}
+
+	s, ok := schema.(map[string]interface{})
+
+	if !ok {
+		return fail("invalid json schema")
+	}
+
+	if ref, ok := s["$ref"].(string); ok {
+		sub, err := v.ref(ref)
+
+		if err != nil {
+			return fail(err.Error())
+		}
+
+		if err := v.validate(sub, val, path); err != nil {
+			return err
+		}
+	}
+
+	switch typ := s["type"].(type) {
+	case string:
+		if !istype(val, typ) {
+			return fail("expected " + typ + ", got " + jsontypeof(val))
+		}
+	case []interface{}:
+		ok := false
+		names := make([]string, 0, len(typ))
+
+		for _, t := range typ {
+			name, _ := t.(string)
+			names = append(names, name)
+
+			if istype(val, name) {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			return fail("expected one of " + strings.Join(names, ", ") + ", got " + jsontypeof(val))
+		}
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+
+		for _, e := range enum {
+			if jsonequal(e, val) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fail("value is not one of the enum values")
+		}
+	}
+
+	if c, ok := s["const"]; ok && !jsonequal(c, val) {
+		return fail("value does not match the const value")
+	}
+
+	switch x := val.(type) {
+	case string:
+		if n, ok := number(s, "minLength"); ok && float64(utf8.RuneCountInString(x)) < n {
+			return fail("expected at least " + s["minLength"].(json.Number).String() + " characters")
+		}
+
+		if n, ok := number(s, "maxLength"); ok && float64(utf8.RuneCountInString(x)) > n {
+			return fail("expected at most " + s["maxLength"].(json.Number).String() + " characters")
+		}
+
+		if pat, ok := s["pattern"].(string); ok {
+			matched, err := v.match(pat, x)
+
+			if err != nil {
+				return fail(err.Error())
+			}
+
+			if !matched {
+				return fail(strconv.Quote(x) + " does not match pattern " + strconv.Quote(pat))
+			}
+		}
+
+		if format, ok := s["format"].(string); ok && !checkformat(format, x) {
+			return fail(strconv.Quote(x) + " is not a valid " + format)
+		}
+	case json.Number:
+		f, _ := x.Float64()
+
+		if n, ok := number(s, "minimum"); ok && f < n {
+			return fail(x.String() + " is less than the minimum " + s["minimum"].(json.Number).String())
+		}
+
+		if n, ok := number(s, "maximum"); ok && f > n {
+			return fail(x.String() + " is greater than the maximum " + s["maximum"].(json.Number).String())
+		}
+
+		if n, ok := number(s, "exclusiveMinimum"); ok && f <= n {
+			return fail(x.String() + " is not greater than " + s["exclusiveMinimum"].(json.Number).String())
+		}
+
+		if n, ok := number(s, "exclusiveMaximum"); ok && f >= n {
+			return fail(x.String() + " is not less than " + s["exclusiveMaximum"].(json.Number).String())
+		}
+
+		if n, ok := s["multipleOf"].(json.Number); ok && !multipleof(x, n) {
+			return fail(x.String() + " is not a multiple of " + n.String())
+		}
+	case []interface{}:
+		if err := v.validatearray(s, x, path); err != nil {
+			return err
+		}
+	case map[string]interface{}:
+		if err := v.validateobject(s, x, path); err != nil {
+			return err
+		}
+	}
+
+	if allof, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range allof {
+			if err := v.validate(sub, val, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if anyof, ok := s["anyOf"].([]interface{}); ok {
+		matched := false
+
+		for _, sub := range anyof {
+			if v.validate(sub, val, path) == nil {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return fail("value does not match any of the anyOf schemas")
+		}
+	}
+
+	if oneof, ok := s["oneOf"].([]interface{}); ok {
+		matched := 0
+
+		for _, sub := range oneof {
+			if v.validate(sub, val, path) == nil {
+				matched++
+			}
+		}
+
+		if matched != 1 {
+			return fail("value matches " + strconv.Itoa(matched) + " of the oneOf schemas, expected 1")
+		}
+	}
+
+	if not, ok := s["not"]; ok && v.validate(not, val, path) == nil {
+		return fail("value matches the not schema")
+	}
+
+	if cond, ok := s["if"]; ok {
+		if v.validate(cond, val, path) == nil {
+			if then, ok := s["then"]; ok {
+				return v.validate(then, val, path)
+			}
+		} else if els, ok := s["else"]; ok {
+			return v.validate(els, val, path)
+		}
+	}
+	return nil
+}
+
+// validatearray validates the items of the given array against the given
+// schema.
+func (v *Validator) validatearray(s map[string]interface{}, arr []interface{}, path string) error {
+	fail := func(msg string) error {
+		return ValidationError{Path: path, Err: errors.New(msg)}
+	}
+
+	if n, ok := number(s, "minItems"); ok && float64(len(arr)) < n {
+		return fail("expected at least " + s["minItems"].(json.Number).String() + " items")
+	}
+
+	if n, ok := number(s, "maxItems"); ok && float64(len(arr)) > n {
+		return fail("expected at most " + s["maxItems"].(json.Number).String() + " items")
+	}
+
+	if unique, _ := s["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if jsonequal(arr[i], arr[j]) {
+					return fail("items " + strconv.Itoa(i) + " and " + strconv.Itoa(j) + " are equal")
+				}
+			}
+		}
+	}
+
+	prefix, _ := s["prefixItems"].([]interface{})
+
+	for i, item := range arr {
+		itempath := path + "/" + strconv.Itoa(i)
+
+		if i < len(prefix) {
+			if err := v.validate(prefix[i], item, itempath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if items, ok := s["items"]; ok {
+			if err := v.validate(items, item, itempath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pointer escapes the given property name for use in a JSON pointer.
+func pointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+// validateobject validates the properties of the given object against the
+// given schema.
+func (v *Validator) validateobject(s map[string]interface{}, obj map[string]interface{}, path string) error {
+	fail := func(msg string) error {
+		return ValidationError{Path: path, Err: errors.New(msg)}
+	}
+
+	if n, ok := number(s, "minProperties"); ok && float64(len(obj)) < n {
+		return fail("expected at least " + s["minProperties"].(json.Number).String() + " properties")
+	}
+
+	if n, ok := number(s, "maxProperties"); ok && float64(len(obj)) > n {
+		return fail("expected at most " + s["maxProperties"].(json.Number).String() + " properties")
+	}
+
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+
+			if _, ok := obj[name]; !ok {
+				return fail("missing required property " + name)
+			}
+		}
+	}
+
+	if deps, ok := s["dependentRequired"].(map[string]interface{}); ok {
+		for name, req := range deps {
+			if _, ok := obj[name]; !ok {
+				continue
+			}
+
+			list, _ := req.([]interface{})
+
+			for _, r := range list {
+				dep, _ := r.(string)
+
+				if _, ok := obj[dep]; !ok {
+					return fail("property " + dep + " is required when " + name + " is present")
+				}
+			}
+		}
+	}
+
+	props, _ := s["properties"].(map[string]interface{})
+	patprops, _ := s["patternProperties"].(map[string]interface{})
+	additional, hasadditional := s["additionalProperties"]
+
+	// Properties are validated in order, so the same error is reported each
+	// time for a record with more than one invalid property.
+	names := make([]string, 0, len(obj))
+
+	for name := range obj {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		val := obj[name]
+		proppath := path + "/" + pointer(name)
+		matched := false
+
+		if sub, ok := props[name]; ok {
+			matched = true
+
+			if err := v.validate(sub, val, proppath); err != nil {
+				return err
+			}
+		}
+
+		for pat, sub := range patprops {
+			ok, err := v.match(pat, name)
+
+			if err != nil {
+				return fail(err.Error())
+			}
+
+			if !ok {
+				continue
+			}
+
+			matched = true
+
+			if err := v.validate(sub, val, proppath); err != nil {
+				return err
+			}
+		}
+
+		if !matched && hasadditional {
+			if err := v.validate(additional, val, proppath); err != nil {
+				if b, ok := additional.(bool); ok && !b {
+					return fail("additional property " + name + " is not allowed")
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WithValidator configures the Parser to validate each record against the
+// given Validator before it is emitted. Records that are not valid are
+// reported to the error handler.
+func WithValidator(v *Validator) ParserOption {
+	return func(p *Parser) {
+		p.validator = v
+	}
+}
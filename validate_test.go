@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Validator(t *testing.T) {
+	tests := []struct {
+		schema string
+		doc    string
+		valid  bool
+	}{
+		{`{"type": "object"}`, `{}`, true},
+		{`{"type": "object"}`, `[]`, false},
+		{`{"type": "integer"}`, `1.0`, true},
+		{`{"type": "integer"}`, `1.5`, false},
+		{`{"type": ["string", "null"]}`, `null`, true},
+		{`{"enum": [1, "a"]}`, `1.0`, true},
+		{`{"enum": [1, "a"]}`, `"b"`, false},
+		{`{"const": {"a": [1, 2]}}`, `{"a": [1, 2]}`, true},
+		{`{"minLength": 2, "maxLength": 3}`, `"é"`, false},
+		{`{"pattern": "^[A-Z]+$"}`, `"ABC"`, true},
+		{`{"pattern": "^[A-Z]+$"}`, `"abc"`, false},
+		{`{"format": "email"}`, `"gordon@blackmesa.com"`, true},
+		{`{"format": "email"}`, `"gordon"`, false},
+		{`{"format": "date-time"}`, `"1998-11-19T00:00:00Z"`, true},
+		{`{"format": "ipv4"}`, `"::1"`, false},
+		{`{"format": "unknown"}`, `"anything"`, true},
+		{`{"minimum": 0, "exclusiveMaximum": 10}`, `10`, false},
+		{`{"multipleOf": 0.5}`, `2.5`, true},
+		{`{"multipleOf": 0.5}`, `2.4`, false},
+		{`{"multipleOf": 0.01}`, `19.99`, true},
+		{`{"multipleOf": 0.01}`, `19.995`, false},
+		{`{"multipleOf": 1e-2}`, `0.07`, true},
+		{`{"items": {"type": "integer"}, "minItems": 1}`, `[1, 2]`, true},
+		{`{"items": {"type": "integer"}}`, `[1, "2"]`, false},
+		{`{"uniqueItems": true}`, `[1, 1.0]`, false},
+		{`{"prefixItems": [{"type": "string"}], "items": {"type": "integer"}}`, `["a", 1]`, true},
+		{`{"required": ["id"]}`, `{"name": "Gordon"}`, false},
+		{`{"properties": {"id": {"type": "integer"}}, "additionalProperties": false}`, `{"id": 1, "name": "Gordon"}`, false},
+		{`{"patternProperties": {"^x_": {"type": "string"}}}`, `{"x_id": 1}`, false},
+		{`{"dependentRequired": {"end": ["start"]}}`, `{"end": 1}`, false},
+		{`{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `1`, true},
+		{`{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, `1`, false},
+		{`{"not": {"type": "null"}}`, `null`, false},
+		{`{"if": {"properties": {"status": {"const": "shipped"}}}, "then": {"required": ["shipped_at"]}}`, `{"status": "shipped"}`, false},
+		{`{"if": {"properties": {"status": {"const": "shipped"}}}, "then": {"required": ["shipped_at"]}}`, `{"status": "pending"}`, true},
+		{`{"$defs": {"id": {"type": "integer"}}, "properties": {"id": {"$ref": "#/$defs/id"}}}`, `{"id": "1"}`, false},
+		{`false`, `{}`, false},
+	}
+
+	for i, test := range tests {
+		v, err := NewValidator([]byte(test.schema))
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		doc, err := decodejson([]byte(test.doc))
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		err = v.validate(v.root, doc, "")
+
+		if test.valid && err != nil {
+			t.Errorf("tests[%d] - expected %s to be valid against %s, got error %s\n", i, test.doc, test.schema, err)
+		}
+
+		if !test.valid && err == nil {
+			t.Errorf("tests[%d] - expected %s to be invalid against %s\n", i, test.doc, test.schema)
+		}
+	}
+
+	if _, err := NewValidator([]byte(`{"pattern": "("}`)); err == nil {
+		t.Error("expected error for invalid pattern, got nil")
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	dir := t.TempDir()
+
+	schema := filepath.Join(dir, "orders.schema.json")
+	csv := filepath.Join(dir, "orders.csv")
+
+	js := `{
+	"type": "object",
+	"properties": {
+		"total": {"type": "number", "minimum": 0}
+	},
+	"if": {"properties": {"status": {"const": "shipped"}}},
+	"then": {"required": ["shipped_at"]}
+}`
+
+	if err := os.WriteFile(schema, []byte(js), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := "id,status,total,shipped_at\n" +
+		"1,shipped,10.5,2021-12-01\n" +
+		"2,shipped,20,\n" +
+		"3,pending,-5,\n" +
+		"4,pending,5,\n"
+
+	if err := os.WriteFile(csv, []byte(lines), os.FileMode(0644)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"csv2json", "-validate", schema, "-o", dir, csv}); err != (RecordsError{N: 2}) {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", RecordsError{N: 2}, err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "orders.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, 0)
+
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		ids = append(ids, line[strings.Index(line, `"id":`)+5:][:1])
+	}
+
+	if strings.Join(ids, ",") != "1,4" {
+		t.Errorf("unexpected records, expected ids=%q, got=%q\n", "1,4", strings.Join(ids, ","))
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// parsemap parses the given value of a map option, a comma separated list of
+// values to translate in the form of from=to. A from value of * translates any
+// value that is not in the map.
+func parsemap(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, errors.New("map option requires at least one value")
+	}
+
+	m := make(map[string]string)
+
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, "=")
+
+		if i < 0 {
+			return nil, errors.New("invalid map value " + pair + ", expected from=to")
+		}
+
+		from := pair[:i]
+
+		if _, ok := m[from]; ok {
+			return nil, errors.New("duplicate map value " + from)
+		}
+		m[from] = pair[i+1:]
+	}
+	return m, nil
+}
+
+// mapvalue translates the given value via the map. Values not in the map are
+// translated to the value for *, if any, otherwise they are left as is.
+func mapvalue(m map[string]string, val string) string {
+	if to, ok := m[val]; ok {
+		return to
+	}
+
+	if to, ok := m["*"]; ok {
+		return to
+	}
+	return val
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// webhookEncoder posts records to a URL in batches, each batch being sent as
+// a JSON array.
+type webhookEncoder struct {
+	fetch *Fetcher
+	url   string
+	batch int
+	n     int
+	buf   bytes.Buffer
+}
+
+// NewWebhookEncoder returns an EncoderFunc that posts records to the given URL
+// in batches of the given size, via the given Fetcher. Each file is posted in
+// its own batches, and the last batch of a file may be smaller.
+func NewWebhookEncoder(rawurl string, batch int, fetch *Fetcher) EncoderFunc {
+	return func(_ io.Writer, _ string, _ []Field) (Encoder, error) {
+		return &webhookEncoder{
+			fetch: fetch,
+			url:   rawurl,
+			batch: batch,
+		}, nil
+	}
+}
+
+func (e *webhookEncoder) Encode(r *Record) error {
+	b, err := json.Marshal(r)
+
+	if err != nil {
+		return err
+	}
+
+	if e.n == 0 {
+		e.buf.WriteByte('[')
+	} else {
+		e.buf.WriteByte(',')
+	}
+
+	e.buf.Write(b)
+	e.n++
+
+	if e.n >= e.batch {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *webhookEncoder) flush() error {
+	if e.n == 0 {
+		return nil
+	}
+
+	e.buf.WriteByte(']')
+
+	err := e.fetch.Post(e.url, "application/json", e.buf.Bytes())
+
+	e.buf.Reset()
+	e.n = 0
+
+	return err
+}
+
+func (e *webhookEncoder) Close() error {
+	return e.flush()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_WebhookSink(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]map[string]interface{}
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var batch []map[string]interface{}
+
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	}))
+
+	defer srv.Close()
+
+	args := []string{
+		"csv2json",
+		"-post-url", srv.URL + "/ingest",
+		"-batch", "4",
+		"-s", filepath.Join("testdata", "sales.schema"),
+		filepath.Join("testdata", "sales.csv"),
+	}
+
+	if err := run(args); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(batches) != 2 {
+		t.Fatalf("unexpected number of batches, expected=%d, got=%d\n", 2, len(batches))
+	}
+
+	if len(batches[0]) != 4 || len(batches[1]) != 2 {
+		t.Fatalf("unexpected batch sizes, expected=[4 2], got=[%d %d]\n", len(batches[0]), len(batches[1]))
+	}
+
+	if day := batches[0][0]["day"]; day != "2021-12-01T00:00:00Z" {
+		t.Errorf("unexpected day in first record, expected=%q, got=%v\n", "2021-12-01T00:00:00Z", day)
+	}
+}
+
+func Test_WebhookRetry(t *testing.T) {
+	failures := 2
+	posts := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		if string(b) != `[{"id":1}]` {
+			t.Errorf("unexpected body, expected=%q, got=%q\n", `[{"id":1}]`, b)
+		}
+		posts++
+	}))
+
+	defer srv.Close()
+
+	f := NewFetcher("", 2)
+	f.backoff = time.Millisecond
+
+	enc, err := NewWebhookEncoder(srv.URL, 10, f)(nil, "", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRecord()
+	r.Set("id", &Int{n: 1})
+
+	if err := enc.Encode(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if posts != 0 {
+		t.Fatalf("expected no posts before close, got=%d\n", posts)
+	}
+
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if posts != 1 {
+		t.Fatalf("unexpected number of posts, expected=%d, got=%d\n", 1, posts)
+	}
+
+	failures = 3
+
+	enc, _ = NewWebhookEncoder(srv.URL, 1, f)(nil, "", nil)
+
+	if err := enc.Encode(r); err == nil {
+		t.Fatal("expected error once retries are exhausted")
+	} else if httperr, ok := err.(HTTPError); !ok || httperr.Status != http.StatusTooManyRequests {
+		t.Fatalf("expected HTTPError with status 429, got=%v\n", err)
+	}
+}
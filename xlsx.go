@@ -0,0 +1,444 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xlsx is an Excel workbook being read as CSV.
+type xlsx struct {
+	zr       *zip.ReadCloser
+	strings  []string
+	dates    map[int]bool // cell styles that are formatted as dates
+	date1904 bool
+}
+
+type xlsxWorkbook struct {
+	Pr struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRels struct {
+	Rels []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxText is text that is either plain, or made up of multiple runs of rich
+// text.
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+
+	var buf strings.Builder
+
+	for _, r := range t.Runs {
+		buf.WriteString(r.T)
+	}
+	return buf.String()
+}
+
+type xlsxStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	Xfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+type xlsxCell struct {
+	Ref   string   `xml:"r,attr"`
+	Type  string   `xml:"t,attr"`
+	Style int      `xml:"s,attr"`
+	V     string   `xml:"v"`
+	Is    xlsxText `xml:"is"`
+}
+
+// decodexml decodes the file with the given name in the workbook into v. If
+// the file does not exist, then v is left as is.
+func (x *xlsx) decodexml(name string, v interface{}) error {
+	for _, f := range x.zr.File {
+		if f.Name != name {
+			continue
+		}
+
+		rc, err := f.Open()
+
+		if err != nil {
+			return err
+		}
+
+		defer rc.Close()
+
+		return xml.NewDecoder(rc).Decode(v)
+	}
+	return nil
+}
+
+// isdatefmt checks if the given number format code is for a date or time.
+func isdatefmt(code string) bool {
+	quoted := false
+
+	for i := 0; i < len(code); i++ {
+		switch c := code[i]; c {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			i++
+		case '[':
+			// Skip over colors and conditions, such as [Red].
+			if j := strings.IndexByte(code[i:], ']'); j > 0 {
+				i += j
+			}
+		default:
+			if quoted {
+				continue
+			}
+
+			switch c {
+			case 'd', 'm', 'y', 'h', 's', 'D', 'M', 'Y', 'H', 'S':
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// openxlsx opens the given Excel workbook, and returns the given sheet as CSV
+// with the given delimeter. If sheet is empty, then the first sheet is used.
+func openxlsx(fname, sheet string, delim rune) (io.ReadCloser, error) {
+	zr, err := zip.OpenReader(fname)
+
+	if err != nil {
+		return nil, err
+	}
+
+	x := &xlsx{
+		zr:    zr,
+		dates: make(map[int]bool),
+	}
+
+	name, err := x.sheetpath(sheet)
+
+	if err != nil {
+		zr.Close()
+		return nil, errors.New(fname + ": " + err.Error())
+	}
+
+	var sst xlsxStrings
+
+	if err := x.decodexml("xl/sharedStrings.xml", &sst); err != nil {
+		zr.Close()
+		return nil, errors.New(fname + ": " + err.Error())
+	}
+
+	for _, si := range sst.Items {
+		x.strings = append(x.strings, si.String())
+	}
+
+	var styles xlsxStyles
+
+	if err := x.decodexml("xl/styles.xml", &styles); err != nil {
+		zr.Close()
+		return nil, errors.New(fname + ": " + err.Error())
+	}
+
+	custom := make(map[int]string)
+
+	for _, nf := range styles.NumFmts {
+		custom[nf.ID] = nf.Code
+	}
+
+	for i, xf := range styles.Xfs {
+		id := xf.NumFmtID
+
+		// The built in date and time formats.
+		if (id >= 14 && id <= 22) || (id >= 45 && id <= 47) {
+			x.dates[i] = true
+			continue
+		}
+
+		if code, ok := custom[id]; ok && isdatefmt(code) {
+			x.dates[i] = true
+		}
+	}
+
+	var f *zip.File
+
+	for _, zf := range zr.File {
+		if zf.Name == name {
+			f = zf
+			break
+		}
+	}
+
+	if f == nil {
+		zr.Close()
+		return nil, errors.New(fname + ": missing " + name)
+	}
+
+	rc, err := f.Open()
+
+	if err != nil {
+		zr.Close()
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		err := x.writecsv(pw, rc, delim)
+
+		rc.Close()
+		zr.Close()
+
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// sheetpath returns the path to the given sheet in the workbook.
+func (x *xlsx) sheetpath(sheet string) (string, error) {
+	var wb xlsxWorkbook
+
+	if err := x.decodexml("xl/workbook.xml", &wb); err != nil {
+		return "", err
+	}
+
+	x.date1904 = wb.Pr.Date1904
+
+	if len(wb.Sheets) == 0 {
+		return "", errors.New("workbook has no sheets")
+	}
+
+	id := wb.Sheets[0].ID
+
+	if sheet != "" {
+		id = ""
+
+		for _, s := range wb.Sheets {
+			if s.Name == sheet {
+				id = s.ID
+				break
+			}
+		}
+
+		if id == "" {
+			return "", errors.New("no such sheet " + strconv.Quote(sheet))
+		}
+	}
+
+	var rels xlsxRels
+
+	if err := x.decodexml("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return "", err
+	}
+
+	for _, rel := range rels.Rels {
+		if rel.ID != id {
+			continue
+		}
+
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+	return "", errors.New("missing relationship for sheet " + id)
+}
+
+// colindex returns the zero based index of the column in the given cell
+// reference, such as B3.
+func colindex(ref string) int {
+	n := 0
+
+	for _, c := range ref {
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		n = n*26 + int(c-'A'+1)
+	}
+	return n - 1
+}
+
+// value returns the value of the given cell as a string.
+func (x *xlsx) value(c xlsxCell) string {
+	switch c.Type {
+	case "s":
+		i, err := strconv.Atoi(c.V)
+
+		if err != nil || i < 0 || i >= len(x.strings) {
+			return ""
+		}
+		return x.strings[i]
+	case "inlineStr":
+		return c.Is.String()
+	case "b":
+		if c.V == "1" {
+			return "true"
+		}
+		return "false"
+	case "str", "e":
+		return c.V
+	}
+
+	if !x.dates[c.Style] || c.V == "" {
+		return c.V
+	}
+
+	n, err := strconv.ParseFloat(c.V, 64)
+
+	if err != nil {
+		return c.V
+	}
+
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+	if x.date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	days, frac := math.Modf(n)
+
+	t := epoch.AddDate(0, 0, int(days)).Add(time.Duration(math.Round(frac*86400)) * time.Second)
+
+	if frac == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// writecsv reads the rows of the sheet from r, and writes them as CSV to w.
+// Every row is padded to the width of the first row.
+func (x *xlsx) writecsv(w io.Writer, r io.Reader, delim rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = delim
+
+	dec := xml.NewDecoder(r)
+
+	width := -1
+
+	var record []string
+
+	for {
+		tok, err := dec.Token()
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "row":
+				record = record[:0]
+			case "c":
+				var c xlsxCell
+
+				if err := dec.DecodeElement(&c, &el); err != nil {
+					return err
+				}
+
+				i := len(record)
+
+				if c.Ref != "" {
+					i = colindex(c.Ref)
+				}
+
+				for len(record) <= i {
+					record = append(record, "")
+				}
+				record[i] = x.value(c)
+			}
+		case xml.EndElement:
+			if el.Name.Local != "row" {
+				continue
+			}
+
+			if width < 0 {
+				width = len(record)
+			}
+
+			for len(record) < width {
+				record = append(record, "")
+			}
+
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// isxlsx checks if the given file is an Excel workbook, going by its
+// extension.
+func isxlsx(fname string) bool {
+	return strings.HasSuffix(strings.ToLower(fname), ".xlsx")
+}
+
+// openinput opens the given file for parsing, or requests it with the given
+// Fetcher if it is a URL. Excel workbooks are opened as CSV, using the given
+// sheet.
+func openinput(fname, sheet string, delim rune, fetch *Fetcher) (io.ReadCloser, error) {
+	if isremote(fname) {
+		if isxlsx(urlbase(fname)) {
+			return nil, errURLWorkbook
+		}
+		return fetch.Open(fname)
+	}
+
+	if isxlsx(fname) {
+		// A workbook has no delimiter to detect, so it is written with the
+		// default.
+		if delim == 0 {
+			delim = ','
+		}
+		return openxlsx(fname, sheet, delim)
+	}
+	return os.Open(fname)
+}
+
+// inputopts returns the ParserOptions to use for the given file. The CSV of
+// an Excel workbook is always UTF-8 with the default quoting, so any options
+// for these are reset.
+func inputopts(fname string, opts []ParserOption) []ParserOption {
+	if !isxlsx(fname) {
+		return opts
+	}
+	return append(opts[:len(opts):len(opts)], WithEncoding(""), WithQuote(0, 0))
+}
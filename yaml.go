@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// yamlReserved are the plain scalars that YAML would read as something other
+// than a string.
+var yamlReserved = map[string]struct{}{
+	"true":  {},
+	"false": {},
+	"yes":   {},
+	"no":    {},
+	"on":    {},
+	"off":   {},
+	"y":     {},
+	"n":     {},
+	"null":  {},
+	"~":     {},
+}
+
+// yamlplain checks if the given string can be written as a plain scalar. This
+// errs on the side of quoting, so anything that could be read as another type,
+// or contains an indicator character, is not plain.
+func yamlplain(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s {
+		return false
+	}
+
+	if _, ok := yamlReserved[strings.ToLower(s)]; ok {
+		return false
+	}
+
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9', c == ' ', c == '.', c == '/', c == '-', c == '@', c == '+':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// yamlEncoder writes records as YAML mappings, either as a stream of documents,
+// or as the items of a single sequence.
+type yamlEncoder struct {
+	w   io.Writer
+	seq bool
+	n   int // number of records written
+	buf bytes.Buffer
+}
+
+// NewYAMLEncoder returns an EncoderFunc for writing records as YAML. If seq is
+// true then the records are written as a single sequence, otherwise each
+// record is written as its own document.
+func NewYAMLEncoder(seq bool) EncoderFunc {
+	return func(w io.Writer, _ string, _ []Field) (Encoder, error) {
+		return &yamlEncoder{w: w, seq: seq}, nil
+	}
+}
+
+func (e *yamlEncoder) string(s string) {
+	if yamlplain(s) {
+		e.buf.WriteString(s)
+		return
+	}
+
+	// YAML double-quoted scalars are a superset of JSON strings.
+	b, _ := json.Marshal(s)
+	e.buf.Write(b)
+}
+
+// value writes the given value in flow style, so arrays can be written inline.
+func (e *yamlEncoder) value(v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		e.buf.WriteString("null")
+	case bool:
+		e.buf.WriteString(strconv.FormatBool(x))
+	case int64:
+		e.buf.WriteString(strconv.FormatInt(x, 10))
+	case float64:
+		switch {
+		case math.IsNaN(x):
+			e.buf.WriteString(".nan")
+		case math.IsInf(x, 1):
+			e.buf.WriteString(".inf")
+		case math.IsInf(x, -1):
+			e.buf.WriteString("-.inf")
+		default:
+			b, err := json.Marshal(x)
+
+			if err != nil {
+				return err
+			}
+			e.buf.Write(b)
+		}
+	case string:
+		e.string(x)
+	case []interface{}:
+		e.buf.WriteByte('[')
+
+		for i, item := range x {
+			if i > 0 {
+				e.buf.WriteString(", ")
+			}
+
+			if err := e.value(item); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteByte(']')
+	default:
+		return errors.New("cannot encode value")
+	}
+	return nil
+}
+
+func (e *yamlEncoder) Encode(r *Record) error {
+	e.buf.Reset()
+
+	// Items of a sequence are indented under the dash, documents are not.
+	first, indent := "", ""
+
+	if e.seq {
+		first, indent = "- ", "  "
+	} else {
+		e.buf.WriteString("---\n")
+	}
+
+	keys := r.Keys()
+
+	if len(keys) == 0 {
+		e.buf.WriteString(first + "{}\n")
+	}
+
+	for i, key := range keys {
+		v, _ := r.Get(key)
+
+		val, err := native(v)
+
+		if err != nil {
+			return EncodeError{Field: key, Err: err}
+		}
+
+		if i == 0 {
+			e.buf.WriteString(first)
+		} else {
+			e.buf.WriteString(indent)
+		}
+
+		e.string(key)
+		e.buf.WriteString(": ")
+
+		if err := e.value(val); err != nil {
+			return EncodeError{Field: key, Err: err}
+		}
+		e.buf.WriteByte('\n')
+	}
+
+	if _, err := e.w.Write(e.buf.Bytes()); err != nil {
+		return err
+	}
+
+	e.n++
+	return nil
+}
+
+// Close writes an empty sequence if no records were written, so the output is
+// still a sequence.
+func (e *yamlEncoder) Close() error {
+	if e.seq && e.n == 0 {
+		_, err := io.WriteString(e.w, "[]\n")
+		return err
+	}
+	return nil
+}